@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/efrenfuentes/authy/internal/handlers"
 	"github.com/efrenfuentes/authy/internal/middleware"
 	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/notifications"
 	"github.com/efrenfuentes/authy/internal/services"
 	"github.com/efrenfuentes/authy/pkg/auth"
 	"github.com/efrenfuentes/authy/pkg/logger"
@@ -21,6 +23,7 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/swagger"
+	"github.com/google/uuid"
 )
 
 // @title Authy Authentication Service API
@@ -65,16 +68,67 @@ func main() {
 		log.Fatal("Failed to connect to cache", "error", err)
 	}
 	
+	// Load JWT signing key
+	signingKey, err := loadSigningKey(cfg)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing key", "error", err)
+	}
+	
+	// Initialize keyring, shared with other instances through the database
+	keyEncryptionKey, err := loadKeyEncryptionKey(cfg)
+	if err != nil {
+		log.Fatal("Failed to load key encryption key", "error", err)
+	}
+	if cfg.KeyEncryptionKey == "" {
+		log.Warn("KEY_ENCRYPTION_KEY is not set; encrypting signing keys with the development key")
+	}
+	
+	encryptor, err := auth.NewEncryptor(keyEncryptionKey)
+	if err != nil {
+		log.Fatal("Failed to initialize key encryption", "error", err)
+	}
+	
+	keyring := auth.NewKeyring(signingKey)
+	keyService := services.NewKeyService(db, log, keyring, encryptor)
+	if err := keyService.Bootstrap(signingKey); err != nil {
+		log.Fatal("Failed to load signing keys", "error", err)
+	}
+	keyService.StartAutoReload(time.Minute)
+	
 	// Initialize JWT service
-	jwtService := auth.NewJWTService(
-		cfg.JWTSecret,
+	jwtService := auth.NewJWTServiceWithKeyring(
+		keyring,
 		time.Duration(cfg.JWTExpiration)*time.Second,
 		time.Duration(cfg.RefreshExpiration)*time.Second,
-		cfg.ServiceName,
+		cfg.IssuerURL,
 	)
 	
 	// Initialize session service
-	sessionService := auth.NewSessionService(cache, jwtService)
+	sessionService := auth.NewSessionService(
+		cache,
+		jwtService,
+		time.Duration(cfg.SessionIdleTimeout)*time.Second,
+		time.Duration(cfg.SessionMaxAge)*time.Second,
+	)
+	
+	// Re-resolve the permissions of tokens issued before a role or permission change
+	permissionVersionService := services.NewPermissionVersionService(db, cache, log)
+	sessionService.SetPermissionResolver(permissionVersionService)
+	
+	// Resolve opaque access tokens the cache has lost from the database
+	sessionService.SetReferenceTokenStore(services.NewReferenceTokenService(db))
+	
+	// Management API tokens must be issued for the configured application
+	var apiAudience uuid.UUID
+	if cfg.APIAudience != "" {
+		apiAudience, err = uuid.Parse(cfg.APIAudience)
+		if err != nil {
+			log.Fatal("Invalid API audience", "error", err)
+		}
+	} else {
+		log.Warn("API_AUDIENCE is not set, management API accepts tokens of any application")
+	}
+	apiAuth := middleware.AuthRequiredForAudience(sessionService, db, apiAudience)
 	
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -100,32 +154,82 @@ func main() {
 	// Swagger documentation
 	app.Get("/docs/*", swagger.HandlerDefault)
 	
+	// Well-known discovery endpoints
+	wellKnownHandler := handlers.NewWellKnownHandler(jwtService)
+	app.Get("/.well-known/jwks.json", wellKnownHandler.JWKS)
+	app.Get("/.well-known/openid-configuration", wellKnownHandler.OpenIDConfiguration)
+	
 	// API routes
 	api := app.Group("/api/v1")
 	
 	// Initialize services
 	auditService := services.NewAuditService(db, log)
 
+	// Deliver password reset links and other user notifications in the background
+	transport, err := notifications.NewTransport(cfg.NotifierTransport, cfg.NotifierFileDir, notifications.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		Security: cfg.SMTPSecurity,
+	}, log)
+	if err != nil {
+		log.Fatal("Failed to initialize notification transport", "error", err)
+	}
+	notifier := notifications.NewService(db, transport, encryptor, log, notifications.Options{
+		From:        cfg.NotifierFrom,
+		AppName:     cfg.ServiceName,
+		Workers:     cfg.NotifierWorkers,
+		MaxAttempts: cfg.NotifierMaxAttempts,
+		RetryDelay:  time.Duration(cfg.NotifierRetryDelay) * time.Second,
+	})
+	notifier.Start()
+
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db, cache, log, sessionService)
-	userHandler := handlers.NewUserHandler(db, cache, log, sessionService)
+	authHandler := handlers.NewAuthHandler(db, cache, log, sessionService, encryptor, notifier, cfg.PasswordResetURL, cfg.EmailVerificationURL)
+	userHandler := handlers.NewUserHandler(db, cache, log, sessionService, permissionVersionService, notifier, cfg.EmailVerificationURL)
 	appHandler := handlers.NewApplicationHandler(db, cache, log)
-	permissionHandler := handlers.NewPermissionHandler(db, log)
-	roleHandler := handlers.NewRoleHandler(db, log)
+	permissionHandler := handlers.NewPermissionHandler(db, log, permissionVersionService)
+	roleHandler := handlers.NewRoleHandler(db, log, permissionVersionService)
 	auditHandler := handlers.NewAuditHandler(auditService)
 	analyticsHandler := handlers.NewAnalyticsHandler(db, log)
+	keyHandler := handlers.NewKeyHandler(db, log, keyService)
 	
 	// Auth routes (with rate limiting)
 	auth := api.Group("/auth")
 	auth.Use(authRateLimit)
 	auth.Post("/login", authHandler.Login)
+	auth.Post("/register", authHandler.Register)
+	auth.Post("/mfa/verify", authHandler.VerifyMFA)
+	auth.Post("/mfa/webauthn", authHandler.BeginMFAWebAuthn)
+	auth.Post("/webauthn/login/begin", authHandler.BeginWebAuthnLogin)
+	auth.Post("/webauthn/login/finish", authHandler.FinishWebAuthnLogin)
 	auth.Post("/logout", authHandler.Logout)
 	auth.Post("/refresh", authHandler.RefreshToken)
 	auth.Post("/validate", authHandler.ValidateToken)
+	auth.Post("/password/forgot", authHandler.ForgotPassword)
+	auth.Post("/password/reset", authHandler.ResetPassword)
+	auth.Post("/email/verify", authHandler.VerifyEmail)
+	auth.Post("/email/verify/resend", authHandler.ResendEmailVerification)
+	
+	// OAuth 2.0 endpoints (credential-accepting ones with rate limiting)
+	oauth := app.Group("/oauth")
+	oauth.Get("/authorize", authRateLimit, authHandler.Authorize)
+	oauth.Post("/authorize", authRateLimit, authHandler.AuthorizeLogin)
+	oauth.Post("/token", authRateLimit, authHandler.Token)
+	oauth.Post("/revoke", authRateLimit, authHandler.Revoke)
+	oauth.Post("/introspect", authHandler.Introspect) // called by resource servers on every request
+	oauth.Post("/device_authorization", authRateLimit, authHandler.DeviceAuthorization)
+	oauth.Get("/device", authRateLimit, authHandler.DeviceVerification)
+	oauth.Post("/device", authRateLimit, authHandler.DeviceVerificationLogin)
+	
+	// OpenID Connect userinfo (require authentication)
+	api.Get("/userinfo", middleware.AuthRequired(sessionService, db), authHandler.UserInfo)
+	api.Post("/userinfo", middleware.AuthRequired(sessionService, db), authHandler.UserInfo)
 	
 	// User routes (require authentication)
 	users := api.Group("/users")
-	users.Use(middleware.AuthRequired(sessionService))
+	users.Use(apiAuth)
 	users.Get("/", userHandler.GetUsers)
 	users.Post("/", middleware.RequirePermission("users", "create"), userHandler.CreateUser)
 	users.Get("/:id", middleware.RequirePermission("users", "read"), userHandler.GetUser)
@@ -133,20 +237,45 @@ func main() {
 	users.Delete("/:id", middleware.RequirePermission("users", "delete"), userHandler.DeleteUser)
 	users.Post("/:id/roles", middleware.RequirePermission("users", "update"), userHandler.AssignRole)
 	users.Delete("/:id/roles/:role_id", middleware.RequirePermission("users", "update"), userHandler.RemoveRole)
+	users.Get("/:id/sessions", middleware.RequirePermission("users", "read"), userHandler.GetUserSessions)
+	users.Delete("/:id/sessions/:session_id", middleware.RequirePermission("users", "update"), userHandler.RevokeUserSession)
+	users.Delete("/:id/mfa", middleware.RequirePermission("users", "update"), userHandler.ResetUserMFA)
+	users.Post("/:id/approve", middleware.RequirePermission("users", "update"), userHandler.ApproveUser)
+	
+	// Current user routes (require authentication)
+	me := api.Group("/me")
+	me.Use(middleware.AuthRequired(sessionService, db))
+	me.Get("/sessions", userHandler.GetMySessions)
+	me.Post("/device", authRateLimit, authHandler.ApproveDevice)
+	me.Post("/mfa/totp", authRateLimit, authHandler.EnrollTOTP)
+	me.Post("/mfa/totp/confirm", authRateLimit, authHandler.ConfirmTOTP)
+	me.Delete("/mfa/totp", authRateLimit, authHandler.DisableTOTP)
+	me.Post("/mfa/recovery-codes", authRateLimit, authHandler.RegenerateRecoveryCodes)
+	me.Post("/webauthn/step-up", authHandler.BeginStepUpWebAuthn)
+	me.Post("/webauthn/register/begin", authRateLimit, authHandler.BeginWebAuthnRegistration)
+	me.Post("/webauthn/register/finish", authHandler.FinishWebAuthnRegistration)
+	me.Get("/webauthn/credentials", authHandler.GetWebAuthnCredentials)
+	me.Delete("/webauthn/credentials/:id", authRateLimit, authHandler.DeleteWebAuthnCredential)
 	
 	// Application routes (require authentication)
 	apps := api.Group("/applications")
-	apps.Use(middleware.AuthRequired(sessionService))
+	apps.Use(apiAuth)
 	apps.Get("/", middleware.RequirePermission("applications", "read"), appHandler.GetApplications)
 	apps.Post("/", middleware.RequirePermission("applications", "create"), appHandler.CreateApplication)
 	apps.Get("/:id", middleware.RequirePermission("applications", "read"), appHandler.GetApplication)
 	apps.Put("/:id", middleware.RequirePermission("applications", "update"), appHandler.UpdateApplication)
 	apps.Delete("/:id", middleware.RequirePermission("applications", "delete"), appHandler.DeleteApplication)
 	apps.Post("/:id/regenerate-key", middleware.RequirePermission("applications", "update"), appHandler.RegenerateAPIKey)
+	apps.Get("/:id/service-roles", middleware.RequirePermission("applications", "read"), appHandler.GetServiceRoles)
+	apps.Post("/:id/service-roles", middleware.RequirePermission("applications", "update"), appHandler.AssignServiceRole)
+	apps.Delete("/:id/service-roles/:role_id", middleware.RequirePermission("applications", "update"), appHandler.RemoveServiceRole)
+	apps.Get("/:id/notification-templates", middleware.RequirePermission("applications", "read"), appHandler.GetNotificationTemplates)
+	apps.Put("/:id/notification-templates/:kind/:locale", middleware.RequirePermission("applications", "update"), appHandler.SetNotificationTemplate)
+	apps.Delete("/:id/notification-templates/:kind/:locale", middleware.RequirePermission("applications", "update"), appHandler.DeleteNotificationTemplate)
 	
 	// Permission routes (require authentication)
 	permissions := api.Group("/permissions")
-	permissions.Use(middleware.AuthRequired(sessionService))
+	permissions.Use(apiAuth)
 	permissions.Get("/", middleware.RequirePermission("permissions", "list"), permissionHandler.GetPermissions)
 	permissions.Post("/", middleware.RequirePermission("permissions", "create"), permissionHandler.CreatePermission)
 	permissions.Get("/:id", middleware.RequirePermission("permissions", "read"), permissionHandler.GetPermission)
@@ -154,7 +283,7 @@ func main() {
 	
 	// Role routes (require authentication)
 	roles := api.Group("/roles")
-	roles.Use(middleware.AuthRequired(sessionService))
+	roles.Use(apiAuth)
 	roles.Get("/", middleware.RequirePermission("roles", "list"), roleHandler.GetRoles)
 	roles.Post("/", middleware.RequirePermission("roles", "create"), roleHandler.CreateRole)
 	roles.Get("/:id", middleware.RequirePermission("roles", "read"), roleHandler.GetRole)
@@ -164,20 +293,29 @@ func main() {
 	
 	// Audit log routes (require authentication and audit permissions)
 	auditLogs := api.Group("/audit-logs")
-	auditLogs.Use(middleware.AuthRequired(sessionService))
+	auditLogs.Use(apiAuth)
 	auditLogs.Get("/", middleware.RequirePermission("system", "audit"), auditHandler.GetAuditLogs)
 	auditLogs.Get("/stats", middleware.RequirePermission("system", "audit"), auditHandler.GetAuditStats)
 	auditLogs.Get("/export", middleware.RequirePermission("system", "audit"), auditHandler.ExportAuditLogs)
 	auditLogs.Get("/options", middleware.RequirePermission("system", "audit"), auditHandler.GetAuditOptions)
+	auditLogs.Get("/notifications", middleware.RequirePermission("system", "audit"), auditHandler.GetNotifications)
 	
 	// Analytics routes (require authentication and audit permissions)
 	analytics := api.Group("/analytics")
-	analytics.Use(middleware.AuthRequired(sessionService))
+	analytics.Use(apiAuth)
 	analytics.Get("/authentication", middleware.RequirePermission("system", "audit"), analyticsHandler.GetAuthenticationAnalytics)
 	analytics.Get("/users", middleware.RequirePermission("system", "audit"), analyticsHandler.GetUserAnalytics)
 	analytics.Get("/applications", middleware.RequirePermission("system", "audit"), analyticsHandler.GetApplicationAnalytics)
 	analytics.Get("/security", middleware.RequirePermission("system", "audit"), analyticsHandler.GetSecurityAnalytics)
 	
+	// Signing key routes (require authentication and system config permissions)
+	keys := api.Group("/keys")
+	keys.Use(apiAuth)
+	keys.Get("/", middleware.RequirePermission("system", "config"), keyHandler.GetKeys)
+	keys.Post("/", middleware.RequirePermission("system", "config"), keyHandler.CreateKey)
+	keys.Post("/:kid/promote", middleware.RequirePermission("system", "config"), keyHandler.PromoteKey)
+	keys.Post("/:kid/retire", middleware.RequirePermission("system", "config"), keyHandler.RetireKey)
+	
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -188,4 +326,40 @@ func main() {
 	if err := app.Listen(":" + port); err != nil {
 		log.Fatal("Failed to start server", "error", err)
 	}
-}
\ No newline at end of file
+}
+
+// developmentKeyEncryptionKey encrypts signing keys at rest in development when KEY_ENCRYPTION_KEY is unset;
+// it is the former built-in default, so existing development databases keep their keys
+const developmentKeyEncryptionKey = "your-key-encryption-key"
+
+// loadKeyEncryptionKey returns the key signing keys are encrypted with at rest. Only development may
+// leave it unset, as a built-in key protects nothing.
+func loadKeyEncryptionKey(cfg *config.Config) (string, error) {
+	if cfg.KeyEncryptionKey != "" {
+		return cfg.KeyEncryptionKey, nil
+	}
+	
+	if cfg.Environment != "development" {
+		return "", fmt.Errorf("KEY_ENCRYPTION_KEY is required when ENVIRONMENT is %s", cfg.Environment)
+	}
+	
+	return developmentKeyEncryptionKey, nil
+}
+
+// loadSigningKey builds the token signing key from configuration
+func loadSigningKey(cfg *config.Config) (*auth.SigningKey, error) {
+	if cfg.JWTAlgorithm == auth.AlgorithmHS256 {
+		return auth.NewHMACSigningKey(cfg.JWTSecret), nil
+	}
+	
+	if cfg.JWTPrivateKeyFile == "" {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is required when JWT_ALGORITHM is %s", cfg.JWTAlgorithm)
+	}
+	
+	pemData, err := os.ReadFile(cfg.JWTPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	
+	return auth.ParseSigningKey(cfg.JWTAlgorithm, pemData)
+}
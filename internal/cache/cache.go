@@ -2,10 +2,25 @@ package cache
 
 import (
 	"context"
+	"strconv"
 	"time"
 	"github.com/valkey-io/valkey-go"
 )
 
+// Store is the key-value store sessions, codes and token indexes are kept in. Client implements it
+// on Valkey; Memory implements it in memory for tests.
+type Store interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl int) error
+	Delete(ctx context.Context, key string) error
+	GetDel(ctx context.Context, key string) (string, error)
+	DeleteIfEqual(ctx context.Context, key, value string) (bool, error)
+	SetNX(ctx context.Context, key, value string, ttl int) (bool, error)
+	Incr(ctx context.Context, key string, ttl int) (int64, error)
+	IndexAdd(ctx context.Context, key, member string, expiresAt time.Time) error
+	IndexMembers(ctx context.Context, key string) (map[string]time.Time, error)
+}
+
 type Client struct {
 	client valkey.Client
 }
@@ -38,4 +53,88 @@ func (c *Client) Set(ctx context.Context, key, value string, ttl int) error {
 
 func (c *Client) Delete(ctx context.Context, key string) error {
 	return c.client.Do(ctx, c.client.B().Del().Key(key).Build()).Error()
-}
\ No newline at end of file
+}
+
+// GetDel atomically reads and deletes a key, for single-use values
+func (c *Client) GetDel(ctx context.Context, key string) (string, error) {
+	result := c.client.Do(ctx, c.client.B().Getdel().Key(key).Build())
+	return result.ToString()
+}
+
+// deleteIfEqualScript deletes a key only while it holds the given value
+var deleteIfEqualScript = valkey.NewLuaScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// DeleteIfEqual atomically deletes a key only while it holds value, reporting whether it did
+func (c *Client) DeleteIfEqual(ctx context.Context, key, value string) (bool, error) {
+	deleted, err := deleteIfEqualScript.Exec(ctx, c.client, []string{key}, []string{value}).AsInt64()
+	return deleted == 1, err
+}
+
+// SetNX sets a key only if it does not exist yet, reporting whether it was set
+func (c *Client) SetNX(ctx context.Context, key, value string, ttl int) (bool, error) {
+	err := c.client.Do(ctx, c.client.B().Set().Key(key).Value(value).Nx().Ex(time.Duration(ttl)*time.Second).Build()).Error()
+	if valkey.IsValkeyNil(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// incrScript increments a counter, starting its expiry when the counter is created
+var incrScript = valkey.NewLuaScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// Incr atomically increments a counter and returns its new value; a new counter expires after ttl seconds
+func (c *Client) Incr(ctx context.Context, key string, ttl int) (int64, error) {
+	return incrScript.Exec(ctx, c.client, []string{key}, []string{strconv.Itoa(ttl)}).AsInt64()
+}
+
+// indexAddScript adds a member scored by its expiry to a sorted set, drops the expired members and keeps the
+// set as long as its longest-lived member. Running as one script, concurrent additions never lose members.
+var indexAddScript = valkey.NewLuaScript(`
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+local latest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if latest[2] then
+	redis.call('EXPIREAT', KEYS[1], latest[2])
+end
+return 1
+`)
+
+// IndexAdd records a member of an index until it expires, such as a token in a user's token index
+func (c *Client) IndexAdd(ctx context.Context, key, member string, expiresAt time.Time) error {
+	args := []string{
+		strconv.FormatInt(expiresAt.Unix(), 10),
+		member,
+		strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	return indexAddScript.Exec(ctx, c.client, []string{key}, args).Error()
+}
+
+// IndexMembers returns the members of an index that have not expired yet, with their expiry
+func (c *Client) IndexMembers(ctx context.Context, key string) (map[string]time.Time, error) {
+	// Members expiring this second or before are left out, as IndexAdd drops them
+	after := "(" + strconv.FormatInt(time.Now().Unix(), 10)
+	scores, err := c.client.Do(ctx, c.client.B().Zrange().Key(key).Min(after).Max("+inf").Byscore().Withscores().Build()).AsZScores()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return map[string]time.Time{}, nil
+		}
+		return nil, err
+	}
+
+	members := make(map[string]time.Time, len(scores))
+	for _, score := range scores {
+		members[score.Member] = time.Unix(int64(score.Score), 0)
+	}
+	return members, nil
+}
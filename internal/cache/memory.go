@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// errWrongType is returned for index operations on a key holding a string, as Valkey does
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// memoryEntry is a value kept by Memory with its expiry
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+	index     map[string]time.Time // members of an index, with their expiry
+}
+
+// Memory keeps values in memory with the same semantics as Client, so tests can run without Valkey
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]*memoryEntry)}
+}
+
+// entry returns the live entry of a key, dropping it once expired. The caller holds the lock.
+func (m *Memory) entry(key string) (*memoryEntry, bool) {
+	entry, ok := m.entries[key]
+	if ok && !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return entry, ok
+}
+
+// Get returns the value of a key, or valkey.Nil when it doesn't exist
+func (m *Memory) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entry(key)
+	if !ok || entry.index != nil {
+		return "", valkey.Nil
+	}
+	return entry.value, nil
+}
+
+// Set stores a value for ttl seconds
+func (m *Memory) Set(ctx context.Context, key, value string, ttl int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = &memoryEntry{value: value, expiresAt: time.Now().Add(time.Duration(ttl) * time.Second)}
+	return nil
+}
+
+// Delete removes a key
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// GetDel atomically reads and deletes a key, for single-use values
+func (m *Memory) GetDel(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entry(key)
+	if !ok || entry.index != nil {
+		return "", valkey.Nil
+	}
+	delete(m.entries, key)
+	return entry.value, nil
+}
+
+// DeleteIfEqual atomically deletes a key only while it holds value, reporting whether it did
+func (m *Memory) DeleteIfEqual(ctx context.Context, key, value string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entry(key)
+	if !ok || entry.index != nil || entry.value != value {
+		return false, nil
+	}
+	delete(m.entries, key)
+	return true, nil
+}
+
+// SetNX sets a key only if it does not exist yet, reporting whether it was set
+func (m *Memory) SetNX(ctx context.Context, key, value string, ttl int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entry(key); ok {
+		return false, nil
+	}
+	m.entries[key] = &memoryEntry{value: value, expiresAt: time.Now().Add(time.Duration(ttl) * time.Second)}
+	return true, nil
+}
+
+// Incr atomically increments a counter and returns its new value; a new counter expires after ttl seconds
+func (m *Memory) Incr(ctx context.Context, key string, ttl int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entry(key)
+	if ok && entry.index != nil {
+		return 0, errWrongType
+	}
+	if !ok {
+		entry = &memoryEntry{value: "0", expiresAt: time.Now().Add(time.Duration(ttl) * time.Second)}
+		m.entries[key] = entry
+	}
+
+	count, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return 0, errors.New("ERR value is not an integer or out of range")
+	}
+	count++
+	entry.value = strconv.FormatInt(count, 10)
+	return count, nil
+}
+
+// IndexAdd records a member of an index until it expires, dropping the expired members
+func (m *Memory) IndexAdd(ctx context.Context, key, member string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entry(key)
+	if ok && entry.index == nil {
+		return errWrongType
+	}
+	if !ok {
+		entry = &memoryEntry{index: make(map[string]time.Time)}
+		m.entries[key] = entry
+	}
+
+	entry.index[member] = expiresAt.Truncate(time.Second)
+	now := time.Now()
+	entry.expiresAt = time.Time{}
+	for member, memberExpiresAt := range entry.index {
+		if !memberExpiresAt.After(now) {
+			delete(entry.index, member)
+			continue
+		}
+		if memberExpiresAt.After(entry.expiresAt) {
+			entry.expiresAt = memberExpiresAt
+		}
+	}
+	if len(entry.index) == 0 {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// IndexMembers returns the members of an index that have not expired yet, with their expiry
+func (m *Memory) IndexMembers(ctx context.Context, key string) (map[string]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make(map[string]time.Time)
+	entry, ok := m.entry(key)
+	if !ok {
+		return members, nil
+	}
+	if entry.index == nil {
+		return nil, errWrongType
+	}
+
+	now := time.Now()
+	for member, expiresAt := range entry.index {
+		if expiresAt.After(now) {
+			members[member] = expiresAt
+		}
+	}
+	return members, nil
+}
@@ -114,6 +114,7 @@ type SecurityAnalytics struct {
 	FailedLoginIPs        []FailedLoginIP          `json:"failed_login_ips"`
 	PermissionUsage       []PermissionUsage        `json:"permission_usage"`
 	SecurityEventsTrend   []SecurityEventTrend     `json:"security_events_trend"`
+	RefreshTokenReuse     int64                    `json:"refresh_token_reuse"`
 }
 
 type FailedLoginIP struct {
@@ -365,12 +366,16 @@ func (h *AnalyticsHandler) GetSecurityAnalytics(c *fiber.Ctx) error {
 	// Get security events trend
 	securityEventsTrend := h.getSecurityEventsTrend(startTime, endTime)
 
+	// Get replayed refresh tokens (each one revoked a token family)
+	refreshTokenReuse := h.getRefreshTokenReuse(startTime, endTime)
+
 	analytics := SecurityAnalytics{
 		SuspiciousActivities: suspiciousActivities,
 		BlockedIPs:          0, // Mock data for now
 		FailedLoginIPs:      failedLoginIPs,
 		PermissionUsage:     permissionUsage,
 		SecurityEventsTrend: securityEventsTrend,
+		RefreshTokenReuse:   refreshTokenReuse,
 	}
 
 	return c.JSON(fiber.Map{
@@ -428,7 +433,7 @@ func (h *AnalyticsHandler) getLoginTrends(startTime, endTime time.Time) []Authen
 			Count(&successful)
 		
 		h.db.Model(&models.AuditLog{}).
-			Where("action = ? AND created_at >= ? AND created_at < ?", models.ActionLoginFailed, dayStart, dayEnd).
+			Where("action IN ? AND created_at >= ? AND created_at < ?", []models.AuditAction{models.ActionLoginFailed, models.ActionTokenReuse}, dayStart, dayEnd).
 			Count(&failed)
 		
 		trends = append(trends, AuthenticationTrendPoint{
@@ -598,6 +603,15 @@ func (h *AnalyticsHandler) getSuspiciousActivities(startTime, endTime time.Time)
 	return count
 }
 
+func (h *AnalyticsHandler) getRefreshTokenReuse(startTime, endTime time.Time) int64 {
+	var count int64
+	h.db.Model(&models.AuditLog{}).
+		Where("action = ? AND created_at BETWEEN ? AND ?", models.ActionTokenReuse, startTime, endTime).
+		Count(&count)
+	
+	return count
+}
+
 func (h *AnalyticsHandler) getFailedLoginIPs(startTime, endTime time.Time) []FailedLoginIP {
 	var results []struct {
 		IPAddress   string
@@ -647,7 +661,7 @@ func (h *AnalyticsHandler) getSecurityEventsTrend(startTime, endTime time.Time)
 		
 		var events int64
 		h.db.Model(&models.AuditLog{}).
-			Where("action = ? AND created_at >= ? AND created_at < ?", models.ActionLoginFailed, dayStart, dayEnd).
+			Where("action IN ? AND created_at >= ? AND created_at < ?", []models.AuditAction{models.ActionLoginFailed, models.ActionTokenReuse}, dayStart, dayEnd).
 			Count(&events)
 		
 		trends = append(trends, SecurityEventTrend{
@@ -13,14 +13,26 @@ import (
 
 // CreateApplicationRequest represents the create application request payload
 type CreateApplicationRequest struct {
-	Name        string `json:"name" validate:"required,min=2,max=100"`
-	Description string `json:"description" validate:"max=500"`
+	Name              string   `json:"name" validate:"required,min=2,max=100"`
+	Description       string   `json:"description" validate:"max=500"`
+	RedirectURIs      []string `json:"redirect_uris,omitempty"`
+	ClientType        string   `json:"client_type,omitempty" validate:"omitempty,oneof=confidential public"`
+	AllowedGrantTypes []string `json:"allowed_grant_types,omitempty"`
+	SessionPolicy     *ApplicationSessionPolicy `json:"session_policy,omitempty"`
+	WebAuthn          *ApplicationWebAuthnSettings `json:"webauthn,omitempty"`
+	Registration      *ApplicationRegistrationSettings `json:"registration,omitempty"`
 }
 
 // UpdateApplicationRequest represents the update application request payload  
 type UpdateApplicationRequest struct {
-	Name        *string `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
-	Description *string `json:"description,omitempty" validate:"omitempty,max=500"`
+	Name              *string   `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
+	Description       *string   `json:"description,omitempty" validate:"omitempty,max=500"`
+	RedirectURIs      *[]string `json:"redirect_uris,omitempty"`
+	ClientType        *string   `json:"client_type,omitempty" validate:"omitempty,oneof=confidential public"`
+	AllowedGrantTypes *[]string `json:"allowed_grant_types,omitempty"`
+	SessionPolicy     *ApplicationSessionPolicy `json:"session_policy,omitempty"` // replaces the whole policy
+	WebAuthn          *ApplicationWebAuthnSettings `json:"webauthn,omitempty"`       // replaces all WebAuthn settings
+	Registration      *ApplicationRegistrationSettings `json:"registration,omitempty"` // replaces all registration settings
 }
 
 // ApplicationResponse represents an application in API responses
@@ -30,12 +42,49 @@ type ApplicationResponse struct {
 	Description string    `json:"description"`
 	IsSystem    bool      `json:"is_system"`
 	APIKey      string    `json:"api_key,omitempty"` // Only shown to admins
+	RedirectURIs      []string `json:"redirect_uris"`
+	ClientType        string   `json:"client_type"`
+	AllowedGrantTypes []string `json:"allowed_grant_types"`
+	SessionPolicy     ApplicationSessionPolicy `json:"session_policy"`
+	WebAuthn          ApplicationWebAuthnSettings `json:"webauthn"`
+	Registration      ApplicationRegistrationSettings `json:"registration"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	UserCount   int64     `json:"user_count,omitempty"`
 	RoleCount   int64     `json:"role_count,omitempty"`
 }
 
+// ApplicationSessionPolicy represents an application's token lifetimes and session limits in seconds;
+// 0 uses the service default (or no limit). The access token format is jwt (default) or opaque.
+type ApplicationSessionPolicy struct {
+	AccessTokenTTL        int    `json:"access_token_ttl"`
+	RefreshTokenTTL       int    `json:"refresh_token_ttl"`
+	SessionLifetime       int    `json:"session_lifetime"`
+	IdleTimeout           int    `json:"idle_timeout"`
+	MaxConcurrentSessions int    `json:"max_concurrent_sessions"`
+	AccessTokenFormat     string `json:"access_token_format,omitempty" validate:"omitempty,oneof=jwt opaque"`
+	RequireDPoP           bool   `json:"require_dpop"`           // tokens can only be obtained with a DPoP proof
+	RequireVerifiedEmail  bool   `json:"require_verified_email"` // users sign in only after verifying their email
+}
+
+// ApplicationWebAuthnSettings represents the WebAuthn relying party users register passkeys for.
+// Origins must be on the RP ID or its subdomains; passkeys are disabled without an RP ID.
+type ApplicationWebAuthnSettings struct {
+	RPID    string   `json:"rp_id"`
+	RPName  string   `json:"rp_name"`
+	Origins []string `json:"origins"`
+}
+
+// ApplicationRegistrationSettings represents who may register themselves with an application at /auth/register.
+// Any email domain may register when none are listed; default roles must be roles of the application. When domains
+// are listed, registered users get the default roles only once they verify their email.
+type ApplicationRegistrationSettings struct {
+	Enabled         bool        `json:"enabled"`
+	AllowedDomains  []string    `json:"allowed_domains"`
+	DefaultRoleIDs  []uuid.UUID `json:"default_role_ids"`
+	RequireApproval bool        `json:"require_approval"` // registered users stay inactive until an admin approves them
+}
+
 // ApplicationWithStatsResponse represents an application with detailed statistics
 type ApplicationWithStatsResponse struct {
 	ApplicationResponse
@@ -155,6 +204,12 @@ func (h *ApplicationHandler) GetApplications(c *fiber.Ctx) error {
 			Name:        app.Name,
 			Description: app.Description,
 			IsSystem:    app.IsSystem,
+			RedirectURIs:      app.RedirectURIs,
+			ClientType:        string(app.ClientType),
+			AllowedGrantTypes: app.AllowedGrantTypes,
+			SessionPolicy:     newApplicationSessionPolicy(&app),
+			WebAuthn:          newApplicationWebAuthnSettings(&app),
+			Registration:      newApplicationRegistrationSettings(&app),
 			CreatedAt:   app.CreatedAt,
 			UpdatedAt:   app.UpdatedAt,
 		}
@@ -242,11 +297,60 @@ func (h *ApplicationHandler) CreateApplication(c *fiber.Ctx) error {
 		})
 	}
 
+	// Validate OAuth client settings
+	clientType := models.ClientType(req.ClientType)
+	if clientType == "" {
+		clientType = models.ClientTypeConfidential
+	}
+	if message := validateOAuthClientSettings(req.RedirectURIs, clientType, req.AllowedGrantTypes); message != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: message,
+		})
+	}
+	if req.SessionPolicy != nil {
+		if message := req.SessionPolicy.validate(); message != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: message,
+			})
+		}
+	}
+	if req.WebAuthn != nil {
+		if message := req.WebAuthn.validate(); message != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: message,
+			})
+		}
+	}
+	if req.Registration != nil {
+		// A new application has no roles yet, so default roles are set once they exist
+		if message := req.Registration.validate(h.db, uuid.Nil); message != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: message,
+			})
+		}
+	}
+
 	// Create new application
 	application := models.Application{
-		Name:        req.Name,
-		Description: req.Description,
-		IsSystem:    false, // New applications are never system applications
+		Name:              req.Name,
+		Description:       req.Description,
+		IsSystem:          false, // New applications are never system applications
+		RedirectURIs:      req.RedirectURIs,
+		ClientType:        clientType,
+		AllowedGrantTypes: req.AllowedGrantTypes, // defaults are applied on create when omitted
+	}
+	if req.SessionPolicy != nil {
+		req.SessionPolicy.applyTo(&application)
+	}
+	if req.WebAuthn != nil {
+		req.WebAuthn.applyTo(&application)
+	}
+	if req.Registration != nil {
+		req.Registration.applyTo(&application)
 	}
 
 	// Save application to database (API key will be auto-generated)
@@ -263,9 +367,15 @@ func (h *ApplicationHandler) CreateApplication(c *fiber.Ctx) error {
 	models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionApplicationCreate, "application",
 		&appIDStr,
 		map[string]interface{}{
-			"name":        application.Name,
-			"description": application.Description,
-			"api_key":     application.APIKey,
+			"name":                application.Name,
+			"description":         application.Description,
+			"api_key":             application.APIKey,
+			"redirect_uris":       application.RedirectURIs,
+			"client_type":         application.ClientType,
+			"allowed_grant_types": application.AllowedGrantTypes,
+			"session_policy":      newApplicationSessionPolicy(&application),
+			"webauthn":            newApplicationWebAuthnSettings(&application),
+			"registration":        newApplicationRegistrationSettings(&application),
 		}, &clientIP, &userAgent)
 
 	return c.Status(fiber.StatusCreated).JSON(ApplicationResponse{
@@ -274,6 +384,12 @@ func (h *ApplicationHandler) CreateApplication(c *fiber.Ctx) error {
 		Description: application.Description,
 		IsSystem:    application.IsSystem,
 		APIKey:      application.APIKey,
+		RedirectURIs:      application.RedirectURIs,
+		ClientType:        string(application.ClientType),
+		AllowedGrantTypes: application.AllowedGrantTypes,
+			SessionPolicy:     newApplicationSessionPolicy(&application),
+			WebAuthn:          newApplicationWebAuthnSettings(&application),
+			Registration:      newApplicationRegistrationSettings(&application),
 		CreatedAt:   application.CreatedAt,
 		UpdatedAt:   application.UpdatedAt,
 	})
@@ -344,6 +460,12 @@ func (h *ApplicationHandler) GetApplication(c *fiber.Ctx) error {
 			Name:        application.Name,
 			Description: application.Description,
 			IsSystem:    application.IsSystem,
+			RedirectURIs:      application.RedirectURIs,
+			ClientType:        string(application.ClientType),
+			AllowedGrantTypes: application.AllowedGrantTypes,
+			SessionPolicy:     newApplicationSessionPolicy(&application),
+			WebAuthn:          newApplicationWebAuthnSettings(&application),
+			Registration:      newApplicationRegistrationSettings(&application),
 			CreatedAt:   application.CreatedAt,
 			UpdatedAt:   application.UpdatedAt,
 		},
@@ -459,8 +581,14 @@ func (h *ApplicationHandler) UpdateApplication(c *fiber.Ctx) error {
 
 	// Store original values for audit logging
 	originalValues := map[string]interface{}{
-		"name":        application.Name,
-		"description": application.Description,
+		"name":                application.Name,
+		"description":         application.Description,
+		"redirect_uris":       application.RedirectURIs,
+		"client_type":         application.ClientType,
+		"allowed_grant_types": application.AllowedGrantTypes,
+		"session_policy":      newApplicationSessionPolicy(&application),
+		"webauthn":            newApplicationWebAuthnSettings(&application),
+		"registration":        newApplicationRegistrationSettings(&application),
 	}
 
 	// Prevent modification of system application name
@@ -487,6 +615,50 @@ func (h *ApplicationHandler) UpdateApplication(c *fiber.Ctx) error {
 	if req.Description != nil {
 		application.Description = *req.Description
 	}
+	if req.RedirectURIs != nil {
+		application.RedirectURIs = *req.RedirectURIs
+	}
+	if req.ClientType != nil {
+		application.ClientType = models.ClientType(*req.ClientType)
+	}
+	if req.AllowedGrantTypes != nil {
+		application.AllowedGrantTypes = *req.AllowedGrantTypes
+	}
+	if req.SessionPolicy != nil {
+		if message := req.SessionPolicy.validate(); message != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: message,
+			})
+		}
+		req.SessionPolicy.applyTo(&application)
+	}
+	if req.WebAuthn != nil {
+		if message := req.WebAuthn.validate(); message != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: message,
+			})
+		}
+		req.WebAuthn.applyTo(&application)
+	}
+	if req.Registration != nil {
+		if message := req.Registration.validate(h.db, application.ID); message != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: message,
+			})
+		}
+		req.Registration.applyTo(&application)
+	}
+
+	// Validate OAuth client settings
+	if message := validateOAuthClientSettings(application.RedirectURIs, application.ClientType, application.AllowedGrantTypes); message != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: message,
+		})
+	}
 
 	// Save updates
 	if err := h.db.Save(&application).Error; err != nil {
@@ -499,8 +671,14 @@ func (h *ApplicationHandler) UpdateApplication(c *fiber.Ctx) error {
 
 	// Log the update
 	newValues := map[string]interface{}{
-		"name":        application.Name,
-		"description": application.Description,
+		"name":                application.Name,
+		"description":         application.Description,
+		"redirect_uris":       application.RedirectURIs,
+		"client_type":         application.ClientType,
+		"allowed_grant_types": application.AllowedGrantTypes,
+		"session_policy":      newApplicationSessionPolicy(&application),
+		"webauthn":            newApplicationWebAuthnSettings(&application),
+		"registration":        newApplicationRegistrationSettings(&application),
 	}
 
 	appIDStr := application.ID.String()
@@ -516,6 +694,12 @@ func (h *ApplicationHandler) UpdateApplication(c *fiber.Ctx) error {
 		Name:        application.Name,
 		Description: application.Description,
 		IsSystem:    application.IsSystem,
+		RedirectURIs:      application.RedirectURIs,
+		ClientType:        string(application.ClientType),
+		AllowedGrantTypes: application.AllowedGrantTypes,
+			SessionPolicy:     newApplicationSessionPolicy(&application),
+			WebAuthn:          newApplicationWebAuthnSettings(&application),
+			Registration:      newApplicationRegistrationSettings(&application),
 		CreatedAt:   application.CreatedAt,
 		UpdatedAt:   application.UpdatedAt,
 	})
@@ -596,9 +780,15 @@ func (h *ApplicationHandler) DeleteApplication(c *fiber.Ctx) error {
 	models.CreateAuditLog(h.db, &currentUserID, &currentAppID, models.ActionApplicationDelete, "application",
 		&appIDStr,
 		map[string]interface{}{
-			"name":        application.Name,
-			"description": application.Description,
-			"api_key":     application.APIKey,
+			"name":                application.Name,
+			"description":         application.Description,
+			"api_key":             application.APIKey,
+			"redirect_uris":       application.RedirectURIs,
+			"client_type":         application.ClientType,
+			"allowed_grant_types": application.AllowedGrantTypes,
+			"session_policy":      newApplicationSessionPolicy(&application),
+			"webauthn":            newApplicationWebAuthnSettings(&application),
+			"registration":        newApplicationRegistrationSettings(&application),
 		}, &clientIP, &userAgent)
 
 	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
@@ -696,4 +886,161 @@ func (h *ApplicationHandler) RegenerateAPIKey(c *fiber.Ctx) error {
 		Message: "API key regenerated successfully",
 		APIKey:  application.APIKey,
 	})
-}
\ No newline at end of file
+}
+
+// validateOAuthClientSettings checks redirect URIs, client type and grant types, returning an error message when invalid
+func validateOAuthClientSettings(redirectURIs []string, clientType models.ClientType, grantTypes []string) string {
+	if !models.IsValidClientType(clientType) {
+		return "Invalid client type"
+	}
+
+	for _, redirectURI := range redirectURIs {
+		if err := models.ValidateRedirectURI(redirectURI); err != nil {
+			return "Invalid redirect URI " + redirectURI + ": " + err.Error()
+		}
+	}
+
+	for _, grantType := range grantTypes {
+		if !models.IsValidGrantType(grantType) {
+			return "Unsupported grant type " + grantType
+		}
+	}
+
+	return ""
+}
+
+// newApplicationSessionPolicy returns the session policy settings of an application
+func newApplicationSessionPolicy(app *models.Application) ApplicationSessionPolicy {
+	return ApplicationSessionPolicy{
+		AccessTokenTTL:        app.AccessTokenTTL,
+		RefreshTokenTTL:       app.RefreshTokenTTL,
+		SessionLifetime:       app.SessionLifetime,
+		IdleTimeout:           app.IdleTimeout,
+		MaxConcurrentSessions: app.MaxConcurrentSessions,
+		AccessTokenFormat:     string(app.AccessTokenFormat),
+		RequireDPoP:           app.RequireDPoP,
+		RequireVerifiedEmail:  app.RequireVerifiedEmail,
+	}
+}
+
+// validate checks the session policy settings, returning an error message when invalid
+func (p *ApplicationSessionPolicy) validate() string {
+	if p.AccessTokenTTL < 0 || p.RefreshTokenTTL < 0 || p.SessionLifetime < 0 || p.IdleTimeout < 0 || p.MaxConcurrentSessions < 0 {
+		return "Session policy values cannot be negative"
+	}
+
+	if p.AccessTokenTTL > 0 && p.RefreshTokenTTL > 0 && p.RefreshTokenTTL < p.AccessTokenTTL {
+		return "Refresh token TTL cannot be shorter than access token TTL"
+	}
+
+	if p.AccessTokenFormat != "" && !models.IsValidAccessTokenFormat(models.AccessTokenFormat(p.AccessTokenFormat)) {
+		return "Invalid access token format"
+	}
+
+	return ""
+}
+
+// applyTo copies the session policy settings onto an application
+func (p *ApplicationSessionPolicy) applyTo(app *models.Application) {
+	app.AccessTokenTTL = p.AccessTokenTTL
+	app.RefreshTokenTTL = p.RefreshTokenTTL
+	app.SessionLifetime = p.SessionLifetime
+	app.IdleTimeout = p.IdleTimeout
+	app.MaxConcurrentSessions = p.MaxConcurrentSessions
+	app.RequireDPoP = p.RequireDPoP
+	app.RequireVerifiedEmail = p.RequireVerifiedEmail
+	app.AccessTokenFormat = models.AccessTokenFormat(p.AccessTokenFormat)
+	if app.AccessTokenFormat == "" {
+		app.AccessTokenFormat = models.AccessTokenFormatJWT
+	}
+}
+
+// newApplicationWebAuthnSettings returns the WebAuthn relying party settings of an application
+func newApplicationWebAuthnSettings(app *models.Application) ApplicationWebAuthnSettings {
+	return ApplicationWebAuthnSettings{
+		RPID:    app.WebAuthnRPID,
+		RPName:  app.WebAuthnRPName,
+		Origins: app.WebAuthnOrigins,
+	}
+}
+
+// validate checks the WebAuthn settings, returning an error message when invalid
+func (w *ApplicationWebAuthnSettings) validate() string {
+	if err := models.ValidateWebAuthnSettings(w.RPID, w.Origins); err != nil {
+		return "Invalid WebAuthn settings: " + err.Error()
+	}
+	if w.RPID != "" && len(w.Origins) == 0 {
+		return "Invalid WebAuthn settings: at least one origin is required"
+	}
+	if len(w.RPName) > 100 {
+		return "Invalid WebAuthn settings: RP name cannot exceed 100 characters"
+	}
+	return ""
+}
+
+// applyTo copies the WebAuthn settings onto an application
+func (w *ApplicationWebAuthnSettings) applyTo(app *models.Application) {
+	app.WebAuthnRPID = w.RPID
+	app.WebAuthnRPName = w.RPName
+	app.WebAuthnOrigins = w.Origins
+	if app.WebAuthnOrigins == nil {
+		app.WebAuthnOrigins = []string{}
+	}
+}
+
+// newApplicationRegistrationSettings returns the self-service registration settings of an application
+func newApplicationRegistrationSettings(app *models.Application) ApplicationRegistrationSettings {
+	return ApplicationRegistrationSettings{
+		Enabled:         app.RegistrationEnabled,
+		AllowedDomains:  app.RegistrationDomains,
+		DefaultRoleIDs:  app.RegistrationRoleIDs,
+		RequireApproval: app.RegistrationRequireApproval,
+	}
+}
+
+// validate checks the registration settings against the roles of the application, returning an error message when invalid
+func (r *ApplicationRegistrationSettings) validate(db *gorm.DB, applicationID uuid.UUID) string {
+	for _, domain := range r.AllowedDomains {
+		if _, err := models.NormalizeRegistrationDomain(domain); err != nil {
+			return "Invalid registration settings: " + err.Error()
+		}
+	}
+
+	if len(r.DefaultRoleIDs) > 0 {
+		var count int64
+		if err := db.Model(&models.Role{}).Where("id IN ? AND application_id = ?", r.DefaultRoleIDs, applicationID).Count(&count).Error; err != nil {
+			return "Invalid registration settings: default roles could not be checked"
+		}
+		if count != int64(len(uniqueRoleIDs(r.DefaultRoleIDs))) {
+			return "Invalid registration settings: default roles must be roles of the application"
+		}
+	}
+	return ""
+}
+
+// applyTo copies the registration settings onto an application
+func (r *ApplicationRegistrationSettings) applyTo(app *models.Application) {
+	app.RegistrationEnabled = r.Enabled
+	app.RegistrationRequireApproval = r.RequireApproval
+
+	app.RegistrationDomains = []string{}
+	for _, domain := range r.AllowedDomains {
+		normalized, _ := models.NormalizeRegistrationDomain(domain)
+		app.RegistrationDomains = append(app.RegistrationDomains, normalized)
+	}
+
+	app.RegistrationRoleIDs = uniqueRoleIDs(r.DefaultRoleIDs)
+}
+
+// uniqueRoleIDs returns role IDs without duplicates, in their original order
+func uniqueRoleIDs(roleIDs []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(roleIDs))
+	unique := []uuid.UUID{}
+	for _, roleID := range roleIDs {
+		if !seen[roleID] {
+			seen[roleID] = true
+			unique = append(unique, roleID)
+		}
+	}
+	return unique
+}
@@ -32,6 +32,14 @@ type AuditLogsListResponse struct {
 	Pagination PaginationMeta                 `json:"pagination"`
 }
 
+// NotificationsListResponse represents the notification deliveries list response
+type NotificationsListResponse struct {
+	Success       bool                            `json:"success"`
+	Message       string                          `json:"message"`
+	Notifications []services.NotificationResponse `json:"notifications"`
+	Pagination    PaginationMeta                  `json:"pagination"`
+}
+
 // AuditStatsResponse represents the audit statistics response
 type AuditStatsResponse struct {
 	Success bool                    `json:"success"`
@@ -364,6 +372,139 @@ func (h *AuditHandler) ExportAuditLogs(c *fiber.Ctx) error {
 	return c.Send(csvData)
 }
 
+// GetNotifications handles listing notification deliveries
+// @Summary List notification deliveries
+// @Description Get paginated list of notifications sent to users with their delivery status, newest first. Message bodies are never returned.
+// @Tags Audit
+// @Accept json
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page" default(50)
+// @Param user_id query string false "Filter by user ID (UUID)"
+// @Param application_id query string false "Filter by application ID (UUID)"
+// @Param kinds query string false "Filter by kinds (comma-separated)"
+// @Param statuses query string false "Filter by delivery statuses (comma-separated: pending, sent, failed)"
+// @Param recipient query string false "Filter by recipient email"
+// @Param start_date query string false "Start date (RFC3339 format)"
+// @Param end_date query string false "End date (RFC3339 format)"
+// @Security BearerAuth
+// @Success 200 {object} NotificationsListResponse "Notification deliveries list"
+// @Failure 400 {object} ErrorResponse "Invalid request parameters"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /audit-logs/notifications [get]
+func (h *AuditHandler) GetNotifications(c *fiber.Ctx) error {
+	// Extract user context
+	_, _, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Parse query parameters
+	query := services.NotificationQuery{
+		Page:    parseIntQuery(c, "page", 1),
+		PerPage: parseIntQuery(c, "per_page", 50),
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PerPage < 1 || query.PerPage > 1000 {
+		query.PerPage = 50
+	}
+
+	// Parse UUID filters
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		if userID, err := uuid.Parse(userIDStr); err == nil {
+			query.UserID = &userID
+		} else {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Invalid user_id format",
+			})
+		}
+	}
+
+	if appIDStr := c.Query("application_id"); appIDStr != "" {
+		if appID, err := uuid.Parse(appIDStr); err == nil {
+			query.ApplicationID = &appID
+		} else {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Invalid application_id format",
+			})
+		}
+	}
+
+	// Parse array filters
+	if kindsStr := c.Query("kinds"); kindsStr != "" {
+		query.Kinds = strings.Split(kindsStr, ",")
+		for i, kind := range query.Kinds {
+			query.Kinds[i] = strings.TrimSpace(kind)
+		}
+	}
+
+	if statusesStr := c.Query("statuses"); statusesStr != "" {
+		query.Statuses = strings.Split(statusesStr, ",")
+		for i, status := range query.Statuses {
+			query.Statuses[i] = strings.TrimSpace(status)
+		}
+	}
+
+	if recipient := c.Query("recipient"); recipient != "" {
+		query.Recipient = &recipient
+	}
+
+	// Parse date filters
+	if startDateStr := c.Query("start_date"); startDateStr != "" {
+		if startDate, err := time.Parse(time.RFC3339, startDateStr); err == nil {
+			query.StartDate = &startDate
+		} else {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Invalid start_date format, use RFC3339",
+			})
+		}
+	}
+
+	if endDateStr := c.Query("end_date"); endDateStr != "" {
+		if endDate, err := time.Parse(time.RFC3339, endDateStr); err == nil {
+			query.EndDate = &endDate
+		} else {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Invalid end_date format, use RFC3339",
+			})
+		}
+	}
+
+	// Execute query
+	notifications, total, err := h.auditService.QueryNotifications(query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to retrieve notifications",
+		})
+	}
+
+	// Calculate pagination metadata
+	totalPages := int((total + int64(query.PerPage) - 1) / int64(query.PerPage))
+
+	return c.Status(fiber.StatusOK).JSON(NotificationsListResponse{
+		Success:       true,
+		Message:       "Notifications retrieved successfully",
+		Notifications: notifications,
+		Pagination: PaginationMeta{
+			Page:       query.Page,
+			PerPage:    query.PerPage,
+			Total:      total,
+			TotalPages: totalPages,
+		},
+	})
+}
+
 // GetAuditOptions handles retrieving available audit actions and resources
 // @Summary Get audit options
 // @Description Get list of available audit actions and resources for filtering
@@ -2,6 +2,10 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/efrenfuentes/authy/internal/middleware"
@@ -11,14 +15,20 @@ import (
 	"github.com/google/uuid"
 )
 
+// errRefreshTokenReused reports that an already rotated refresh token was presented again
+var errRefreshTokenReused = errors.New("refresh token reuse detected")
+
 // LoginRequest represents the login request payload
 type LoginRequest struct {
 	Email       string `json:"email" validate:"required,email"`
 	Password    string `json:"password" validate:"required"`
 	Application string `json:"application" validate:"required"`
+	Scope       string `json:"scope,omitempty"` // include "openid" to receive an id_token
+	Nonce       string `json:"nonce,omitempty"`
 }
 
-// LoginResponse represents the login response
+// LoginResponse represents the login response. Users who enrolled a second factor get an MFA challenge
+// instead of tokens, to complete at /auth/mfa/verify.
 type LoginResponse struct {
 	Success      bool              `json:"success"`
 	Message      string            `json:"message"`
@@ -26,6 +36,9 @@ type LoginResponse struct {
 	User         *UserInfo         `json:"user,omitempty"`
 	Application  *ApplicationInfo  `json:"application,omitempty"`
 	Permissions  []string          `json:"permissions,omitempty"`
+	MFARequired  bool              `json:"mfa_required,omitempty"`
+	MFAToken     string            `json:"mfa_token,omitempty"`   // short-lived MFA challenge
+	MFAMethods   []string          `json:"mfa_methods,omitempty"` // second factors the challenge accepts
 }
 
 // UserInfo represents user information in responses
@@ -54,30 +67,44 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
-// ValidateRequest represents the token validation request payload
+// ValidateRequest represents the token validation request payload.
+// The expected audience is the calling application when it authenticates (HTTP Basic or
+// client_id/client_secret), otherwise the audience parameter; without either any audience is accepted.
+// Resource servers validating a DPoP-bound token forward the proof with the method and URL of their request.
 type ValidateRequest struct {
-	Token string `json:"token" validate:"required"`
+	Token        string `json:"token" validate:"required"`
+	Audience     string `json:"audience,omitempty"` // application ID the token is presented to
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	DPoPProof    string `json:"dpop_proof,omitempty"` // DPoP header sent with the token
+	HTTPMethod   string `json:"htm,omitempty"`        // method of the request the token was sent with
+	HTTPURL      string `json:"htu,omitempty"`        // URL of the request the token was sent with
 }
 
 // ValidateResponse represents the token validation response
 type ValidateResponse struct {
-	Valid       bool         `json:"valid"`
-	User        *UserInfo    `json:"user,omitempty"`
-	Application *ApplicationInfo `json:"application,omitempty"`
-	Permissions []string     `json:"permissions,omitempty"`
-	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	Valid       bool               `json:"valid"`
+	User        *UserInfo          `json:"user,omitempty"`
+	Application *ApplicationInfo   `json:"application,omitempty"`
+	Permissions []string           `json:"permissions,omitempty"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty"`
+	Error       string             `json:"error,omitempty"` // "invalid_audience" or "invalid_dpop_proof"
+	Actor       *auth.Actor        `json:"act,omitempty"`   // party acting on behalf of the user (exchanged tokens)
+	Cnf         *auth.Confirmation `json:"cnf,omitempty"`   // DPoP key the token is bound to
 }
 
 // Login handles user authentication and token generation
 // @Summary User login
-// @Description Authenticate user and generate JWT tokens
+// @Description Authenticate user and generate JWT tokens. Users who enrolled TOTP or a passkey get an MFA challenge to complete at /auth/mfa/verify instead.
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param login body LoginRequest true "Login credentials"
+// @Param DPoP header string false "DPoP proof binding the issued tokens to the client's key"
 // @Success 200 {object} LoginResponse "Successful login"
 // @Failure 400 {object} ErrorResponse "Invalid request"
 // @Failure 401 {object} ErrorResponse "Invalid credentials"
+// @Failure 403 {object} ErrorResponse "Email address not verified"
 // @Failure 429 {object} ErrorResponse "Rate limit exceeded"
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
@@ -112,7 +139,7 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 
 	// Find the user
 	var user models.User
-	if err := h.db.Where("email = ? AND is_active = true", req.Email).First(&user).Error; err != nil {
+	if err := h.db.Where("LOWER(email) = LOWER(?) AND is_active = true", strings.TrimSpace(req.Email)).First(&user).Error; err != nil {
 		// Log failed login attempt
 		models.CreateAuditLog(h.db, nil, &app.ID, models.ActionLoginFailed, "authentication", nil,
 			map[string]interface{}{
@@ -141,6 +168,39 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
+	// Applications may refuse users until they verify their email address
+	if emailVerificationRequired(&app, &user) {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"email":  req.Email,
+				"reason": "email_not_verified",
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: emailNotVerifiedMessage,
+		})
+	}
+
+	// Users who enrolled a second factor get a challenge to complete instead of tokens
+	if methods := h.mfaMethods(&user, &app); len(methods) > 0 {
+		return h.startMFAChallenge(c, &user, &app, &req, methods, clientIP, userAgent)
+	}
+
+	return h.completeLogin(c, &user, &app, req.Scope, req.Nonce, auth.AuthenticationMethods(auth.AMRPassword), clientIP, userAgent)
+}
+
+// completeLogin issues the tokens of an authenticated user signing in to an application
+func (h *AuthHandler) completeLogin(c *fiber.Ctx, user *models.User, app *models.Application, scope, nonce string, amr []string, clientIP net.IP, userAgent string) error {
+	// Bind the tokens to the client's key when it sent a DPoP proof
+	dpopKey, err := h.verifyDPoP(c, app)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: dpopErrorDescription(err),
+		})
+	}
+
 	// Get user permissions for this application
 	permissions, err := h.getUserPermissions(user.ID, app.ID)
 	if err != nil {
@@ -151,8 +211,8 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Generate token pair
-	tokenPair, accessClaims, refreshClaims, err := h.sessionService.GenerateTokenPair(user.ID, app.ID, permissions)
+	// Generate and store token pair
+	tokenPair, accessClaims, err := h.issueTokenPair(user, app, permissions, dpopKey, amr, clientIP, userAgent)
 	if err != nil {
 		h.logger.Error("Failed to generate tokens", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
@@ -161,47 +221,26 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Store tokens in cache
-	ctx := context.Background()
-	if err := h.sessionService.StoreToken(ctx, tokenPair.AccessToken, accessClaims); err != nil {
-		h.logger.Error("Failed to store access token", "error", err)
-	}
-
-	if err := h.sessionService.StoreToken(ctx, tokenPair.RefreshToken, refreshClaims); err != nil {
-		h.logger.Error("Failed to store refresh token", "error", err)
-	}
-
-	// Store tokens in database for audit trail
-	accessToken := &models.Token{
-		UserID:        user.ID,
-		ApplicationID: app.ID,
-		TokenType:     models.AccessToken,
-		ExpiresAt:     accessClaims.ExpiresAt.Time,
-	}
-	accessToken.HashToken(tokenPair.AccessToken)
-
-	refreshToken := &models.Token{
-		UserID:        user.ID,
-		ApplicationID: app.ID,
-		TokenType:     models.RefreshToken,
-		ExpiresAt:     refreshClaims.ExpiresAt.Time,
-	}
-	refreshToken.HashToken(tokenPair.RefreshToken)
-
-	if err := h.db.Create(accessToken).Error; err != nil {
-		h.logger.Error("Failed to store access token in database", "error", err)
-	}
-
-	if err := h.db.Create(refreshToken).Error; err != nil {
-		h.logger.Error("Failed to store refresh token in database", "error", err)
+	// Issue an OpenID Connect id_token when requested
+	if auth.HasScope(scope, auth.ScopeOpenID) {
+		idToken, err := h.sessionService.GenerateIDToken(userIdentity(user), app.ID, nonce, accessClaims.IssuedAt.Time, tokenPair.AccessToken)
+		if err != nil {
+			h.logger.Error("Failed to generate id token", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Failed to generate tokens",
+			})
+		}
+		tokenPair.IDToken = idToken
 	}
 
 	// Log successful login
 	models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLogin, "authentication", nil,
 		map[string]interface{}{
-			"email":         req.Email,
+			"email":         user.Email,
 			"token_id":      accessClaims.ID,
 			"expires_at":    accessClaims.ExpiresAt.Time,
+			"amr":           amr,
 		}, &clientIP, &userAgent)
 
 	return c.Status(fiber.StatusOK).JSON(LoginResponse{
@@ -247,7 +286,7 @@ func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 
 	// Get the token from header
 	authHeader := c.Get("Authorization")
-	token := auth.ExtractTokenFromHeader(authHeader)
+	token, _ := auth.ExtractTokenAndScheme(authHeader)
 
 	// Get client IP for audit logging
 	clientIP := middleware.ExtractClientIP(c)
@@ -287,6 +326,7 @@ func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Param refresh body RefreshRequest true "Refresh token request"
+// @Param DPoP header string false "DPoP proof (required for refresh tokens bound to a key)"
 // @Success 200 {object} LoginResponse "New token pair"
 // @Failure 400 {object} ErrorResponse "Invalid request"
 // @Failure 401 {object} ErrorResponse "Invalid refresh token"
@@ -305,7 +345,13 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 	userAgent := c.Get("User-Agent")
 
 	// Get user permissions for new token
-	claims, err := h.sessionService.ValidateRefreshToken(context.Background(), req.RefreshToken)
+	claims, err := h.validateRefreshToken(context.Background(), req.RefreshToken, clientIP, userAgent)
+	if err == errRefreshTokenReused {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Refresh token reuse detected, session revoked",
+		})
+	}
 	if err != nil {
 		models.CreateAuditLog(h.db, nil, nil, models.ActionTokenRefresh, "authentication", nil,
 			map[string]interface{}{
@@ -329,8 +375,39 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 		})
 	}
 
+	// Get user and application info; the application's policy applies to the new tokens
+	var user models.User
+	var app models.Application
+
+	h.db.First(&user, claims.UserID)
+	if err := h.db.First(&app, claims.ApplicationID).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid refresh token",
+		})
+	}
+
+	// A bound refresh token can only be used with a proof of its key
+	dpopKey, err := h.verifyDPoP(c, &app)
+	if err != nil || !matchesDPoPKey(claims, dpopKey) {
+		message := "Invalid DPoP proof"
+		if err != nil {
+			message = dpopErrorDescription(err)
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: message,
+		})
+	}
+
 	// Generate new token pair and invalidate old refresh token
-	tokenPair, err := h.sessionService.RefreshTokenPair(context.Background(), req.RefreshToken, permissions)
+	tokenPair, err := h.rotateRefreshToken(context.Background(), req.RefreshToken, &app, permissions, dpopKey, clientIP, userAgent)
+	if err == errRefreshTokenReused {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Refresh token reuse detected, session revoked",
+		})
+	}
 	if err != nil {
 		models.CreateAuditLog(h.db, &claims.UserID, &claims.ApplicationID, models.ActionTokenRefresh, "authentication", nil,
 			map[string]interface{}{
@@ -344,13 +421,6 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get user and application info for response
-	var user models.User
-	var app models.Application
-
-	h.db.First(&user, claims.UserID)
-	h.db.First(&app, claims.ApplicationID)
-
 	// Log successful token refresh
 	models.CreateAuditLog(h.db, &claims.UserID, &claims.ApplicationID, models.ActionTokenRefresh, "authentication", nil,
 		map[string]interface{}{
@@ -379,13 +449,14 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 
 // ValidateToken handles token validation
 // @Summary Validate access token
-// @Description Validate token and return user information
+// @Description Validate token and return user information. Tokens issued for another application than the authenticated caller (or the audience parameter) are rejected with the invalid_audience error, DPoP-bound tokens presented without a valid proof with invalid_dpop_proof.
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param validate body ValidateRequest true "Token validation request"
 // @Success 200 {object} ValidateResponse "Token validation result"
 // @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Client authentication failed"
 // @Router /auth/validate [post]
 func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 	var req ValidateRequest
@@ -400,8 +471,37 @@ func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 	clientIP := middleware.ExtractClientIP(c)
 	userAgent := c.Get("User-Agent")
 
+	audience, err := h.validationAudience(c, &req)
+	if err == errInvalidClient {
+		models.CreateAuditLog(h.db, nil, nil, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"endpoint": "validate",
+				"reason":   "invalid_client",
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Client authentication failed",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid audience",
+		})
+	}
+
 	// Validate the token
-	claims, err := h.sessionService.ValidateToken(context.Background(), req.Token)
+	claims, err := h.sessionService.ValidateTokenForAudience(context.Background(), req.Token, audience)
+	var audienceErr *auth.AudienceError
+	if errors.As(err, &audienceErr) {
+		middleware.AuditAudienceRejection(c, h.db, audienceErr)
+
+		return c.Status(fiber.StatusOK).JSON(ValidateResponse{
+			Valid: false,
+			Error: "invalid_audience",
+		})
+	}
 	if err != nil {
 		models.CreateAuditLog(h.db, nil, nil, models.ActionTokenValidate, "authentication", nil,
 			map[string]interface{}{
@@ -414,6 +514,27 @@ func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 		})
 	}
 
+	// A bound token is only valid together with a proof of its key
+	if claims.Cnf != nil {
+		err := h.sessionService.VerifyTokenBinding(context.Background(), claims, req.DPoPProof, &auth.DPoPRequest{
+			Method:      req.HTTPMethod,
+			URL:         req.HTTPURL,
+			AccessToken: req.Token,
+		})
+		if err != nil {
+			models.CreateAuditLog(h.db, &claims.UserID, &claims.ApplicationID, models.ActionTokenValidate, "authentication", nil,
+				map[string]interface{}{
+					"valid":  false,
+					"reason": err.Error(),
+				}, &clientIP, &userAgent)
+
+			return c.Status(fiber.StatusOK).JSON(ValidateResponse{
+				Valid: false,
+				Error: "invalid_dpop_proof",
+			})
+		}
+	}
+
 	// Get user and application info
 	var user models.User
 	var app models.Application
@@ -430,11 +551,15 @@ func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 		})
 	}
 
-	// Log successful validation
+	// Log successful validation, naming the actor of exchanged tokens
+	validationDetails := map[string]interface{}{
+		"valid": true,
+	}
+	if claims.Act != nil {
+		validationDetails["actor"] = claims.Act
+	}
 	models.CreateAuditLog(h.db, &claims.UserID, &claims.ApplicationID, models.ActionTokenValidate, "authentication", nil,
-		map[string]interface{}{
-			"valid": true,
-		}, &clientIP, &userAgent)
+		validationDetails, &clientIP, &userAgent)
 
 	expiresAt := claims.ExpiresAt.Time
 	return c.Status(fiber.StatusOK).JSON(ValidateResponse{
@@ -453,9 +578,206 @@ func (h *AuthHandler) ValidateToken(c *fiber.Ctx) error {
 		},
 		Permissions: claims.Permissions,
 		ExpiresAt:   &expiresAt,
+		Actor:       claims.Act,
+		Cnf:         claims.Cnf,
 	})
 }
 
+// validationAudience determines which application a token is presented to for validation:
+// the authenticated calling application, else the audience parameter, else uuid.Nil for any
+func (h *AuthHandler) validationAudience(c *fiber.Ctx, req *ValidateRequest) (uuid.UUID, error) {
+	if _, _, ok := parseBasicAuth(c.Get(fiber.HeaderAuthorization)); ok || req.ClientID != "" {
+		app, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return app.ID, nil
+	}
+
+	if req.Audience == "" {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(req.Audience)
+}
+
+// issueTokenPair generates a token pair for a new session of the user in the application and records it in cache and database
+func (h *AuthHandler) issueTokenPair(user *models.User, app *models.Application, permissions []string, dpopKey string, amr []string, clientIP net.IP, userAgent string) (*auth.TokenPair, *auth.Claims, error) {
+	ctx := context.Background()
+
+	// Make room for the new session when the application limits concurrent sessions
+	if app.MaxConcurrentSessions > 0 {
+		h.evictExcessSessions(ctx, user.ID, app, clientIP, userAgent)
+	}
+
+	policy := boundSessionPolicy(app, dpopKey)
+	policy.AuthenticationMethods = amr
+	tokenPair, accessClaims, refreshClaims, err := h.sessionService.GenerateTokenPair(ctx, user.ID, app.ID, uuid.New(), permissions, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Store tokens in cache
+	if err := h.sessionService.StoreToken(ctx, tokenPair.AccessToken, accessClaims); err != nil {
+		h.logger.Error("Failed to store access token", "error", err)
+	}
+
+	if err := h.sessionService.StoreToken(ctx, tokenPair.RefreshToken, refreshClaims); err != nil {
+		h.logger.Error("Failed to store refresh token", "error", err)
+	}
+
+	h.recordTokenPair(refreshClaims.SessionID, tokenPair, accessClaims, refreshClaims, clientIP, userAgent)
+
+	return tokenPair, accessClaims, nil
+}
+
+// evictExcessSessions revokes the least recently refreshed sessions of a user in an application
+// so that a new session stays within the application's concurrent session limit
+func (h *AuthHandler) evictExcessSessions(ctx context.Context, userID uuid.UUID, app *models.Application, clientIP net.IP, userAgent string) {
+	sessionIDs, err := models.GetActiveSessionIDsInApplication(h.db, userID, app.ID)
+	if err != nil {
+		h.logger.Error("Failed to retrieve active sessions", "error", err)
+		return
+	}
+
+	for len(sessionIDs) >= app.MaxConcurrentSessions {
+		sessionID := sessionIDs[0]
+		sessionIDs = sessionIDs[1:]
+
+		revoked, err := revokeSession(ctx, h.db, h.sessionService, sessionID)
+		if err != nil {
+			h.logger.Error("Failed to revoke session", "session_id", sessionID, "error", err)
+			continue
+		}
+
+		sessionIDStr := sessionID.String()
+		models.CreateAuditLog(h.db, &userID, &app.ID, models.ActionTokenRevoke, "session", &sessionIDStr,
+			map[string]interface{}{
+				"reason":                  "max_concurrent_sessions",
+				"max_concurrent_sessions": app.MaxConcurrentSessions,
+				"revoked_tokens":          revoked,
+			}, &clientIP, &userAgent)
+	}
+}
+
+// validateRefreshToken validates a presented refresh token. Presenting a refresh token that
+// was already rotated revokes its whole family and returns errRefreshTokenReused.
+func (h *AuthHandler) validateRefreshToken(ctx context.Context, refreshToken string, clientIP net.IP, userAgent string) (*auth.Claims, error) {
+	claims, err := h.sessionService.ValidateRefreshToken(ctx, refreshToken)
+	if err != nil {
+		if previous, findErr := h.tokens.FindByHash(models.HashTokenString(refreshToken)); findErr == nil && previous.IsRotated() {
+			h.revokeRefreshTokenFamily(ctx, previous, clientIP, userAgent)
+			return nil, errRefreshTokenReused
+		}
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new token pair within the same family
+func (h *AuthHandler) rotateRefreshToken(ctx context.Context, refreshToken string, app *models.Application, permissions []string, dpopKey string, clientIP net.IP, userAgent string) (*auth.TokenPair, error) {
+	// Claim the rotation first so two requests racing with the same token cannot both succeed
+	previous, err := h.tokens.FindByHash(models.HashTokenString(refreshToken))
+	if err == nil {
+		rotated, err := h.tokens.MarkRotated(previous)
+		if err != nil {
+			return nil, err
+		}
+		if !rotated {
+			if current, err := h.tokens.FindByHash(previous.TokenHash); err == nil && current.IsRotated() {
+				h.revokeRefreshTokenFamily(ctx, current, clientIP, userAgent)
+				return nil, errRefreshTokenReused
+			}
+			return nil, auth.ErrInvalidToken
+		}
+	}
+
+	tokenPair, accessClaims, refreshClaims, err := h.sessionService.RefreshTokenPair(ctx, refreshToken, permissions, boundSessionPolicy(app, dpopKey))
+	if err != nil {
+		// No new pair was issued, so a retry with the same token is not a replay
+		if previous != nil {
+			restored, undoErr := h.tokens.UndoRotation(previous)
+			if undoErr != nil {
+				h.logger.Error("Failed to restore refresh token after failed rotation", "error", undoErr)
+			} else if !restored {
+				// Revoked while it was marked rotated, so revocation skipped it in the cache
+				if err := h.sessionService.InvalidateToken(ctx, refreshToken); err != nil {
+					h.logger.Error("Failed to invalidate revoked refresh token", "error", err)
+				}
+			}
+		}
+		return nil, err
+	}
+
+	h.recordTokenPair(refreshClaims.SessionID, tokenPair, accessClaims, refreshClaims, clientIP, userAgent)
+
+	return tokenPair, nil
+}
+
+// revokeRefreshTokenFamily revokes every token issued from the same login as a replayed refresh token
+// and records the replay as a security event
+func (h *AuthHandler) revokeRefreshTokenFamily(ctx context.Context, replayed *models.Token, clientIP net.IP, userAgent string) {
+	revoked := 0
+	if replayed.SessionID != nil {
+		var err error
+		if revoked, err = revokeSessionTokens(ctx, h.tokens, h.sessionService, *replayed.SessionID); err != nil {
+			h.logger.Error("Failed to revoke refresh token family", "session_id", *replayed.SessionID, "error", err)
+		}
+	}
+
+	h.logger.Warn("Refresh token reuse detected", "user_id", replayed.UserID, "application_id", replayed.ApplicationID, "session_id", replayed.SessionID)
+
+	tokenIDStr := replayed.ID.String()
+	models.CreateAuditLog(h.db, &replayed.UserID, &replayed.ApplicationID, models.ActionTokenReuse, "token", &tokenIDStr,
+		map[string]interface{}{
+			"session_id":     replayed.SessionID,
+			"rotated_at":     replayed.RotatedAt,
+			"revoked_tokens": revoked,
+		}, &clientIP, &userAgent)
+}
+
+// recordTokenPair stores a token pair in the database for audit trail and revocation, linked by session
+func (h *AuthHandler) recordTokenPair(sessionID uuid.UUID, tokenPair *auth.TokenPair, accessClaims, refreshClaims *auth.Claims, clientIP net.IP, userAgent string) {
+	h.recordToken(sessionID, models.AccessToken, tokenPair.AccessToken, accessClaims, clientIP, userAgent)
+	h.recordToken(sessionID, models.RefreshToken, tokenPair.RefreshToken, refreshClaims, clientIP, userAgent)
+}
+
+// recordToken stores a single token in the database for audit trail and revocation, linked by session
+func (h *AuthHandler) recordToken(sessionID uuid.UUID, tokenType models.TokenType, tokenString string, claims *auth.Claims, clientIP net.IP, userAgent string) {
+	var ipAddress, userAgentStr *string
+	if clientIP != nil {
+		ip := clientIP.String()
+		ipAddress = &ip
+	}
+	if userAgent != "" {
+		userAgentStr = &userAgent
+	}
+
+	token := &models.Token{
+		UserID:        claims.UserID,
+		ApplicationID: claims.ApplicationID,
+		TokenType:     tokenType,
+		SessionID:     &sessionID,
+		ExpiresAt:     claims.ExpiresAt.Time,
+		IPAddress:     ipAddress,
+		UserAgent:     userAgentStr,
+	}
+	token.HashToken(tokenString)
+
+	// Opaque access tokens carry no claims, so keep them for when the cache loses them
+	if auth.IsReferenceToken(tokenString) {
+		claimsJSON, err := json.Marshal(claims)
+		if err != nil {
+			h.logger.Error("Failed to encode token claims", "error", err)
+		}
+		token.Claims = claimsJSON
+	}
+
+	if err := h.tokens.Create(token); err != nil {
+		h.logger.Error("Failed to store token in database", "token_type", tokenType, "error", err)
+	}
+}
+
 // getUserPermissions retrieves user permissions for a specific application
 func (h *AuthHandler) getUserPermissions(userID, applicationID uuid.UUID) ([]string, error) {
 	return models.GetUserPermissionStrings(h.db, userID, applicationID)
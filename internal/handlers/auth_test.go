@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/cache"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/efrenfuentes/authy/pkg/logger"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// memoryTokenStore keeps token records in memory, following the conditions of the database queries
+type memoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[uuid.UUID]*models.Token
+
+	// afterMarkRotated runs once a token is marked rotated, before the rotation continues
+	afterMarkRotated func(token *models.Token)
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{tokens: make(map[uuid.UUID]*models.Token)}
+}
+
+func (m *memoryTokenStore) Create(token *models.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	stored := *token
+	m.tokens[token.ID] = &stored
+	return nil
+}
+
+func (m *memoryTokenStore) FindByHash(tokenHash string) (*models.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, token := range m.tokens {
+		if token.TokenHash == tokenHash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *memoryTokenStore) MarkRotated(token *models.Token) (bool, error) {
+	m.mu.Lock()
+	now := time.Now()
+	stored := m.tokens[token.ID]
+	if stored == nil || stored.RotatedAt != nil || !stored.ExpiresAt.After(now) {
+		m.mu.Unlock()
+		return false, nil
+	}
+	stored.RotatedAt = &now
+	stored.ExpiresAt = now
+	m.mu.Unlock()
+
+	token.RotatedAt = &now
+	if m.afterMarkRotated != nil {
+		m.afterMarkRotated(token)
+	}
+	return true, nil
+}
+
+func (m *memoryTokenStore) UndoRotation(token *models.Token) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if token.RotatedAt == nil {
+		return false, nil
+	}
+	stored := m.tokens[token.ID]
+	if stored == nil || stored.RotatedAt == nil || !stored.RotatedAt.Equal(*token.RotatedAt) ||
+		!stored.ExpiresAt.Equal(*token.RotatedAt) || stored.RevokedAt != nil {
+		return false, nil
+	}
+	stored.RotatedAt = nil
+	stored.ExpiresAt = token.ExpiresAt
+	token.RotatedAt = nil
+	return true, nil
+}
+
+func (m *memoryTokenStore) ValidSessionTokens(sessionID uuid.UUID) ([]models.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tokens []models.Token
+	for _, token := range m.tokens {
+		if token.SessionID != nil && *token.SessionID == sessionID && token.ExpiresAt.After(time.Now()) {
+			tokens = append(tokens, *token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *memoryTokenStore) InvalidateSession(sessionID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.SessionID == nil || *token.SessionID != sessionID || token.RevokedAt != nil {
+			continue
+		}
+		if !token.ExpiresAt.After(now) && token.RotatedAt == nil {
+			continue
+		}
+		if token.ExpiresAt.After(now) {
+			token.ExpiresAt = now
+		}
+		token.RevokedAt = &now
+	}
+	return nil
+}
+
+// session returns the recorded tokens of a session
+func (m *memoryTokenStore) session(sessionID uuid.UUID) []models.Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tokens []models.Token
+	for _, token := range m.tokens {
+		if token.SessionID != nil && *token.SessionID == sessionID {
+			tokens = append(tokens, *token)
+		}
+	}
+	return tokens
+}
+
+// newTestAuthHandler returns an AuthHandler backed by in-memory tokens and cache. Its database
+// only records the audit logs written through it, which are returned.
+func newTestAuthHandler(t *testing.T) (*AuthHandler, *memoryTokenStore, *[]models.AuditLog) {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.Open("postgres://localhost:1/authy"), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	audits := &[]models.AuditLog{}
+	err = db.Callback().Create().Before("gorm:create").Register("test:audit_logs", func(tx *gorm.DB) {
+		if auditLog, ok := tx.Statement.Dest.(*models.AuditLog); ok {
+			mu.Lock()
+			*audits = append(*audits, *auditLog)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		t.Fatalf("registering audit log callback: %v", err)
+	}
+
+	jwtService := auth.NewJWTService("test-secret", 15*time.Minute, 24*time.Hour, "https://authy.test")
+	tokens := newMemoryTokenStore()
+	h := &AuthHandler{
+		db:             db,
+		logger:         logger.New("error"),
+		sessionService: auth.NewSessionService(cache.NewMemory(), jwtService, 0, 0),
+		tokens:         tokens,
+	}
+	return h, tokens, audits
+}
+
+// issueTestSession signs a user in to a new session bound to dpopKey, when set
+func issueTestSession(t *testing.T, h *AuthHandler, app *models.Application, dpopKey string) (*auth.TokenPair, *auth.Claims) {
+	t.Helper()
+
+	user := &models.User{ID: uuid.New()}
+	pair, claims, err := h.issueTokenPair(user, app, []string{"users:read"}, dpopKey, []string{"pwd"}, net.ParseIP("192.0.2.1"), "test")
+	if err != nil {
+		t.Fatalf("issueTokenPair() error = %v", err)
+	}
+	return pair, claims
+}
+
+func newTestApplication() *models.Application {
+	return &models.Application{ID: uuid.New(), AccessTokenTTL: 900, RefreshTokenTTL: 86400}
+}
+
+func TestRefreshTokenReplayRevokesFamily(t *testing.T) {
+	h, tokens, audits := newTestAuthHandler(t)
+	ctx := context.Background()
+	app := newTestApplication()
+	ip := net.ParseIP("192.0.2.1")
+
+	pair, claims := issueTestSession(t, h, app, "")
+
+	if _, err := h.validateRefreshToken(ctx, pair.RefreshToken, ip, "test"); err != nil {
+		t.Fatalf("validateRefreshToken() error = %v", err)
+	}
+	rotated, err := h.rotateRefreshToken(ctx, pair.RefreshToken, app, []string{"users:read"}, "", ip, "test")
+	if err != nil {
+		t.Fatalf("rotateRefreshToken() error = %v", err)
+	}
+
+	// The rotated token is no longer accepted, and presenting it again is a replay
+	if _, err := h.validateRefreshToken(ctx, pair.RefreshToken, ip, "test"); err != errRefreshTokenReused {
+		t.Fatalf("validateRefreshToken(replayed) error = %v, want errRefreshTokenReused", err)
+	}
+
+	// The replay ends the whole family, including the pair issued by the rotation
+	if _, err := h.sessionService.ValidateToken(ctx, rotated.AccessToken); err == nil {
+		t.Error("access token issued by the rotation still valid after replay")
+	}
+	if _, err := h.sessionService.ValidateRefreshToken(ctx, rotated.RefreshToken); err == nil {
+		t.Error("refresh token issued by the rotation still valid after replay")
+	}
+	if _, err := h.sessionService.ValidateToken(ctx, pair.AccessToken); err == nil {
+		t.Error("original access token still valid after replay")
+	}
+	if _, err := h.rotateRefreshToken(ctx, rotated.RefreshToken, app, nil, "", ip, "test"); err == nil {
+		t.Error("rotateRefreshToken() succeeded with a token of the revoked family")
+	}
+
+	for _, token := range tokens.session(claims.SessionID) {
+		if token.ExpiresAt.After(time.Now()) {
+			t.Errorf("%s token %s still valid after replay", token.TokenType, token.ID)
+		}
+	}
+
+	if len(*audits) == 0 {
+		t.Fatal("replay was not audited")
+	}
+	audit := (*audits)[len(*audits)-1]
+	if audit.Action != string(models.ActionTokenReuse) {
+		t.Fatalf("audit action = %q, want %q", audit.Action, models.ActionTokenReuse)
+	}
+	var details struct {
+		SessionID     uuid.UUID `json:"session_id"`
+		RevokedTokens int       `json:"revoked_tokens"`
+	}
+	if err := json.Unmarshal(audit.Details, &details); err != nil {
+		t.Fatalf("decoding audit details: %v", err)
+	}
+	if details.SessionID != claims.SessionID {
+		t.Errorf("audited session_id = %s, want %s", details.SessionID, claims.SessionID)
+	}
+	// The original access token and the rotated pair
+	if details.RevokedTokens != 3 {
+		t.Errorf("audited revoked_tokens = %d, want 3", details.RevokedTokens)
+	}
+}
+
+func TestRefreshTokenRotatesOnce(t *testing.T) {
+	h, _, audits := newTestAuthHandler(t)
+	ctx := context.Background()
+	app := newTestApplication()
+	ip := net.ParseIP("192.0.2.1")
+
+	pair, _ := issueTestSession(t, h, app, "")
+
+	rotated, err := h.rotateRefreshToken(ctx, pair.RefreshToken, app, nil, "", ip, "test")
+	if err != nil {
+		t.Fatalf("rotateRefreshToken() error = %v", err)
+	}
+
+	if _, err := h.rotateRefreshToken(ctx, pair.RefreshToken, app, nil, "", ip, "test"); err != errRefreshTokenReused {
+		t.Fatalf("second rotateRefreshToken() error = %v, want errRefreshTokenReused", err)
+	}
+	if _, err := h.sessionService.ValidateRefreshToken(ctx, rotated.RefreshToken); err == nil {
+		t.Error("refresh token issued by the first rotation still valid after replay")
+	}
+	if len(*audits) != 1 || (*audits)[0].Action != string(models.ActionTokenReuse) {
+		t.Errorf("audit logs = %+v, want a single refresh_token_reuse", *audits)
+	}
+}
+
+func TestFailedRotationCanBeRetried(t *testing.T) {
+	h, tokens, _ := newTestAuthHandler(t)
+	ctx := context.Background()
+	app := newTestApplication()
+	ip := net.ParseIP("192.0.2.1")
+
+	pair, claims := issueTestSession(t, h, app, "key-a")
+
+	// Proving a different key issues nothing, so the token is not spent
+	if _, err := h.rotateRefreshToken(ctx, pair.RefreshToken, app, nil, "key-b", ip, "test"); err != auth.ErrInvalidDPoPProof {
+		t.Fatalf("rotateRefreshToken(wrong key) error = %v, want ErrInvalidDPoPProof", err)
+	}
+	for _, token := range tokens.session(claims.SessionID) {
+		if token.IsRotated() {
+			t.Fatalf("%s token left marked rotated after a failed rotation", token.TokenType)
+		}
+	}
+
+	if _, err := h.rotateRefreshToken(ctx, pair.RefreshToken, app, nil, "key-a", ip, "test"); err != nil {
+		t.Fatalf("rotateRefreshToken() retry error = %v", err)
+	}
+}
+
+func TestFailedRotationKeepsConcurrentRevocation(t *testing.T) {
+	h, tokens, _ := newTestAuthHandler(t)
+	ctx := context.Background()
+	app := newTestApplication()
+	ip := net.ParseIP("192.0.2.1")
+
+	pair, claims := issueTestSession(t, h, app, "key-a")
+
+	// The session is signed out while the rotation is in flight
+	tokens.afterMarkRotated = func(token *models.Token) {
+		if _, err := revokeSessionTokens(ctx, tokens, h.sessionService, claims.SessionID); err != nil {
+			t.Errorf("revokeSessionTokens() error = %v", err)
+		}
+	}
+
+	if _, err := h.rotateRefreshToken(ctx, pair.RefreshToken, app, nil, "key-b", ip, "test"); err == nil {
+		t.Fatal("rotateRefreshToken(wrong key) succeeded")
+	}
+	tokens.afterMarkRotated = nil
+
+	if _, err := h.sessionService.ValidateRefreshToken(ctx, pair.RefreshToken); err == nil {
+		t.Error("refresh token revoked during a failed rotation is valid again")
+	}
+	for _, token := range tokens.session(claims.SessionID) {
+		if token.RevokedAt == nil || token.ExpiresAt.After(time.Now()) {
+			t.Errorf("%s token restored after being revoked", token.TokenType)
+		}
+	}
+	if _, err := h.rotateRefreshToken(ctx, pair.RefreshToken, app, nil, "key-a", ip, "test"); err == nil {
+		t.Error("rotateRefreshToken() succeeded with a revoked token")
+	}
+}
@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// DeviceAuthorizationRequest represents an RFC 8628 device authorization request
+type DeviceAuthorizationRequest struct {
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	Scope        string `form:"scope"`
+}
+
+// DeviceAuthorizationResponse represents an RFC 8628 device authorization response
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceApprovalRequest represents a signed-in user's decision on a device
+type DeviceApprovalRequest struct {
+	UserCode string `json:"user_code" validate:"required"`
+	Approve  bool   `json:"approve"`
+}
+
+// DeviceAuthorization starts the device authorization grant for input-constrained clients
+// @Summary OAuth 2.0 device authorization endpoint
+// @Description Issue a device code and a user code (RFC 8628). The device shows the user code and verification URI, then polls the token endpoint with the device code.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param client_id formData string false "Application ID (when not using HTTP Basic authentication)"
+// @Param client_secret formData string false "Application API key (confidential clients)"
+// @Param scope formData string false "Requested scopes (include openid for an id_token)"
+// @Success 200 {object} DeviceAuthorizationResponse "Device and user codes"
+// @Failure 400 {object} OAuthErrorResponse "Invalid request or grant not allowed"
+// @Failure 401 {object} OAuthErrorResponse "Client authentication failed"
+// @Router /oauth/device_authorization [post]
+func (h *AuthHandler) DeviceAuthorization(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var req DeviceAuthorizationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Invalid request body")
+	}
+
+	app, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return h.rejectClient(c, map[string]interface{}{
+			"grant_type": models.GrantTypeDeviceCode,
+			"reason":     "invalid_client",
+		})
+	}
+
+	if !app.AllowsGrantType(models.GrantTypeDeviceCode) {
+		return oauthError(c, fiber.StatusBadRequest, "unauthorized_client", "Device code grant not allowed for this client")
+	}
+
+	deviceCode, authorization, err := h.sessionService.StartDeviceAuthorization(context.Background(), app.ID, req.Scope)
+	if err != nil {
+		h.logger.Error("Failed to start device authorization", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue device code")
+	}
+
+	verificationURI := h.sessionService.Issuer() + "/oauth/device"
+	params := url.Values{}
+	params.Set("user_code", authorization.UserCode)
+
+	return c.Status(fiber.StatusOK).JSON(DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                authorization.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: appendQuery(verificationURI, params),
+		ExpiresIn:               int64(auth.DeviceCodeTTL.Seconds()),
+		Interval:                int64(auth.DevicePollInterval.Seconds()),
+	})
+}
+
+// DeviceVerification shows the page where a user enters the code displayed on their device
+// @Summary Device verification page
+// @Description Ask for the user code of a device; with a valid code, show which application the device belongs to and the sign-in form
+// @Tags OAuth
+// @Produce html
+// @Param user_code query string false "User code shown on the device"
+// @Success 200 {string} string "Verification page"
+// @Failure 400 {string} string "Verification page with an error"
+// @Router /oauth/device [get]
+func (h *AuthHandler) DeviceVerification(c *fiber.Ctx) error {
+	userCode := c.Query("user_code")
+	if userCode == "" {
+		return h.renderDevicePage(c, fiber.StatusOK, devicePageData{})
+	}
+
+	app, err := h.pendingDevice(userCode)
+	if err != nil {
+		return h.renderDevicePage(c, fiber.StatusBadRequest, devicePageData{Error: "Invalid or expired code"})
+	}
+
+	return h.renderDevicePage(c, fiber.StatusOK, devicePageData{UserCode: userCode, ApplicationName: app.Name})
+}
+
+// DeviceVerificationLogin authenticates the user on the verification page and records their decision for the device
+// @Summary Device sign-in
+// @Description Verify the user's credentials, then approve or deny the device waiting on the user code
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param user_code formData string true "User code shown on the device"
+// @Param email formData string true "User email"
+// @Param password formData string true "User password"
+// @Param otp formData string false "Authenticator or recovery code (users who enrolled a second factor)"
+// @Param decision formData string true "approve or deny"
+// @Success 200 {string} string "Confirmation page"
+// @Failure 400 {string} string "Invalid or expired code"
+// @Failure 401 {string} string "Verification page with an error"
+// @Router /oauth/device [post]
+func (h *AuthHandler) DeviceVerificationLogin(c *fiber.Ctx) error {
+	userCode := c.FormValue("user_code")
+	email := c.FormValue("email")
+	password := c.FormValue("password")
+	approve := c.FormValue("decision") != "deny"
+
+	app, err := h.pendingDevice(userCode)
+	if err != nil {
+		return h.renderDevicePage(c, fiber.StatusBadRequest, devicePageData{Error: "Invalid or expired code"})
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	retry := devicePageData{UserCode: userCode, ApplicationName: app.Name, Error: "Invalid email or password"}
+
+	// Find the user
+	var user models.User
+	if err := h.db.Where("LOWER(email) = LOWER(?) AND is_active = true", strings.TrimSpace(email)).First(&user).Error; err != nil {
+		models.CreateAuditLog(h.db, nil, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"email":      email,
+				"grant_type": models.GrantTypeDeviceCode,
+				"reason":     "user_not_found",
+			}, &clientIP, &userAgent)
+
+		return h.renderDevicePage(c, fiber.StatusUnauthorized, retry)
+	}
+
+	// Verify password
+	if !user.CheckPassword(password) {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"email":      email,
+				"grant_type": models.GrantTypeDeviceCode,
+				"reason":     "invalid_password",
+			}, &clientIP, &userAgent)
+
+		return h.renderDevicePage(c, fiber.StatusUnauthorized, retry)
+	}
+
+	// Applications may refuse users until they verify their email address
+	if emailVerificationRequired(app, &user) {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"email":      email,
+				"grant_type": models.GrantTypeDeviceCode,
+				"reason":     "email_not_verified",
+			}, &clientIP, &userAgent)
+
+		retry.Error = emailNotVerifiedMessage
+		return h.renderDevicePage(c, fiber.StatusForbidden, retry)
+	}
+
+	// Users who enrolled a second factor also need a code of their authenticator app or a recovery code
+	amr, err := h.secondFactor(context.Background(), &user, app, c.FormValue("otp"))
+	if err != nil {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionMFAFailed, "authentication", nil,
+			map[string]interface{}{
+				"method":     mfaMethodTOTP,
+				"grant_type": models.GrantTypeDeviceCode,
+			}, &clientIP, &userAgent)
+
+		retry.Error = mfaErrorMessage(err)
+		return h.renderDevicePage(c, fiber.StatusUnauthorized, retry)
+	}
+
+	if err := h.decideDevice(userCode, user.ID, app, approve, amr, clientIP, userAgent); err != nil {
+		return h.renderDevicePage(c, fiber.StatusBadRequest, devicePageData{Error: "Invalid or expired code"})
+	}
+
+	result := "Access denied. You can close this page."
+	if approve {
+		result = "Your device is now signed in to " + app.Name + ". You can return to it."
+	}
+	return h.renderDevicePage(c, fiber.StatusOK, devicePageData{Result: result})
+}
+
+// ApproveDevice lets a signed-in user approve or deny a device by its user code
+// @Summary Approve a device
+// @Description Approve or deny the device waiting on a user code, signing it in as the authenticated user. The token must be the user's own token for the application the device signs in to; exchanged tokens acting for another party are refused.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body DeviceApprovalRequest true "User code and decision"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Decision recorded"
+// @Failure 400 {object} ErrorResponse "Invalid or expired code"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Token not valid for the application or email address not verified"
+// @Router /me/device [post]
+func (h *AuthHandler) ApproveDevice(c *fiber.Ctx) error {
+	userID, applicationID, _, ok := middleware.ExtractUserContext(c)
+	claims, hasClaims := c.Locals("claims").(*auth.Claims)
+	if !ok || userID == uuid.Nil || !hasClaims {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var req DeviceApprovalRequest
+	if err := c.BodyParser(&req); err != nil || req.UserCode == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	invalidCode := ErrorResponse{
+		Error:   true,
+		Message: "Invalid or expired code",
+	}
+
+	app, err := h.pendingDevice(req.UserCode)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidCode)
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	// The device gets a full session of its own, so only a session of the user in the same application may
+	// decide: a delegated token, or one issued for another application, would widen into it
+	if claims.Act != nil || applicationID != app.ID {
+		models.CreateAuditLog(h.db, &userID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"grant_type":           models.GrantTypeDeviceCode,
+				"reason":               "token_not_allowed",
+				"token_application_id": applicationID,
+				"delegated":            claims.Act != nil,
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Sign in to the device's application to approve it",
+		})
+	}
+
+	// Approving signs the device in to the application, which may refuse users with an unverified email
+	if req.Approve && app.RequireVerifiedEmail {
+		var user models.User
+		if err := h.db.Where("id = ? AND is_active = true", userID).First(&user).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+				Error:   true,
+				Message: "User not found",
+			})
+		}
+		if emailVerificationRequired(app, &user) {
+			models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+				map[string]interface{}{
+					"email":      user.Email,
+					"grant_type": models.GrantTypeDeviceCode,
+					"reason":     "email_not_verified",
+				}, &clientIP, &userAgent)
+
+			return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+				Error:   true,
+				Message: emailNotVerifiedMessage,
+			})
+		}
+	}
+
+	// The device signs in the way the approving user did
+	if err := h.decideDevice(req.UserCode, userID, app, req.Approve, claims.AMR, clientIP, userAgent); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidCode)
+	}
+
+	message := "Device denied"
+	if req.Approve {
+		message = "Device approved"
+	}
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: message,
+	})
+}
+
+// exchangeDeviceCode answers a device polling the token endpoint, issuing tokens once the user approved it
+func (h *AuthHandler) exchangeDeviceCode(c *fiber.Ctx, app *models.Application, req *OAuthTokenRequest) error {
+	if req.DeviceCode == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "device_code is required")
+	}
+
+	authorization, err := h.sessionService.PollDeviceAuthorization(context.Background(), req.DeviceCode, app.ID)
+	switch err {
+	case nil:
+	case auth.ErrAuthorizationPending:
+		return oauthError(c, fiber.StatusBadRequest, "authorization_pending", "The user has not yet approved the device")
+	case auth.ErrSlowDown:
+		return oauthError(c, fiber.StatusBadRequest, "slow_down", "Polling too frequently, increase the interval by 5 seconds")
+	case auth.ErrAccessDenied:
+		return oauthError(c, fiber.StatusBadRequest, "access_denied", "The user denied the device")
+	case auth.ErrInvalidDeviceCode:
+		return oauthError(c, fiber.StatusBadRequest, "expired_token", "Invalid or expired device code")
+	default:
+		h.logger.Error("Failed to poll device authorization", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", authorization.UserID).First(&user).Error; err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "User is no longer active")
+	}
+
+	// Get user permissions for this application
+	permissions, err := h.getUserPermissions(user.ID, app.ID)
+	if err != nil {
+		h.logger.Error("Failed to get user permissions", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+	}
+
+	// Record the session against where the user approved the device
+	tokenPair, accessClaims, err := h.issueTokenPair(&user, app, permissions, req.dpopKey, authorization.AMR, net.ParseIP(authorization.ClientIP), authorization.UserAgent)
+	if err != nil {
+		h.logger.Error("Failed to generate tokens", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to generate tokens")
+	}
+
+	// Issue an OpenID Connect id_token when requested
+	if auth.HasScope(authorization.Scope, auth.ScopeOpenID) {
+		idToken, err := h.sessionService.GenerateIDToken(userIdentity(&user), app.ID, "", authorization.AuthTime, tokenPair.AccessToken)
+		if err != nil {
+			h.logger.Error("Failed to generate id token", "error", err)
+			return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to generate tokens")
+		}
+		tokenPair.IDToken = idToken
+	}
+
+	h.logger.Info("Device code exchanged", "client_id", app.ID, "user_id", user.ID, "token_id", accessClaims.ID)
+
+	return c.Status(fiber.StatusOK).JSON(OAuthTokenResponse{
+		TokenPair: tokenPair,
+		Scope:     authorization.Scope,
+	})
+}
+
+// pendingDevice looks up the pending device authorization of a user code and the application it belongs to
+func (h *AuthHandler) pendingDevice(userCode string) (*models.Application, error) {
+	authorization, err := h.sessionService.GetDeviceAuthorization(context.Background(), userCode)
+	if err != nil {
+		return nil, err
+	}
+
+	var app models.Application
+	if err := h.db.First(&app, authorization.ClientID).Error; err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+// decideDevice records a user's decision on a device and audits it
+func (h *AuthHandler) decideDevice(userCode string, userID uuid.UUID, app *models.Application, approve bool, amr []string, clientIP net.IP, userAgent string) error {
+	ctx := context.Background()
+
+	if !approve {
+		if _, err := h.sessionService.DenyDeviceAuthorization(ctx, userCode); err != nil {
+			return err
+		}
+
+		models.CreateAuditLog(h.db, &userID, &app.ID, models.ActionDeviceDeny, "authentication", nil,
+			map[string]interface{}{
+				"grant_type": models.GrantTypeDeviceCode,
+			}, &clientIP, &userAgent)
+		return nil
+	}
+
+	authorization, err := h.sessionService.ApproveDeviceAuthorization(ctx, userCode, userID, amr, clientIP.String(), userAgent)
+	if err != nil {
+		return err
+	}
+
+	// Log successful login
+	models.CreateAuditLog(h.db, &userID, &app.ID, models.ActionLogin, "authentication", nil,
+		map[string]interface{}{
+			"grant_type": models.GrantTypeDeviceCode,
+			"scope":      authorization.Scope,
+			"expires_at": time.Unix(authorization.ExpiresAt, 0),
+		}, &clientIP, &userAgent)
+	return nil
+}
+
+// renderDevicePage renders the device verification page
+func (h *AuthHandler) renderDevicePage(c *fiber.Ctx, status int, data devicePageData) error {
+	var page strings.Builder
+	if err := devicePageTemplate.Execute(&page, data); err != nil {
+		h.logger.Error("Failed to render device verification page", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+
+	// The verification page must never be framed by another site
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderContentSecurityPolicy, "frame-ancestors 'none'")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(status).SendString(page.String())
+}
+
+// devicePageData holds the values rendered on the device verification page
+type devicePageData struct {
+	UserCode        string // set once the code is known to be valid
+	ApplicationName string
+	Error           string
+	Result          string // set once the user decided
+}
+
+var devicePageTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device - Authy</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #f4f5f7; display: flex; justify-content: center; padding-top: 10vh; }
+main { background: #fff; padding: 2rem; border-radius: 8px; box-shadow: 0 1px 4px rgba(0,0,0,.1); width: 320px; }
+label { display: block; margin-top: 1rem; font-size: .9rem; }
+input { width: 100%; padding: .5rem; margin-top: .25rem; box-sizing: border-box; }
+button { margin-top: 1.5rem; width: 100%; padding: .6rem; }
+.error { color: #b00020; }
+.code { font-family: monospace; font-size: 1.2rem; letter-spacing: .1rem; }
+</style>
+</head>
+<body>
+<main>
+{{if .Result}}
+<h1>Done</h1>
+<p>{{.Result}}</p>
+{{else if .UserCode}}
+<h1>Sign in</h1>
+<p>to connect a device to <strong>{{.ApplicationName}}</strong></p>
+<p>Only continue if <span class="code">{{.UserCode}}</span> is the code shown on your device.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/device">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+<label>Email <input type="email" name="email" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<label>Authentication code <input type="text" name="otp" autocomplete="one-time-code" placeholder="If two-step verification is on, or a recovery code"></label>
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+{{else}}
+<h1>Connect a device</h1>
+<p>Enter the code shown on your device.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="get" action="/oauth/device">
+<label>Code <input type="text" name="user_code" class="code" autocomplete="off" autocapitalize="characters" required autofocus></label>
+<button type="submit">Continue</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+// verifyDPoP checks the DPoP proof sent to an endpoint issuing tokens and returns the thumbprint of its key,
+// or "" when the client asked for bearer tokens. Applications requiring DPoP must send a proof.
+func (h *AuthHandler) verifyDPoP(c *fiber.Ctx, app *models.Application) (string, error) {
+	proof := c.Get(auth.DPoPHeader)
+	if proof == "" {
+		if app.RequireDPoP {
+			return "", auth.ErrDPoPRequired
+		}
+		return "", nil
+	}
+
+	return h.sessionService.VerifyDPoPProof(context.Background(), proof, &auth.DPoPRequest{
+		Method: c.Method(),
+		URL:    middleware.RequestURL(c),
+	})
+}
+
+// boundSessionPolicy returns the session policy of an application for tokens bound to a DPoP key, if any
+func boundSessionPolicy(app *models.Application, dpopKey string) *auth.SessionPolicy {
+	policy := sessionPolicy(app)
+	policy.DPoPKeyThumbprint = dpopKey
+	return policy
+}
+
+// matchesDPoPKey reports whether a token may be used by a request proving possession of the given key
+func matchesDPoPKey(claims *auth.Claims, dpopKey string) bool {
+	return claims.Cnf == nil || claims.Cnf.JKT == dpopKey
+}
+
+// dpopErrorDescription describes why a DPoP proof was not accepted
+func dpopErrorDescription(err error) string {
+	if err == auth.ErrDPoPRequired {
+		return "This application requires a DPoP proof"
+	}
+	return "Invalid DPoP proof"
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/notifications"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// emailNotVerifiedMessage tells users an application refuses until they verify their email address
+const emailNotVerifiedMessage = "Verify your email address before signing in"
+
+// VerifyEmailRequest represents the confirmation of an email address with the token of a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ResendEmailVerificationRequest represents a request for a new verification link. The optional
+// application selects the templates of the message.
+type ResendEmailVerificationRequest struct {
+	Email       string `json:"email" validate:"required,email"`
+	Application string `json:"application,omitempty"`
+}
+
+// emailVerifier sends the links users confirm their email addresses with
+type emailVerifier struct {
+	db              *gorm.DB
+	sessionService  *auth.SessionService
+	notifier        *notifications.Service
+	verificationURL string // page verification links open
+}
+
+func newEmailVerifier(db *gorm.DB, sessionService *auth.SessionService, notifier *notifications.Service, verificationURL string) *emailVerifier {
+	return &emailVerifier{
+		db:              db,
+		sessionService:  sessionService,
+		notifier:        notifier,
+		verificationURL: verificationURL,
+	}
+}
+
+// send issues a verification token for an address of a user and sends the link to that address,
+// replacing the links sent to the user before
+func (v *emailVerifier) send(ctx context.Context, user *models.User, email string, applicationID *uuid.UUID, locale string) (*models.Notification, error) {
+	token, err := v.sessionService.IssueEmailVerificationToken(ctx, user.ID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := tokenLink(v.verificationURL, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.notifier.Send(ctx, &notifications.Request{
+		Kind:          notifications.KindEmailVerification,
+		ApplicationID: applicationID,
+		UserID:        &user.ID,
+		To:            email,
+		Locale:        locale,
+		Data: map[string]interface{}{
+			"Name":           user.FirstName,
+			"Link":           link,
+			"ExpiresInHours": int(auth.EmailVerificationTTL / time.Hour),
+		},
+	})
+}
+
+// emailVerificationRequired reports whether an application refuses a user until they verify their email address
+func emailVerificationRequired(app *models.Application, user *models.User) bool {
+	return app.RequireVerifiedEmail && !user.EmailVerified
+}
+
+// VerifyEmail confirms an email address with the token of a verification link
+// @Summary Verify email address
+// @Description Confirm the address a verification link was sent to. A pending email change replaces the current address at this point.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body VerifyEmailRequest true "Verification token"
+// @Success 200 {object} SuccessResponse "Email address verified"
+// @Failure 400 {object} ErrorResponse "Invalid request, or invalid or expired token"
+// @Failure 409 {object} ErrorResponse "Email already exists"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/email/verify [post]
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	var req VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	invalidToken := ErrorResponse{
+		Error:   true,
+		Message: "Invalid or expired email verification token",
+	}
+
+	userID, email, err := h.sessionService.ConsumeEmailVerificationToken(context.Background(), req.Token)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidToken)
+	}
+
+	var user models.User
+	// Registered users can verify their email while they wait for approval
+	if err := h.db.Where("id = ? AND (is_active = true OR approval_pending = true)", userID).First(&user).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidToken)
+	}
+
+	resourceID := user.ID.String()
+	now := time.Now()
+	var grantedRoles []uuid.UUID
+
+	switch {
+	case user.PendingEmail != nil && *user.PendingEmail == email:
+		// Another account may have taken the address since the change was requested
+		var taken int64
+		if err := h.db.Model(&models.User{}).Where("email = ? AND id != ?", email, user.ID).Count(&taken).Error; err != nil {
+			h.logger.Error("Failed to check email uniqueness", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Failed to verify email address",
+			})
+		}
+		if taken > 0 {
+			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Email already exists",
+			})
+		}
+
+		previousEmail := user.Email
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"email":             email,
+				"pending_email":     nil,
+				"email_verified":    true,
+				"email_verified_at": now,
+			}).Error; err != nil {
+				return err
+			}
+
+			var err error
+			grantedRoles, err = grantPendingRegistrationRoles(tx, &user, email)
+			return err
+		})
+		if err != nil {
+			h.logger.Error("Failed to change email address", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Failed to verify email address",
+			})
+		}
+
+		models.CreateAuditLog(h.db, &user.ID, nil, models.ActionEmailChange, "user", &resourceID,
+			map[string]interface{}{
+				"previous_email": previousEmail,
+				"email":          email,
+				"role_ids":       grantedRoles,
+			}, &clientIP, &userAgent)
+
+	case user.Email == email:
+		if !user.EmailVerified {
+			err := h.db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&user).Updates(map[string]interface{}{
+					"email_verified":    true,
+					"email_verified_at": now,
+				}).Error; err != nil {
+					return err
+				}
+
+				// Registered users of applications limiting email domains get their roles now the domain is proven
+				var err error
+				grantedRoles, err = grantPendingRegistrationRoles(tx, &user, email)
+				return err
+			})
+			if err != nil {
+				h.logger.Error("Failed to verify email address", "error", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+					Error:   true,
+					Message: "Failed to verify email address",
+				})
+			}
+
+			models.CreateAuditLog(h.db, &user.ID, nil, models.ActionEmailVerify, "user", &resourceID,
+				map[string]interface{}{
+					"email":    email,
+					"role_ids": grantedRoles,
+				}, &clientIP, &userAgent)
+		}
+
+	default:
+		// The address changed again since the link was sent
+		return c.Status(fiber.StatusBadRequest).JSON(invalidToken)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "Email address verified",
+	})
+}
+
+// ResendEmailVerification sends a new verification link to an address awaiting verification
+// @Summary Resend email verification
+// @Description Send a new verification link to an unverified email address or a pending email change, in the language of the Accept-Language header. The response is the same whether or not the address awaits verification.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body ResendEmailVerificationRequest true "Email address to verify"
+// @Param Accept-Language header string false "Preferred language of the message"
+// @Success 200 {object} SuccessResponse "Verification link sent if the address awaits verification"
+// @Failure 400 {object} ErrorResponse "Invalid request or application"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/email/verify/resend [post]
+func (h *AuthHandler) ResendEmailVerification(c *fiber.Ctx) error {
+	var req ResendEmailVerificationRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	var applicationID *uuid.UUID
+	if req.Application != "" {
+		var app models.Application
+		if err := h.db.Where("name = ?", req.Application).First(&app).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Invalid application",
+			})
+		}
+		applicationID = &app.ID
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := strings.Clone(c.Get("User-Agent"))
+	locale := notifications.PreferredLocale(strings.Clone(c.Get("Accept-Language")))
+
+	// The link is sent in the background so the response takes as long for other addresses
+	go h.resendEmailVerification(req.Email, applicationID, locale, clientIP, userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "If the email is awaiting verification, a new verification link has been sent",
+	})
+}
+
+// resendEmailVerification sends a verification link to the address if an active user awaits its verification
+func (h *AuthHandler) resendEmailVerification(email string, applicationID *uuid.UUID, locale string, clientIP net.IP, userAgent string) {
+	var user models.User
+	email = strings.ToLower(strings.TrimSpace(email))
+	err := h.db.Where("(is_active = true OR approval_pending = true) AND ((LOWER(email) = ? AND email_verified = false) OR LOWER(pending_email) = ?)", email, email).
+		First(&user).Error
+	if err != nil {
+		return
+	}
+
+	notification, err := h.emailVerifier.send(context.Background(), &user, email, applicationID, locale)
+	if err != nil {
+		h.logger.Error("Failed to send email verification link", "error", err, "user_id", user.ID)
+		return
+	}
+
+	resourceID := user.ID.String()
+	models.CreateAuditLog(h.db, &user.ID, applicationID, models.ActionEmailVerifySend, "user", &resourceID,
+		map[string]interface{}{
+			"email":           email,
+			"notification_id": notification.ID,
+		}, &clientIP, &userAgent)
+}
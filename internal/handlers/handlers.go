@@ -3,6 +3,8 @@ package handlers
 import (
 	"github.com/efrenfuentes/authy/internal/cache"
 	"github.com/efrenfuentes/authy/internal/config"
+	"github.com/efrenfuentes/authy/internal/notifications"
+	"github.com/efrenfuentes/authy/internal/services"
 	"github.com/efrenfuentes/authy/pkg/auth"
 	"github.com/efrenfuentes/authy/pkg/logger"
 	"github.com/efrenfuentes/authy/pkg/metrics"
@@ -12,17 +14,24 @@ import (
 )
 
 type AuthHandler struct {
-	db             *gorm.DB
-	cache          *cache.Client
-	logger         *logger.Logger
-	sessionService *auth.SessionService
+	db               *gorm.DB
+	cache            *cache.Client
+	logger           *logger.Logger
+	sessionService   *auth.SessionService
+	tokens           tokenStore
+	encryptor        *auth.Encryptor // encrypts MFA secrets and hashes recovery codes at rest
+	notifier         *notifications.Service
+	passwordResetURL string // page password reset links open
+	emailVerifier    *emailVerifier
 }
 
 type UserHandler struct {
-	db             *gorm.DB
-	cache          *cache.Client
-	logger         *logger.Logger
-	sessionService *auth.SessionService
+	db                 *gorm.DB
+	cache              *cache.Client
+	logger             *logger.Logger
+	sessionService     *auth.SessionService
+	permissionVersions *services.PermissionVersionService
+	emailVerifier      *emailVerifier
 }
 
 type ApplicationHandler struct {
@@ -31,21 +40,28 @@ type ApplicationHandler struct {
 	logger *logger.Logger
 }
 
-func NewAuthHandler(db *gorm.DB, cache *cache.Client, logger *logger.Logger, sessionService *auth.SessionService) *AuthHandler {
+func NewAuthHandler(db *gorm.DB, cache *cache.Client, logger *logger.Logger, sessionService *auth.SessionService, encryptor *auth.Encryptor, notifier *notifications.Service, passwordResetURL, emailVerificationURL string) *AuthHandler {
 	return &AuthHandler{
-		db:             db,
-		cache:          cache,
-		logger:         logger,
-		sessionService: sessionService,
+		db:               db,
+		cache:            cache,
+		logger:           logger,
+		sessionService:   sessionService,
+		tokens:           &databaseTokenStore{db: db},
+		encryptor:        encryptor,
+		notifier:         notifier,
+		passwordResetURL: passwordResetURL,
+		emailVerifier:    newEmailVerifier(db, sessionService, notifier, emailVerificationURL),
 	}
 }
 
-func NewUserHandler(db *gorm.DB, cache *cache.Client, logger *logger.Logger, sessionService *auth.SessionService) *UserHandler {
+func NewUserHandler(db *gorm.DB, cache *cache.Client, logger *logger.Logger, sessionService *auth.SessionService, permissionVersions *services.PermissionVersionService, notifier *notifications.Service, emailVerificationURL string) *UserHandler {
 	return &UserHandler{
-		db:             db,
-		cache:          cache,
-		logger:         logger,
-		sessionService: sessionService,
+		db:                 db,
+		cache:              cache,
+		logger:             logger,
+		sessionService:     sessionService,
+		permissionVersions: permissionVersions,
+		emailVerifier:      newEmailVerifier(db, sessionService, notifier, emailVerificationURL),
 	}
 }
 
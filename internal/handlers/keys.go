@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/services"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/efrenfuentes/authy/pkg/logger"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// KeyHandler handles signing key administration
+type KeyHandler struct {
+	db         *gorm.DB
+	logger     *logger.Logger
+	keyService *services.KeyService
+}
+
+// NewKeyHandler creates a new signing key handler
+func NewKeyHandler(db *gorm.DB, logger *logger.Logger, keyService *services.KeyService) *KeyHandler {
+	return &KeyHandler{
+		db:         db,
+		logger:     logger,
+		keyService: keyService,
+	}
+}
+
+// CreateSigningKeyRequest represents the create signing key request payload
+type CreateSigningKeyRequest struct {
+	Algorithm string `json:"algorithm" validate:"required,oneof=HS256 RS256 ES256 EdDSA"`
+}
+
+// SigningKeyResponse represents a signing key in API responses
+type SigningKeyResponse struct {
+	KID         string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// SigningKeysListResponse represents the signing keys list response
+type SigningKeysListResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	Keys    []SigningKeyResponse `json:"keys"`
+}
+
+// GetKeys handles listing signing keys
+// @Summary List signing keys
+// @Description List active, verification-only and retired token signing keys
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} SigningKeysListResponse "Signing keys list"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /keys [get]
+func (h *KeyHandler) GetKeys(c *fiber.Ctx) error {
+	keys, err := h.keyService.ListKeys()
+	if err != nil {
+		h.logger.Error("Failed to retrieve signing keys", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to retrieve signing keys",
+		})
+	}
+
+	keyResponses := make([]SigningKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		keyResponses = append(keyResponses, toSigningKeyResponse(&key))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(SigningKeysListResponse{
+		Success: true,
+		Message: "Signing keys retrieved successfully",
+		Keys:    keyResponses,
+	})
+}
+
+// CreateKey handles generating a new verification-only signing key
+// @Summary Create signing key
+// @Description Generate a new key pair; it is published for verification and must be promoted to start signing
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Param key body CreateSigningKeyRequest true "Key algorithm"
+// @Security BearerAuth
+// @Success 201 {object} SigningKeyResponse "Created signing key"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /keys [post]
+func (h *KeyHandler) CreateKey(c *fiber.Ctx) error {
+	var req CreateSigningKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, applicationID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	key, err := h.keyService.CreateKey(req.Algorithm)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnsupportedAlgorithm) {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Unsupported signing algorithm",
+			})
+		}
+		h.logger.Error("Failed to create signing key", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to create signing key",
+		})
+	}
+
+	models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionSigningKeyCreate, "signing_key",
+		&key.KID,
+		map[string]interface{}{
+			"algorithm": key.Algorithm,
+			"status":    key.Status,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusCreated).JSON(toSigningKeyResponse(key))
+}
+
+// PromoteKey handles making a key the active signing key
+// @Summary Promote signing key
+// @Description Make a key the active signing key; the previous active key stays valid for verification
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Param kid path string true "Key ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Signing key promoted"
+// @Failure 400 {object} ErrorResponse "Key cannot be promoted"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Signing key not found"
+// @Router /keys/{kid}/promote [post]
+func (h *KeyHandler) PromoteKey(c *fiber.Ctx) error {
+	return h.changeKeyStatus(c, models.ActionSigningKeyPromote, h.keyService.PromoteKey, "Signing key promoted successfully")
+}
+
+// RetireKey handles retiring a verification-only key
+// @Summary Retire signing key
+// @Description Stop accepting tokens signed with a key (the active key cannot be retired)
+// @Tags Keys
+// @Accept json
+// @Produce json
+// @Param kid path string true "Key ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Signing key retired"
+// @Failure 400 {object} ErrorResponse "Key cannot be retired"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Signing key not found"
+// @Router /keys/{kid}/retire [post]
+func (h *KeyHandler) RetireKey(c *fiber.Ctx) error {
+	return h.changeKeyStatus(c, models.ActionSigningKeyRetire, h.keyService.RetireKey, "Signing key retired successfully")
+}
+
+// changeKeyStatus applies a status transition to the key named in the path and audits it
+func (h *KeyHandler) changeKeyStatus(c *fiber.Ctx, action models.AuditAction, transition func(kid string) error, successMessage string) error {
+	kid := c.Params("kid")
+
+	// Extract user context for audit logging
+	currentUserID, applicationID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	if _, err := models.FindSigningKeyByKID(h.db, kid); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Signing key not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve signing key", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to update signing key",
+		})
+	}
+
+	if err := transition(kid); err != nil {
+		h.logger.Error("Failed to update signing key", "kid", kid, "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: err.Error(),
+		})
+	}
+
+	models.CreateAuditLog(h.db, &currentUserID, &applicationID, action, "signing_key", &kid,
+		map[string]interface{}{
+			"kid": kid,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: successMessage,
+	})
+}
+
+// toSigningKeyResponse converts a stored signing key to its API representation
+func toSigningKeyResponse(key *models.SigningKey) SigningKeyResponse {
+	return SigningKeyResponse{
+		KID:         key.KID,
+		Algorithm:   key.Algorithm,
+		Status:      string(key.Status),
+		CreatedAt:   key.CreatedAt,
+		ActivatedAt: key.ActivatedAt,
+		RetiredAt:   key.RetiredAt,
+	}
+}
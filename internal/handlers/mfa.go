@@ -0,0 +1,884 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// totpIssuer names Authy in authenticator apps
+const totpIssuer = "Authy"
+
+// stepUpErrorMessage tells users a sensitive change of their account was not confirmed
+const stepUpErrorMessage = "Confirm with your password, an authentication code or a passkey"
+
+// MFA methods a login challenge can be completed with
+const (
+	mfaMethodTOTP         = "totp"
+	mfaMethodWebAuthn     = "webauthn"
+	mfaMethodRecoveryCode = "recovery_code"
+)
+
+var (
+	// errMFARequired reports a sign-in without the second factor of a user who enrolled one
+	errMFARequired = errors.New("authentication code required")
+	// errPasskeyRequired reports a sign-in on a page that can't run WebAuthn by a user whose second factor is a passkey
+	errPasskeyRequired = errors.New("passkey required")
+	// errStepUpFailed reports a sensitive change of an account confirmed without a valid password, code or passkey
+	errStepUpFailed = errors.New("confirmation required")
+)
+
+// TOTPEnrollmentResponse represents a started TOTP enrollment
+type TOTPEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI, usually shown as a QR code
+}
+
+// TOTPCodeRequest represents a request carrying a code of the user's authenticator app
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// StepUpRequest confirms a sensitive change of the authenticated user's account with their password, a code
+// of their authenticator app or a passkey assertion for options from /me/webauthn/step-up. A bearer token
+// alone is not enough, so a stolen token can't be used to take over the account.
+type StepUpRequest struct {
+	Password string                          `json:"password,omitempty"`
+	Code     string                          `json:"code,omitempty"`
+	WebAuthn *auth.WebAuthnAssertionResponse `json:"webauthn,omitempty"`
+}
+
+// MFAVerifyRequest represents the second step of a login that requires MFA, completed with a code
+// of the user's authenticator app, a passkey assertion for options from /auth/mfa/webauthn or a recovery code
+type MFAVerifyRequest struct {
+	MFAToken     string                          `json:"mfa_token" validate:"required"`
+	Code         string                          `json:"code,omitempty"`
+	WebAuthn     *auth.WebAuthnAssertionResponse `json:"webauthn,omitempty"`
+	RecoveryCode string                          `json:"recovery_code,omitempty"` // single use
+}
+
+// MFARecoveryCodesResponse represents newly generated recovery codes, which are shown only once
+type MFARecoveryCodesResponse struct {
+	Success       bool     `json:"success"`
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// MFAWebAuthnRequest represents a request for the passkey options of an MFA challenge
+type MFAWebAuthnRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+// VerifyMFA completes a login challenged for a second factor
+// @Summary Verify MFA code
+// @Description Exchange the MFA challenge returned by login and a code of the user's authenticator app, a passkey assertion or a recovery code for tokens
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param verify body MFAVerifyRequest true "MFA challenge and code"
+// @Param DPoP header string false "DPoP proof binding the issued tokens to the client's key"
+// @Success 200 {object} LoginResponse "Successful login"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Invalid or expired challenge, or invalid code or passkey"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded or too many invalid codes"
+// @Router /auth/mfa/verify [post]
+func (h *AuthHandler) VerifyMFA(c *fiber.Ctx) error {
+	var req MFAVerifyRequest
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	invalidChallenge := ErrorResponse{
+		Error:   true,
+		Message: "Invalid or expired MFA challenge",
+	}
+
+	ctx := context.Background()
+	challenge, err := h.sessionService.GetMFAChallenge(ctx, req.MFAToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidChallenge)
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", challenge.UserID).First(&user).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidChallenge)
+	}
+
+	var app models.Application
+	if err := h.db.First(&app, challenge.ApplicationID).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidChallenge)
+	}
+
+	// Each attempt counts before the code is checked, so parallel guesses can't exceed the limit
+	if err := h.sessionService.AttemptMFAChallenge(ctx, req.MFAToken, challenge); err != nil {
+		if err != auth.ErrInvalidMFAChallenge {
+			h.logger.Error("Failed to count MFA attempt", "error", err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidChallenge)
+	}
+
+	// A new challenge for every password login would reset the challenge's limit, so the user's limit applies too
+	if err := h.sessionService.AttemptUserMFA(ctx, user.ID); err != nil {
+		if err != auth.ErrMFALocked {
+			h.logger.Error("Failed to count MFA attempt", "error", err)
+		}
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionMFAFailed, "authentication", nil,
+			map[string]interface{}{
+				"reason": "too_many_attempts",
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusTooManyRequests).JSON(ErrorResponse{
+			Error:   true,
+			Message: mfaErrorMessage(auth.ErrMFALocked),
+		})
+	}
+
+	method, methodAMR, err := h.verifyMFAResponse(ctx, &user, &app, &req)
+	if err != nil {
+		if err := h.sessionService.FailMFAChallenge(ctx, req.MFAToken, challenge); err != nil {
+			h.logger.Error("Failed to record MFA failure", "error", err)
+		}
+
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionMFAFailed, "authentication", nil,
+			map[string]interface{}{
+				"method":   method,
+				"attempts": challenge.Attempts,
+			}, &clientIP, &userAgent)
+
+		message := "Invalid authentication code"
+		if method == mfaMethodWebAuthn {
+			message = "Invalid passkey"
+		} else if method == mfaMethodRecoveryCode {
+			message = "Invalid recovery code"
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: message,
+		})
+	}
+
+	// A challenge completes only once, even when the same code is sent twice at the same time
+	if _, err := h.sessionService.CompleteMFAChallenge(ctx, req.MFAToken); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidChallenge)
+	}
+	h.sessionService.ResetUserMFA(ctx, user.ID)
+
+	if method == mfaMethodRecoveryCode {
+		h.auditRecoveryCodeUse(&user, &app, clientIP, userAgent)
+	}
+
+	amr := auth.AuthenticationMethods(append(challenge.Methods, methodAMR)...)
+	return h.completeLogin(c, &user, &app, challenge.Scope, challenge.Nonce, amr, clientIP, userAgent)
+}
+
+// BeginMFAWebAuthn starts completing an MFA challenge with a passkey
+// @Summary Begin passkey MFA
+// @Description Get the WebAuthn request options for completing the MFA challenge returned by login with one of the user's passkeys
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body MFAWebAuthnRequest true "MFA challenge"
+// @Success 200 {object} auth.PublicKeyCredentialRequestOptions "Options for navigator.credentials.get"
+// @Failure 400 {object} ErrorResponse "Invalid request or no passkey registered"
+// @Failure 401 {object} ErrorResponse "Invalid or expired challenge"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/mfa/webauthn [post]
+func (h *AuthHandler) BeginMFAWebAuthn(c *fiber.Ctx) error {
+	var req MFAWebAuthnRequest
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	invalidChallenge := ErrorResponse{
+		Error:   true,
+		Message: "Invalid or expired MFA challenge",
+	}
+
+	ctx := context.Background()
+	challenge, err := h.sessionService.GetMFAChallenge(ctx, req.MFAToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidChallenge)
+	}
+
+	var app models.Application
+	if err := h.db.First(&app, challenge.ApplicationID).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidChallenge)
+	}
+
+	var credentials []models.WebAuthnCredential
+	if app.WebAuthnEnabled() {
+		credentials, err = models.GetWebAuthnCredentials(h.db, challenge.UserID, app.WebAuthnRPID)
+		if err != nil {
+			h.logger.Error("Failed to retrieve passkeys", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Internal server error",
+			})
+		}
+	}
+	if len(credentials) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "No passkey registered for this application",
+		})
+	}
+
+	// The assertion only completes the challenge it was requested for
+	options, err := h.sessionService.BeginWebAuthnLogin(ctx, relyingParty(&app), challenge.UserID,
+		webAuthnCredentials(credentials), mfaWebAuthnBinding(req.MFAToken))
+	if err != nil {
+		h.logger.Error("Failed to start WebAuthn login", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Internal server error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(options)
+}
+
+// BeginStepUpWebAuthn starts confirming a sensitive change of the authenticated user's account with a passkey
+// @Summary Begin passkey confirmation
+// @Description Get the WebAuthn request options for confirming a sensitive change of the account, such as registering a passkey, with one of the user's passkeys for the token's application
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} auth.PublicKeyCredentialRequestOptions "Options for navigator.credentials.get"
+// @Failure 400 {object} ErrorResponse "No passkey registered"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Router /me/webauthn/step-up [post]
+func (h *AuthHandler) BeginStepUpWebAuthn(c *fiber.Ctx) error {
+	user, app, err := h.currentUserAndApplication(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var credentials []models.WebAuthnCredential
+	if app.WebAuthnEnabled() {
+		credentials, err = models.GetWebAuthnCredentials(h.db, user.ID, app.WebAuthnRPID)
+		if err != nil {
+			h.logger.Error("Failed to retrieve passkeys", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Internal server error",
+			})
+		}
+	}
+	if len(credentials) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "No passkey registered for this application",
+		})
+	}
+
+	options, err := h.sessionService.BeginWebAuthnLogin(context.Background(), relyingParty(app), user.ID,
+		webAuthnCredentials(credentials), stepUpWebAuthnBinding(user.ID))
+	if err != nil {
+		h.logger.Error("Failed to start WebAuthn login", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Internal server error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(options)
+}
+
+// EnrollTOTP starts the TOTP enrollment of the authenticated user, who confirms it with their password or a passkey
+// @Summary Enroll TOTP
+// @Description Generate a TOTP secret for the authenticated user. The user confirms with their password or one of their passkeys. It takes effect once confirmed with a first code.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body StepUpRequest true "Password or passkey assertion"
+// @Security BearerAuth
+// @Success 200 {object} TOTPEnrollmentResponse "Secret and provisioning URI"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Invalid password or passkey"
+// @Failure 409 {object} ErrorResponse "TOTP is already enabled"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /me/mfa/totp [post]
+func (h *AuthHandler) EnrollTOTP(c *fiber.Ctx) error {
+	user, app, err := h.currentUserAndApplication(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var req StepUpRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+			Error:   true,
+			Message: "TOTP is already enabled",
+		})
+	}
+
+	// The authenticator enrolled here becomes a second factor, so a bearer token alone can't choose it
+	if err := h.verifyStepUp(c, user, app, &req, "totp_enroll"); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: stepUpErrorMessage,
+		})
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		h.logger.Error("Failed to generate TOTP secret", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to start enrollment",
+		})
+	}
+
+	encryptedSecret, err := h.encryptor.Encrypt([]byte(secret))
+	if err != nil {
+		h.logger.Error("Failed to encrypt TOTP secret", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to start enrollment",
+		})
+	}
+
+	// A new enrollment replaces any unconfirmed one
+	user.TOTPSecret = &encryptedSecret
+	if err := h.db.Save(user).Error; err != nil {
+		h.logger.Error("Failed to save TOTP secret", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to start enrollment",
+		})
+	}
+
+	h.auditMFA(c, user.ID, models.ActionMFAEnroll, map[string]interface{}{
+		"method": mfaMethodTOTP,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(TOTPEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables TOTP for the authenticated user once a first code proves the enrollment
+// @Summary Confirm TOTP enrollment
+// @Description Enable TOTP with a first code of the authenticator app; later logins require a code. Users without recovery codes get a new set, shown only once.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body TOTPCodeRequest true "Authenticator code"
+// @Security BearerAuth
+// @Success 200 {object} MFARecoveryCodesResponse "TOTP enabled"
+// @Failure 400 {object} ErrorResponse "Invalid code or no enrollment in progress"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 409 {object} ErrorResponse "TOTP is already enabled"
+// @Router /me/mfa/totp/confirm [post]
+func (h *AuthHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var req TOTPCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+			Error:   true,
+			Message: "TOTP is already enabled",
+		})
+	}
+
+	if user.TOTPSecret == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "No TOTP enrollment in progress",
+		})
+	}
+
+	if err := h.verifyTOTP(context.Background(), user, req.Code); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication code",
+		})
+	}
+
+	now := time.Now()
+	user.TOTPEnabled = true
+	user.TOTPEnabledAt = &now
+	if err := h.db.Save(user).Error; err != nil {
+		h.logger.Error("Failed to enable TOTP", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to enable TOTP",
+		})
+	}
+
+	h.auditMFA(c, user.ID, models.ActionMFAEnable, map[string]interface{}{
+		"method": mfaMethodTOTP,
+	})
+
+	recoveryCodes, err := h.ensureRecoveryCodes(c, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to generate recovery codes", "error", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(MFARecoveryCodesResponse{
+		Success:       true,
+		Message:       "TOTP enabled",
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// DisableTOTP turns TOTP off for the authenticated user, who proves possession with a current code
+// @Summary Disable TOTP
+// @Description Disable TOTP for the authenticated user with a current code of the authenticator app
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body TOTPCodeRequest true "Authenticator code"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "TOTP disabled"
+// @Failure 400 {object} ErrorResponse "Invalid code or TOTP not enabled"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Router /me/mfa/totp [delete]
+func (h *AuthHandler) DisableTOTP(c *fiber.Ctx) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var req TOTPCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	if !user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "TOTP is not enabled",
+		})
+	}
+
+	if err := h.verifyTOTP(context.Background(), user, req.Code); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication code",
+		})
+	}
+
+	user.TOTPSecret = nil
+	user.TOTPEnabled = false
+	user.TOTPEnabledAt = nil
+	if err := h.db.Save(user).Error; err != nil {
+		h.logger.Error("Failed to disable TOTP", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to disable TOTP",
+		})
+	}
+
+	h.auditMFA(c, user.ID, models.ActionMFADisable, map[string]interface{}{
+		"method": mfaMethodTOTP,
+	})
+	h.discardUnneededRecoveryCodes(user)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "TOTP disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the authenticated user, who confirms it with a current
+// code, a passkey or their password
+// @Summary Regenerate recovery codes
+// @Description Generate a new set of recovery codes for the authenticated user, invalidating the previous ones. The user confirms with a code of their authenticator app, one of their passkeys or their password. The codes are shown only once.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body StepUpRequest true "Authenticator code, passkey assertion or password"
+// @Security BearerAuth
+// @Success 200 {object} MFARecoveryCodesResponse "New recovery codes"
+// @Failure 400 {object} ErrorResponse "Invalid request or no second factor enrolled"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Invalid authentication code, passkey or password"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /me/mfa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, app, err := h.currentUserAndApplication(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var req StepUpRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	if !h.hasSecondFactor(user) {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "No second factor enrolled",
+		})
+	}
+
+	// Recovery codes stand in for the second factor, so a bearer token alone can't replace them
+	if err := h.verifyStepUp(c, user, app, &req, "recovery_codes"); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: stepUpErrorMessage,
+		})
+	}
+
+	recoveryCodes, err := h.issueRecoveryCodes(c, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to generate recovery codes", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to generate recovery codes",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(MFARecoveryCodesResponse{
+		Success:       true,
+		Message:       "Recovery codes generated",
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// startMFAChallenge answers a login whose password was verified with a challenge for the second factor
+func (h *AuthHandler) startMFAChallenge(c *fiber.Ctx, user *models.User, app *models.Application, req *LoginRequest, methods []string, clientIP net.IP, userAgent string) error {
+	mfaToken, err := h.sessionService.StartMFAChallenge(context.Background(), &auth.MFAChallenge{
+		UserID:        user.ID,
+		ApplicationID: app.ID,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		Methods:       []string{auth.AMRPassword},
+	})
+	if err != nil {
+		h.logger.Error("Failed to start MFA challenge", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Internal server error",
+		})
+	}
+
+	models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionMFAChallenge, "authentication", nil,
+		map[string]interface{}{
+			"email":   user.Email,
+			"methods": methods,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(LoginResponse{
+		Success:     true,
+		Message:     "Multi-factor authentication required",
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		MFAMethods:  methods,
+	})
+}
+
+// mfaMethods returns the second factors a user enrolled for signing in to an application
+func (h *AuthHandler) mfaMethods(user *models.User, app *models.Application) []string {
+	var methods []string
+	if user.TOTPEnabled {
+		methods = append(methods, mfaMethodTOTP)
+	}
+	if h.hasPasskeys(user, app) {
+		methods = append(methods, mfaMethodWebAuthn)
+	}
+	if len(methods) > 0 && h.hasRecoveryCodes(user) {
+		methods = append(methods, mfaMethodRecoveryCode)
+	}
+	return methods
+}
+
+// verifyMFAResponse checks the second factor sent to complete an MFA challenge and returns the MFA method
+// and amr value it counts as
+func (h *AuthHandler) verifyMFAResponse(ctx context.Context, user *models.User, app *models.Application, req *MFAVerifyRequest) (string, string, error) {
+	if req.WebAuthn != nil {
+		if _, err := h.finishPasskeyLogin(ctx, app, req.WebAuthn, mfaWebAuthnBinding(req.MFAToken)); err != nil {
+			return mfaMethodWebAuthn, "", err
+		}
+		return mfaMethodWebAuthn, auth.AMRHardwareKey, nil
+	}
+
+	// Recovery codes are one-time passwords standing in for the enrolled factor
+	if req.RecoveryCode != "" {
+		if err := h.useRecoveryCode(user, req.RecoveryCode); err != nil {
+			return mfaMethodRecoveryCode, "", err
+		}
+		return mfaMethodRecoveryCode, auth.AMROTP, nil
+	}
+
+	// An unconfirmed enrollment does not count as a second factor
+	if !user.TOTPEnabled {
+		return mfaMethodTOTP, "", auth.ErrInvalidTOTPCode
+	}
+	if err := h.verifyTOTP(ctx, user, req.Code); err != nil {
+		return mfaMethodTOTP, "", err
+	}
+	return mfaMethodTOTP, auth.AMROTP, nil
+}
+
+// secondFactor checks the TOTP or recovery code sent along with a password on the sign-in pages and returns
+// how the user authenticated; users who did not enroll sign in with their password alone
+func (h *AuthHandler) secondFactor(ctx context.Context, user *models.User, app *models.Application, code string) ([]string, error) {
+	if len(h.mfaMethods(user, app)) == 0 {
+		return auth.AuthenticationMethods(auth.AMRPassword), nil
+	}
+	if code == "" {
+		// The sign-in pages can't run WebAuthn, so users whose second factor is a passkey need a recovery code
+		if !user.TOTPEnabled {
+			return nil, errPasskeyRequired
+		}
+		return nil, errMFARequired
+	}
+
+	// The pages have no challenge to cap guesses, so they count against the user's limit
+	if err := h.sessionService.AttemptUserMFA(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled && h.verifyTOTP(ctx, user, code) == nil {
+		h.sessionService.ResetUserMFA(ctx, user.ID)
+		return auth.AuthenticationMethods(auth.AMRPassword, auth.AMROTP), nil
+	}
+	if err := h.useRecoveryCode(user, code); err != nil {
+		return nil, err
+	}
+	h.sessionService.ResetUserMFA(ctx, user.ID)
+	return auth.AuthenticationMethods(auth.AMRPassword, auth.AMROTP), nil
+}
+
+// mfaErrorMessage describes a failed second factor on the sign-in pages
+func mfaErrorMessage(err error) string {
+	if err == errMFARequired {
+		return "Enter the code of your authenticator app"
+	}
+	if err == errPasskeyRequired {
+		return "This account signs in with a passkey, which this page does not support; enter a recovery code"
+	}
+	if err == auth.ErrMFALocked {
+		return "Too many invalid authentication codes; try again later"
+	}
+	return "Invalid authentication code"
+}
+
+// ensureRecoveryCodes gives a user who enrolled a second factor recovery codes unless some are left,
+// returning the new codes
+func (h *AuthHandler) ensureRecoveryCodes(c *fiber.Ctx, userID uuid.UUID) ([]string, error) {
+	remaining, err := models.CountUnusedMFARecoveryCodes(h.db, userID)
+	if err != nil || remaining > 0 {
+		return nil, err
+	}
+	return h.issueRecoveryCodes(c, userID)
+}
+
+// issueRecoveryCodes replaces a user's recovery codes with a new set; only their hashes are stored
+func (h *AuthHandler) issueRecoveryCodes(c *fiber.Ctx, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	codeHashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		codeHashes = append(codeHashes, auth.HashRecoveryCode(h.encryptor, code))
+	}
+	if err := models.ReplaceMFARecoveryCodes(h.db, userID, codeHashes); err != nil {
+		return nil, err
+	}
+
+	h.auditMFA(c, userID, models.ActionMFARecoveryCodes, map[string]interface{}{
+		"method": mfaMethodRecoveryCode,
+		"count":  len(codes),
+	})
+
+	return codes, nil
+}
+
+// useRecoveryCode consumes one of the user's recovery codes
+func (h *AuthHandler) useRecoveryCode(user *models.User, code string) error {
+	used, err := models.UseMFARecoveryCode(h.db, user.ID, auth.HashRecoveryCode(h.encryptor, code))
+	if err != nil {
+		h.logger.Error("Failed to use recovery code", "error", err, "user_id", user.ID)
+		return err
+	}
+	if !used {
+		return auth.ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// hasRecoveryCodes reports whether a user has unused recovery codes
+func (h *AuthHandler) hasRecoveryCodes(user *models.User) bool {
+	remaining, err := models.CountUnusedMFARecoveryCodes(h.db, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to count recovery codes", "error", err, "user_id", user.ID)
+		return false
+	}
+	return remaining > 0
+}
+
+// hasSecondFactor reports whether a user enrolled TOTP or registered a passkey for any application
+func (h *AuthHandler) hasSecondFactor(user *models.User) bool {
+	if user.TOTPEnabled {
+		return true
+	}
+
+	var passkeys int64
+	if err := h.db.Model(&models.WebAuthnCredential{}).Where("user_id = ?", user.ID).Count(&passkeys).Error; err != nil {
+		h.logger.Error("Failed to count passkeys", "error", err, "user_id", user.ID)
+		return true
+	}
+	return passkeys > 0
+}
+
+// discardUnneededRecoveryCodes removes the recovery codes of a user whose last second factor was removed
+func (h *AuthHandler) discardUnneededRecoveryCodes(user *models.User) {
+	if h.hasSecondFactor(user) {
+		return
+	}
+	if _, err := models.DeleteMFARecoveryCodes(h.db, user.ID); err != nil {
+		h.logger.Error("Failed to remove recovery codes", "error", err, "user_id", user.ID)
+	}
+}
+
+// auditRecoveryCodeUse records a login completed with a recovery code, with how many codes are left
+func (h *AuthHandler) auditRecoveryCodeUse(user *models.User, app *models.Application, clientIP net.IP, userAgent string) {
+	remaining, _ := models.CountUnusedMFARecoveryCodes(h.db, user.ID)
+
+	resourceID := user.ID.String()
+	models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionMFARecoveryUse, "user", &resourceID,
+		map[string]interface{}{
+			"method":    mfaMethodRecoveryCode,
+			"remaining": remaining,
+		}, &clientIP, &userAgent)
+}
+
+// verifyStepUp checks the password, authentication code or passkey assertion confirming a sensitive change
+// of the user's account, and audits failed confirmations
+func (h *AuthHandler) verifyStepUp(c *fiber.Ctx, user *models.User, app *models.Application, req *StepUpRequest, change string) error {
+	ctx := context.Background()
+
+	var method string
+	var err error
+	switch {
+	case req.WebAuthn != nil:
+		method = mfaMethodWebAuthn
+		var credential *models.WebAuthnCredential
+		if credential, err = h.finishPasskeyLogin(ctx, app, req.WebAuthn, stepUpWebAuthnBinding(user.ID)); err == nil && credential.UserID != user.ID {
+			err = auth.ErrInvalidWebAuthnResponse
+		}
+	case req.Code != "":
+		method = mfaMethodTOTP
+		err = auth.ErrInvalidTOTPCode
+		if user.TOTPEnabled {
+			err = h.verifyTOTP(ctx, user, req.Code)
+		}
+	case req.Password != "":
+		method = "password"
+		if !user.CheckPassword(req.Password) {
+			err = errStepUpFailed
+		}
+	default:
+		return errStepUpFailed
+	}
+	if err == nil {
+		return nil
+	}
+
+	h.auditMFA(c, user.ID, models.ActionStepUpFailed, map[string]interface{}{
+		"method": method,
+		"change": change,
+	})
+	return errStepUpFailed
+}
+
+// verifyTOTP checks a code against the user's enrolled TOTP secret
+func (h *AuthHandler) verifyTOTP(ctx context.Context, user *models.User, code string) error {
+	if user.TOTPSecret == nil {
+		return auth.ErrInvalidTOTPCode
+	}
+
+	secret, err := h.encryptor.Decrypt(*user.TOTPSecret)
+	if err != nil {
+		h.logger.Error("Failed to decrypt TOTP secret", "error", err, "user_id", user.ID)
+		return err
+	}
+
+	return h.sessionService.VerifyTOTP(ctx, user.ID, string(secret), code)
+}
+
+// currentUser loads the active user the request is authenticated as
+func (h *AuthHandler) currentUser(c *fiber.Ctx) (*models.User, error) {
+	userID, _, _, ok := middleware.ExtractUserContext(c)
+	if !ok || userID == uuid.Nil {
+		return nil, errors.New("no user in authentication context")
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// auditMFA records a change of the authenticated user's MFA settings
+func (h *AuthHandler) auditMFA(c *fiber.Ctx, userID uuid.UUID, action models.AuditAction, details map[string]interface{}) {
+	_, applicationID, _, _ := middleware.ExtractUserContext(c)
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	resourceID := userID.String()
+	models.CreateAuditLog(h.db, &userID, &applicationID, action, "user", &resourceID, details, &clientIP, &userAgent)
+}
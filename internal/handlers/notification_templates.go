@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/notifications"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NotificationTemplateRequest represents the subject and body of a notification template, as Go text/template sources
+type NotificationTemplateRequest struct {
+	Subject string `json:"subject" validate:"required"`
+	Body    string `json:"body" validate:"required"`
+}
+
+// NotificationTemplatesListResponse represents the notification templates of an application
+type NotificationTemplatesListResponse struct {
+	Success   bool                          `json:"success"`
+	Message   string                        `json:"message"`
+	Templates []models.NotificationTemplate `json:"templates"`
+	Kinds     []string                      `json:"kinds"`
+}
+
+// NotificationTemplateResponse represents a single notification template response
+type NotificationTemplateResponse struct {
+	Success  bool                         `json:"success"`
+	Message  string                       `json:"message"`
+	Template *models.NotificationTemplate `json:"template"`
+}
+
+// GetNotificationTemplates handles listing the notification templates of an application
+// @Summary List notification templates
+// @Description List the templates an application overrides the built-in notification templates with, and the notification kinds
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Security BearerAuth
+// @Success 200 {object} NotificationTemplatesListResponse "Notification templates"
+// @Failure 400 {object} ErrorResponse "Invalid application ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /applications/{id}/notification-templates [get]
+func (h *ApplicationHandler) GetNotificationTemplates(c *fiber.Ctx) error {
+	applicationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid application ID",
+		})
+	}
+
+	templates, err := models.GetNotificationTemplates(h.db, applicationID)
+	if err != nil {
+		h.logger.Error("Failed to retrieve notification templates", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to retrieve notification templates",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(NotificationTemplatesListResponse{
+		Success:   true,
+		Message:   "Notification templates retrieved successfully",
+		Templates: templates,
+		Kinds:     notifications.Kinds(),
+	})
+}
+
+// SetNotificationTemplate handles creating or replacing a notification template of an application
+// @Summary Set notification template
+// @Description Override the built-in template of a notification kind for an application and locale. Templates use Go text/template syntax; AppName, Name and Email are always available, plus Link and ExpiresInMinutes for password_reset, Link and ExpiresInHours for email_verification, Link, ExpiresInHours and InvitedBy for invitation, and Device, IPAddress and Time for new_device.
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param kind path string true "Notification kind" Enums(email_verification, invitation, new_device, password_reset)
+// @Param locale path string true "Locale, such as en or pt-BR"
+// @Param template body NotificationTemplateRequest true "Template subject and body"
+// @Security BearerAuth
+// @Success 200 {object} NotificationTemplateResponse "Notification template saved"
+// @Failure 400 {object} ErrorResponse "Invalid request or template"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Application not found"
+// @Router /applications/{id}/notification-templates/{kind}/{locale} [put]
+func (h *ApplicationHandler) SetNotificationTemplate(c *fiber.Ctx) error {
+	applicationID, kind, locale, err := notificationTemplateParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: err.Error(),
+		})
+	}
+
+	var req NotificationTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	if err := (notifications.Template{Subject: req.Subject, Body: req.Body}).Validate(kind); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid template: " + err.Error(),
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, currentAppID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	var application models.Application
+	if err := h.db.First(&application, "id = ?", applicationID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Application not found",
+		})
+	}
+
+	template, err := models.FindNotificationTemplate(h.db, applicationID, kind, locale)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		template, err = &models.NotificationTemplate{ApplicationID: applicationID, Kind: kind, Locale: locale}, nil
+	}
+	if err == nil {
+		template.Subject = req.Subject
+		template.Body = req.Body
+		err = h.db.Save(template).Error
+	}
+	if err != nil {
+		h.logger.Error("Failed to save notification template", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to save notification template",
+		})
+	}
+
+	// Log the template change
+	resourceID := template.ID.String()
+	models.CreateAuditLog(h.db, &currentUserID, &currentAppID, models.ActionTemplateUpdate, "notification_template", &resourceID,
+		map[string]interface{}{
+			"client_application_id": applicationID,
+			"kind":                  kind,
+			"locale":                locale,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(NotificationTemplateResponse{
+		Success:  true,
+		Message:  "Notification template saved successfully",
+		Template: template,
+	})
+}
+
+// DeleteNotificationTemplate handles removing a notification template of an application
+// @Summary Delete notification template
+// @Description Remove a template of an application, so its notifications of that kind and locale use the built-in template again
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param kind path string true "Notification kind"
+// @Param locale path string true "Locale"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Notification template deleted"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Notification template not found"
+// @Router /applications/{id}/notification-templates/{kind}/{locale} [delete]
+func (h *ApplicationHandler) DeleteNotificationTemplate(c *fiber.Ctx) error {
+	applicationID, kind, locale, err := notificationTemplateParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: err.Error(),
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, currentAppID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	template, err := models.FindNotificationTemplate(h.db, applicationID, kind, locale)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Notification template not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve notification template", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to delete notification template",
+		})
+	}
+
+	if err := h.db.Delete(template).Error; err != nil {
+		h.logger.Error("Failed to delete notification template", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to delete notification template",
+		})
+	}
+
+	// Log the template removal
+	resourceID := template.ID.String()
+	models.CreateAuditLog(h.db, &currentUserID, &currentAppID, models.ActionTemplateDelete, "notification_template", &resourceID,
+		map[string]interface{}{
+			"client_application_id": applicationID,
+			"kind":                  kind,
+			"locale":                locale,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "Notification template deleted successfully",
+	})
+}
+
+// notificationTemplateParams parses the application, kind and locale of a template route
+func notificationTemplateParams(c *fiber.Ctx) (uuid.UUID, string, string, error) {
+	applicationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, "", "", errors.New("Invalid application ID")
+	}
+
+	kind := c.Params("kind")
+	if !notifications.IsKind(kind) {
+		return uuid.Nil, "", "", errors.New("Unknown notification kind")
+	}
+
+	locale := notifications.NormalizeLocale(c.Params("locale"))
+	if locale == "" {
+		return uuid.Nil, "", "", errors.New("Invalid locale")
+	}
+
+	return applicationID, kind, locale, nil
+}
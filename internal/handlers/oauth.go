@@ -0,0 +1,1155 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errInvalidClient = errors.New("client authentication failed")
+
+// OAuthAuthorizeRequest represents an OAuth 2.0 authorization request
+type OAuthAuthorizeRequest struct {
+	ResponseType        string `query:"response_type" form:"response_type"`
+	ClientID            string `query:"client_id" form:"client_id"`
+	RedirectURI         string `query:"redirect_uri" form:"redirect_uri"`
+	Scope               string `query:"scope" form:"scope"`
+	State               string `query:"state" form:"state"`
+	Nonce               string `query:"nonce" form:"nonce"`
+	CodeChallenge       string `query:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method" form:"code_challenge_method"`
+}
+
+// OAuthTokenRequest represents an OAuth 2.0 token request
+type OAuthTokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	DeviceCode   string `form:"device_code"`
+	Audience     string `form:"audience"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+
+	// Token exchange (RFC 8693)
+	SubjectToken       string `form:"subject_token"`
+	SubjectTokenType   string `form:"subject_token_type"`
+	ActorToken         string `form:"actor_token"`
+	ActorTokenType     string `form:"actor_token_type"`
+	RequestedTokenType string `form:"requested_token_type"`
+
+	dpopKey string // thumbprint of the key of the request's DPoP proof, set by Token
+}
+
+// OAuthTokenResponse represents a successful OAuth 2.0 token response
+type OAuthTokenResponse struct {
+	*auth.TokenPair
+	Scope           string `json:"scope,omitempty"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"` // token exchange only
+}
+
+// OAuthIntrospectRequest represents an RFC 7662 token introspection request
+type OAuthIntrospectRequest struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// OAuthIntrospectResponse represents an RFC 7662 token introspection response
+type OAuthIntrospectResponse struct {
+	Active    bool        `json:"active"`
+	Scope     string      `json:"scope,omitempty"` // space-delimited permissions
+	ClientID  string      `json:"client_id,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  []string    `json:"aud,omitempty"`
+	Issuer    string      `json:"iss,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	JTI       string             `json:"jti,omitempty"`
+	Act       *auth.Actor        `json:"act,omitempty"` // party acting on behalf of the subject
+	Cnf       *auth.Confirmation `json:"cnf,omitempty"` // DPoP key the token is bound to
+}
+
+// OAuthRevokeRequest represents an RFC 7009 token revocation request
+type OAuthRevokeRequest struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// OAuthErrorResponse represents an OAuth 2.0 error response (RFC 6749 section 5.2)
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// oauthAuthorizeError describes why an authorization request was rejected
+type oauthAuthorizeError struct {
+	code        string
+	description string
+	redirect    bool // false when the client or redirect URI cannot be trusted
+}
+
+// Authorize starts the authorization code flow by showing the Authy sign-in page
+// @Summary OAuth 2.0 authorization endpoint
+// @Description Validate an authorization code request (PKCE S256 required) and show the Authy sign-in page
+// @Tags OAuth
+// @Produce html
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Application ID"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param scope query string false "Requested scopes (include openid for an id_token)"
+// @Param state query string false "Opaque value returned to the client"
+// @Param nonce query string false "OpenID Connect nonce"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Success 200 {string} string "Sign-in page"
+// @Failure 302 {string} string "Redirect to the client with an error"
+// @Failure 400 {string} string "Invalid client or redirect URI"
+// @Router /oauth/authorize [get]
+func (h *AuthHandler) Authorize(c *fiber.Ctx) error {
+	var req OAuthAuthorizeRequest
+	if err := c.QueryParser(&req); err != nil {
+		return h.rejectAuthorizeRequest(c, &req, &oauthAuthorizeError{"invalid_request", "Malformed authorization request", false})
+	}
+
+	app, authErr := h.validateAuthorizeRequest(&req)
+	if authErr != nil {
+		return h.rejectAuthorizeRequest(c, &req, authErr)
+	}
+
+	return h.renderAuthorizePage(c, fiber.StatusOK, &req, app, "")
+}
+
+// AuthorizeLogin authenticates the user on the sign-in page and redirects back with an authorization code
+// @Summary OAuth 2.0 sign-in
+// @Description Verify the user's credentials and redirect to the client with a single-use authorization code
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param email formData string true "User email"
+// @Param password formData string true "User password"
+// @Param otp formData string false "Authenticator or recovery code (users who enrolled a second factor)"
+// @Success 303 {string} string "Redirect to the client with code and state"
+// @Failure 400 {string} string "Invalid client or redirect URI"
+// @Failure 401 {string} string "Sign-in page with an error"
+// @Router /oauth/authorize [post]
+func (h *AuthHandler) AuthorizeLogin(c *fiber.Ctx) error {
+	var req OAuthAuthorizeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return h.rejectAuthorizeRequest(c, &req, &oauthAuthorizeError{"invalid_request", "Malformed authorization request", false})
+	}
+
+	app, authErr := h.validateAuthorizeRequest(&req)
+	if authErr != nil {
+		return h.rejectAuthorizeRequest(c, &req, authErr)
+	}
+
+	email := c.FormValue("email")
+	password := c.FormValue("password")
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	// Find the user
+	var user models.User
+	if err := h.db.Where("LOWER(email) = LOWER(?) AND is_active = true", strings.TrimSpace(email)).First(&user).Error; err != nil {
+		models.CreateAuditLog(h.db, nil, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"email":      email,
+				"grant_type": models.GrantTypeAuthorizationCode,
+				"reason":     "user_not_found",
+			}, &clientIP, &userAgent)
+
+		return h.renderAuthorizePage(c, fiber.StatusUnauthorized, &req, app, "Invalid email or password")
+	}
+
+	// Verify password
+	if !user.CheckPassword(password) {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"email":      email,
+				"grant_type": models.GrantTypeAuthorizationCode,
+				"reason":     "invalid_password",
+			}, &clientIP, &userAgent)
+
+		return h.renderAuthorizePage(c, fiber.StatusUnauthorized, &req, app, "Invalid email or password")
+	}
+
+	// Applications may refuse users until they verify their email address
+	if emailVerificationRequired(app, &user) {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"email":      email,
+				"grant_type": models.GrantTypeAuthorizationCode,
+				"reason":     "email_not_verified",
+			}, &clientIP, &userAgent)
+
+		return h.renderAuthorizePage(c, fiber.StatusForbidden, &req, app, emailNotVerifiedMessage)
+	}
+
+	// Users who enrolled a second factor also need a code of their authenticator app or a recovery code
+	amr, err := h.secondFactor(context.Background(), &user, app, c.FormValue("otp"))
+	if err != nil {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionMFAFailed, "authentication", nil,
+			map[string]interface{}{
+				"method":     mfaMethodTOTP,
+				"grant_type": models.GrantTypeAuthorizationCode,
+			}, &clientIP, &userAgent)
+
+		return h.renderAuthorizePage(c, fiber.StatusUnauthorized, &req, app, mfaErrorMessage(err))
+	}
+
+	// Issue a short-lived authorization code bound to the client, redirect URI and PKCE challenge
+	code, err := h.sessionService.StoreAuthorizationCode(context.Background(), &auth.AuthorizationCode{
+		ClientID:            app.ID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            time.Now(),
+		AMR:                 amr,
+		ClientIP:            clientIP.String(),
+		UserAgent:           userAgent,
+	})
+	if err != nil {
+		h.logger.Error("Failed to store authorization code", "error", err)
+		return h.rejectAuthorizeRequest(c, &req, &oauthAuthorizeError{"server_error", "Failed to issue authorization code", true})
+	}
+
+	// Log successful login
+	models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLogin, "authentication", nil,
+		map[string]interface{}{
+			"email":      email,
+			"grant_type": models.GrantTypeAuthorizationCode,
+			"scope":      req.Scope,
+		}, &clientIP, &userAgent)
+
+	params := url.Values{}
+	params.Set("code", code)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	return c.Redirect(appendQuery(req.RedirectURI, params), fiber.StatusSeeOther)
+}
+
+// Token exchanges a grant for tokens
+// @Summary OAuth 2.0 token endpoint
+// @Description Exchange an authorization code (with PKCE verifier), device code, refresh token or client credentials for tokens. Confidential clients authenticate with their API key as client secret.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, refresh_token, client_credentials, urn:ietf:params:oauth:grant-type:token-exchange or urn:ietf:params:oauth:grant-type:device_code"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI used in the authorization request"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param device_code formData string false "Device code from the device authorization endpoint"
+// @Param audience formData string false "Target application ID for client_credentials and token exchange (defaults to the client itself)"
+// @Param scope formData string false "Space-delimited permissions to down-scope an exchanged token to"
+// @Param subject_token formData string false "Token exchange: access token of the subject, or user ID to impersonate"
+// @Param subject_token_type formData string false "Token exchange: urn:ietf:params:oauth:token-type:access_token or urn:authy:params:oauth:token-type:user_id"
+// @Param actor_token formData string false "Token exchange: access token of the acting user (required to impersonate)"
+// @Param actor_token_type formData string false "Token exchange: urn:ietf:params:oauth:token-type:access_token"
+// @Param requested_token_type formData string false "Token exchange: urn:ietf:params:oauth:token-type:access_token"
+// @Param client_id formData string false "Application ID (when not using HTTP Basic authentication)"
+// @Param client_secret formData string false "Application API key (confidential clients)"
+// @Param DPoP header string false "DPoP proof binding the issued tokens to the client's key"
+// @Success 200 {object} OAuthTokenResponse "Issued tokens"
+// @Failure 400 {object} OAuthErrorResponse "Invalid grant or request, or device authorization_pending / slow_down"
+// @Failure 401 {object} OAuthErrorResponse "Client authentication failed"
+// @Router /oauth/token [post]
+func (h *AuthHandler) Token(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var req OAuthTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Invalid request body")
+	}
+
+	app, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return h.rejectClient(c, map[string]interface{}{
+			"grant_type": req.GrantType,
+			"reason":     "invalid_client",
+		})
+	}
+
+	if models.IsValidGrantType(req.GrantType) && !app.AllowsGrantType(req.GrantType) {
+		return oauthError(c, fiber.StatusBadRequest, "unauthorized_client", "Grant type not allowed for this client")
+	}
+
+	// Bind the issued tokens to the client's key when it sent a DPoP proof
+	if req.dpopKey, err = h.verifyDPoP(c, app); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_dpop_proof", dpopErrorDescription(err))
+	}
+
+	switch req.GrantType {
+	case models.GrantTypeAuthorizationCode:
+		return h.exchangeAuthorizationCode(c, app, &req)
+	case models.GrantTypeRefreshToken:
+		return h.exchangeRefreshToken(c, app, &req)
+	case models.GrantTypeClientCredentials:
+		return h.exchangeClientCredentials(c, app, &req)
+	case models.GrantTypeTokenExchange:
+		return h.exchangeToken(c, app, &req)
+	case models.GrantTypeDeviceCode:
+		return h.exchangeDeviceCode(c, app, &req)
+	default:
+		return oauthError(c, fiber.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type")
+	}
+}
+
+// Introspect reports whether a token is active (RFC 7662)
+// @Summary OAuth 2.0 token introspection
+// @Description Return the state of an access or refresh token. The caller authenticates with its application credentials and can only introspect tokens issued for its own audience.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Param client_id formData string false "Application ID (when not using HTTP Basic authentication)"
+// @Param client_secret formData string false "Application API key (when not using HTTP Basic authentication)"
+// @Success 200 {object} OAuthIntrospectResponse "Token state"
+// @Failure 400 {object} OAuthErrorResponse "Invalid request"
+// @Failure 401 {object} OAuthErrorResponse "Client authentication failed"
+// @Router /oauth/introspect [post]
+func (h *AuthHandler) Introspect(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var req OAuthIntrospectRequest
+	if err := c.BodyParser(&req); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Invalid request body")
+	}
+
+	// Only confidential clients can prove who is asking
+	app, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if err != nil || app.IsPublicClient() {
+		return h.rejectClient(c, map[string]interface{}{
+			"endpoint": "introspect",
+			"reason":   "invalid_client",
+		})
+	}
+
+	if req.Token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	inactive := OAuthIntrospectResponse{Active: false}
+
+	// Tokens issued for another audience are reported as inactive so their existence is not disclosed
+	claims, err := h.sessionService.IntrospectToken(context.Background(), req.Token, app.ID)
+	if err != nil {
+		var audienceErr *auth.AudienceError
+		if errors.As(err, &audienceErr) {
+			middleware.AuditAudienceRejection(c, h.db, audienceErr)
+		}
+		return c.Status(fiber.StatusOK).JSON(inactive)
+	}
+
+	// A user token stops being active as soon as the user is deactivated
+	if !claims.IsServicePrincipal() {
+		var count int64
+		if err := h.db.Model(&models.User{}).Where("id = ? AND is_active = true", claims.UserID).Count(&count).Error; err != nil || count == 0 {
+			return c.Status(fiber.StatusOK).JSON(inactive)
+		}
+	}
+
+	response := OAuthIntrospectResponse{
+		Active:   true,
+		Scope:    strings.Join(claims.Permissions, " "),
+		ClientID: claims.IssuedTo().String(),
+		Subject:  claims.Subject,
+		Audience: claims.Audience,
+		Issuer:   claims.Issuer,
+		JTI:      claims.ID,
+		Act:      claims.Act,
+		Cnf:      claims.Cnf,
+	}
+	if claims.ExpiresAt != nil {
+		response.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response.IssuedAt = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		response.NotBefore = claims.NotBefore.Unix()
+	}
+	if claims.TokenType == auth.AccessTokenType {
+		response.TokenType = "Bearer"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// Revoke invalidates a token (RFC 7009)
+// @Summary OAuth 2.0 token revocation
+// @Description Revoke an access or refresh token issued to the calling client. Revoking a refresh token ends its whole session, including the access tokens issued from it.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to revoke"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Param client_id formData string false "Application ID (when not using HTTP Basic authentication)"
+// @Param client_secret formData string false "Application API key (confidential clients)"
+// @Success 200 "Token revoked (also returned for unknown or already invalid tokens)"
+// @Failure 400 {object} OAuthErrorResponse "Invalid request"
+// @Failure 401 {object} OAuthErrorResponse "Client authentication failed"
+// @Router /oauth/revoke [post]
+func (h *AuthHandler) Revoke(c *fiber.Ctx) error {
+	var req OAuthRevokeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Invalid request body")
+	}
+
+	app, err := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return h.rejectClient(c, map[string]interface{}{
+			"endpoint": "revoke",
+			"reason":   "invalid_client",
+		})
+	}
+
+	if req.Token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	// Invalid, expired and already revoked tokens need no further action
+	ctx := context.Background()
+	claims, err := h.sessionService.InspectToken(ctx, req.Token)
+	if err != nil {
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	if claims.IssuedTo() != app.ID {
+		return oauthError(c, fiber.StatusBadRequest, "unauthorized_client", "Token was not issued to this client")
+	}
+
+	tokenHash := models.HashTokenString(req.Token)
+	if err := h.sessionService.InvalidateTokenHash(ctx, tokenHash, claims.ExpiresAt.Time); err != nil {
+		h.logger.Error("Failed to invalidate token", "error", err)
+		return oauthError(c, fiber.StatusServiceUnavailable, "temporarily_unavailable", "Failed to revoke token")
+	}
+
+	revoked := 1
+	var sessionID *uuid.UUID
+	if storedToken, err := models.FindTokenByHash(h.db, tokenHash); err == nil {
+		sessionID = storedToken.SessionID
+
+		if claims.TokenType == auth.RefreshTokenType && sessionID != nil {
+			// Revoking a refresh token ends its session, including the access tokens issued from it
+			count, err := revokeSession(ctx, h.db, h.sessionService, *sessionID)
+			if err != nil {
+				h.logger.Error("Failed to revoke session tokens", "session_id", *sessionID, "error", err)
+			}
+			revoked += count
+		} else if err := storedToken.InvalidateToken(h.db); err != nil {
+			h.logger.Error("Failed to invalidate token in database", "error", err)
+		}
+	}
+
+	userID := claims.UserID
+	models.CreateAuditLog(h.db, &userID, &app.ID, models.ActionTokenRevoke, "token", &claims.ID,
+		map[string]interface{}{
+			"token_type":     claims.TokenType,
+			"session_id":     sessionID,
+			"revoked_tokens": revoked,
+		}, &clientIP, &userAgent)
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// exchangeAuthorizationCode redeems an authorization code for tokens
+func (h *AuthHandler) exchangeAuthorizationCode(c *fiber.Ctx, app *models.Application, req *OAuthTokenRequest) error {
+	if req.Code == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "code is required")
+	}
+
+	authCode, err := h.sessionService.ConsumeAuthorizationCode(context.Background(), req.Code)
+	if err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code")
+	}
+
+	if authCode.ClientID != app.ID {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client")
+	}
+
+	if authCode.RedirectURI != req.RedirectURI {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+	}
+
+	if err := auth.VerifyPKCE(req.CodeVerifier, authCode.CodeChallenge, authCode.CodeChallengeMethod); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", authCode.UserID).First(&user).Error; err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "User is no longer active")
+	}
+
+	// Get user permissions for this application
+	permissions, err := h.getUserPermissions(user.ID, app.ID)
+	if err != nil {
+		h.logger.Error("Failed to get user permissions", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+	}
+
+	// Record the session against where the user signed in, not the client's token request
+	tokenPair, accessClaims, err := h.issueTokenPair(&user, app, permissions, req.dpopKey, authCode.AMR, net.ParseIP(authCode.ClientIP), authCode.UserAgent)
+	if err != nil {
+		h.logger.Error("Failed to generate tokens", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to generate tokens")
+	}
+
+	// Issue an OpenID Connect id_token when requested
+	if auth.HasScope(authCode.Scope, auth.ScopeOpenID) {
+		idToken, err := h.sessionService.GenerateIDToken(userIdentity(&user), app.ID, authCode.Nonce, authCode.AuthTime, tokenPair.AccessToken)
+		if err != nil {
+			h.logger.Error("Failed to generate id token", "error", err)
+			return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to generate tokens")
+		}
+		tokenPair.IDToken = idToken
+	}
+
+	h.logger.Info("Authorization code exchanged", "client_id", app.ID, "user_id", user.ID, "token_id", accessClaims.ID)
+
+	return c.Status(fiber.StatusOK).JSON(OAuthTokenResponse{
+		TokenPair: tokenPair,
+		Scope:     authCode.Scope,
+	})
+}
+
+// exchangeRefreshToken rotates a refresh token issued to the client
+func (h *AuthHandler) exchangeRefreshToken(c *fiber.Ctx, app *models.Application, req *OAuthTokenRequest) error {
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	ctx := context.Background()
+	claims, err := h.validateRefreshToken(ctx, req.RefreshToken, clientIP, userAgent)
+	if err == errRefreshTokenReused {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Refresh token reuse detected, session revoked")
+	}
+	if err != nil || claims.ApplicationID != app.ID {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+	}
+	if !matchesDPoPKey(claims, req.dpopKey) {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_dpop_proof", "Refresh token is bound to another DPoP key")
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", claims.UserID).First(&user).Error; err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "User is no longer active")
+	}
+
+	// Get updated user permissions
+	permissions, err := h.getUserPermissions(user.ID, app.ID)
+	if err != nil {
+		h.logger.Error("Failed to get user permissions", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+	}
+
+	tokenPair, err := h.rotateRefreshToken(ctx, req.RefreshToken, app, permissions, req.dpopKey, clientIP, userAgent)
+	if err == errRefreshTokenReused {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Refresh token reuse detected, session revoked")
+	}
+	if err != nil {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionTokenRefresh, "authentication", nil,
+			map[string]interface{}{
+				"grant_type": models.GrantTypeRefreshToken,
+				"reason":     "refresh_failed",
+				"error":      err.Error(),
+			}, &clientIP, &userAgent)
+
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+	}
+
+	models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionTokenRefresh, "authentication", nil,
+		map[string]interface{}{
+			"grant_type": models.GrantTypeRefreshToken,
+			"success":    true,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(OAuthTokenResponse{
+		TokenPair: tokenPair,
+	})
+}
+
+// exchangeClientCredentials issues an access token to the application itself, carrying its service principal permissions
+func (h *AuthHandler) exchangeClientCredentials(c *fiber.Ctx, app *models.Application, req *OAuthTokenRequest) error {
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	// Public clients cannot keep a secret, so they can never act on their own behalf
+	if app.IsPublicClient() {
+		return oauthError(c, fiber.StatusBadRequest, "unauthorized_client", "Public clients cannot use client credentials")
+	}
+
+	audienceID := app.ID
+	if req.Audience != "" {
+		parsedID, err := uuid.Parse(req.Audience)
+		if err != nil {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_target", "Unknown audience")
+		}
+
+		if parsedID != app.ID {
+			hasAccess, err := models.HasServicePrincipalAccess(h.db, app.ID, parsedID)
+			if err != nil {
+				h.logger.Error("Failed to check service principal access", "error", err)
+				return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+			}
+			if !hasAccess {
+				return oauthError(c, fiber.StatusBadRequest, "invalid_target", "Client has no roles in the requested audience")
+			}
+		}
+		audienceID = parsedID
+	}
+
+	permissions, err := models.GetServicePrincipalPermissionStrings(h.db, app.ID, audienceID)
+	if err != nil {
+		h.logger.Error("Failed to get service principal permissions", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+	}
+
+	// The audience's policy sets the token lifetime
+	audience := app
+	if audienceID != app.ID {
+		audience = &models.Application{}
+		if err := h.db.First(audience, audienceID).Error; err != nil {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_target", "Unknown audience")
+		}
+	}
+
+	if audience.RequireDPoP && req.dpopKey == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_dpop_proof", dpopErrorDescription(auth.ErrDPoPRequired))
+	}
+
+	accessToken, claims, err := h.sessionService.GenerateClientToken(app.ID, audienceID, permissions, boundSessionPolicy(audience, req.dpopKey))
+	if err != nil {
+		h.logger.Error("Failed to generate client token", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to generate tokens")
+	}
+
+	if err := h.sessionService.StoreToken(context.Background(), accessToken, claims); err != nil {
+		h.logger.Error("Failed to store access token", "error", err)
+
+		// Service principal tokens are not recorded in the database, so an opaque one exists only in the cache
+		if auth.IsReferenceToken(accessToken) {
+			return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to generate tokens")
+		}
+	}
+
+	models.CreateAuditLog(h.db, nil, &app.ID, models.ActionServiceTokenIssue, "authentication", nil,
+		map[string]interface{}{
+			"grant_type":  models.GrantTypeClientCredentials,
+			"audience":    audienceID,
+			"token_id":    claims.ID,
+			"permissions": permissions,
+			"expires_at":  claims.ExpiresAt.Time,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(OAuthTokenResponse{
+		TokenPair: &auth.TokenPair{
+			AccessToken: accessToken,
+			TokenType:   claims.AuthorizationScheme(),
+			ExpiresIn:   int64(claims.ExpiresAt.Sub(claims.IssuedAt.Time).Seconds()),
+		},
+	})
+}
+
+// exchangeToken swaps a subject token for a down-scoped access token aimed at another audience (RFC 8693).
+// The client is recorded as the actor, unless an actor token names the acting user. Impersonating a user
+// by ID requires an actor granted the authy_users:impersonate permission in the Authy system application.
+func (h *AuthHandler) exchangeToken(c *fiber.Ctx, app *models.Application, req *OAuthTokenRequest) error {
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	if app.IsPublicClient() {
+		return oauthError(c, fiber.StatusBadRequest, "unauthorized_client", "Public clients cannot exchange tokens")
+	}
+
+	if req.RequestedTokenType != "" && req.RequestedTokenType != auth.TokenTypeAccessToken {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Only access tokens can be requested")
+	}
+
+	if req.SubjectToken == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "subject_token is required")
+	}
+
+	ctx := context.Background()
+
+	// The actor, when given, must be a user signed in to the client
+	var actorClaims *auth.Claims
+	if req.ActorToken != "" {
+		if req.ActorTokenType != auth.TokenTypeAccessToken {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Unsupported actor_token_type")
+		}
+
+		claims, err := h.presentedAccessToken(ctx, c, req.ActorToken, app)
+		if err != nil || claims.IsServicePrincipal() {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid actor token")
+		}
+		if !matchesDPoPKey(claims, req.dpopKey) {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_dpop_proof", "Actor token is bound to another DPoP key")
+		}
+		actorClaims = claims
+	}
+
+	exchange := &auth.TokenExchange{
+		ClientID:   app.ID,
+		AudienceID: app.ID,
+	}
+
+	var subjectClaims *auth.Claims
+	switch req.SubjectTokenType {
+	case auth.TokenTypeAccessToken:
+		claims, err := h.presentedAccessToken(ctx, c, req.SubjectToken, app)
+		if err != nil || claims.IsServicePrincipal() {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid subject token")
+		}
+		if !matchesDPoPKey(claims, req.dpopKey) {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_dpop_proof", "Subject token is bound to another DPoP key")
+		}
+		subjectClaims = claims
+
+		exchange.UserID = claims.UserID
+		exchange.SessionID = claims.SessionID
+		exchange.AuthTime = claims.IssuedAt.Time
+		if claims.AuthTime != nil {
+			exchange.AuthTime = claims.AuthTime.Time
+		}
+		exchange.AMR = claims.AMR
+		exchange.NotAfter = claims.ExpiresAt.Time
+	case auth.TokenTypeUserID:
+		if actorClaims == nil {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_request", "actor_token is required to impersonate a user")
+		}
+		// Only an explicit grant counts: the actor token's own audience and wildcard permissions don't
+		canImpersonate, err := models.HasSystemPermission(h.db, actorClaims.UserID, "authy_users", "impersonate")
+		if err != nil {
+			h.logger.Error("Failed to check impersonation permission", "error", err)
+			return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+		}
+		if !canImpersonate {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Actor is not allowed to impersonate users")
+		}
+
+		userID, err := uuid.Parse(req.SubjectToken)
+		if err != nil {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Unknown subject")
+		}
+
+		exchange.UserID = userID
+		exchange.SessionID = uuid.New()
+		exchange.AuthTime = time.Now()
+	default:
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Unsupported subject_token_type")
+	}
+
+	if exchange.SessionID == uuid.Nil {
+		exchange.SessionID = uuid.New() // subject token issued before sessions were tracked
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", exchange.UserID).First(&user).Error; err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Unknown subject")
+	}
+
+	// Other audiences need the same grant as the client credentials flow
+	audience := app
+	if req.Audience != "" {
+		audienceID, err := uuid.Parse(req.Audience)
+		if err != nil {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_target", "Unknown audience")
+		}
+
+		if audienceID != app.ID {
+			hasAccess, err := models.HasServicePrincipalAccess(h.db, app.ID, audienceID)
+			if err != nil {
+				h.logger.Error("Failed to check service principal access", "error", err)
+				return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+			}
+			if !hasAccess {
+				return oauthError(c, fiber.StatusBadRequest, "invalid_target", "Client has no roles in the requested audience")
+			}
+
+			audience = &models.Application{}
+			if err := h.db.First(audience, audienceID).Error; err != nil {
+				return oauthError(c, fiber.StatusBadRequest, "invalid_target", "Unknown audience")
+			}
+		}
+	}
+	exchange.AudienceID = audience.ID
+
+	if audience.RequireDPoP && req.dpopKey == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_dpop_proof", dpopErrorDescription(auth.ErrDPoPRequired))
+	}
+
+	// An exchanged token never carries more than the subject holds; within the subject token's own
+	// audience, no more than the subject token itself
+	available, err := h.getUserPermissions(user.ID, audience.ID)
+	if err != nil {
+		h.logger.Error("Failed to get user permissions", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+	}
+	if subjectClaims != nil && subjectClaims.ApplicationID == audience.ID {
+		available = intersectPermissions(available, subjectClaims.Permissions)
+	}
+
+	// Impersonating another impersonator (or an administrator) would escalate the actor's access
+	if subjectClaims == nil {
+		isImpersonator, err := models.HasSystemPermission(h.db, user.ID, "authy_users", "impersonate")
+		if err != nil {
+			h.logger.Error("Failed to check impersonation permission", "error", err)
+			return oauthError(c, fiber.StatusInternalServerError, "server_error", "Internal server error")
+		}
+		if isImpersonator || middleware.HasPermission(available, "users", "impersonate") {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "This user cannot be impersonated")
+		}
+	}
+
+	exchange.Permissions = available
+	if req.Scope != "" {
+		requested := strings.Fields(req.Scope)
+		if len(intersectPermissions(requested, available)) != len(requested) {
+			return oauthError(c, fiber.StatusBadRequest, "invalid_scope", "Requested scope exceeds the subject's permissions")
+		}
+		exchange.Permissions = requested
+	}
+
+	// Record who is acting, keeping any earlier actors of the subject token
+	exchange.Actor = &auth.Actor{
+		Subject:  app.ID.String(),
+		ClientID: app.ID.String(),
+	}
+	if actorClaims != nil {
+		exchange.Actor.Subject = actorClaims.UserID.String()
+	}
+	if subjectClaims != nil {
+		exchange.Actor.Actor = subjectClaims.Act
+	}
+
+	accessToken, claims, err := h.sessionService.ExchangeToken(ctx, exchange, boundSessionPolicy(audience, req.dpopKey))
+	if err == auth.ErrSessionExpired {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Session has expired")
+	}
+	if err != nil {
+		h.logger.Error("Failed to exchange token", "error", err)
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to generate tokens")
+	}
+
+	if err := h.sessionService.StoreToken(ctx, accessToken, claims); err != nil {
+		h.logger.Error("Failed to store access token", "error", err)
+	}
+
+	h.recordToken(claims.SessionID, models.AccessToken, accessToken, claims, clientIP, userAgent)
+
+	details := map[string]interface{}{
+		"grant_type":    models.GrantTypeTokenExchange,
+		"client_id":     app.ID,
+		"actor":         claims.Act,
+		"impersonation": subjectClaims == nil,
+		"session_id":    claims.SessionID,
+		"token_id":      claims.ID,
+		"permissions":   claims.Permissions,
+		"expires_at":    claims.ExpiresAt.Time,
+	}
+	if actorClaims != nil {
+		details["actor_user_id"] = actorClaims.UserID
+	}
+	models.CreateAuditLog(h.db, &user.ID, &audience.ID, models.ActionTokenExchange, "token", nil, details, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(OAuthTokenResponse{
+		TokenPair: &auth.TokenPair{
+			AccessToken: accessToken,
+			TokenType:   claims.AuthorizationScheme(),
+			ExpiresIn:   int64(time.Until(claims.ExpiresAt.Time).Round(time.Second).Seconds()),
+		},
+		Scope:           strings.Join(claims.Permissions, " "),
+		IssuedTokenType: auth.TokenTypeAccessToken,
+	})
+}
+
+// presentedAccessToken validates an access token a client presents on behalf of a user, which must have
+// been issued for that client. Tokens of other applications are audited as audience rejections.
+func (h *AuthHandler) presentedAccessToken(ctx context.Context, c *fiber.Ctx, token string, app *models.Application) (*auth.Claims, error) {
+	// Introspection returns the complete claims, including expiry and actor
+	claims, err := h.sessionService.IntrospectToken(ctx, token, app.ID)
+	if err != nil {
+		var audienceErr *auth.AudienceError
+		if errors.As(err, &audienceErr) {
+			middleware.AuditAudienceRejection(c, h.db, audienceErr)
+		}
+		return nil, err
+	}
+
+	if claims.TokenType != auth.AccessTokenType {
+		return nil, auth.ErrInvalidTokenType
+	}
+
+	return claims, nil
+}
+
+// intersectPermissions returns the permissions of requested that are also in allowed
+func intersectPermissions(requested, allowed []string) []string {
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, permission := range allowed {
+		allowedSet[permission] = true
+	}
+
+	var permissions []string
+	for _, permission := range requested {
+		if allowedSet[permission] {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// validateAuthorizeRequest checks the client, redirect URI and PKCE parameters of an authorization request
+func (h *AuthHandler) validateAuthorizeRequest(req *OAuthAuthorizeRequest) (*models.Application, *oauthAuthorizeError) {
+	clientID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		return nil, &oauthAuthorizeError{"invalid_request", "Unknown client", false}
+	}
+
+	var app models.Application
+	if err := h.db.First(&app, clientID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, &oauthAuthorizeError{"invalid_request", "Unknown client", false}
+		}
+		h.logger.Error("Failed to retrieve application", "error", err)
+		return nil, &oauthAuthorizeError{"server_error", "Internal server error", false}
+	}
+
+	// Never redirect to an unregistered URI, not even to report an error
+	if req.RedirectURI == "" || !app.HasRedirectURI(req.RedirectURI) {
+		return nil, &oauthAuthorizeError{"invalid_request", "redirect_uri is not registered for this client", false}
+	}
+
+	if req.ResponseType != "code" {
+		return nil, &oauthAuthorizeError{"unsupported_response_type", "Only the code response type is supported", true}
+	}
+
+	if !app.AllowsGrantType(models.GrantTypeAuthorizationCode) {
+		return nil, &oauthAuthorizeError{"unauthorized_client", "Authorization code grant not allowed for this client", true}
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != auth.PKCEMethodS256 {
+		return nil, &oauthAuthorizeError{"invalid_request", "PKCE with code_challenge_method S256 is required", true}
+	}
+
+	return &app, nil
+}
+
+// rejectAuthorizeRequest reports an authorization error to the client, or on the page when the client cannot be trusted
+func (h *AuthHandler) rejectAuthorizeRequest(c *fiber.Ctx, req *OAuthAuthorizeRequest, authErr *oauthAuthorizeError) error {
+	if authErr.redirect {
+		params := url.Values{}
+		params.Set("error", authErr.code)
+		params.Set("error_description", authErr.description)
+		if req.State != "" {
+			params.Set("state", req.State)
+		}
+		return c.Redirect(appendQuery(req.RedirectURI, params), fiber.StatusFound)
+	}
+
+	return h.renderAuthorizePage(c, fiber.StatusBadRequest, nil, nil, authErr.description)
+}
+
+// renderAuthorizePage renders the Authy sign-in page; without a request only the error is shown
+func (h *AuthHandler) renderAuthorizePage(c *fiber.Ctx, status int, req *OAuthAuthorizeRequest, app *models.Application, message string) error {
+	data := authorizePageData{Request: req, Error: message}
+	if app != nil {
+		data.ApplicationName = app.Name
+	}
+
+	var page strings.Builder
+	if err := authorizePageTemplate.Execute(&page, data); err != nil {
+		h.logger.Error("Failed to render authorization page", "error", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	}
+
+	// The sign-in page must never be framed by another site
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderContentSecurityPolicy, "frame-ancestors 'none'")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(status).SendString(page.String())
+}
+
+// authenticateClient identifies the calling application with HTTP Basic or form credentials.
+// Confidential clients must present their API key as client secret; public clients are only identified.
+func (h *AuthHandler) authenticateClient(c *fiber.Ctx, clientID, clientSecret string) (*models.Application, error) {
+	if basicID, basicSecret, ok := parseBasicAuth(c.Get(fiber.HeaderAuthorization)); ok {
+		if clientSecret != "" {
+			return nil, errInvalidClient // only one authentication method is allowed
+		}
+		clientID, clientSecret = basicID, basicSecret
+	}
+
+	applicationID, err := uuid.Parse(clientID)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+
+	var app models.Application
+	if err := h.db.First(&app, applicationID).Error; err != nil {
+		return nil, errInvalidClient
+	}
+
+	if app.IsPublicClient() {
+		return &app, nil
+	}
+
+	if clientSecret == "" || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(app.APIKey)) != 1 {
+		return nil, errInvalidClient
+	}
+
+	return &app, nil
+}
+
+// rejectClient audits a failed client authentication and answers with invalid_client
+func (h *AuthHandler) rejectClient(c *fiber.Ctx, details map[string]interface{}) error {
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+	models.CreateAuditLog(h.db, nil, nil, models.ActionLoginFailed, "authentication", nil, details, &clientIP, &userAgent)
+
+	c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="authy"`)
+	return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication failed")
+}
+
+// parseBasicAuth extracts client credentials from an HTTP Basic Authorization header (RFC 6749 section 2.3.1)
+func parseBasicAuth(header string) (string, string, bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+
+	id, secret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+
+	id, err = url.QueryUnescape(id)
+	if err != nil {
+		return "", "", false
+	}
+	secret, err = url.QueryUnescape(secret)
+	if err != nil {
+		return "", "", false
+	}
+
+	return id, secret, true
+}
+
+// appendQuery adds parameters to a redirect URI, preserving any query it already has
+func appendQuery(redirectURI string, params url.Values) string {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := parsed.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String()
+}
+
+// oauthError writes an OAuth 2.0 error response
+func oauthError(c *fiber.Ctx, status int, code, description string) error {
+	return c.Status(status).JSON(OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+// authorizePageData holds the values rendered on the sign-in page
+type authorizePageData struct {
+	ApplicationName string
+	Request         *OAuthAuthorizeRequest
+	Error           string
+}
+
+var authorizePageTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in - Authy</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #f4f5f7; display: flex; justify-content: center; padding-top: 10vh; }
+main { background: #fff; padding: 2rem; border-radius: 8px; box-shadow: 0 1px 4px rgba(0,0,0,.1); width: 320px; }
+label { display: block; margin-top: 1rem; font-size: .9rem; }
+input { width: 100%; padding: .5rem; margin-top: .25rem; box-sizing: border-box; }
+button { margin-top: 1.5rem; width: 100%; padding: .6rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<main>
+{{if .Request}}
+<h1>Sign in</h1>
+<p>to continue to <strong>{{.ApplicationName}}</strong></p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<label>Email <input type="email" name="email" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<label>Authentication code <input type="text" name="otp" autocomplete="one-time-code" placeholder="If two-step verification is on, or a recovery code"></label>
+<button type="submit">Sign in</button>
+</form>
+{{else}}
+<h1>Authorization error</h1>
+<p class="error">{{.Error}}</p>
+{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func testCodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// exchangeTestCode redeems an authorization code through the token endpoint's code exchange
+func exchangeTestCode(t *testing.T, h *AuthHandler, app *models.Application, req *OAuthTokenRequest) (int, OAuthErrorResponse) {
+	t.Helper()
+
+	server := fiber.New()
+	server.Post("/token", func(c *fiber.Ctx) error {
+		return h.exchangeAuthorizationCode(c, app, req)
+	})
+
+	resp, err := server.Test(httptest.NewRequest(fiber.MethodPost, "/token", strings.NewReader("")))
+	if err != nil {
+		t.Fatalf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body OAuthErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding token response: %v", err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestExchangeAuthorizationCodeRejectsMismatch(t *testing.T) {
+	app := newTestApplication()
+
+	tests := []struct {
+		name        string
+		clientID    uuid.UUID
+		redirectURI string
+		verifier    string
+	}{
+		{"code verifier mismatch", app.ID, "https://app.test/callback", "wrong-verifier-wrong-verifier-wrong-verifier-0"},
+		{"missing code verifier", app.ID, "https://app.test/callback", ""},
+		{"redirect_uri mismatch", app.ID, "https://app.test/callback/other", testCodeVerifier},
+		{"redirect_uri prefix", app.ID, "https://app.test/call", testCodeVerifier},
+		{"another client", uuid.New(), "https://app.test/callback", testCodeVerifier},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, _ := newTestAuthHandler(t)
+			ctx := context.Background()
+
+			code, err := h.sessionService.StoreAuthorizationCode(ctx, &auth.AuthorizationCode{
+				ClientID:            tt.clientID,
+				UserID:              uuid.New(),
+				RedirectURI:         "https://app.test/callback",
+				CodeChallenge:       testCodeChallenge(testCodeVerifier),
+				CodeChallengeMethod: auth.PKCEMethodS256,
+				AuthTime:            time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("StoreAuthorizationCode() error = %v", err)
+			}
+
+			status, body := exchangeTestCode(t, h, app, &OAuthTokenRequest{
+				Code:         code,
+				RedirectURI:  tt.redirectURI,
+				CodeVerifier: tt.verifier,
+			})
+			if status != fiber.StatusBadRequest || body.Error != "invalid_grant" {
+				t.Fatalf("exchange = %d %q, want 400 invalid_grant", status, body.Error)
+			}
+
+			// A rejected attempt spends the code, so it can't be retried with the right values
+			status, body = exchangeTestCode(t, h, app, &OAuthTokenRequest{
+				Code:         code,
+				RedirectURI:  "https://app.test/callback",
+				CodeVerifier: testCodeVerifier,
+			})
+			if status != fiber.StatusBadRequest || body.ErrorDescription != "Invalid or expired authorization code" {
+				t.Errorf("second exchange = %d %q, want the code to be spent", status, body.ErrorDescription)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// UserInfoResponse represents the OpenID Connect userinfo response
+type UserInfoResponse struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+}
+
+// UserInfo returns standard claims about the user owning the access token
+// @Summary OpenID Connect userinfo
+// @Description Return standard OIDC claims for the authenticated user
+// @Tags Authentication
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} UserInfoResponse "User claims"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Router /userinfo [get]
+func (h *AuthHandler) UserInfo(c *fiber.Ctx) error {
+	userID, _, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+				Error:   true,
+				Message: "User not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve user", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Internal server error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(UserInfoResponse{
+		Subject:       user.ID.String(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Name:          user.GetFullName(),
+		GivenName:     user.FirstName,
+		FamilyName:    user.LastName,
+	})
+}
+
+// userIdentity maps a user onto the standard OIDC identity claims
+func userIdentity(user *models.User) *auth.UserIdentity {
+	return &auth.UserIdentity{
+		UserID:        user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		GivenName:     user.FirstName,
+		FamilyName:    user.LastName,
+	}
+}
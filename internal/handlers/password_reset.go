@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/notifications"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// minPasswordLength is the shortest password users can choose
+const minPasswordLength = 8
+
+// ForgotPasswordRequest represents a request for a password reset link. The optional application
+// selects the templates of the message.
+type ForgotPasswordRequest struct {
+	Email       string `json:"email" validate:"required,email"`
+	Application string `json:"application,omitempty"`
+}
+
+// ResetPasswordRequest represents a password reset with the token of a reset link
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// ForgotPassword sends a password reset link to a user
+// @Summary Request password reset
+// @Description Send a single-use password reset link to the email of an active user, in the language of the Accept-Language header. The response is the same whether or not the email is registered.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Email of the account"
+// @Param Accept-Language header string false "Preferred language of the message"
+// @Success 200 {object} SuccessResponse "Reset link sent if the email is registered"
+// @Failure 400 {object} ErrorResponse "Invalid request or application"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	var applicationID *uuid.UUID
+	if req.Application != "" {
+		var app models.Application
+		if err := h.db.Where("name = ?", req.Application).First(&app).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Invalid application",
+			})
+		}
+		applicationID = &app.ID
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := strings.Clone(c.Get("User-Agent"))
+	locale := notifications.PreferredLocale(strings.Clone(c.Get("Accept-Language")))
+
+	// The link is sent in the background so the response takes as long for unknown emails
+	go h.sendPasswordReset(req.Email, applicationID, locale, clientIP, userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "If the email is registered, a password reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password with the token of a reset link
+// @Summary Reset password
+// @Description Set a new password with the token of a password reset link. The token works once, and every session of the user ends.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} SuccessResponse "Password changed"
+// @Failure 400 {object} ErrorResponse "Invalid request, password too short, or invalid or expired token"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	// Checked before the token is used up, so the user can try another password
+	if len(req.Password) < minPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Password must be at least 8 characters",
+		})
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	invalidToken := ErrorResponse{
+		Error:   true,
+		Message: "Invalid or expired password reset token",
+	}
+
+	ctx := context.Background()
+	userID, err := h.sessionService.ConsumePasswordResetToken(ctx, req.Token)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidToken)
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", userID).First(&user).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(invalidToken)
+	}
+
+	if err := user.SetPassword(req.Password); err != nil {
+		h.logger.Error("Failed to hash password", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to reset password",
+		})
+	}
+	if err := h.db.Model(&user).Update("password_hash", user.PasswordHash).Error; err != nil {
+		h.logger.Error("Failed to save password", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to reset password",
+		})
+	}
+
+	// Whoever knew the old password may hold a session
+	revokedTokens, err := revokeAllUserSessions(ctx, h.db, h.sessionService, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to invalidate user tokens", "error", err)
+	}
+
+	resourceID := user.ID.String()
+	models.CreateAuditLog(h.db, &user.ID, nil, models.ActionPasswordChange, "user", &resourceID,
+		map[string]interface{}{
+			"email":          user.Email,
+			"method":         "reset_token",
+			"revoked_tokens": revokedTokens,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "Password changed",
+	})
+}
+
+// sendPasswordReset issues a reset token for the active user with the given email, if any, and sends them the link
+func (h *AuthHandler) sendPasswordReset(email string, applicationID *uuid.UUID, locale string, clientIP net.IP, userAgent string) {
+	var user models.User
+	if err := h.db.Where("LOWER(email) = LOWER(?) AND is_active = true", strings.TrimSpace(email)).First(&user).Error; err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	token, err := h.sessionService.IssuePasswordResetToken(ctx, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to issue password reset token", "error", err, "user_id", user.ID)
+		return
+	}
+
+	link, err := tokenLink(h.passwordResetURL, token)
+	if err != nil {
+		h.logger.Error("Invalid password reset URL", "error", err)
+		return
+	}
+
+	notification, err := h.notifier.Send(ctx, &notifications.Request{
+		Kind:          notifications.KindPasswordReset,
+		ApplicationID: applicationID,
+		UserID:        &user.ID,
+		To:            user.Email,
+		Locale:        locale,
+		Data: map[string]interface{}{
+			"Name":             user.FirstName,
+			"Link":             link,
+			"ExpiresInMinutes": int(auth.PasswordResetTTL / time.Minute),
+		},
+	})
+	if err != nil {
+		h.logger.Error("Failed to send password reset link", "error", err, "user_id", user.ID)
+		return
+	}
+
+	resourceID := user.ID.String()
+	models.CreateAuditLog(h.db, &user.ID, applicationID, models.ActionPasswordReset, "user", &resourceID,
+		map[string]interface{}{
+			"email":           user.Email,
+			"notification_id": notification.ID,
+		}, &clientIP, &userAgent)
+}
+
+// tokenLink returns the link of a page for a password reset or email verification token
+func tokenLink(pageURL, token string) (string, error) {
+	link, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"strconv"
 
 	"github.com/efrenfuentes/authy/internal/middleware"
 	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/services"
 	"github.com/efrenfuentes/authy/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -13,15 +15,17 @@ import (
 
 // PermissionHandler handles permission-related requests
 type PermissionHandler struct {
-	db     *gorm.DB
-	logger *logger.Logger
+	db                 *gorm.DB
+	logger             *logger.Logger
+	permissionVersions *services.PermissionVersionService
 }
 
 // NewPermissionHandler creates a new permission handler
-func NewPermissionHandler(db *gorm.DB, logger *logger.Logger) *PermissionHandler {
+func NewPermissionHandler(db *gorm.DB, logger *logger.Logger, permissionVersions *services.PermissionVersionService) *PermissionHandler {
 	return &PermissionHandler{
-		db:     db,
-		logger: logger,
+		db:                 db,
+		logger:             logger,
+		permissionVersions: permissionVersions,
 	}
 }
 
@@ -323,6 +327,16 @@ func (h *PermissionHandler) DeletePermission(c *fiber.Ctx) error {
 		})
 	}
 
+	// The cascade hides who held the permission, so collect the holders first
+	holders, err := models.GetPermissionHolders(h.db, permissionID)
+	if err != nil {
+		h.logger.Error("Failed to retrieve permission holders", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to delete permission",
+		})
+	}
+
 	// Delete the permission (this will cascade to role_permissions)
 	if err := h.db.Delete(&permission).Error; err != nil {
 		h.logger.Error("Failed to delete permission", "error", err)
@@ -332,6 +346,11 @@ func (h *PermissionHandler) DeletePermission(c *fiber.Ctx) error {
 		})
 	}
 
+	// Tokens of the holders lose the permission on their next use
+	if err := h.permissionVersions.BumpHolders(context.Background(), holders); err != nil {
+		h.logger.Error("Failed to bump permission versions", "permission_id", permissionID, "error", err)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
 		Success: true,
 		Message: "Permission deleted successfully",
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/notifications"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RegisterRequest represents a user registering themselves with an application
+type RegisterRequest struct {
+	Application string `json:"application" validate:"required"`
+	Email       string `json:"email" validate:"required,email"`
+	Password    string `json:"password" validate:"required,min=8"`
+	FirstName   string `json:"first_name" validate:"required"`
+	LastName    string `json:"last_name" validate:"required"`
+}
+
+// RegisterResponse represents the outcome of a registration and what the user has to do before signing in
+type RegisterResponse struct {
+	Success              bool   `json:"success"`
+	Message              string `json:"message"`
+	VerificationRequired bool   `json:"verification_required"` // the application requires a verified email to sign in
+	ApprovalRequired     bool   `json:"approval_required"`     // the account stays inactive until an admin approves it
+}
+
+// Register creates an account for a user registering themselves with an application
+// @Summary Register
+// @Description Create an account with an application that enabled self-service registration. The email must be on one of the application's allowed domains; the user gets the application's default roles (once the email is verified when the application limits domains) and a verification link in the language of the Accept-Language header. The response is the same whether or not the email is already registered.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body RegisterRequest true "Application and account details"
+// @Param Accept-Language header string false "Preferred language of the verification message"
+// @Success 201 {object} RegisterResponse "Registration received"
+// @Failure 400 {object} ErrorResponse "Invalid request, email, application or password"
+// @Failure 403 {object} ErrorResponse "Registration disabled or email domain not allowed"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/register [post]
+func (h *AuthHandler) Register(c *fiber.Ctx) error {
+	var req RegisterRequest
+	if err := c.BodyParser(&req); err != nil || req.Application == "" || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	email, ok := normalizeRegistrationEmail(req.Email)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid email address",
+		})
+	}
+
+	if strings.TrimSpace(req.FirstName) == "" || strings.TrimSpace(req.LastName) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "First and last name are required",
+		})
+	}
+
+	if len(req.Password) < minPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Password must be at least 8 characters",
+		})
+	}
+
+	var app models.Application
+	if err := h.db.Where("name = ?", req.Application).First(&app).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid application",
+		})
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	if !app.RegistrationEnabled {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Registration is not enabled for this application",
+		})
+	}
+
+	if !app.AllowsRegistrationEmail(email) {
+		models.CreateAuditLog(h.db, nil, &app.ID, models.ActionUserRegister, "user", nil,
+			map[string]interface{}{
+				"email":  email,
+				"reason": "email_domain_not_allowed",
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Email domain is not allowed",
+		})
+	}
+
+	response := RegisterResponse{
+		Success:              true,
+		Message:              "Registration received. Check your email to verify your address.",
+		VerificationRequired: app.RequireVerifiedEmail,
+		ApprovalRequired:     app.RegistrationRequireApproval,
+	}
+	if app.RegistrationRequireApproval {
+		response.Message = "Registration received. You can sign in once an administrator approves your account."
+	}
+
+	user := models.User{
+		Email:     email,
+		FirstName: strings.TrimSpace(req.FirstName),
+		LastName:  strings.TrimSpace(req.LastName),
+		IsActive:  true,
+	}
+
+	// Hashed before the email is looked up, so the response takes as long for registered emails
+	if err := user.SetPassword(req.Password); err != nil {
+		h.logger.Error("Failed to hash password", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to register",
+		})
+	}
+
+	// A registered email gets the same response, so registration cannot tell which emails have accounts
+	var existingUser models.User
+	if err := h.db.Where("LOWER(email) = ?", email).First(&existingUser).Error; err == nil {
+		models.CreateAuditLog(h.db, nil, &app.ID, models.ActionUserRegister, "user", nil,
+			map[string]interface{}{
+				"email":  email,
+				"reason": "email_already_exists",
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusCreated).JSON(response)
+	}
+
+	grantedRoles, err := h.registerUser(&user, &app)
+	if err != nil {
+		h.logger.Error("Failed to register user", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to register",
+		})
+	}
+
+	locale := notifications.PreferredLocale(strings.Clone(c.Get("Accept-Language")))
+	verificationSent := true
+	if _, err := h.emailVerifier.send(context.Background(), &user, user.Email, &app.ID, locale); err != nil {
+		h.logger.Error("Failed to send email verification link", "error", err, "user_id", user.ID)
+		verificationSent = false
+	}
+
+	resourceID := user.ID.String()
+	models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionUserRegister, "user", &resourceID,
+		map[string]interface{}{
+			"email":             user.Email,
+			"first_name":        user.FirstName,
+			"last_name":         user.LastName,
+			"role_ids":          grantedRoles,
+			"roles_pending":     user.PendingRegistrationApplicationID != nil,
+			"approval_pending":  user.ApprovalPending,
+			"verification_sent": verificationSent,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// normalizeRegistrationEmail parses a bare email address with a single @, returning it trimmed and lowercased
+func normalizeRegistrationEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if strings.Count(email, "@") != 1 {
+		return "", false
+	}
+
+	// Display names and quoted or commented local parts parse to a different address
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", false
+	}
+	return email, true
+}
+
+// registerUser creates a registered user with the default roles of the application, returning the granted role IDs.
+// An application limiting email domains grants them only once the email is verified, as until then the domain
+// is only claimed.
+func (h *AuthHandler) registerUser(user *models.User, app *models.Application) ([]uuid.UUID, error) {
+	grantedRoles := []uuid.UUID{}
+
+	if len(app.RegistrationDomains) > 0 {
+		user.PendingRegistrationApplicationID = &app.ID
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+
+		// Set after creating, as the database defaults an omitted is_active to true
+		if app.RegistrationRequireApproval {
+			if err := tx.Model(user).Updates(map[string]interface{}{
+				"is_active":        false,
+				"approval_pending": true,
+			}).Error; err != nil {
+				return err
+			}
+			user.IsActive = false
+			user.ApprovalPending = true
+		}
+
+		if user.PendingRegistrationApplicationID != nil {
+			return nil
+		}
+
+		var err error
+		grantedRoles, err = grantRegistrationRoles(tx, user.ID, app)
+		return err
+	})
+
+	return grantedRoles, err
+}
+
+// grantPendingRegistrationRoles grants a registered user the default roles they wait for once they verify an
+// email address the application allows, returning the granted role IDs. The wait ends with any verification.
+func grantPendingRegistrationRoles(tx *gorm.DB, user *models.User, email string) ([]uuid.UUID, error) {
+	applicationID := user.PendingRegistrationApplicationID
+	if applicationID == nil {
+		return []uuid.UUID{}, nil
+	}
+
+	if err := tx.Model(user).Update("pending_registration_application_id", nil).Error; err != nil {
+		return nil, err
+	}
+	user.PendingRegistrationApplicationID = nil
+
+	// The allowlist may have changed, or the user verified another address, since they registered
+	var app models.Application
+	if err := tx.First(&app, "id = ?", *applicationID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return []uuid.UUID{}, nil
+		}
+		return nil, err
+	}
+	if !app.AllowsRegistrationEmail(email) {
+		return []uuid.UUID{}, nil
+	}
+
+	return grantRegistrationRoles(tx, user.ID, &app)
+}
+
+// grantRegistrationRoles grants a user the default roles of an application, returning the granted role IDs.
+// Default roles deleted since the application was configured are skipped.
+func grantRegistrationRoles(tx *gorm.DB, userID uuid.UUID, app *models.Application) ([]uuid.UUID, error) {
+	grantedRoles := []uuid.UUID{}
+	if len(app.RegistrationRoleIDs) == 0 {
+		return grantedRoles, nil
+	}
+
+	var roles []models.Role
+	if err := tx.Where("id IN ? AND application_id = ?", app.RegistrationRoleIDs, app.ID).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		var existing int64
+		if err := tx.Model(&models.UserRole{}).
+			Where("user_id = ? AND role_id = ? AND application_id = ?", userID, role.ID, app.ID).
+			Count(&existing).Error; err != nil {
+			return nil, err
+		}
+		if existing > 0 {
+			continue
+		}
+
+		userRole := models.UserRole{
+			UserID:        userID,
+			RoleID:        role.ID,
+			ApplicationID: app.ID,
+		}
+		if err := tx.Create(&userRole).Error; err != nil {
+			return nil, err
+		}
+		grantedRoles = append(grantedRoles, role.ID)
+	}
+	return grantedRoles, nil
+}
+
+// ApproveUser handles approving a user who registered with an application requiring approval
+// @Summary Approve registered user
+// @Description Activate a user waiting for approval after registering themselves
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "User approved"
+// @Failure 400 {object} ErrorResponse "Invalid user ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 409 {object} ErrorResponse "User is not waiting for approval"
+// @Router /users/{id}/approve [post]
+func (h *UserHandler) ApproveUser(c *fiber.Ctx) error {
+	userIDStr := c.Params("id")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid user ID",
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, applicationID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   true,
+				Message: "User not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve user", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to approve user",
+		})
+	}
+
+	if !user.ApprovalPending {
+		return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+			Error:   true,
+			Message: "User is not waiting for approval",
+		})
+	}
+
+	if err := h.db.Model(&user).Updates(map[string]interface{}{
+		"is_active":        true,
+		"approval_pending": false,
+	}).Error; err != nil {
+		h.logger.Error("Failed to approve user", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to approve user",
+		})
+	}
+
+	userIDForAudit := user.ID.String()
+	models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionUserActivate, "user",
+		&userIDForAudit,
+		map[string]interface{}{
+			"email":  user.Email,
+			"reason": "registration_approved",
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "User approved",
+	})
+}
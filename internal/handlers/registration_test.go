@@ -0,0 +1,30 @@
+package handlers
+
+import "testing"
+
+func TestNormalizeRegistrationEmail(t *testing.T) {
+	tests := []struct {
+		email  string
+		want   string
+		wantOK bool
+	}{
+		{"ada@example.com", "ada@example.com", true},
+		{"  Ada.Lovelace+authy@Example.COM ", "ada.lovelace+authy@example.com", true},
+		{"ada@evil.com@example.com", "", false},
+		{`"ada@evil.com"@example.com`, "", false},
+		{"Ada <ada@example.com>", "", false},
+		{"ada@example.com (Ada)", "", false},
+		{"ada@example.com, eve@example.com", "", false},
+		{"ada@", "", false},
+		{"@example.com", "", false},
+		{"ada", "", false},
+		{"ada@exa mple.com", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := normalizeRegistrationEmail(tt.email)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("normalizeRegistrationEmail(%q) = %q, %v, want %q, %v", tt.email, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
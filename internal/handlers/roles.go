@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"strconv"
 
 	"github.com/efrenfuentes/authy/internal/middleware"
 	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/internal/services"
 	"github.com/efrenfuentes/authy/pkg/logger"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -13,15 +15,17 @@ import (
 
 // RoleHandler handles role-related requests
 type RoleHandler struct {
-	db     *gorm.DB
-	logger *logger.Logger
+	db                 *gorm.DB
+	logger             *logger.Logger
+	permissionVersions *services.PermissionVersionService
 }
 
 // NewRoleHandler creates a new role handler
-func NewRoleHandler(db *gorm.DB, logger *logger.Logger) *RoleHandler {
+func NewRoleHandler(db *gorm.DB, logger *logger.Logger, permissionVersions *services.PermissionVersionService) *RoleHandler {
 	return &RoleHandler{
-		db:     db,
-		logger: logger,
+		db:                 db,
+		logger:             logger,
+		permissionVersions: permissionVersions,
 	}
 }
 
@@ -679,6 +683,11 @@ func (h *RoleHandler) AssignPermissions(c *fiber.Ctx) error {
 		})
 	}
 
+	// Tokens of the role's holders pick up the new permissions on their next use
+	if err := h.permissionVersions.BumpRole(context.Background(), roleID); err != nil {
+		h.logger.Error("Failed to bump permission versions", "role_id", roleID, "error", err)
+	}
+
 	// Get updated role with permissions
 	if err := h.db.Preload("Application").Preload("Permissions").First(&role, roleID).Error; err != nil {
 		h.logger.Error("Failed to load updated role", "error", err)
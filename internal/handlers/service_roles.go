@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AssignServiceRoleRequest represents the service principal role assignment payload
+type AssignServiceRoleRequest struct {
+	RoleID uuid.UUID `json:"role_id" validate:"required"`
+}
+
+// ServiceRoleResponse represents a role granted to an application acting as a service principal
+type ServiceRoleResponse struct {
+	ID              uuid.UUID `json:"id"`
+	RoleID          uuid.UUID `json:"role_id"`
+	RoleName        string    `json:"role_name"`
+	ApplicationID   uuid.UUID `json:"application_id"`
+	ApplicationName string    `json:"application_name"`
+	GrantedAt       time.Time `json:"granted_at"`
+}
+
+// ServiceRolesListResponse represents the service principal roles list response
+type ServiceRolesListResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message"`
+	Roles   []ServiceRoleResponse `json:"roles"`
+}
+
+// GetServiceRoles handles listing the roles an application holds as a service principal
+// @Summary List service principal roles
+// @Description List roles granted to an application for client credentials tokens
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Security BearerAuth
+// @Success 200 {object} ServiceRolesListResponse "Service principal roles"
+// @Failure 400 {object} ErrorResponse "Invalid application ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /applications/{id}/service-roles [get]
+func (h *ApplicationHandler) GetServiceRoles(c *fiber.Ctx) error {
+	applicationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid application ID",
+		})
+	}
+
+	grants, err := models.GetServicePrincipalRoles(h.db, applicationID)
+	if err != nil {
+		h.logger.Error("Failed to retrieve service principal roles", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to retrieve service roles",
+		})
+	}
+
+	roles := make([]ServiceRoleResponse, 0, len(grants))
+	for _, grant := range grants {
+		roleResponse := ServiceRoleResponse{
+			ID:            grant.ID,
+			RoleID:        grant.RoleID,
+			ApplicationID: grant.ApplicationID,
+			GrantedAt:     grant.GrantedAt,
+		}
+		if grant.Role != nil {
+			roleResponse.RoleName = grant.Role.Name
+		}
+		if grant.Application != nil {
+			roleResponse.ApplicationName = grant.Application.Name
+		}
+		roles = append(roles, roleResponse)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(ServiceRolesListResponse{
+		Success: true,
+		Message: "Service roles retrieved successfully",
+		Roles:   roles,
+	})
+}
+
+// AssignServiceRole handles granting a role to an application acting as a service principal
+// @Summary Assign service principal role
+// @Description Grant a role to an application; its client credentials tokens for the role's application carry the role's permissions
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param role body AssignServiceRoleRequest true "Role to grant"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Role assigned successfully"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Application or role not found"
+// @Failure 409 {object} ErrorResponse "Role already assigned"
+// @Router /applications/{id}/service-roles [post]
+func (h *ApplicationHandler) AssignServiceRole(c *fiber.Ctx) error {
+	clientApplicationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid application ID",
+		})
+	}
+
+	var req AssignServiceRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, currentAppID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	// Verify the client application exists
+	var clientApp models.Application
+	if err := h.db.First(&clientApp, clientApplicationID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Application not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve application", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to assign role",
+		})
+	}
+
+	// Verify the role exists; it determines the application the permissions apply to
+	var role models.Role
+	if err := h.db.First(&role, req.RoleID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   true,
+				Message: "Role not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve role", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to assign role",
+		})
+	}
+
+	// Check if role is already assigned
+	hasRole, err := models.HasServicePrincipalRole(h.db, clientApplicationID, req.RoleID)
+	if err != nil {
+		h.logger.Error("Failed to check existing service role", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to assign role",
+		})
+	}
+
+	if hasRole {
+		return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Role already assigned to application",
+		})
+	}
+
+	grant := models.ServicePrincipalRole{
+		ClientApplicationID: clientApplicationID,
+		RoleID:              role.ID,
+		ApplicationID:       role.ApplicationID,
+		GrantedBy:           &currentUserID,
+	}
+	if currentUserID == uuid.Nil {
+		grant.GrantedBy = nil // granted by another service principal
+	}
+
+	if err := h.db.Create(&grant).Error; err != nil {
+		h.logger.Error("Failed to assign service role", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to assign role",
+		})
+	}
+
+	// Log the role assignment
+	grantIDStr := grant.ID.String()
+	models.CreateAuditLog(h.db, &currentUserID, &currentAppID, models.ActionRoleAssign, "service_principal_role",
+		&grantIDStr,
+		map[string]interface{}{
+			"client_application_id": clientApplicationID,
+			"client_application":    clientApp.Name,
+			"role_id":               role.ID,
+			"role_name":             role.Name,
+			"application_id":        role.ApplicationID,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "Role assigned successfully",
+	})
+}
+
+// RemoveServiceRole handles revoking a role from an application acting as a service principal
+// @Summary Remove service principal role
+// @Description Revoke a role granted to an application for client credentials tokens
+// @Tags Applications
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param role_id path string true "Role ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Role removed successfully"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Role assignment not found"
+// @Router /applications/{id}/service-roles/{role_id} [delete]
+func (h *ApplicationHandler) RemoveServiceRole(c *fiber.Ctx) error {
+	clientApplicationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid application ID",
+		})
+	}
+
+	roleID, err := uuid.Parse(c.Params("role_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid role ID",
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, currentAppID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	hasRole, err := models.HasServicePrincipalRole(h.db, clientApplicationID, roleID)
+	if err != nil {
+		h.logger.Error("Failed to check existing service role", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to remove role",
+		})
+	}
+
+	if !hasRole {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Role assignment not found",
+		})
+	}
+
+	if err := models.RemoveServicePrincipalRole(h.db, clientApplicationID, roleID); err != nil {
+		h.logger.Error("Failed to remove service role", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to remove role",
+		})
+	}
+
+	// Log the role removal
+	models.CreateAuditLog(h.db, &currentUserID, &currentAppID, models.ActionRoleRemove, "service_principal_role", nil,
+		map[string]interface{}{
+			"client_application_id": clientApplicationID,
+			"role_id":               roleID,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "Role removed successfully",
+	})
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SessionResponse represents an active login of a user in an application
+type SessionResponse struct {
+	SessionID   uuid.UUID       `json:"session_id"`
+	Application ApplicationInfo `json:"application"`
+	IssuedAt    time.Time       `json:"issued_at"`
+	LastUsedAt  time.Time       `json:"last_used_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	IPAddress   *string         `json:"ip_address,omitempty"`
+	UserAgent   *string         `json:"user_agent,omitempty"`
+	Current     bool            `json:"current"`
+}
+
+// SessionsListResponse represents the active sessions list response
+type SessionsListResponse struct {
+	Success  bool              `json:"success"`
+	Message  string            `json:"message"`
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// GetUserSessions handles listing the active sessions of a user
+// @Summary List user sessions
+// @Description List where a user is logged in across all applications
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} SessionsListResponse "Active sessions"
+// @Failure 400 {object} ErrorResponse "Invalid user ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Router /users/{id}/sessions [get]
+func (h *UserHandler) GetUserSessions(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid user ID",
+		})
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   true,
+				Message: "User not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve user", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to retrieve sessions",
+		})
+	}
+
+	return h.listSessions(c, user.ID)
+}
+
+// GetMySessions handles listing the active sessions of the authenticated user
+// @Summary List my sessions
+// @Description List where the authenticated user is logged in across all applications
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} SessionsListResponse "Active sessions"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Router /me/sessions [get]
+func (h *UserHandler) GetMySessions(c *fiber.Ctx) error {
+	userID, _, _, ok := middleware.ExtractUserContext(c)
+	if !ok || userID == uuid.Nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	return h.listSessions(c, userID)
+}
+
+// RevokeUserSession handles revoking one session of a user
+// @Summary Revoke user session
+// @Description Revoke every token of one session of a user
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param session_id path string true "Session ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Session revoked successfully"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Session not found"
+// @Router /users/{id}/sessions/{session_id} [delete]
+func (h *UserHandler) RevokeUserSession(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid user ID",
+		})
+	}
+
+	sessionID, err := uuid.Parse(c.Params("session_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid session ID",
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, currentAppID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	hasSession, err := models.HasUserSession(h.db, userID, sessionID)
+	if err != nil {
+		h.logger.Error("Failed to check user session", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to revoke session",
+		})
+	}
+
+	if !hasSession {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Session not found",
+		})
+	}
+
+	revoked, err := revokeSession(context.Background(), h.db, h.sessionService, sessionID)
+	if err != nil {
+		h.logger.Error("Failed to revoke session", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to revoke session",
+		})
+	}
+
+	// Log the revocation
+	sessionIDStr := sessionID.String()
+	models.CreateAuditLog(h.db, &currentUserID, &currentAppID, models.ActionTokenRevoke, "session", &sessionIDStr,
+		map[string]interface{}{
+			"user_id":        userID,
+			"revoked_tokens": revoked,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "Session revoked successfully",
+	})
+}
+
+// listSessions responds with the active sessions of a user, flagging the one making the request
+func (h *UserHandler) listSessions(c *fiber.Ctx, userID uuid.UUID) error {
+	userSessions, err := models.GetActiveUserSessions(h.db, userID)
+	if err != nil {
+		h.logger.Error("Failed to retrieve sessions", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to retrieve sessions",
+		})
+	}
+
+	var currentSessionID uuid.UUID
+	if claims, ok := c.Locals("claims").(*auth.Claims); ok {
+		currentSessionID = claims.SessionID
+	}
+
+	ctx := context.Background()
+	sessions := make([]SessionResponse, 0, len(userSessions))
+	for _, userSession := range userSessions {
+		// Requests are tracked in the cache; refreshes are the fallback when it has nothing
+		lastUsedAt := userSession.LastRefreshedAt
+		if lastUsed, ok := h.sessionService.GetSessionLastUsed(ctx, userSession.SessionID); ok && lastUsed.After(lastUsedAt) {
+			lastUsedAt = lastUsed
+		}
+
+		sessionResponse := SessionResponse{
+			SessionID:  userSession.SessionID,
+			IssuedAt:   userSession.IssuedAt,
+			LastUsedAt: lastUsedAt,
+			ExpiresAt:  userSession.ExpiresAt,
+			IPAddress:  userSession.IPAddress,
+			UserAgent:  userSession.UserAgent,
+			Current:    userSession.SessionID == currentSessionID,
+		}
+		if userSession.Application != nil {
+			sessionResponse.Application = ApplicationInfo{
+				ID:          userSession.Application.ID,
+				Name:        userSession.Application.Name,
+				Description: userSession.Application.Description,
+			}
+		}
+		sessions = append(sessions, sessionResponse)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(SessionsListResponse{
+		Success:  true,
+		Message:  "Sessions retrieved successfully",
+		Sessions: sessions,
+	})
+}
+
+// sessionPolicy converts an application's session settings for the session service
+func sessionPolicy(app *models.Application) *auth.SessionPolicy {
+	return &auth.SessionPolicy{
+		AccessTokenTTL:        time.Duration(app.AccessTokenTTL) * time.Second,
+		RefreshTokenTTL:       time.Duration(app.RefreshTokenTTL) * time.Second,
+		AbsoluteLifetime:      time.Duration(app.SessionLifetime) * time.Second,
+		IdleTimeout:           time.Duration(app.IdleTimeout) * time.Second,
+		MaxConcurrentSessions: app.MaxConcurrentSessions,
+		OpaqueAccessTokens:    app.UsesOpaqueAccessTokens(),
+	}
+}
+
+// revokeSession blacklists and invalidates every still-valid token of a session, returning how many were revoked
+func revokeSession(ctx context.Context, db *gorm.DB, sessionService *auth.SessionService, sessionID uuid.UUID) (int, error) {
+	return revokeSessionTokens(ctx, &databaseTokenStore{db: db}, sessionService, sessionID)
+}
+
+// revokeSessionTokens revokes every still-valid token of a session recorded in the given store
+func revokeSessionTokens(ctx context.Context, store tokenStore, sessionService *auth.SessionService, sessionID uuid.UUID) (int, error) {
+	tokens, err := store.ValidSessionTokens(sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, token := range tokens {
+		if err := sessionService.InvalidateTokenHash(ctx, token.TokenHash, token.ExpiresAt); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(tokens), store.InvalidateSession(sessionID)
+}
+
+// revokeAllUserSessions immediately ends every session a user has in every application.
+// The tokens table is the source of truth; the cache index catches tokens that were never recorded.
+// It returns how many recorded tokens were revoked.
+func revokeAllUserSessions(ctx context.Context, db *gorm.DB, sessionService *auth.SessionService, userID uuid.UUID) (int, error) {
+	if err := sessionService.InvalidateAllUserTokens(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	tokens, err := models.GetValidUserTokens(db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, token := range tokens {
+		if err := sessionService.InvalidateTokenHash(ctx, token.TokenHash, token.ExpiresAt); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(tokens), models.InvalidateAllUserTokens(db, userID)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// tokenStore records issued tokens and the refresh token rotations of their sessions
+type tokenStore interface {
+	Create(token *models.Token) error
+	FindByHash(tokenHash string) (*models.Token, error)
+	MarkRotated(token *models.Token) (bool, error)
+	UndoRotation(token *models.Token) (bool, error)
+	ValidSessionTokens(sessionID uuid.UUID) ([]models.Token, error)
+	InvalidateSession(sessionID uuid.UUID) error
+}
+
+// databaseTokenStore records tokens in the database
+type databaseTokenStore struct {
+	db *gorm.DB
+}
+
+func (d *databaseTokenStore) Create(token *models.Token) error {
+	return d.db.Create(token).Error
+}
+
+func (d *databaseTokenStore) FindByHash(tokenHash string) (*models.Token, error) {
+	return models.FindTokenByHash(d.db, tokenHash)
+}
+
+func (d *databaseTokenStore) MarkRotated(token *models.Token) (bool, error) {
+	return token.MarkRotated(d.db)
+}
+
+func (d *databaseTokenStore) UndoRotation(token *models.Token) (bool, error) {
+	return token.UndoRotation(d.db)
+}
+
+func (d *databaseTokenStore) ValidSessionTokens(sessionID uuid.UUID) ([]models.Token, error) {
+	return models.GetValidSessionTokens(d.db, sessionID)
+}
+
+func (d *databaseTokenStore) InvalidateSession(sessionID uuid.UUID) error {
+	return models.InvalidateSessionTokens(d.db, sessionID)
+}
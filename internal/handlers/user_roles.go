@@ -5,7 +5,6 @@ import (
 
 	"github.com/efrenfuentes/authy/internal/middleware"
 	"github.com/efrenfuentes/authy/internal/models"
-	"github.com/efrenfuentes/authy/pkg/auth"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -139,9 +138,9 @@ func (h *UserHandler) AssignRole(c *fiber.Ctx) error {
 		})
 	}
 
-	// Invalidate user tokens to refresh permissions
-	if err := h.sessionService.InvalidateUserTokensInApplication(context.Background(), userID, req.ApplicationID, auth.AccessTokenType); err != nil {
-		h.logger.Error("Failed to invalidate user tokens", "error", err)
+	// Tokens issued before the change pick up the new permissions on their next use
+	if err := h.permissionVersions.BumpUser(context.Background(), userID, req.ApplicationID); err != nil {
+		h.logger.Error("Failed to bump permission version", "error", err)
 	}
 
 	// Log the role assignment
@@ -254,9 +253,9 @@ func (h *UserHandler) RemoveRole(c *fiber.Ctx) error {
 		})
 	}
 
-	// Invalidate user tokens to refresh permissions
-	if err := h.sessionService.InvalidateUserTokensInApplication(context.Background(), userID, applicationID, auth.AccessTokenType); err != nil {
-		h.logger.Error("Failed to invalidate user tokens", "error", err)
+	// Tokens issued before the change pick up the new permissions on their next use
+	if err := h.permissionVersions.BumpUser(context.Background(), userID, applicationID); err != nil {
+		h.logger.Error("Failed to bump permission version", "error", err)
 	}
 
 	// Log the role removal
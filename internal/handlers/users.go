@@ -31,14 +31,17 @@ type UpdateUserRequest struct {
 
 // UserResponse represents a user in API responses
 type UserResponse struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	FullName  string    `json:"full_name"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID `json:"id"`
+	Email           string    `json:"email"`
+	FirstName       string    `json:"first_name"`
+	LastName        string    `json:"last_name"`
+	FullName        string    `json:"full_name"`
+	IsActive        bool      `json:"is_active"`
+	EmailVerified   bool      `json:"email_verified"`
+	PendingEmail    *string   `json:"pending_email,omitempty"` // new address waiting for confirmation
+	ApprovalPending bool      `json:"approval_pending"`        // registered and waiting for an admin to approve
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 // UserWithRolesResponse represents a user with their roles
@@ -83,6 +86,7 @@ type UsersListResponse struct {
 // @Param per_page query int false "Items per page" default(10)
 // @Param search query string false "Search term for email or name"
 // @Param active query bool false "Filter by active status"
+// @Param approval_pending query bool false "Filter by registered users waiting for approval"
 // @Security BearerAuth
 // @Success 200 {object} UsersListResponse "Users list"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
@@ -103,6 +107,7 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 	perPage, _ := strconv.Atoi(c.Query("per_page", "10"))
 	search := c.Query("search", "")
 	activeParam := c.Query("active", "")
+	approvalPendingParam := c.Query("approval_pending", "")
 
 	if page < 1 {
 		page = 1
@@ -128,6 +133,12 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 		}
 	}
 
+	if approvalPendingParam != "" {
+		if approvalPending, err := strconv.ParseBool(approvalPendingParam); err == nil {
+			query = query.Where("approval_pending = ?", approvalPending)
+		}
+	}
+
 	// Get total count
 	var total int64
 	if err := query.Count(&total).Error; err != nil {
@@ -175,14 +186,15 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 			// Include granted by user info if available
 			if userRole.GrantedByUser != nil {
 				roleResponse.GrantedByUser = &UserResponse{
-					ID:        userRole.GrantedByUser.ID,
-					Email:     userRole.GrantedByUser.Email,
-					FirstName: userRole.GrantedByUser.FirstName,
-					LastName:  userRole.GrantedByUser.LastName,
-					FullName:  userRole.GrantedByUser.GetFullName(),
-					IsActive:  userRole.GrantedByUser.IsActive,
-					CreatedAt: userRole.GrantedByUser.CreatedAt,
-					UpdatedAt: userRole.GrantedByUser.UpdatedAt,
+					ID:            userRole.GrantedByUser.ID,
+					Email:         userRole.GrantedByUser.Email,
+					FirstName:     userRole.GrantedByUser.FirstName,
+					LastName:      userRole.GrantedByUser.LastName,
+					FullName:      userRole.GrantedByUser.GetFullName(),
+					IsActive:      userRole.GrantedByUser.IsActive,
+					EmailVerified: userRole.GrantedByUser.EmailVerified,
+					CreatedAt:     userRole.GrantedByUser.CreatedAt,
+					UpdatedAt:     userRole.GrantedByUser.UpdatedAt,
 				}
 			}
 			
@@ -191,14 +203,17 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 		
 		userResponses = append(userResponses, UserWithRolesResponse{
 			UserResponse: UserResponse{
-				ID:        user.ID,
-				Email:     user.Email,
-				FirstName: user.FirstName,
-				LastName:  user.LastName,
-				FullName:  user.GetFullName(),
-				IsActive:  user.IsActive,
-				CreatedAt: user.CreatedAt,
-				UpdatedAt: user.UpdatedAt,
+				ID:              user.ID,
+				Email:           user.Email,
+				FirstName:       user.FirstName,
+				LastName:        user.LastName,
+				FullName:        user.GetFullName(),
+				IsActive:        user.IsActive,
+				EmailVerified:   user.EmailVerified,
+				PendingEmail:    user.PendingEmail,
+				ApprovalPending: user.ApprovalPending,
+				CreatedAt:       user.CreatedAt,
+				UpdatedAt:       user.UpdatedAt,
 			},
 			Roles: userRoleResponses,
 		})
@@ -258,7 +273,7 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 
 	// Check if email already exists
 	var existingUser models.User
-	if err := h.db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := h.db.Where("LOWER(email) = LOWER(?)", req.Email).First(&existingUser).Error; err == nil {
 		models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionUserCreate, "user", nil,
 			map[string]interface{}{
 				"email":  req.Email,
@@ -297,25 +312,36 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// The user confirms their address with the link sent to it
+	verificationSent := true
+	if _, err := h.emailVerifier.send(context.Background(), &user, user.Email, nil, ""); err != nil {
+		h.logger.Error("Failed to send email verification link", "error", err, "user_id", user.ID)
+		verificationSent = false
+	}
+
 	// Log successful creation
 	userIDStr := user.ID.String()
 	models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionUserCreate, "user", 
 		&userIDStr,
 		map[string]interface{}{
-			"email":      user.Email,
-			"first_name": user.FirstName,
-			"last_name":  user.LastName,
+			"email":             user.Email,
+			"first_name":        user.FirstName,
+			"last_name":         user.LastName,
+			"verification_sent": verificationSent,
 		}, &clientIP, &userAgent)
 
 	return c.Status(fiber.StatusCreated).JSON(UserResponse{
-		ID:        user.ID,
-		Email:     user.Email,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		FullName:  user.GetFullName(),
-		IsActive:  user.IsActive,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+		ID:              user.ID,
+		Email:           user.Email,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		FullName:        user.GetFullName(),
+		IsActive:        user.IsActive,
+		EmailVerified:   user.EmailVerified,
+		PendingEmail:    user.PendingEmail,
+		ApprovalPending: user.ApprovalPending,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
 	})
 }
 
@@ -415,14 +441,17 @@ func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(UserWithRolesResponse{
 		UserResponse: UserResponse{
-			ID:        user.ID,
-			Email:     user.Email,
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-			FullName:  user.GetFullName(),
-			IsActive:  user.IsActive,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
+			ID:              user.ID,
+			Email:           user.Email,
+			FirstName:       user.FirstName,
+			LastName:        user.LastName,
+			FullName:        user.GetFullName(),
+			IsActive:        user.IsActive,
+			EmailVerified:   user.EmailVerified,
+			PendingEmail:    user.PendingEmail,
+			ApprovalPending: user.ApprovalPending,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
 		},
 		Roles: roleResponses,
 	})
@@ -430,7 +459,7 @@ func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 
 // UpdateUser handles updating a user
 // @Summary Update user
-// @Description Update user information
+// @Description Update user information. A new email replaces the current one once the user confirms the verification link sent to it.
 // @Tags Users
 // @Accept json
 // @Produce json
@@ -493,22 +522,30 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 
 	// Store original values for audit logging
 	originalValues := map[string]interface{}{
-		"email":      user.Email,
-		"first_name": user.FirstName,
-		"last_name":  user.LastName,
-		"is_active":  user.IsActive,
-	}
-
-	// Check email uniqueness if email is being updated
-	if req.Email != nil && *req.Email != user.Email {
-		var existingUser models.User
-		if err := h.db.Where("email = ? AND id != ?", *req.Email, userID).First(&existingUser).Error; err == nil {
-			return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
-				Error:   true,
-				Message: "Email already exists",
-			})
+		"email":         user.Email,
+		"pending_email": user.PendingEmail,
+		"first_name":    user.FirstName,
+		"last_name":     user.LastName,
+		"is_active":     user.IsActive,
+	}
+
+	// A new email replaces the current one once the link sent to it is confirmed; sending the
+	// current email cancels a pending change
+	var verifyEmail *string
+	if req.Email != nil {
+		if *req.Email == user.Email {
+			user.PendingEmail = nil
+		} else if user.PendingEmail == nil || *user.PendingEmail != *req.Email {
+			var existingUser models.User
+			if err := h.db.Where("LOWER(email) = LOWER(?) AND id != ?", *req.Email, userID).First(&existingUser).Error; err == nil {
+				return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+					Error:   true,
+					Message: "Email already exists",
+				})
+			}
+			user.PendingEmail = req.Email
+			verifyEmail = req.Email
 		}
-		user.Email = *req.Email
 	}
 
 	// Update fields if provided
@@ -520,6 +557,9 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 	}
 	if req.IsActive != nil {
 		user.IsActive = *req.IsActive
+		if user.IsActive {
+			user.ApprovalPending = false // activating a registered user approves them
+		}
 	}
 	if req.Password != nil {
 		if err := user.SetPassword(*req.Password); err != nil {
@@ -540,31 +580,52 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Deactivating a user or resetting their password ends all of their sessions
+	revokedTokens := 0
+	if (req.IsActive != nil && !*req.IsActive) || req.Password != nil {
+		revokedTokens, err = revokeAllUserSessions(context.Background(), h.db, h.sessionService, user.ID)
+		if err != nil {
+			h.logger.Error("Failed to invalidate user tokens", "error", err)
+		}
+	}
+
+	if verifyEmail != nil {
+		if _, err := h.emailVerifier.send(context.Background(), &user, *verifyEmail, nil, ""); err != nil {
+			h.logger.Error("Failed to send email verification link", "error", err, "user_id", user.ID)
+		}
+	}
+
 	// Log the update
 	newValues := map[string]interface{}{
-		"email":      user.Email,
-		"first_name": user.FirstName,
-		"last_name":  user.LastName,
-		"is_active":  user.IsActive,
+		"email":         user.Email,
+		"pending_email": user.PendingEmail,
+		"first_name":    user.FirstName,
+		"last_name":     user.LastName,
+		"is_active":     user.IsActive,
 	}
 
 	userIDForAudit := user.ID.String()
 	models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionUserUpdate, "user", 
 		&userIDForAudit,
 		map[string]interface{}{
-			"original": originalValues,
-			"updated":  newValues,
+			"original":         originalValues,
+			"updated":          newValues,
+			"password_changed": req.Password != nil,
+			"revoked_tokens":   revokedTokens,
 		}, &clientIP, &userAgent)
 
 	return c.Status(fiber.StatusOK).JSON(UserResponse{
-		ID:        user.ID,
-		Email:     user.Email,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		FullName:  user.GetFullName(),
-		IsActive:  user.IsActive,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+		ID:              user.ID,
+		Email:           user.Email,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		FullName:        user.GetFullName(),
+		IsActive:        user.IsActive,
+		EmailVerified:   user.EmailVerified,
+		PendingEmail:    user.PendingEmail,
+		ApprovalPending: user.ApprovalPending,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
 	})
 }
 
@@ -639,8 +700,9 @@ func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
-	// Invalidate all user tokens
-	if err := h.sessionService.InvalidateAllUserTokens(context.Background(), userID); err != nil {
+	// Invalidate all user tokens in every application
+	revokedTokens, err := revokeAllUserSessions(context.Background(), h.db, h.sessionService, userID)
+	if err != nil {
 		h.logger.Error("Failed to invalidate user tokens", "error", err)
 	}
 
@@ -649,14 +711,119 @@ func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 	models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionUserDelete, "user", 
 		&userIDForAudit,
 		map[string]interface{}{
-			"email":      user.Email,
-			"first_name": user.FirstName,
-			"last_name":  user.LastName,
-			"method":     "soft_delete",
+			"email":          user.Email,
+			"first_name":     user.FirstName,
+			"last_name":      user.LastName,
+			"method":         "soft_delete",
+			"revoked_tokens": revokedTokens,
 		}, &clientIP, &userAgent)
 
 	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
 		Success: true,
 		Message: "User deleted successfully",
 	})
+}
+
+// ResetUserMFA handles removing every second factor of a user who lost access to them
+// @Summary Reset user MFA
+// @Description Disable TOTP and remove the passkeys and recovery codes of a user, ending all of the user's sessions
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "MFA reset successfully"
+// @Failure 400 {object} ErrorResponse "Invalid user ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Router /users/{id}/mfa [delete]
+func (h *UserHandler) ResetUserMFA(c *fiber.Ctx) error {
+	userIDStr := c.Params("id")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid user ID",
+		})
+	}
+
+	// Extract user context for audit logging
+	currentUserID, applicationID, _, ok := middleware.ExtractUserContext(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	// Get client info for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   true,
+				Message: "User not found",
+			})
+		}
+		h.logger.Error("Failed to retrieve user", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to reset MFA",
+		})
+	}
+
+	// Remove every factor at once so the user is never left with only some of them
+	totpWasEnabled := user.TOTPEnabled
+	var removedPasskeys, removedRecoveryCodes int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_secret":     nil,
+			"totp_enabled":    false,
+			"totp_enabled_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("user_id = ?", user.ID).Delete(&models.WebAuthnCredential{})
+		if result.Error != nil {
+			return result.Error
+		}
+		removedPasskeys = result.RowsAffected
+
+		removedRecoveryCodes, err = models.DeleteMFARecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		h.logger.Error("Failed to reset MFA", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to reset MFA",
+		})
+	}
+
+	// Whoever held the lost factors may hold a session too
+	revokedTokens, err := revokeAllUserSessions(context.Background(), h.db, h.sessionService, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to invalidate user tokens", "error", err)
+	}
+
+	userIDForAudit := user.ID.String()
+	models.CreateAuditLog(h.db, &currentUserID, &applicationID, models.ActionMFAReset, "user",
+		&userIDForAudit,
+		map[string]interface{}{
+			"email":                  user.Email,
+			"totp_disabled":          totpWasEnabled,
+			"removed_passkeys":       removedPasskeys,
+			"removed_recovery_codes": removedRecoveryCodes,
+			"revoked_tokens":         revokedTokens,
+		}, &clientIP, &userAgent)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "MFA reset successfully",
+	})
 }
\ No newline at end of file
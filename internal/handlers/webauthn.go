@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/middleware"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// WebAuthnRegisterRequest represents the answer to a passkey registration
+type WebAuthnRegisterRequest struct {
+	Name       string                            `json:"name" validate:"required,max=100"` // shown to the user to tell passkeys apart
+	Credential auth.WebAuthnRegistrationResponse `json:"credential"`
+}
+
+// WebAuthnRegisterResponse represents a registered passkey. Users registering their first second factor
+// also get recovery codes, shown only once.
+type WebAuthnRegisterResponse struct {
+	Credential    models.WebAuthnCredential `json:"credential"`
+	RecoveryCodes []string                  `json:"recovery_codes,omitempty"`
+}
+
+// WebAuthnLoginBeginRequest represents the start of a passwordless login
+type WebAuthnLoginBeginRequest struct {
+	Application string `json:"application" validate:"required"`
+}
+
+// WebAuthnLoginFinishRequest represents the answer to a passwordless login
+type WebAuthnLoginFinishRequest struct {
+	Application string                         `json:"application" validate:"required"`
+	Scope       string                         `json:"scope,omitempty"` // include "openid" to receive an id_token
+	Nonce       string                         `json:"nonce,omitempty"`
+	Credential  auth.WebAuthnAssertionResponse `json:"credential"`
+}
+
+// WebAuthnCredentialsListResponse represents the passkeys of a user
+type WebAuthnCredentialsListResponse struct {
+	Success     bool                        `json:"success"`
+	Message     string                      `json:"message"`
+	Credentials []models.WebAuthnCredential `json:"credentials"`
+}
+
+// BeginWebAuthnRegistration starts registering a passkey for the authenticated user, who confirms it with
+// their password or an existing second factor
+// @Summary Begin passkey registration
+// @Description Get the WebAuthn creation options for registering a passkey with the relying party of the token's application. The user confirms with their password, a code of their authenticator app or one of their passkeys.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body StepUpRequest true "Password, authenticator code or passkey assertion"
+// @Security BearerAuth
+// @Success 200 {object} auth.PublicKeyCredentialCreationOptions "Options for navigator.credentials.create"
+// @Failure 400 {object} ErrorResponse "Invalid request or passkeys are not enabled for the application"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Invalid password, authentication code or passkey"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /me/webauthn/register/begin [post]
+func (h *AuthHandler) BeginWebAuthnRegistration(c *fiber.Ctx) error {
+	user, app, err := h.currentUserAndApplication(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var req StepUpRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	if !app.WebAuthnEnabled() {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Passkeys are not enabled for this application",
+		})
+	}
+
+	// A passkey signs the user in on its own, so adding one needs more than a bearer token
+	if err := h.verifyStepUp(c, user, app, &req, "webauthn_register"); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: stepUpErrorMessage,
+		})
+	}
+
+	// Authenticators refuse to register a second passkey for the same account
+	existing, err := models.GetWebAuthnCredentials(h.db, user.ID, app.WebAuthnRPID)
+	if err != nil {
+		h.logger.Error("Failed to retrieve passkeys", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to start registration",
+		})
+	}
+
+	options, err := h.sessionService.BeginWebAuthnRegistration(context.Background(), relyingParty(app),
+		&auth.WebAuthnUser{
+			ID:          user.ID,
+			Name:        user.Email,
+			DisplayName: user.GetFullName(),
+		}, webAuthnCredentials(existing), registrationWebAuthnBinding(user.ID))
+	if err != nil {
+		h.logger.Error("Failed to start WebAuthn registration", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to start registration",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(options)
+}
+
+// FinishWebAuthnRegistration stores the passkey the authenticated user created
+// @Summary Finish passkey registration
+// @Description Verify the credential created for the options of the registration and store it as a passkey of the user
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body WebAuthnRegisterRequest true "Passkey name and created credential"
+// @Security BearerAuth
+// @Success 201 {object} WebAuthnRegisterResponse "Registered passkey"
+// @Failure 400 {object} ErrorResponse "Invalid request or credential"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 409 {object} ErrorResponse "Passkey already registered"
+// @Router /me/webauthn/register/finish [post]
+func (h *AuthHandler) FinishWebAuthnRegistration(c *fiber.Ctx) error {
+	user, app, err := h.currentUserAndApplication(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	var req WebAuthnRegisterRequest
+	if err := c.BodyParser(&req); err != nil || req.Name == "" || len(req.Name) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	if !app.WebAuthnEnabled() {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Passkeys are not enabled for this application",
+		})
+	}
+
+	credential, _, err := h.sessionService.FinishWebAuthnRegistration(context.Background(), relyingParty(app),
+		&req.Credential, registrationWebAuthnBinding(user.ID))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid passkey",
+		})
+	}
+
+	if _, err := models.FindWebAuthnCredential(h.db, app.WebAuthnRPID, credential.ID); err == nil {
+		return c.Status(fiber.StatusConflict).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Passkey already registered",
+		})
+	}
+
+	stored := models.WebAuthnCredential{
+		UserID:         user.ID,
+		RPID:           app.WebAuthnRPID,
+		CredentialID:   credential.ID,
+		PublicKey:      credential.PublicKey,
+		SignCount:      int64(credential.SignCount),
+		Transports:     credential.Transports,
+		AAGUID:         credential.AAGUID,
+		BackupEligible: credential.BackupEligible,
+		Name:           req.Name,
+	}
+	if err := h.db.Create(&stored).Error; err != nil {
+		h.logger.Error("Failed to save passkey", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to register passkey",
+		})
+	}
+
+	h.auditMFA(c, user.ID, models.ActionWebAuthnRegister, map[string]interface{}{
+		"method":        mfaMethodWebAuthn,
+		"credential_id": stored.ID,
+		"name":          stored.Name,
+		"rp_id":         stored.RPID,
+	})
+
+	recoveryCodes, err := h.ensureRecoveryCodes(c, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to generate recovery codes", "error", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(WebAuthnRegisterResponse{
+		Credential:    stored,
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// GetWebAuthnCredentials lists the passkeys of the authenticated user
+// @Summary List my passkeys
+// @Description List the passkeys the authenticated user registered, for every relying party
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} WebAuthnCredentialsListResponse "Registered passkeys"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Router /me/webauthn/credentials [get]
+func (h *AuthHandler) GetWebAuthnCredentials(c *fiber.Ctx) error {
+	userID, _, _, ok := middleware.ExtractUserContext(c)
+	if !ok || userID == uuid.Nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	credentials := []models.WebAuthnCredential{}
+	if err := h.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&credentials).Error; err != nil {
+		h.logger.Error("Failed to retrieve passkeys", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to retrieve passkeys",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(WebAuthnCredentialsListResponse{
+		Success:     true,
+		Message:     "Passkeys retrieved successfully",
+		Credentials: credentials,
+	})
+}
+
+// DeleteWebAuthnCredential removes a passkey of the authenticated user, who confirms it with their password
+// or an existing second factor
+// @Summary Remove a passkey
+// @Description Remove one of the authenticated user's passkeys; it can no longer be used to log in. The user confirms with their password, a code of their authenticator app or one of their passkeys.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "Passkey ID"
+// @Param request body StepUpRequest true "Password, authenticator code or passkey assertion"
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse "Passkey removed"
+// @Failure 400 {object} ErrorResponse "Invalid request or passkey ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Invalid password, authentication code or passkey"
+// @Failure 404 {object} ErrorResponse "Passkey not found"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /me/webauthn/credentials/{id} [delete]
+func (h *AuthHandler) DeleteWebAuthnCredential(c *fiber.Ctx) error {
+	user, app, err := h.currentUserAndApplication(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid authentication context",
+		})
+	}
+
+	credentialID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid passkey ID",
+		})
+	}
+
+	var req StepUpRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	var credential models.WebAuthnCredential
+	if err := h.db.Where("id = ? AND user_id = ?", credentialID, user.ID).First(&credential).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Passkey not found",
+		})
+	}
+
+	// Removing a passkey can leave the account with a password alone, so a bearer token alone can't do it
+	if err := h.verifyStepUp(c, user, app, &req, "webauthn_remove"); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: stepUpErrorMessage,
+		})
+	}
+
+	if err := h.db.Delete(&credential).Error; err != nil {
+		h.logger.Error("Failed to remove passkey", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Failed to remove passkey",
+		})
+	}
+
+	h.auditMFA(c, user.ID, models.ActionWebAuthnRemove, map[string]interface{}{
+		"method":        mfaMethodWebAuthn,
+		"credential_id": credential.ID,
+		"name":          credential.Name,
+		"rp_id":         credential.RPID,
+	})
+	h.discardUnneededRecoveryCodes(user)
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{
+		Success: true,
+		Message: "Passkey removed",
+	})
+}
+
+// BeginWebAuthnLogin starts a passwordless login
+// @Summary Begin passkey login
+// @Description Get the WebAuthn request options for logging in to an application with a passkey instead of a password
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body WebAuthnLoginBeginRequest true "Application"
+// @Success 200 {object} auth.PublicKeyCredentialRequestOptions "Options for navigator.credentials.get"
+// @Failure 400 {object} ErrorResponse "Invalid request or passkeys not enabled"
+// @Failure 401 {object} ErrorResponse "Invalid application"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/webauthn/login/begin [post]
+func (h *AuthHandler) BeginWebAuthnLogin(c *fiber.Ctx) error {
+	var req WebAuthnLoginBeginRequest
+	if err := c.BodyParser(&req); err != nil || req.Application == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	var app models.Application
+	if err := h.db.Where("name = ?", req.Application).First(&app).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid application",
+		})
+	}
+
+	if !app.WebAuthnEnabled() {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Passkeys are not enabled for this application",
+		})
+	}
+
+	// The user is not known yet: the authenticator offers its discoverable passkeys for the RP
+	options, err := h.sessionService.BeginWebAuthnLogin(context.Background(), relyingParty(&app), uuid.Nil, nil,
+		loginWebAuthnBinding(app.ID))
+	if err != nil {
+		h.logger.Error("Failed to start WebAuthn login", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Internal server error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(options)
+}
+
+// FinishWebAuthnLogin completes a passwordless login
+// @Summary Finish passkey login
+// @Description Verify the passkey assertion for the options of the login and generate JWT tokens
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body WebAuthnLoginFinishRequest true "Application and passkey assertion"
+// @Param DPoP header string false "DPoP proof binding the issued tokens to the client's key"
+// @Success 200 {object} LoginResponse "Successful login"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Invalid application or passkey"
+// @Failure 403 {object} ErrorResponse "Email address not verified"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /auth/webauthn/login/finish [post]
+func (h *AuthHandler) FinishWebAuthnLogin(c *fiber.Ctx) error {
+	var req WebAuthnLoginFinishRequest
+	if err := c.BodyParser(&req); err != nil || req.Application == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid request body",
+		})
+	}
+
+	// Get client IP for audit logging
+	clientIP := middleware.ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+
+	var app models.Application
+	if err := h.db.Where("name = ?", req.Application).First(&app).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   true,
+			Message: "Invalid application",
+		})
+	}
+
+	invalidPasskey := ErrorResponse{
+		Error:   true,
+		Message: "Invalid passkey",
+	}
+
+	credential, err := h.finishPasskeyLogin(context.Background(), &app, &req.Credential, loginWebAuthnBinding(app.ID))
+	if err != nil {
+		models.CreateAuditLog(h.db, nil, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"method": mfaMethodWebAuthn,
+				"reason": "invalid_passkey",
+				"error":  err.Error(),
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidPasskey)
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND is_active = true", credential.UserID).First(&user).Error; err != nil {
+		models.CreateAuditLog(h.db, &credential.UserID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"method": mfaMethodWebAuthn,
+				"reason": "user_not_found",
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusUnauthorized).JSON(invalidPasskey)
+	}
+
+	if emailVerificationRequired(&app, &user) {
+		models.CreateAuditLog(h.db, &user.ID, &app.ID, models.ActionLoginFailed, "authentication", nil,
+			map[string]interface{}{
+				"method": mfaMethodWebAuthn,
+				"reason": "email_not_verified",
+			}, &clientIP, &userAgent)
+
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+			Error:   true,
+			Message: emailNotVerifiedMessage,
+		})
+	}
+
+	// A passkey verified with the user's PIN or biometric is both possession and a second factor
+	amr := []string{auth.AMRHardwareKey, auth.AMRMFA}
+	return h.completeLogin(c, &user, &app, req.Scope, req.Nonce, amr, clientIP, userAgent)
+}
+
+// finishPasskeyLogin verifies a passkey assertion for an application and records the use of the passkey
+func (h *AuthHandler) finishPasskeyLogin(ctx context.Context, app *models.Application, response *auth.WebAuthnAssertionResponse, binding string) (*models.WebAuthnCredential, error) {
+	if !app.WebAuthnEnabled() {
+		return nil, auth.ErrInvalidWebAuthnResponse
+	}
+
+	var stored *models.WebAuthnCredential
+	lookup := func(credentialID []byte) (*auth.WebAuthnCredential, uuid.UUID, error) {
+		credential, err := models.FindWebAuthnCredential(h.db, app.WebAuthnRPID, credentialID)
+		if err != nil {
+			return nil, uuid.Nil, err
+		}
+		stored = credential
+		return &webAuthnCredentials([]models.WebAuthnCredential{*credential})[0], credential.UserID, nil
+	}
+
+	assertion, _, err := h.sessionService.FinishWebAuthnLogin(ctx, relyingParty(app), response, binding, lookup)
+	if err != nil {
+		if err == auth.ErrWebAuthnSignCount {
+			h.logger.Warn("Passkey sign count went backwards", "credential_id", stored.ID, "user_id", stored.UserID)
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	stored.SignCount = int64(assertion.SignCount)
+	stored.LastUsedAt = &now
+	if err := h.db.Model(stored).Updates(map[string]interface{}{
+		"sign_count":   stored.SignCount,
+		"last_used_at": now,
+	}).Error; err != nil {
+		h.logger.Error("Failed to update passkey", "error", err)
+	}
+
+	return stored, nil
+}
+
+// currentUserAndApplication loads the active user the request is authenticated as and the application of the token
+func (h *AuthHandler) currentUserAndApplication(c *fiber.Ctx) (*models.User, *models.Application, error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, applicationID, _, _ := middleware.ExtractUserContext(c)
+	var app models.Application
+	if err := h.db.First(&app, applicationID).Error; err != nil {
+		return nil, nil, err
+	}
+	return user, &app, nil
+}
+
+// hasPasskeys reports whether a user registered passkeys for an application; lookup errors count as
+// registered so a second factor is never skipped
+func (h *AuthHandler) hasPasskeys(user *models.User, app *models.Application) bool {
+	if !app.WebAuthnEnabled() {
+		return false
+	}
+
+	registered, err := models.HasWebAuthnCredentials(h.db, user.ID, app.WebAuthnRPID)
+	if err != nil {
+		h.logger.Error("Failed to check passkeys", "error", err, "user_id", user.ID)
+		return true
+	}
+	return registered
+}
+
+// relyingParty returns the WebAuthn relying party of an application
+func relyingParty(app *models.Application) *auth.RelyingParty {
+	name := app.WebAuthnRPName
+	if name == "" {
+		name = app.Name
+	}
+	return &auth.RelyingParty{
+		ID:      app.WebAuthnRPID,
+		Name:    name,
+		Origins: app.WebAuthnOrigins,
+	}
+}
+
+// webAuthnCredentials converts stored passkeys for WebAuthn ceremonies
+func webAuthnCredentials(credentials []models.WebAuthnCredential) []auth.WebAuthnCredential {
+	converted := make([]auth.WebAuthnCredential, 0, len(credentials))
+	for _, credential := range credentials {
+		converted = append(converted, auth.WebAuthnCredential{
+			ID:             credential.CredentialID,
+			PublicKey:      credential.PublicKey,
+			SignCount:      uint32(credential.SignCount),
+			Transports:     credential.Transports,
+			AAGUID:         credential.AAGUID,
+			BackupEligible: credential.BackupEligible,
+		})
+	}
+	return converted
+}
+
+// Bindings tie each WebAuthn ceremony to what it was started for
+func registrationWebAuthnBinding(userID uuid.UUID) string { return "register:" + userID.String() }
+func loginWebAuthnBinding(applicationID uuid.UUID) string { return "login:" + applicationID.String() }
+func mfaWebAuthnBinding(mfaToken string) string           { return "mfa:" + mfaToken }
+func stepUpWebAuthnBinding(userID uuid.UUID) string       { return "step-up:" + userID.String() }
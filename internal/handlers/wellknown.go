@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+// WellKnownHandler serves public discovery documents under /.well-known
+type WellKnownHandler struct {
+	jwtService *auth.JWTService
+}
+
+// NewWellKnownHandler creates a new well-known handler
+func NewWellKnownHandler(jwtService *auth.JWTService) *WellKnownHandler {
+	return &WellKnownHandler{
+		jwtService: jwtService,
+	}
+}
+
+// OpenIDConfiguration represents the OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration publishes the OpenID Connect discovery document
+// @Summary OpenID Connect discovery
+// @Description OpenID Provider metadata for off-the-shelf OIDC client libraries
+// @Tags Discovery
+// @Produce json
+// @Success 200 {object} OpenIDConfiguration "OpenID Provider metadata"
+// @Router /.well-known/openid-configuration [get]
+func (h *WellKnownHandler) OpenIDConfiguration(c *fiber.Ctx) error {
+	issuer := h.jwtService.Issuer()
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Status(fiber.StatusOK).JSON(OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		UserInfoEndpoint:                  issuer + "/api/v1/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{auth.ScopeOpenID, auth.ScopeProfile, auth.ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               models.GetGrantTypesList(),
+		CodeChallengeMethodsSupported:     []string{auth.PKCEMethodS256},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{h.jwtService.SigningAlgorithm()},
+		DPoPSigningAlgValuesSupported:     auth.DPoPSigningAlgorithms,
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr",
+			"email", "email_verified", "name", "given_name", "family_name",
+		},
+	})
+}
+
+// JWKS publishes the public keys used to verify tokens
+// @Summary JSON Web Key Set
+// @Description Public keys for verifying Authy-issued tokens offline (empty when signing with HS256)
+// @Tags Discovery
+// @Produce json
+// @Success 200 {object} auth.JWKSet "JSON Web Key Set"
+// @Router /.well-known/jwks.json [get]
+func (h *WellKnownHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Status(fiber.StatusOK).JSON(h.jwtService.JWKS())
+}
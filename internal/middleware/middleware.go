@@ -2,17 +2,20 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/efrenfuentes/authy/internal/cache"
+	"github.com/efrenfuentes/authy/internal/models"
 	"github.com/efrenfuentes/authy/pkg/auth"
 	"github.com/efrenfuentes/authy/pkg/logger"
 	"github.com/efrenfuentes/authy/pkg/metrics"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // Error handler middleware
@@ -76,8 +79,19 @@ func Metrics() fiber.Handler {
 	}
 }
 
-// AuthRequired creates a middleware that requires valid JWT authentication
-func AuthRequired(sessionService *auth.SessionService) fiber.Handler {
+// AuthRequired creates a middleware that requires valid JWT authentication, for tokens of any audience
+func AuthRequired(sessionService *auth.SessionService, db *gorm.DB) fiber.Handler {
+	return authRequired(sessionService, db, uuid.Nil)
+}
+
+// AuthRequiredForAudience creates a middleware that requires valid JWT authentication with a token
+// issued for the given application. Tokens of other applications are rejected and audited.
+// uuid.Nil accepts tokens of any application.
+func AuthRequiredForAudience(sessionService *auth.SessionService, db *gorm.DB, audience uuid.UUID) fiber.Handler {
+	return authRequired(sessionService, db, audience)
+}
+
+func authRequired(sessionService *auth.SessionService, db *gorm.DB, audience uuid.UUID) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -88,7 +102,7 @@ func AuthRequired(sessionService *auth.SessionService) fiber.Handler {
 		}
 		
 		// Extract token from header
-		token := auth.ExtractTokenFromHeader(authHeader)
+		token, scheme := auth.ExtractTokenAndScheme(authHeader)
 		if token == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error":   true,
@@ -97,8 +111,17 @@ func AuthRequired(sessionService *auth.SessionService) fiber.Handler {
 		}
 		
 		// Validate token
-		claims, err := sessionService.ValidateToken(context.Background(), token)
+		claims, err := sessionService.ValidateTokenForAudience(context.Background(), token, audience)
 		if err != nil {
+			var audienceErr *auth.AudienceError
+			if errors.As(err, &audienceErr) {
+				AuditAudienceRejection(c, db, audienceErr)
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error":   true,
+					"message": "Token was not issued for this application",
+				})
+			}
+			
 			var message string
 			switch err {
 			case auth.ErrExpiredToken:
@@ -107,6 +130,8 @@ func AuthRequired(sessionService *auth.SessionService) fiber.Handler {
 				message = "Invalid token"
 			case auth.ErrInvalidTokenType:
 				message = "Invalid token type"
+			case auth.ErrSessionExpired:
+				message = "Session has expired"
 			default:
 				message = "Token validation failed"
 			}
@@ -125,6 +150,31 @@ func AuthRequired(sessionService *auth.SessionService) fiber.Handler {
 			})
 		}
 		
+		// DPoP-bound tokens must come with a proof of their key, and only they may use the DPoP scheme
+		if scheme != claims.AuthorizationScheme() {
+			return rejectDPoP(c, "Token must be sent with the "+claims.AuthorizationScheme()+" authorization scheme")
+		}
+		if err := sessionService.VerifyTokenBinding(context.Background(), claims, c.Get(auth.DPoPHeader), &auth.DPoPRequest{
+			Method:      c.Method(),
+			URL:         RequestURL(c),
+			AccessToken: token,
+		}); err != nil {
+			return rejectDPoP(c, "Invalid DPoP proof")
+		}
+		
+		// Requests made with an exchanged token are recorded with both the subject and the actor
+		if claims.Act != nil {
+			clientIP := ExtractClientIP(c)
+			userAgent := c.Get("User-Agent")
+			models.CreateAuditLog(db, &claims.UserID, &claims.ApplicationID, models.ActionDelegatedAccess, "token", nil,
+				map[string]interface{}{
+					"actor":     claims.Act,
+					"client_id": claims.IssuedTo(),
+					"method":    c.Method(),
+					"path":      c.Path(),
+				}, &clientIP, &userAgent)
+		}
+		
 		// Store user info in context for handlers
 		c.Locals("user_id", claims.UserID)
 		c.Locals("application_id", claims.ApplicationID)
@@ -135,6 +185,33 @@ func AuthRequired(sessionService *auth.SessionService) fiber.Handler {
 	}
 }
 
+// rejectDPoP answers a request whose token binding could not be verified (RFC 9449 section 7.1)
+func rejectDPoP(c *fiber.Ctx, message string) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `DPoP error="invalid_dpop_proof", algs="`+strings.Join(auth.DPoPSigningAlgorithms, " ")+`"`)
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error":   true,
+		"message": message,
+	})
+}
+
+// RequestURL returns the absolute URL of the request without its query, as a DPoP proof names it
+func RequestURL(c *fiber.Ctx) string {
+	return c.BaseURL() + c.Path()
+}
+
+// AuditAudienceRejection records a valid token presented to an application it was not issued for
+func AuditAudienceRejection(c *fiber.Ctx, db *gorm.DB, audienceErr *auth.AudienceError) {
+	clientIP := ExtractClientIP(c)
+	userAgent := c.Get("User-Agent")
+	details := map[string]interface{}{
+		"token_audience": audienceErr.Claims.ApplicationID,
+		"client_id":      audienceErr.Claims.IssuedTo(),
+		"path":           c.Path(),
+	}
+	models.CreateAuditLog(db, &audienceErr.Claims.UserID, &audienceErr.Expected, models.ActionAudienceReject, "token", nil,
+		details, &clientIP, &userAgent)
+}
+
 // RateLimiter creates a middleware for rate limiting
 func RateLimiter(cache *cache.Client, requestsPerMinute int) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -188,21 +265,8 @@ func RequirePermission(resource, action string) fiber.Handler {
 			})
 		}
 		
-		// Convert resource to application-scoped format if needed
-		scopedResource := resource
-		if !strings.HasPrefix(resource, "authy_") {
-			scopedResource = "authy_" + resource
-		}
-		
-		// Check for wildcard permission or exact match
-		requiredPermission := scopedResource + ":" + action
-		for _, perm := range permissions {
-			if perm == "*" || 
-			   perm == "authy_system:admin" || // Super admin permission
-			   perm == scopedResource+":*" || 
-			   perm == requiredPermission {
-				return c.Next()
-			}
+		if HasPermission(permissions, resource, action) {
+			return c.Next()
 		}
 		
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
@@ -212,6 +276,28 @@ func RequirePermission(resource, action string) fiber.Handler {
 	}
 }
 
+// HasPermission reports whether a set of permissions grants an action on an Authy resource
+func HasPermission(permissions []string, resource, action string) bool {
+	// Convert resource to application-scoped format if needed
+	scopedResource := resource
+	if !strings.HasPrefix(resource, "authy_") {
+		scopedResource = "authy_" + resource
+	}
+	
+	// Check for wildcard permission or exact match
+	requiredPermission := scopedResource + ":" + action
+	for _, perm := range permissions {
+		if perm == "*" || 
+		   perm == "authy_system:admin" || // Super admin permission
+		   perm == scopedResource+":*" || 
+		   perm == requiredPermission {
+			return true
+		}
+	}
+	
+	return false
+}
+
 // ExtractUserContext extracts user information from fiber context
 func ExtractUserContext(c *fiber.Ctx) (uuid.UUID, uuid.UUID, []string, bool) {
 	userID, ok1 := c.Locals("user_id").(uuid.UUID)
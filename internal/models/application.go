@@ -3,17 +3,89 @@ package models
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
 	"time"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ClientType distinguishes OAuth clients that can keep a secret from those that cannot
+type ClientType string
+
+const (
+	ClientTypeConfidential ClientType = "confidential"
+	ClientTypePublic       ClientType = "public"
+)
+
+// AccessTokenFormat selects whether an application's access tokens are self-contained or opaque
+type AccessTokenFormat string
+
+const (
+	AccessTokenFormatJWT    AccessTokenFormat = "jwt"    // signed JWT carrying the claims
+	AccessTokenFormatOpaque AccessTokenFormat = "opaque" // random reference resolvable only by validation or introspection
+)
+
+// OAuth 2.0 grant types an application may be allowed to use
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// GetGrantTypesList returns all grant types applications can be configured with
+func GetGrantTypesList() []string {
+	return []string{
+		GrantTypeAuthorizationCode,
+		GrantTypeRefreshToken,
+		GrantTypeClientCredentials,
+		GrantTypeTokenExchange,
+		GrantTypeDeviceCode,
+	}
+}
+
 type Application struct {
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
 	Name        string    `json:"name" gorm:"uniqueIndex;not null;size:100"`
 	Description string    `json:"description" gorm:"type:text"`
 	IsSystem    bool      `json:"is_system" gorm:"default:false"`
 	APIKey      string    `json:"api_key" gorm:"uniqueIndex;not null;size:255"`
+
+	// OAuth 2.0 client settings
+	RedirectURIs      []string   `json:"redirect_uris" gorm:"type:jsonb;serializer:json;default:'[]'"`
+	ClientType        ClientType `json:"client_type" gorm:"not null;size:20;default:'confidential'"`
+	AllowedGrantTypes []string   `json:"allowed_grant_types" gorm:"type:jsonb;serializer:json;default:'[]'"`
+
+	// Token lifetimes and session limits, in seconds; 0 uses the service default (or no limit)
+	AccessTokenTTL        int `json:"access_token_ttl" gorm:"not null;default:0"`
+	RefreshTokenTTL       int `json:"refresh_token_ttl" gorm:"not null;default:0"`
+	SessionLifetime       int `json:"session_lifetime" gorm:"not null;default:0"` // absolute, regardless of refreshes
+	IdleTimeout           int `json:"idle_timeout" gorm:"not null;default:0"`
+	MaxConcurrentSessions int `json:"max_concurrent_sessions" gorm:"not null;default:0"` // per user
+
+	// Whether access tokens carry their claims or only reference them, and whether they must be DPoP-bound
+	AccessTokenFormat AccessTokenFormat `json:"access_token_format" gorm:"not null;size:20;default:'jwt'"`
+	RequireDPoP       bool              `json:"require_dpop" gorm:"not null;default:false"`
+
+	// Whether users must verify their email address before they can log in
+	RequireVerifiedEmail bool `json:"require_verified_email" gorm:"not null;default:false"`
+
+	// WebAuthn relying party users register passkeys for; passkeys are disabled without an RP ID
+	WebAuthnRPID    string   `json:"webauthn_rp_id" gorm:"not null;size:253;default:''"`
+	WebAuthnRPName  string   `json:"webauthn_rp_name" gorm:"not null;size:100;default:''"`
+	WebAuthnOrigins []string `json:"webauthn_origins" gorm:"type:jsonb;serializer:json;default:'[]'"`
+
+	// Self-service registration; signups are limited to the allowed email domains (any domain when empty),
+	// get the default roles of the application (after verifying their email when domains are limited), and wait for
+	// an admin when approval is required
+	RegistrationEnabled         bool        `json:"registration_enabled" gorm:"not null;default:false"`
+	RegistrationDomains         []string    `json:"registration_domains" gorm:"type:jsonb;serializer:json;default:'[]'"`
+	RegistrationRoleIDs         []uuid.UUID `json:"registration_role_ids" gorm:"type:jsonb;serializer:json;default:'[]'"`
+	RegistrationRequireApproval bool        `json:"registration_require_approval" gorm:"not null;default:false"`
+
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
@@ -42,6 +114,34 @@ func (a *Application) BeforeCreate(tx *gorm.DB) error {
 		}
 		a.APIKey = apiKey
 	}
+
+	if a.ClientType == "" {
+		a.ClientType = ClientTypeConfidential
+	}
+
+	if a.RedirectURIs == nil {
+		a.RedirectURIs = []string{}
+	}
+
+	if a.AllowedGrantTypes == nil {
+		a.AllowedGrantTypes = []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken}
+	}
+
+	if a.AccessTokenFormat == "" {
+		a.AccessTokenFormat = AccessTokenFormatJWT
+	}
+
+	if a.WebAuthnOrigins == nil {
+		a.WebAuthnOrigins = []string{}
+	}
+
+	if a.RegistrationDomains == nil {
+		a.RegistrationDomains = []string{}
+	}
+
+	if a.RegistrationRoleIDs == nil {
+		a.RegistrationRoleIDs = []uuid.UUID{}
+	}
 	
 	return nil
 }
@@ -106,4 +206,133 @@ func (a *Application) ValidateSystemApplication() error {
 		return gorm.ErrInvalidData
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// IsPublicClient reports whether the application cannot keep a client secret (SPAs, mobile apps)
+func (a *Application) IsPublicClient() bool {
+	return a.ClientType == ClientTypePublic
+}
+
+// UsesOpaqueAccessTokens reports whether the application's access tokens are opaque references
+func (a *Application) UsesOpaqueAccessTokens() bool {
+	return a.AccessTokenFormat == AccessTokenFormatOpaque
+}
+
+// WebAuthnEnabled reports whether users can register and log in with passkeys for the application
+func (a *Application) WebAuthnEnabled() bool {
+	return a.WebAuthnRPID != "" && len(a.WebAuthnOrigins) > 0
+}
+
+// AllowsRegistrationEmail reports whether an email address may register itself with the application
+func (a *Application) AllowsRegistrationEmail(email string) bool {
+	if len(a.RegistrationDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range a.RegistrationDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsGrantType reports whether the application may use the given OAuth grant type
+func (a *Application) AllowsGrantType(grantType string) bool {
+	for _, allowed := range a.AllowedGrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRedirectURI reports whether the URI exactly matches one of the registered redirect URIs
+func (a *Application) HasRedirectURI(redirectURI string) bool {
+	for _, registered := range a.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidClientType checks if a client type is supported
+func IsValidClientType(clientType ClientType) bool {
+	return clientType == ClientTypeConfidential || clientType == ClientTypePublic
+}
+
+// IsValidAccessTokenFormat checks if an access token format is supported
+func IsValidAccessTokenFormat(format AccessTokenFormat) bool {
+	return format == AccessTokenFormatJWT || format == AccessTokenFormatOpaque
+}
+
+// IsValidGrantType checks if a grant type is supported
+func IsValidGrantType(grantType string) bool {
+	for _, valid := range GetGrantTypesList() {
+		if valid == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRedirectURI ensures a redirect URI is absolute and carries no fragment (RFC 6749 section 3.1.2)
+func ValidateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return err
+	}
+	if !parsed.IsAbs() {
+		return errors.New("redirect URI must be absolute")
+	}
+	if parsed.Fragment != "" || strings.Contains(redirectURI, "#") {
+		return errors.New("redirect URI must not contain a fragment")
+	}
+	return nil
+}
+
+// NormalizeRegistrationDomain returns an allowed registration domain in lower case, or an error when it is
+// not a bare domain name
+func NormalizeRegistrationDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" || strings.ContainsAny(domain, "@:/?#* ") || !strings.Contains(domain, ".") ||
+		strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", errors.New("invalid registration domain " + domain)
+	}
+	return domain, nil
+}
+
+// ValidateWebAuthnSettings ensures the RP ID is a bare host name and every origin is an https origin (or
+// http on localhost) on that host or one of its subdomains, as browsers require
+func ValidateWebAuthnSettings(rpID string, origins []string) error {
+	if rpID == "" {
+		if len(origins) > 0 {
+			return errors.New("WebAuthn origins require an RP ID")
+		}
+		return nil
+	}
+	if strings.ContainsAny(rpID, ":/?#@ ") || strings.HasPrefix(rpID, ".") || strings.HasSuffix(rpID, ".") {
+		return errors.New("WebAuthn RP ID must be a host name")
+	}
+
+	for _, origin := range origins {
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "" {
+			return errors.New("invalid WebAuthn origin " + origin)
+		}
+		host := parsed.Hostname()
+		if parsed.Scheme != "https" && !(parsed.Scheme == "http" && host == "localhost") {
+			return errors.New("WebAuthn origin " + origin + " must use https")
+		}
+		if host != rpID && !strings.HasSuffix(host, "."+rpID) {
+			return errors.New("WebAuthn origin " + origin + " is not on the RP ID " + rpID)
+		}
+	}
+	return nil
+}
@@ -48,11 +48,15 @@ const (
 	ActionLogout            AuditAction = "logout"
 	ActionTokenRefresh      AuditAction = "token_refresh"
 	ActionTokenValidate     AuditAction = "token_validate"
+	ActionTokenRevoke       AuditAction = "token_revoke"
+	ActionTokenReuse        AuditAction = "refresh_token_reuse"
+	ActionAudienceReject    AuditAction = "token_audience_rejected"
 	ActionUserCreate        AuditAction = "user_create"
 	ActionUserUpdate        AuditAction = "user_update"
 	ActionUserDelete        AuditAction = "user_delete"
 	ActionUserActivate      AuditAction = "user_activate"
 	ActionUserDeactivate    AuditAction = "user_deactivate"
+	ActionUserRegister      AuditAction = "user_register"
 	ActionRoleAssign        AuditAction = "role_assign"
 	ActionRoleRemove        AuditAction = "role_remove"
 	ActionApplicationCreate AuditAction = "application_create"
@@ -62,7 +66,31 @@ const (
 	ActionRoleUpdate        AuditAction = "role_update"
 	ActionRoleDelete        AuditAction = "role_delete"
 	ActionPasswordChange    AuditAction = "password_change"
+	ActionPasswordReset     AuditAction = "password_reset_request"
+	ActionEmailVerifySend   AuditAction = "email_verification_send"
+	ActionEmailVerify       AuditAction = "email_verify"
+	ActionEmailChange       AuditAction = "email_change"
 	ActionAPIKeyRegenerate  AuditAction = "api_key_regenerate"
+	ActionSigningKeyCreate  AuditAction = "signing_key_create"
+	ActionSigningKeyPromote AuditAction = "signing_key_promote"
+	ActionSigningKeyRetire  AuditAction = "signing_key_retire"
+	ActionServiceTokenIssue AuditAction = "service_token_issue"
+	ActionTokenExchange     AuditAction = "token_exchange"
+	ActionDelegatedAccess   AuditAction = "delegated_access"
+	ActionDeviceDeny        AuditAction = "device_authorization_deny"
+	ActionMFAEnroll         AuditAction = "mfa_enroll"
+	ActionMFAEnable         AuditAction = "mfa_enable"
+	ActionMFADisable        AuditAction = "mfa_disable"
+	ActionMFAChallenge      AuditAction = "mfa_challenge"
+	ActionMFAFailed         AuditAction = "mfa_failed"
+	ActionWebAuthnRegister  AuditAction = "webauthn_register"
+	ActionWebAuthnRemove    AuditAction = "webauthn_remove"
+	ActionMFARecoveryCodes  AuditAction = "mfa_recovery_codes"
+	ActionMFARecoveryUse    AuditAction = "mfa_recovery_use"
+	ActionMFAReset          AuditAction = "mfa_reset"
+	ActionStepUpFailed      AuditAction = "step_up_failed"
+	ActionTemplateUpdate    AuditAction = "notification_template_update"
+	ActionTemplateDelete    AuditAction = "notification_template_delete"
 )
 
 // SetDetails sets the details field from a map or struct
@@ -85,6 +113,11 @@ func (al *AuditLog) GetDetails(destination interface{}) error {
 
 // CreateAuditLog creates a new audit log entry
 func CreateAuditLog(db *gorm.DB, userID *uuid.UUID, applicationID *uuid.UUID, action AuditAction, resource string, resourceID *string, details interface{}, ipAddress *net.IP, userAgent *string) error {
+	// Service principal tokens carry no user; the application identifies the actor
+	if userID != nil && *userID == uuid.Nil {
+		userID = nil
+	}
+
 	var ipStr *string
 	if ipAddress != nil {
 		str := ipAddress.String()
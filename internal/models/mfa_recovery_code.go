@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MFARecoveryCode stores the hash of a single-use code that stands in for a user's second factor
+type MFARecoveryCode struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	CodeHash  string     `json:"-" gorm:"not null;size:64"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for GORM
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// BeforeCreate hook to generate UUID if not provided
+func (r *MFARecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// ReplaceMFARecoveryCodes discards a user's recovery codes and stores the given code hashes instead
+func ReplaceMFARecoveryCodes(db *gorm.DB, userID uuid.UUID, codeHashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]MFARecoveryCode, 0, len(codeHashes))
+		for _, codeHash := range codeHashes {
+			codes = append(codes, MFARecoveryCode{UserID: userID, CodeHash: codeHash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseMFARecoveryCode marks an unused recovery code of a user as used, reporting whether there was one.
+// A code is only ever accepted once, even when sent twice at the same time.
+func UseMFARecoveryCode(db *gorm.DB, userID uuid.UUID, codeHash string) (bool, error) {
+	result := db.Model(&MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// CountUnusedMFARecoveryCodes returns how many recovery codes a user has left
+func CountUnusedMFARecoveryCodes(db *gorm.DB, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := db.Model(&MFARecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// DeleteMFARecoveryCodes removes every recovery code of a user, returning how many there were
+func DeleteMFARecoveryCodes(db *gorm.DB, userID uuid.UUID) (int64, error) {
+	result := db.Where("user_id = ?", userID).Delete(&MFARecoveryCode{})
+	return result.RowsAffected, result.Error
+}
@@ -21,6 +21,13 @@ func AllModels() []interface{} {
 		&AuditLog{},
 		&Permission{},
 		&RolePermission{},
+		&SigningKey{},
+		&ServicePrincipalRole{},
+		&PermissionVersion{},
+		&WebAuthnCredential{},
+		&MFARecoveryCode{},
+		&Notification{},
+		&NotificationTemplate{},
 	}
 }
 
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type NotificationStatus string
+
+const (
+	// NotificationPending waits for its first or next delivery attempt
+	NotificationPending NotificationStatus = "pending"
+	// NotificationSent was accepted by the transport
+	NotificationSent NotificationStatus = "sent"
+	// NotificationFailed ran out of delivery attempts
+	NotificationFailed NotificationStatus = "failed"
+)
+
+// Notification records a message sent to a user and the state of its delivery. The rendered body holds
+// secrets such as reset links, so it is stored encrypted and dropped once delivery ends.
+type Notification struct {
+	ID            uuid.UUID          `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	ApplicationID *uuid.UUID         `json:"application_id" gorm:"type:uuid;index"`
+	UserID        *uuid.UUID         `json:"user_id" gorm:"type:uuid;index"`
+	Kind          string             `json:"kind" gorm:"not null;size:50;index"`
+	Locale        string             `json:"locale" gorm:"not null;size:20"`
+	Recipient     string             `json:"recipient" gorm:"not null;size:255"`
+	Subject       string             `json:"subject" gorm:"type:text;not null"`
+	EncryptedBody string             `json:"-" gorm:"type:text;not null"`
+	Status        NotificationStatus `json:"status" gorm:"not null;size:20;index:idx_notifications_status_next"`
+	Attempts      int                `json:"attempts" gorm:"not null;default:0"`
+	LastError     string             `json:"last_error,omitempty" gorm:"type:text"`
+	NextAttemptAt time.Time          `json:"next_attempt_at" gorm:"index:idx_notifications_status_next"`
+	SentAt        *time.Time         `json:"sent_at"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+
+	// Relationships
+	User        *User        `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Application *Application `json:"application,omitempty" gorm:"foreignKey:ApplicationID"`
+}
+
+// TableName specifies the table name for GORM
+func (Notification) TableName() string {
+	return "notifications"
+}
+
+// BeforeCreate hook to generate UUID if not provided
+func (n *Notification) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
+
+// GetDueNotificationIDs returns pending notifications whose next delivery attempt is due, oldest first
+func GetDueNotificationIDs(db *gorm.DB, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&Notification{}).
+		Where("status = ? AND next_attempt_at <= ?", NotificationPending, time.Now()).
+		Order("next_attempt_at").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ClaimNotification counts a delivery attempt of a due notification and holds it for the lease, reporting
+// whether it was claimed. Only one worker of any instance claims an attempt; when that worker dies, the
+// notification is due again once the lease ends.
+func ClaimNotification(db *gorm.DB, id uuid.UUID, lease time.Duration) (bool, error) {
+	now := time.Now()
+	result := db.Model(&Notification{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, NotificationPending, now).
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(lease),
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// MarkNotificationSent records the delivery of a notification
+func MarkNotificationSent(db *gorm.DB, id uuid.UUID) error {
+	now := time.Now()
+	return db.Model(&Notification{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":         NotificationSent,
+		"sent_at":        now,
+		"last_error":     "",
+		"encrypted_body": "",
+	}).Error
+}
+
+// MarkNotificationFailed records a failed delivery attempt. The notification is retried at nextAttempt,
+// or fails for good when nextAttempt is nil.
+func MarkNotificationFailed(db *gorm.DB, id uuid.UUID, deliveryError string, nextAttempt *time.Time) error {
+	updates := map[string]interface{}{
+		"last_error": deliveryError,
+	}
+	if nextAttempt != nil {
+		updates["next_attempt_at"] = *nextAttempt
+	} else {
+		updates["status"] = NotificationFailed
+		updates["encrypted_body"] = ""
+	}
+	return db.Model(&Notification{}).Where("id = ?", id).Updates(updates).Error
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NotificationTemplate overrides the built-in template of a notification kind for one application and locale
+type NotificationTemplate struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	ApplicationID uuid.UUID `json:"application_id" gorm:"type:uuid;not null;uniqueIndex:idx_notification_templates_app_kind_locale"`
+	Kind          string    `json:"kind" gorm:"not null;size:50;uniqueIndex:idx_notification_templates_app_kind_locale"`
+	Locale        string    `json:"locale" gorm:"not null;size:20;uniqueIndex:idx_notification_templates_app_kind_locale"`
+	Subject       string    `json:"subject" gorm:"type:text;not null"`
+	Body          string    `json:"body" gorm:"type:text;not null"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for GORM
+func (NotificationTemplate) TableName() string {
+	return "notification_templates"
+}
+
+// BeforeCreate hook to generate UUID if not provided
+func (t *NotificationTemplate) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// GetNotificationTemplates returns the templates of an application
+func GetNotificationTemplates(db *gorm.DB, applicationID uuid.UUID) ([]NotificationTemplate, error) {
+	var templates []NotificationTemplate
+	err := db.Where("application_id = ?", applicationID).Order("kind, locale").Find(&templates).Error
+	return templates, err
+}
+
+// FindNotificationTemplate returns the template of an application for a kind and locale
+func FindNotificationTemplate(db *gorm.DB, applicationID uuid.UUID, kind, locale string) (*NotificationTemplate, error) {
+	var template NotificationTemplate
+	err := db.Where("application_id = ? AND kind = ? AND locale = ?", applicationID, kind, locale).First(&template).Error
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
@@ -177,6 +177,23 @@ func HasUserPermission(db *gorm.DB, userID, applicationID uuid.UUID, resource, a
 	return count > 0, err
 }
 
+// HasSystemPermission checks if a user holds exactly the given permission through their roles in the Authy
+// system application. Wildcard and super admin permissions don't count.
+func HasSystemPermission(db *gorm.DB, userID uuid.UUID, resource, action string) (bool, error) {
+	permissionName := fmt.Sprintf("%s:%s", strings.ToLower(resource), strings.ToLower(action))
+	
+	var count int64
+	err := db.Model(&Permission{}).
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Joins("JOIN applications ON applications.id = user_roles.application_id").
+		Where("user_roles.user_id = ? AND applications.is_system = true AND permissions.name = ?", 
+			userID, permissionName).
+		Count(&count).Error
+	
+	return count > 0, err
+}
+
 // ParsePermission parses a permission string (resource:action) into resource and action
 func ParsePermission(permission string) (resource, action string, err error) {
 	parts := strings.Split(permission, ":")
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PermissionVersion counts the changes to the permissions a user holds in an application.
+// Tokens carry the version they were issued with so stale permissions can be detected.
+type PermissionVersion struct {
+	UserID        uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	ApplicationID uuid.UUID `json:"application_id" gorm:"type:uuid;primaryKey"`
+	Version       int64     `json:"version" gorm:"not null;default:0"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for GORM
+func (PermissionVersion) TableName() string {
+	return "permission_versions"
+}
+
+// PermissionHolder identifies a user whose permissions in an application depend on a role
+type PermissionHolder struct {
+	UserID        uuid.UUID
+	ApplicationID uuid.UUID
+}
+
+// GetPermissionVersion returns the permission version of a user in an application, 0 if it never changed
+func GetPermissionVersion(db *gorm.DB, userID, applicationID uuid.UUID) (int64, error) {
+	var permissionVersion PermissionVersion
+	err := db.Where("user_id = ? AND application_id = ?", userID, applicationID).First(&permissionVersion).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return permissionVersion.Version, nil
+}
+
+// BumpPermissionVersion increments the permission version of a user in an application and returns the new version
+func BumpPermissionVersion(db *gorm.DB, userID, applicationID uuid.UUID) (int64, error) {
+	var version int64
+	err := db.Raw(`INSERT INTO permission_versions (user_id, application_id, version, updated_at)
+		VALUES (?, ?, 1, NOW())
+		ON CONFLICT (user_id, application_id)
+		DO UPDATE SET version = permission_versions.version + 1, updated_at = NOW()
+		RETURNING version`, userID, applicationID).Scan(&version).Error
+	return version, err
+}
+
+// GetRoleHolders returns every user and application pair holding a role
+func GetRoleHolders(db *gorm.DB, roleID uuid.UUID) ([]PermissionHolder, error) {
+	var holders []PermissionHolder
+	err := db.Model(&UserRole{}).
+		Distinct("user_id", "application_id").
+		Where("role_id = ?", roleID).
+		Scan(&holders).Error
+	return holders, err
+}
+
+// GetPermissionHolders returns every user and application pair holding a permission through one of their roles
+func GetPermissionHolders(db *gorm.DB, permissionID uuid.UUID) ([]PermissionHolder, error) {
+	var holders []PermissionHolder
+	err := db.Model(&UserRole{}).
+		Distinct("user_roles.user_id", "user_roles.application_id").
+		Joins("JOIN role_permissions ON role_permissions.role_id = user_roles.role_id").
+		Where("role_permissions.permission_id = ?", permissionID).
+		Scan(&holders).Error
+	return holders, err
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ServicePrincipalRole grants a role to an application acting on its own behalf (client credentials grant)
+type ServicePrincipalRole struct {
+	ID                  uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	ClientApplicationID uuid.UUID  `json:"client_application_id" gorm:"type:uuid;not null;uniqueIndex:idx_service_principal_roles_unique"`
+	RoleID              uuid.UUID  `json:"role_id" gorm:"type:uuid;not null;uniqueIndex:idx_service_principal_roles_unique"`
+	ApplicationID       uuid.UUID  `json:"application_id" gorm:"type:uuid;not null;index"` // application the role belongs to
+	GrantedAt           time.Time  `json:"granted_at"`
+	GrantedBy           *uuid.UUID `json:"granted_by" gorm:"type:uuid"`
+
+	// Relationships
+	ClientApplication *Application `json:"client_application,omitempty" gorm:"foreignKey:ClientApplicationID;constraint:OnDelete:CASCADE"`
+	Role              *Role        `json:"role,omitempty" gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+	Application       *Application `json:"application,omitempty" gorm:"foreignKey:ApplicationID;constraint:OnDelete:CASCADE"`
+}
+
+// TableName specifies the table name for GORM
+func (ServicePrincipalRole) TableName() string {
+	return "service_principal_roles"
+}
+
+// BeforeCreate hook to generate UUID and set granted_at if not provided
+func (spr *ServicePrincipalRole) BeforeCreate(tx *gorm.DB) error {
+	if spr.ID == uuid.Nil {
+		spr.ID = uuid.New()
+	}
+
+	if spr.GrantedAt.IsZero() {
+		spr.GrantedAt = time.Now()
+	}
+
+	return nil
+}
+
+// GetServicePrincipalRoles returns all roles granted to a client application
+func GetServicePrincipalRoles(db *gorm.DB, clientApplicationID uuid.UUID) ([]ServicePrincipalRole, error) {
+	var roles []ServicePrincipalRole
+	err := db.Preload("Role").
+		Preload("Application").
+		Where("client_application_id = ?", clientApplicationID).
+		Order("granted_at").
+		Find(&roles).Error
+
+	return roles, err
+}
+
+// HasServicePrincipalRole checks if a client application was granted a specific role
+func HasServicePrincipalRole(db *gorm.DB, clientApplicationID, roleID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&ServicePrincipalRole{}).
+		Where("client_application_id = ? AND role_id = ?", clientApplicationID, roleID).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+// HasServicePrincipalAccess checks if a client application holds any role in the given application
+func HasServicePrincipalAccess(db *gorm.DB, clientApplicationID, applicationID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&ServicePrincipalRole{}).
+		Where("client_application_id = ? AND application_id = ?", clientApplicationID, applicationID).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+// RemoveServicePrincipalRole revokes a role from a client application
+func RemoveServicePrincipalRole(db *gorm.DB, clientApplicationID, roleID uuid.UUID) error {
+	return db.Where("client_application_id = ? AND role_id = ?", clientApplicationID, roleID).
+		Delete(&ServicePrincipalRole{}).Error
+}
+
+// GetServicePrincipalPermissionStrings returns the permissions a client application holds in an application
+func GetServicePrincipalPermissionStrings(db *gorm.DB, clientApplicationID, applicationID uuid.UUID) ([]string, error) {
+	var permissions []Permission
+	err := db.Distinct().
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN service_principal_roles ON service_principal_roles.role_id = role_permissions.role_id").
+		Where("service_principal_roles.client_application_id = ? AND service_principal_roles.application_id = ?", clientApplicationID, applicationID).
+		Order("permissions.category, permissions.resource, permissions.action").
+		Find(&permissions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var permissionStrings []string
+	for _, permission := range permissions {
+		permissionStrings = append(permissionStrings, permission.Name)
+	}
+
+	return permissionStrings, nil
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SigningKeyStatus string
+
+const (
+	// SigningKeyActive signs new tokens; exactly one key has this status
+	SigningKeyActive SigningKeyStatus = "active"
+	// SigningKeyVerification is published and accepted for verification but never signs
+	SigningKeyVerification SigningKeyStatus = "verification"
+	// SigningKeyRetired is no longer accepted; tokens signed with it are rejected
+	SigningKeyRetired SigningKeyStatus = "retired"
+)
+
+// SigningKey stores a token signing key shared by every Authy instance
+type SigningKey struct {
+	ID           uuid.UUID        `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	KID          string           `json:"kid" gorm:"uniqueIndex;not null;size:100"`
+	Algorithm    string           `json:"algorithm" gorm:"not null;size:10"`
+	EncryptedKey string           `json:"-" gorm:"type:text;not null"`
+	Status       SigningKeyStatus `json:"status" gorm:"not null;size:20;index:idx_signing_keys_status"`
+	CreatedAt    time.Time        `json:"created_at"`
+	ActivatedAt  *time.Time       `json:"activated_at"`
+	RetiredAt    *time.Time       `json:"retired_at"`
+}
+
+// TableName specifies the table name for GORM
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
+
+// BeforeCreate hook to generate UUID if not provided
+func (k *SigningKey) BeforeCreate(tx *gorm.DB) error {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return nil
+}
+
+// GetSigningKeys returns every signing key, newest first
+func GetSigningKeys(db *gorm.DB) ([]SigningKey, error) {
+	var keys []SigningKey
+	err := db.Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// GetUsableSigningKeys returns the active key and all verification-only keys
+func GetUsableSigningKeys(db *gorm.DB) ([]SigningKey, error) {
+	var keys []SigningKey
+	err := db.Where("status IN ?", []SigningKeyStatus{SigningKeyActive, SigningKeyVerification}).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// FindSigningKeyByKID finds a signing key by its key ID
+func FindSigningKeyByKID(db *gorm.DB, kid string) (*SigningKey, error) {
+	var key SigningKey
+	err := db.Where("kid = ?", kid).First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// PromoteSigningKey makes the given key active and demotes the current active key to verification-only
+func PromoteSigningKey(db *gorm.DB, kid string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		key, err := FindSigningKeyByKID(tx, kid)
+		if err != nil {
+			return err
+		}
+
+		if key.Status == SigningKeyRetired {
+			return fmt.Errorf("cannot promote a retired signing key")
+		}
+
+		if err := tx.Model(&SigningKey{}).
+			Where("status = ? AND kid != ?", SigningKeyActive, kid).
+			Update("status", SigningKeyVerification).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Model(key).Updates(map[string]interface{}{
+			"status":       SigningKeyActive,
+			"activated_at": now,
+		}).Error
+	})
+}
+
+// RetireSigningKey stops accepting tokens signed with the given key
+func RetireSigningKey(db *gorm.DB, kid string) error {
+	key, err := FindSigningKeyByKID(db, kid)
+	if err != nil {
+		return err
+	}
+
+	if key.Status == SigningKeyActive {
+		return fmt.Errorf("cannot retire the active signing key")
+	}
+
+	now := time.Now()
+	return db.Model(key).Updates(map[string]interface{}{
+		"status":     SigningKeyRetired,
+		"retired_at": now,
+	}).Error
+}
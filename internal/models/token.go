@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"time"
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -21,7 +22,13 @@ type Token struct {
 	ApplicationID uuid.UUID `json:"application_id" gorm:"type:uuid;not null;index:idx_tokens_user_app"`
 	TokenHash     string    `json:"-" gorm:"not null;size:255;index:idx_tokens_hash"`
 	TokenType     TokenType `json:"token_type" gorm:"not null;size:20"`
+	SessionID     *uuid.UUID `json:"session_id,omitempty" gorm:"type:uuid;index:idx_tokens_session"` // refresh token family: shared by all tokens of one login
 	ExpiresAt     time.Time `json:"expires_at" gorm:"not null;index:idx_tokens_expires"`
+	RotatedAt     *time.Time `json:"rotated_at,omitempty"` // set once a refresh token has been exchanged for a new pair
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"` // set once a token was revoked; a revoked token is never restored
+	IPAddress     *string    `json:"ip_address,omitempty" gorm:"type:inet"`
+	UserAgent     *string    `json:"user_agent,omitempty" gorm:"type:text"`
+	Claims        datatypes.JSON `json:"-" gorm:"type:jsonb"` // opaque access tokens only: the claims they stand for
 	CreatedAt     time.Time `json:"created_at"`
 
 	// Relationships
@@ -29,6 +36,18 @@ type Token struct {
 	Application *Application `json:"application,omitempty" gorm:"foreignKey:ApplicationID"`
 }
 
+// UserSession summarizes one active login (refresh token family) of a user
+type UserSession struct {
+	SessionID       uuid.UUID    `json:"session_id"`
+	ApplicationID   uuid.UUID    `json:"application_id"`
+	Application     *Application `json:"application,omitempty"`
+	IssuedAt        time.Time    `json:"issued_at"`
+	LastRefreshedAt time.Time    `json:"last_refreshed_at"`
+	ExpiresAt       time.Time    `json:"expires_at"`
+	IPAddress       *string      `json:"ip_address,omitempty"`
+	UserAgent       *string      `json:"user_agent,omitempty"`
+}
+
 // TableName specifies the table name for GORM
 func (Token) TableName() string {
 	return "tokens"
@@ -44,8 +63,13 @@ func (t *Token) BeforeCreate(tx *gorm.DB) error {
 
 // HashToken creates a SHA-256 hash of the token string
 func (t *Token) HashToken(tokenString string) {
+	t.TokenHash = HashTokenString(tokenString)
+}
+
+// HashTokenString returns the SHA-256 hash under which a token is stored
+func HashTokenString(tokenString string) string {
 	hash := sha256.Sum256([]byte(tokenString))
-	t.TokenHash = hex.EncodeToString(hash[:])
+	return hex.EncodeToString(hash[:])
 }
 
 // IsExpired checks if the token is expired
@@ -86,6 +110,13 @@ func GetUserTokens(db *gorm.DB, userID uuid.UUID) ([]Token, error) {
 	return tokens, err
 }
 
+// GetValidUserTokens returns all valid tokens for a user across all applications
+func GetValidUserTokens(db *gorm.DB, userID uuid.UUID) ([]Token, error) {
+	var tokens []Token
+	err := db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Find(&tokens).Error
+	return tokens, err
+}
+
 // GetUserTokensInApplication returns all tokens for a user in a specific application
 func GetUserTokensInApplication(db *gorm.DB, userID, applicationID uuid.UUID) ([]Token, error) {
 	var tokens []Token
@@ -105,22 +136,165 @@ func GetValidUserTokensInApplication(db *gorm.DB, userID, applicationID uuid.UUI
 
 // InvalidateToken marks a token as expired by setting expires_at to now
 func (t *Token) InvalidateToken(db *gorm.DB) error {
-	return db.Model(t).Update("expires_at", time.Now()).Error
+	return revokeTokens(db.Model(&Token{}).Where("id = ?", t.ID))
+}
+
+// revokeTokens invalidates the tokens a query matches and marks them revoked. Refresh tokens marked rotated are
+// included, so UndoRotation can't bring them back.
+func revokeTokens(query *gorm.DB) error {
+	now := time.Now()
+	return query.
+		Where("revoked_at IS NULL AND (expires_at > ? OR rotated_at IS NOT NULL)", now).
+		Updates(map[string]interface{}{
+			"expires_at": gorm.Expr("LEAST(expires_at, ?)", now),
+			"revoked_at": now,
+		}).Error
+}
+
+// IsRotated checks if a refresh token was already exchanged for a new pair
+func (t *Token) IsRotated() bool {
+	return t.RotatedAt != nil
+}
+
+// MarkRotated atomically marks a still-valid refresh token as rotated and invalidates it, recording the
+// rotation time on t. It returns false when the token was already rotated or is no longer valid.
+func (t *Token) MarkRotated(db *gorm.DB) (bool, error) {
+	// Truncated to the database's precision so UndoRotation can match it
+	now := time.Now().Truncate(time.Microsecond)
+	result := db.Model(&Token{}).
+		Where("id = ? AND rotated_at IS NULL AND expires_at > ?", t.ID, now).
+		Updates(map[string]interface{}{
+			"rotated_at": now,
+			"expires_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+	t.RotatedAt = &now
+	return true, nil
+}
+
+// UndoRotation restores a refresh token marked rotated by MarkRotated when no new pair was issued for it,
+// so the client can retry. A token revoked since it was marked stays revoked, and false is returned.
+func (t *Token) UndoRotation(db *gorm.DB) (bool, error) {
+	if t.RotatedAt == nil {
+		return false, nil
+	}
+	result := db.Model(&Token{}).
+		Where("id = ? AND rotated_at = ? AND expires_at = ? AND revoked_at IS NULL", t.ID, *t.RotatedAt, *t.RotatedAt).
+		Updates(map[string]interface{}{
+			"rotated_at": nil,
+			"expires_at": t.ExpiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+	t.RotatedAt = nil
+	return true, nil
+}
+
+// GetActiveUserSessions returns the active sessions of a user across all applications, most recent first.
+// A session stays active while its current refresh token is valid.
+func GetActiveUserSessions(db *gorm.DB, userID uuid.UUID) ([]UserSession, error) {
+	var refreshTokens []Token
+	err := db.Preload("Application").
+		Where("user_id = ? AND token_type = ? AND session_id IS NOT NULL AND expires_at > ?", userID, RefreshToken, time.Now()).
+		Order("created_at DESC").
+		Find(&refreshTokens).Error
+	if err != nil || len(refreshTokens) == 0 {
+		return []UserSession{}, err
+	}
+
+	sessionIDs := make([]uuid.UUID, 0, len(refreshTokens))
+	for _, token := range refreshTokens {
+		sessionIDs = append(sessionIDs, *token.SessionID)
+	}
+
+	// The session started with its oldest token
+	var starts []struct {
+		SessionID uuid.UUID
+		IssuedAt  time.Time
+	}
+	err = db.Model(&Token{}).
+		Select("session_id, MIN(created_at) AS issued_at").
+		Where("session_id IN ?", sessionIDs).
+		Group("session_id").
+		Scan(&starts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	issuedAt := make(map[uuid.UUID]time.Time, len(starts))
+	for _, start := range starts {
+		issuedAt[start.SessionID] = start.IssuedAt
+	}
+
+	sessions := make([]UserSession, 0, len(refreshTokens))
+	for _, token := range refreshTokens {
+		sessions = append(sessions, UserSession{
+			SessionID:       *token.SessionID,
+			ApplicationID:   token.ApplicationID,
+			Application:     token.Application,
+			IssuedAt:        issuedAt[*token.SessionID],
+			LastRefreshedAt: token.CreatedAt,
+			ExpiresAt:       token.ExpiresAt,
+			IPAddress:       token.IPAddress,
+			UserAgent:       token.UserAgent,
+		})
+	}
+
+	return sessions, nil
+}
+
+// GetActiveSessionIDsInApplication returns the active sessions of a user in an application, least recently refreshed first
+func GetActiveSessionIDsInApplication(db *gorm.DB, userID, applicationID uuid.UUID) ([]uuid.UUID, error) {
+	var sessionIDs []uuid.UUID
+	err := db.Model(&Token{}).
+		Where("user_id = ? AND application_id = ? AND token_type = ? AND session_id IS NOT NULL AND expires_at > ?",
+			userID, applicationID, RefreshToken, time.Now()).
+		Order("created_at").
+		Pluck("session_id", &sessionIDs).Error
+	return sessionIDs, err
+}
+
+// HasUserSession checks if a session belongs to a user
+func HasUserSession(db *gorm.DB, userID, sessionID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&Token{}).
+		Where("user_id = ? AND session_id = ?", userID, sessionID).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+// GetValidSessionTokens returns the valid tokens issued within a session
+func GetValidSessionTokens(db *gorm.DB, sessionID uuid.UUID) ([]Token, error) {
+	var tokens []Token
+	err := db.Where("session_id = ? AND expires_at > ?", sessionID, time.Now()).
+		Find(&tokens).Error
+	return tokens, err
+}
+
+// InvalidateSessionTokens invalidates all tokens issued within a session
+func InvalidateSessionTokens(db *gorm.DB, sessionID uuid.UUID) error {
+	return revokeTokens(db.Model(&Token{}).Where("session_id = ?", sessionID))
 }
 
 // InvalidateUserTokensInApplication invalidates all tokens for a user in a specific application
 func InvalidateUserTokensInApplication(db *gorm.DB, userID, applicationID uuid.UUID, tokenType TokenType) error {
-	return db.Model(&Token{}).
-		Where("user_id = ? AND application_id = ? AND token_type = ? AND expires_at > ?", 
-			userID, applicationID, tokenType, time.Now()).
-		Update("expires_at", time.Now()).Error
+	return revokeTokens(db.Model(&Token{}).
+		Where("user_id = ? AND application_id = ? AND token_type = ?", userID, applicationID, tokenType))
 }
 
 // InvalidateAllUserTokens invalidates all tokens for a user across all applications
 func InvalidateAllUserTokens(db *gorm.DB, userID uuid.UUID) error {
-	return db.Model(&Token{}).
-		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
-		Update("expires_at", time.Now()).Error
+	return revokeTokens(db.Model(&Token{}).Where("user_id = ?", userID))
 }
 
 // CleanupExpiredTokens removes expired tokens from the database
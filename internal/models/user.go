@@ -17,10 +17,29 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 
+	// Email verification; a new address waits in PendingEmail until it is confirmed, keeping the old one in use
+	EmailVerified   bool       `json:"email_verified" gorm:"not null;default:false"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	PendingEmail    *string    `json:"pending_email,omitempty" gorm:"size:255;index"`
+
+	// Set on users who registered themselves with an application requiring approval; they stay inactive until approved
+	ApprovalPending bool `json:"approval_pending" gorm:"not null;default:false"`
+
+	// Set on users who registered with an application limiting email domains; they get its default roles once
+	// they verify an allowed email address
+	PendingRegistrationApplicationID *uuid.UUID `json:"-" gorm:"type:uuid"`
+
+	// TOTP multi-factor authentication; the secret is encrypted and set from enrollment on
+	TOTPSecret    *string    `json:"-" gorm:"type:text"`
+	TOTPEnabled   bool       `json:"totp_enabled" gorm:"not null;default:false"` // set once enrollment was confirmed
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at,omitempty"`
+
 	// Relationships
-	UserRoles []UserRole  `json:"user_roles,omitempty" gorm:"foreignKey:UserID"`
-	Tokens    []Token     `json:"tokens,omitempty" gorm:"foreignKey:UserID"`
-	AuditLogs []AuditLog  `json:"audit_logs,omitempty" gorm:"foreignKey:UserID"`
+	UserRoles           []UserRole           `json:"user_roles,omitempty" gorm:"foreignKey:UserID"`
+	Tokens              []Token              `json:"tokens,omitempty" gorm:"foreignKey:UserID"`
+	AuditLogs           []AuditLog           `json:"audit_logs,omitempty" gorm:"foreignKey:UserID"`
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials,omitempty" gorm:"foreignKey:UserID"`
+	MFARecoveryCodes    []MFARecoveryCode    `json:"-" gorm:"foreignKey:UserID"`
 }
 
 // TableName specifies the table name for GORM
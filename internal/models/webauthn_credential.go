@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WebAuthnCredential stores a passkey a user registered for a relying party
+type WebAuthnCredential struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	UserID         uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	RPID           string     `json:"rp_id" gorm:"not null;size:253"`
+	CredentialID   []byte     `json:"-" gorm:"type:bytea;not null;uniqueIndex"`
+	PublicKey      []byte     `json:"-" gorm:"type:bytea;not null"` // COSE_Key
+	SignCount      int64      `json:"sign_count" gorm:"not null;default:0"`
+	Transports     []string   `json:"transports" gorm:"type:jsonb;serializer:json;default:'[]'"`
+	AAGUID         uuid.UUID  `json:"aaguid" gorm:"type:uuid"`
+	BackupEligible bool       `json:"backup_eligible" gorm:"not null;default:false"` // synced passkey
+	Name           string     `json:"name" gorm:"not null;size:100"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// Relationships
+	User *User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// TableName specifies the table name for GORM
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// BeforeCreate hook to generate UUID if not provided
+func (w *WebAuthnCredential) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	if w.Transports == nil {
+		w.Transports = []string{}
+	}
+	return nil
+}
+
+// GetWebAuthnCredentials returns the credentials a user registered for a relying party, oldest first
+func GetWebAuthnCredentials(db *gorm.DB, userID uuid.UUID, rpID string) ([]WebAuthnCredential, error) {
+	var credentials []WebAuthnCredential
+	err := db.Where("user_id = ? AND rp_id = ?", userID, rpID).Order("created_at ASC").Find(&credentials).Error
+	return credentials, err
+}
+
+// FindWebAuthnCredential looks up a credential of a relying party by the ID its authenticator assigned
+func FindWebAuthnCredential(db *gorm.DB, rpID string, credentialID []byte) (*WebAuthnCredential, error) {
+	var credential WebAuthnCredential
+	if err := db.Where("rp_id = ? AND credential_id = ?", rpID, credentialID).First(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// HasWebAuthnCredentials reports whether a user registered any credential for a relying party
+func HasWebAuthnCredentials(db *gorm.DB, userID uuid.UUID, rpID string) (bool, error) {
+	var count int64
+	err := db.Model(&WebAuthnCredential{}).Where("user_id = ? AND rp_id = ?", userID, rpID).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,33 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileTransport drops each message as an .eml file into a directory, where it can be opened with a mail client
+type FileTransport struct {
+	dir string
+}
+
+// NewFileTransport creates a transport writing into dir, creating it when missing
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file transport requires a directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+// Send writes the message to a new file named after the time and kind of the message
+func (t *FileTransport) Send(ctx context.Context, message *Message) error {
+	name := fmt.Sprintf("%s-%s-%s.eml", time.Now().UTC().Format("20060102T150405Z"), message.Kind, uuid.New().String()[:8])
+	return os.WriteFile(filepath.Join(t.dir, name), message.Bytes(), 0o600)
+}
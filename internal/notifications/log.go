@@ -0,0 +1,24 @@
+package notifications
+
+import (
+	"context"
+
+	"github.com/efrenfuentes/authy/pkg/logger"
+)
+
+// LogTransport writes messages to the service log instead of delivering them. Messages carry secrets
+// such as reset links, so it is meant for development only.
+type LogTransport struct {
+	logger *logger.Logger
+}
+
+// NewLogTransport creates a transport writing to the given logger
+func NewLogTransport(log *logger.Logger) *LogTransport {
+	return &LogTransport{logger: log}
+}
+
+// Send logs the message
+func (t *LogTransport) Send(ctx context.Context, message *Message) error {
+	t.logger.Info("Notification", "kind", message.Kind, "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
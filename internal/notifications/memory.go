@@ -0,0 +1,42 @@
+package notifications
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryTransport keeps messages in memory so tests can inspect what was sent
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryTransport creates an empty in-memory transport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Send stores a copy of the message
+func (t *MemoryTransport) Send(ctx context.Context, message *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, *message)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Message(nil), t.messages...)
+}
+
+// Reset discards the messages sent so far
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
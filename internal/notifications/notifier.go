@@ -0,0 +1,83 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"time"
+
+	"github.com/efrenfuentes/authy/pkg/logger"
+)
+
+// Notification kinds
+const (
+	KindPasswordReset     = "password_reset"
+	KindEmailVerification = "email_verification"
+	KindInvitation        = "invitation"
+	KindNewDevice         = "new_device"
+)
+
+// Transports messages can be delivered with
+const (
+	TransportLog    = "log"    // writes messages to the service log, for development
+	TransportFile   = "file"   // drops each message into a directory, for development and tests
+	TransportMemory = "memory" // keeps messages in memory, for tests
+	TransportSMTP   = "smtp"   // delivers messages through an SMTP server
+)
+
+// Message is an email addressed to a user
+type Message struct {
+	Kind    string
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Transport delivers messages to users
+type Transport interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+// NewTransport returns the transport for a configured name
+func NewTransport(transport, fileDir string, smtp SMTPConfig, log *logger.Logger) (Transport, error) {
+	switch transport {
+	case TransportLog, "":
+		return NewLogTransport(log), nil
+	case TransportFile:
+		return NewFileTransport(fileDir)
+	case TransportMemory:
+		return NewMemoryTransport(), nil
+	case TransportSMTP:
+		return NewSMTPTransport(smtp)
+	default:
+		return nil, fmt.Errorf("unsupported notifier transport %q", transport)
+	}
+}
+
+// Bytes formats the message as a plain text email
+func (m *Message) Bytes() []byte {
+	var content strings.Builder
+	if m.From != "" {
+		fmt.Fprintf(&content, "From: %s\r\n", headerValue(m.From))
+	}
+	fmt.Fprintf(&content, "To: %s\r\n", headerValue(m.To))
+	fmt.Fprintf(&content, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(m.Subject)))
+	fmt.Fprintf(&content, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	content.WriteString("MIME-Version: 1.0\r\n")
+	content.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	content.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	body := quotedprintable.NewWriter(&content)
+	body.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n")))
+	body.Close()
+
+	return []byte(content.String())
+}
+
+// headerValue keeps a value on its header line
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,331 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/efrenfuentes/authy/pkg/logger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// deliveryTimeout bounds one delivery attempt
+	deliveryTimeout = time.Minute
+	// deliveryLease holds a claimed notification back from other workers; longer than deliveryTimeout
+	deliveryLease = 5 * time.Minute
+	// sweepInterval is how often due notifications are looked up, picking up retries and queue overflow
+	sweepInterval = 15 * time.Second
+	// maxRetryDelay caps the backoff between delivery attempts
+	maxRetryDelay = time.Hour
+)
+
+// Options configures the delivery of notifications
+type Options struct {
+	From        string        // sender of every message
+	AppName     string        // name templates use for notifications not tied to an application
+	Workers     int           // concurrent deliveries
+	MaxAttempts int           // delivery attempts before a notification fails for good
+	RetryDelay  time.Duration // wait before the first retry, doubled after every further failure
+}
+
+// Request describes a notification to send to a user
+type Request struct {
+	Kind          string
+	ApplicationID *uuid.UUID // templates of this application take precedence over the built-in ones
+	UserID        *uuid.UUID
+	To            string
+	Locale        string // preferred locale of the recipient, such as from an Accept-Language header
+	Data          map[string]interface{}
+}
+
+// store records notifications and looks up what rendering them needs. Template lookups return
+// gorm.ErrRecordNotFound when the application has no template for the kind and locale.
+type store interface {
+	ApplicationName(ctx context.Context, id uuid.UUID) (string, error)
+	FindTemplate(ctx context.Context, applicationID uuid.UUID, kind, locale string) (*models.NotificationTemplate, error)
+	Create(ctx context.Context, notification *models.Notification) error
+	DueIDs(limit int) ([]uuid.UUID, error)
+	Claim(id uuid.UUID, lease time.Duration) (bool, error)
+	Find(id uuid.UUID) (*models.Notification, error)
+	MarkSent(id uuid.UUID) error
+	MarkFailed(id uuid.UUID, deliveryError string, nextAttempt *time.Time) error
+}
+
+// databaseStore records notifications in the database
+type databaseStore struct {
+	db *gorm.DB
+}
+
+func (d *databaseStore) ApplicationName(ctx context.Context, id uuid.UUID) (string, error) {
+	var app models.Application
+	if err := d.db.WithContext(ctx).Select("name").First(&app, "id = ?", id).Error; err != nil {
+		return "", err
+	}
+	return app.Name, nil
+}
+
+func (d *databaseStore) FindTemplate(ctx context.Context, applicationID uuid.UUID, kind, locale string) (*models.NotificationTemplate, error) {
+	return models.FindNotificationTemplate(d.db.WithContext(ctx), applicationID, kind, locale)
+}
+
+func (d *databaseStore) Create(ctx context.Context, notification *models.Notification) error {
+	return d.db.WithContext(ctx).Create(notification).Error
+}
+
+func (d *databaseStore) DueIDs(limit int) ([]uuid.UUID, error) {
+	return models.GetDueNotificationIDs(d.db, limit)
+}
+
+func (d *databaseStore) Claim(id uuid.UUID, lease time.Duration) (bool, error) {
+	return models.ClaimNotification(d.db, id, lease)
+}
+
+func (d *databaseStore) Find(id uuid.UUID) (*models.Notification, error) {
+	var notification models.Notification
+	if err := d.db.First(&notification, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+func (d *databaseStore) MarkSent(id uuid.UUID) error {
+	return models.MarkNotificationSent(d.db, id)
+}
+
+func (d *databaseStore) MarkFailed(id uuid.UUID, deliveryError string, nextAttempt *time.Time) error {
+	return models.MarkNotificationFailed(d.db, id, deliveryError, nextAttempt)
+}
+
+// Service renders notifications, records them and delivers them in the background, retrying failed
+// deliveries. Recorded notifications survive restarts, and any instance may deliver them.
+type Service struct {
+	store     store
+	transport Transport
+	encryptor *auth.Encryptor
+	logger    *logger.Logger
+	options   Options
+	queue     chan uuid.UUID
+}
+
+// NewService creates a notification service delivering through the given transport
+func NewService(db *gorm.DB, transport Transport, encryptor *auth.Encryptor, logger *logger.Logger, options Options) *Service {
+	return newService(&databaseStore{db: db}, transport, encryptor, logger, options)
+}
+
+func newService(store store, transport Transport, encryptor *auth.Encryptor, logger *logger.Logger, options Options) *Service {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.MaxAttempts < 1 {
+		options.MaxAttempts = 1
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = 30 * time.Second
+	}
+
+	return &Service{
+		store:     store,
+		transport: transport,
+		encryptor: encryptor,
+		logger:    logger,
+		options:   options,
+		queue:     make(chan uuid.UUID, 100*options.Workers),
+	}
+}
+
+// Start launches the delivery workers and the sweep for due notifications
+func (s *Service) Start() {
+	for i := 0; i < s.options.Workers; i++ {
+		go func() {
+			for id := range s.queue {
+				s.deliver(id)
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			s.sweep()
+			<-ticker.C
+		}
+	}()
+}
+
+// Send renders and records a notification and queues it for delivery
+func (s *Service) Send(ctx context.Context, req *Request) (*models.Notification, error) {
+	if !IsKind(req.Kind) {
+		return nil, fmt.Errorf("unknown notification kind %q", req.Kind)
+	}
+
+	tmpl, locale, err := s.template(ctx, req.ApplicationID, req.Kind, req.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"AppName": s.options.AppName,
+		"Name":    "",
+		"Email":   req.To,
+	}
+	if req.ApplicationID != nil {
+		if name, err := s.store.ApplicationName(ctx, *req.ApplicationID); err == nil {
+			data["AppName"] = name
+		}
+	}
+	for key, value := range req.Data {
+		data[key] = value
+	}
+
+	subject, body, err := tmpl.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s notification: %w", req.Kind, err)
+	}
+
+	encryptedBody, err := s.encryptor.Encrypt([]byte(body))
+	if err != nil {
+		return nil, err
+	}
+
+	notification := &models.Notification{
+		ApplicationID: req.ApplicationID,
+		UserID:        req.UserID,
+		Kind:          req.Kind,
+		Locale:        locale,
+		Recipient:     req.To,
+		Subject:       subject,
+		EncryptedBody: encryptedBody,
+		Status:        models.NotificationPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := s.store.Create(ctx, notification); err != nil {
+		return nil, err
+	}
+
+	s.enqueue(notification.ID)
+	return notification, nil
+}
+
+// template returns the template for a kind closest to the locale, preferring those of the application
+func (s *Service) template(ctx context.Context, applicationID *uuid.UUID, kind, locale string) (Template, string, error) {
+	for _, candidate := range LocaleCandidates(locale) {
+		if applicationID != nil {
+			stored, err := s.store.FindTemplate(ctx, *applicationID, kind, candidate)
+			if err == nil {
+				return Template{Subject: stored.Subject, Body: stored.Body}, candidate, nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return Template{}, "", err
+			}
+		}
+
+		if tmpl, ok := BuiltinTemplate(kind, candidate); ok {
+			return tmpl, candidate, nil
+		}
+	}
+	return Template{}, "", fmt.Errorf("no template for %s notifications", kind)
+}
+
+// enqueue hands a notification to the workers; when the queue is full, the next sweep picks it up
+func (s *Service) enqueue(id uuid.UUID) {
+	select {
+	case s.queue <- id:
+	default:
+	}
+}
+
+// sweep queues the notifications whose next delivery attempt is due
+func (s *Service) sweep() {
+	ids, err := s.store.DueIDs(cap(s.queue))
+	if err != nil {
+		s.logger.Error("Failed to load due notifications", "error", err)
+		return
+	}
+	for _, id := range ids {
+		s.enqueue(id)
+	}
+}
+
+// deliver makes one delivery attempt of a notification and records its outcome
+func (s *Service) deliver(id uuid.UUID) {
+	claimed, err := s.store.Claim(id, deliveryLease)
+	if err != nil {
+		s.logger.Error("Failed to claim notification", "error", err, "notification_id", id)
+		return
+	}
+	if !claimed {
+		return // delivered by another worker, or not due yet
+	}
+
+	notification, err := s.store.Find(id)
+	if err != nil {
+		s.logger.Error("Failed to load notification", "error", err, "notification_id", id)
+		return
+	}
+
+	body, err := s.encryptor.Decrypt(notification.EncryptedBody)
+	if err != nil {
+		// Retrying cannot help a body encrypted with another key
+		s.recordFailure(notification, err, true)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	err = s.transport.Send(ctx, &Message{
+		Kind:    notification.Kind,
+		From:    s.options.From,
+		To:      notification.Recipient,
+		Subject: notification.Subject,
+		Body:    string(body),
+	})
+	if err != nil {
+		s.recordFailure(notification, err, false)
+		return
+	}
+
+	if err := s.store.MarkSent(id); err != nil {
+		s.logger.Error("Failed to record notification delivery", "error", err, "notification_id", id)
+	}
+}
+
+// recordFailure schedules the next attempt of a notification, or fails it when no attempts are left
+func (s *Service) recordFailure(notification *models.Notification, deliveryErr error, permanent bool) {
+	var nextAttempt *time.Time
+	if !permanent && notification.Attempts < s.options.MaxAttempts {
+		next := time.Now().Add(s.retryDelay(notification.Attempts))
+		nextAttempt = &next
+	}
+
+	if nextAttempt != nil {
+		s.logger.Warn("Notification delivery failed, retrying", "error", deliveryErr, "notification_id", notification.ID,
+			"kind", notification.Kind, "attempts", notification.Attempts, "next_attempt_at", *nextAttempt)
+	} else {
+		s.logger.Error("Notification delivery failed", "error", deliveryErr, "notification_id", notification.ID,
+			"kind", notification.Kind, "attempts", notification.Attempts)
+	}
+
+	if err := s.store.MarkFailed(notification.ID, deliveryErr.Error(), nextAttempt); err != nil {
+		s.logger.Error("Failed to record notification failure", "error", err, "notification_id", notification.ID)
+	}
+}
+
+// retryDelay returns the wait after the given number of failed attempts
+func (s *Service) retryDelay(attempts int) time.Duration {
+	delay := s.options.RetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,472 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/efrenfuentes/authy/pkg/logger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// memoryStore records notifications in memory with the semantics of the database store
+type memoryStore struct {
+	mu            sync.Mutex
+	applications  map[uuid.UUID]string
+	templates     map[string]models.NotificationTemplate // by application, kind and locale
+	notifications map[uuid.UUID]*models.Notification
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		applications:  make(map[uuid.UUID]string),
+		templates:     make(map[string]models.NotificationTemplate),
+		notifications: make(map[uuid.UUID]*models.Notification),
+	}
+}
+
+func templateKey(applicationID uuid.UUID, kind, locale string) string {
+	return applicationID.String() + "/" + kind + "/" + locale
+}
+
+func (m *memoryStore) addTemplate(applicationID uuid.UUID, kind, locale string, tmpl Template) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.templates[templateKey(applicationID, kind, locale)] = models.NotificationTemplate{
+		ApplicationID: applicationID, Kind: kind, Locale: locale, Subject: tmpl.Subject, Body: tmpl.Body,
+	}
+}
+
+func (m *memoryStore) ApplicationName(ctx context.Context, id uuid.UUID) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, ok := m.applications[id]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return name, nil
+}
+
+func (m *memoryStore) FindTemplate(ctx context.Context, applicationID uuid.UUID, kind, locale string) (*models.NotificationTemplate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tmpl, ok := m.templates[templateKey(applicationID, kind, locale)]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &tmpl, nil
+}
+
+func (m *memoryStore) Create(ctx context.Context, notification *models.Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notification.ID = uuid.New()
+	stored := *notification
+	m.notifications[notification.ID] = &stored
+	return nil
+}
+
+func (m *memoryStore) DueIDs(limit int) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []uuid.UUID
+	for id, notification := range m.notifications {
+		if len(ids) < limit && notification.Status == models.NotificationPending && !notification.NextAttemptAt.After(time.Now()) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memoryStore) Claim(id uuid.UUID, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notification, ok := m.notifications[id]
+	now := time.Now()
+	if !ok || notification.Status != models.NotificationPending || notification.NextAttemptAt.After(now) {
+		return false, nil
+	}
+	notification.Attempts++
+	notification.NextAttemptAt = now.Add(lease)
+	return true, nil
+}
+
+func (m *memoryStore) Find(id uuid.UUID) (*models.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notification, ok := m.notifications[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *notification
+	return &found, nil
+}
+
+func (m *memoryStore) MarkSent(id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	notification := m.notifications[id]
+	notification.Status = models.NotificationSent
+	notification.SentAt = &now
+	notification.LastError = ""
+	notification.EncryptedBody = ""
+	return nil
+}
+
+func (m *memoryStore) MarkFailed(id uuid.UUID, deliveryError string, nextAttempt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notification := m.notifications[id]
+	notification.LastError = deliveryError
+	if nextAttempt != nil {
+		notification.NextAttemptAt = *nextAttempt
+	} else {
+		notification.Status = models.NotificationFailed
+		notification.EncryptedBody = ""
+	}
+	return nil
+}
+
+// makeDue moves the next delivery attempt of a notification to now, as if its retry delay had passed
+func (m *memoryStore) makeDue(id uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.notifications[id].NextAttemptAt = time.Now()
+}
+
+// failingTransport fails the first deliveries, then hands messages to a MemoryTransport
+type failingTransport struct {
+	*MemoryTransport
+	mu       sync.Mutex
+	failures int
+}
+
+func (t *failingTransport) Send(ctx context.Context, message *Message) error {
+	t.mu.Lock()
+	if t.failures > 0 {
+		t.failures--
+		t.mu.Unlock()
+		return errors.New("connection refused")
+	}
+	t.mu.Unlock()
+	return t.MemoryTransport.Send(ctx, message)
+}
+
+func newTestService(t *testing.T, transport Transport, options Options) (*Service, *memoryStore) {
+	t.Helper()
+
+	encryptor, err := auth.NewEncryptor("test-key")
+	if err != nil {
+		t.Fatalf("NewEncryptor: %v", err)
+	}
+	store := newMemoryStore()
+	return newService(store, transport, encryptor, logger.New("error"), options), store
+}
+
+func passwordResetRequest(locale string) *Request {
+	return &Request{
+		Kind:   KindPasswordReset,
+		To:     "ada@example.com",
+		Locale: locale,
+		Data:   map[string]interface{}{"Name": "Ada", "Link": "https://example.com/reset?token=secret", "ExpiresInMinutes": 30},
+	}
+}
+
+func TestSendQueuesNotification(t *testing.T) {
+	transport := NewMemoryTransport()
+	s, store := newTestService(t, transport, Options{From: "authy@example.com", AppName: "Authy"})
+
+	notification, err := s.Send(context.Background(), passwordResetRequest("en"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if notification.Status != models.NotificationPending || notification.Attempts != 0 {
+		t.Fatalf("got status %s after %d attempts, want pending", notification.Status, notification.Attempts)
+	}
+	if notification.Subject != "Reset your Authy password" {
+		t.Fatalf("subject %q", notification.Subject)
+	}
+
+	// The rendered body holds the reset link, so only its encryption is stored
+	stored, _ := store.Find(notification.ID)
+	if stored.EncryptedBody == "" || strings.Contains(stored.EncryptedBody, "secret") {
+		t.Fatal("body stored in the clear")
+	}
+
+	select {
+	case id := <-s.queue:
+		if id != notification.ID {
+			t.Fatalf("queued %s, want %s", id, notification.ID)
+		}
+	default:
+		t.Fatal("notification not queued")
+	}
+	if len(transport.Messages()) != 0 {
+		t.Fatal("delivered before a worker picked the notification up")
+	}
+}
+
+func TestSendRejectsUnknownKind(t *testing.T) {
+	s, _ := newTestService(t, NewMemoryTransport(), Options{})
+
+	if _, err := s.Send(context.Background(), &Request{Kind: "newsletter", To: "ada@example.com"}); err == nil {
+		t.Fatal("sent a notification of an unknown kind")
+	}
+	if _, err := s.Send(context.Background(), &Request{Kind: KindPasswordReset, To: "ada@example.com"}); err == nil {
+		t.Fatal("sent a notification missing the data of its template")
+	}
+}
+
+func TestDeliverRecordsStatus(t *testing.T) {
+	transport := NewMemoryTransport()
+	s, store := newTestService(t, transport, Options{From: "authy@example.com", AppName: "Authy"})
+
+	notification, err := s.Send(context.Background(), passwordResetRequest("en"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	s.deliver(<-s.queue)
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(messages))
+	}
+	message := messages[0]
+	if message.Kind != KindPasswordReset || message.From != "authy@example.com" || message.To != "ada@example.com" ||
+		!strings.Contains(message.Body, "https://example.com/reset?token=secret") || !strings.HasPrefix(message.Body, "Hi Ada,") {
+		t.Fatalf("unexpected message %+v", message)
+	}
+
+	stored, _ := store.Find(notification.ID)
+	if stored.Status != models.NotificationSent || stored.SentAt == nil || stored.Attempts != 1 {
+		t.Fatalf("got status %s after %d attempts, want sent after 1", stored.Status, stored.Attempts)
+	}
+	if stored.EncryptedBody != "" {
+		t.Fatal("body kept after delivery")
+	}
+
+	// A delivered notification is not delivered again
+	s.deliver(notification.ID)
+	if len(transport.Messages()) != 1 {
+		t.Fatal("delivered twice")
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	transport := &failingTransport{MemoryTransport: NewMemoryTransport(), failures: 2}
+	retryDelay := time.Minute
+	s, store := newTestService(t, transport, Options{MaxAttempts: 3, RetryDelay: retryDelay})
+
+	notification, err := s.Send(context.Background(), passwordResetRequest("en"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		s.deliver(notification.ID)
+
+		stored, _ := store.Find(notification.ID)
+		if stored.Status != models.NotificationPending || stored.Attempts != attempt || stored.LastError != "connection refused" {
+			t.Fatalf("attempt %d: got status %s after %d attempts with error %q", attempt, stored.Status, stored.Attempts, stored.LastError)
+		}
+
+		// The wait doubles after every failure
+		wantDelay := retryDelay << (attempt - 1)
+		if stored.NextAttemptAt.Before(before.Add(wantDelay)) || stored.NextAttemptAt.After(time.Now().Add(wantDelay)) {
+			t.Fatalf("attempt %d: next attempt in %s, want %s", attempt, time.Until(stored.NextAttemptAt).Round(time.Second), wantDelay)
+		}
+
+		// Not due yet: neither delivered nor counted as an attempt
+		s.deliver(notification.ID)
+		if stored, _ := store.Find(notification.ID); stored.Attempts != attempt {
+			t.Fatalf("attempt %d: delivered before the retry was due", attempt)
+		}
+		store.makeDue(notification.ID)
+	}
+
+	s.deliver(notification.ID)
+	stored, _ := store.Find(notification.ID)
+	if stored.Status != models.NotificationSent || stored.Attempts != 3 || stored.LastError != "" {
+		t.Fatalf("got status %s after %d attempts with error %q, want sent after 3", stored.Status, stored.Attempts, stored.LastError)
+	}
+	if len(transport.Messages()) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(transport.Messages()))
+	}
+}
+
+func TestDeliverFailsAfterMaxAttempts(t *testing.T) {
+	transport := &failingTransport{MemoryTransport: NewMemoryTransport(), failures: 10}
+	s, store := newTestService(t, transport, Options{MaxAttempts: 2, RetryDelay: time.Second})
+
+	notification, err := s.Send(context.Background(), passwordResetRequest("en"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-s.queue
+
+	s.deliver(notification.ID)
+	store.makeDue(notification.ID)
+	s.deliver(notification.ID)
+
+	stored, _ := store.Find(notification.ID)
+	if stored.Status != models.NotificationFailed || stored.Attempts != 2 || stored.LastError != "connection refused" {
+		t.Fatalf("got status %s after %d attempts with error %q, want failed after 2", stored.Status, stored.Attempts, stored.LastError)
+	}
+	if stored.EncryptedBody != "" {
+		t.Fatal("body kept after delivery failed for good")
+	}
+
+	// Failed notifications are no longer swept up for delivery
+	store.makeDue(notification.ID)
+	s.sweep()
+	select {
+	case id := <-s.queue:
+		if id == notification.ID {
+			t.Fatal("failed notification queued again")
+		}
+	default:
+	}
+}
+
+func TestDeliverUndecryptableBodyFailsForGood(t *testing.T) {
+	s, store := newTestService(t, NewMemoryTransport(), Options{MaxAttempts: 5})
+
+	notification, err := s.Send(context.Background(), passwordResetRequest("en"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// Encrypted with another key, such as after the key encryption key was replaced
+	other, _ := auth.NewEncryptor("other-key")
+	s.encryptor = other
+	s.deliver(notification.ID)
+
+	if stored, _ := store.Find(notification.ID); stored.Status != models.NotificationFailed || stored.Attempts != 1 {
+		t.Fatalf("got status %s after %d attempts, want failed after 1", stored.Status, stored.Attempts)
+	}
+}
+
+func TestSweepQueuesDueNotifications(t *testing.T) {
+	s, store := newTestService(t, &failingTransport{MemoryTransport: NewMemoryTransport(), failures: 1}, Options{MaxAttempts: 3})
+	ctx := context.Background()
+
+	due, err := s.Send(ctx, passwordResetRequest("en"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	retrying, err := s.Send(ctx, passwordResetRequest("en"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-s.queue
+	<-s.queue
+
+	// The first delivery of one fails, so it waits for its retry; the other is still due
+	s.deliver(retrying.ID)
+	s.sweep()
+
+	select {
+	case id := <-s.queue:
+		if id != due.ID {
+			t.Fatalf("queued %s, want the due notification %s", id, due.ID)
+		}
+	default:
+		t.Fatal("due notification not queued")
+	}
+	select {
+	case id := <-s.queue:
+		t.Fatalf("queued %s, which is not due", id)
+	default:
+	}
+
+	if stored, _ := store.Find(retrying.ID); stored.Attempts != 1 {
+		t.Fatalf("retrying notification has %d attempts, want 1", stored.Attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	s, _ := newTestService(t, NewMemoryTransport(), Options{RetryDelay: 30 * time.Second})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{8, maxRetryDelay},
+		{50, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := s.retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("after %d attempts: got %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSendTemplateLocaleFallback(t *testing.T) {
+	applicationID := uuid.New()
+	otherApplicationID := uuid.New()
+
+	tests := []struct {
+		name          string
+		applicationID *uuid.UUID
+		locale        string
+		wantLocale    string
+		wantSubject   string
+	}{
+		{"built-in of the locale", nil, "es", "es", "Restablece tu contraseña de Authy"},
+		{"built-in of the language of a regional locale", nil, "es-MX", "es", "Restablece tu contraseña de Authy"},
+		{"default locale for an unknown locale", nil, "fr-CH", "en", "Reset your Authy password"},
+		{"default locale for no locale", nil, "", "en", "Reset your Authy password"},
+		{"default locale for an invalid locale", nil, "../es", "en", "Reset your Authy password"},
+		{"application template of the regional locale", &applicationID, "pt-BR", "pt-br", "Redefina sua senha do Example"},
+		{"application template before the built-in of a less specific locale", &applicationID, "es-MX", "es-mx", "Cambia tu contraseña de Example"},
+		{"built-in before the application template of the default locale", &applicationID, "es", "es", "Restablece tu contraseña de Example"},
+		{"application template of the default locale", &applicationID, "de", "en", "Example password reset"},
+		{"templates of other applications ignored", &otherApplicationID, "pt-BR", "en", "Reset your Other password"},
+	}
+
+	s, store := newTestService(t, NewMemoryTransport(), Options{AppName: "Authy"})
+	store.applications[applicationID] = "Example"
+	store.applications[otherApplicationID] = "Other"
+	store.addTemplate(applicationID, KindPasswordReset, "pt-br", Template{Subject: "Redefina sua senha do {{.AppName}}", Body: "{{.Link}}"})
+	store.addTemplate(applicationID, KindPasswordReset, "es-mx", Template{Subject: "Cambia tu contraseña de {{.AppName}}", Body: "{{.Link}}"})
+	store.addTemplate(applicationID, KindPasswordReset, "en", Template{Subject: "{{.AppName}} password reset", Body: "{{.Link}}"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := passwordResetRequest(tt.locale)
+			req.ApplicationID = tt.applicationID
+
+			notification, err := s.Send(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			<-s.queue
+
+			if notification.Locale != tt.wantLocale || notification.Subject != tt.wantSubject {
+				t.Fatalf("got %q in %s, want %q in %s", notification.Subject, notification.Locale, tt.wantSubject, tt.wantLocale)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package notifications
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP connection security modes
+const (
+	SMTPStartTLS = "starttls" // upgrade the connection with STARTTLS, which the server must offer
+	SMTPTLS      = "tls"      // connect over TLS, usually on port 465
+	SMTPNone     = "none"     // send in the clear, only for local relays
+)
+
+// SMTPConfig describes the SMTP server messages are delivered through
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Security string // starttls, tls or none
+}
+
+// SMTPTransport delivers messages through an SMTP server
+type SMTPTransport struct {
+	config SMTPConfig
+}
+
+// NewSMTPTransport creates a transport for the configured server
+func NewSMTPTransport(config SMTPConfig) (*SMTPTransport, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("smtp transport requires a host")
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid smtp port %d", config.Port)
+	}
+
+	switch config.Security {
+	case "":
+		config.Security = SMTPStartTLS
+	case SMTPStartTLS, SMTPTLS, SMTPNone:
+	default:
+		return nil, fmt.Errorf("unsupported smtp security %q", config.Security)
+	}
+
+	return &SMTPTransport{config: config}, nil
+}
+
+// Send delivers the message in one SMTP session, giving up when the context ends
+func (t *SMTPTransport) Send(ctx context.Context, message *Message) error {
+	from, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	address := net.JoinHostPort(t.config.Host, strconv.Itoa(t.config.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	tlsConfig := &tls.Config{ServerName: t.config.Host, MinVersion: tls.VersionTLS12}
+	if t.config.Security == SMTPTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, t.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if t.config.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not offer STARTTLS", t.config.Host)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if t.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", t.config.Username, t.config.Password, t.config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message.Bytes()); err != nil {
+		data.Close()
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,220 @@
+package notifications
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultLocale is used when no template matches the locale of the recipient
+const DefaultLocale = "en"
+
+// maxTemplateSize bounds the subject and body of a template
+const maxTemplateSize = 16 * 1024
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// Template is the subject and body of a notification as text/template sources
+type Template struct {
+	Subject string
+	Body    string
+}
+
+// templateFields lists the data each kind of notification is rendered with, besides AppName, Name and Email
+var templateFields = map[string][]string{
+	KindPasswordReset:     {"Link", "ExpiresInMinutes"},
+	KindEmailVerification: {"Link", "ExpiresInHours"},
+	KindInvitation:        {"Link", "ExpiresInHours", "InvitedBy"},
+	KindNewDevice:         {"Device", "IPAddress", "Time"},
+}
+
+// builtinTemplates are used for every application without templates of its own
+var builtinTemplates = map[string]map[string]Template{
+	KindPasswordReset: {
+		"en": {
+			Subject: "Reset your {{.AppName}} password",
+			Body: "Hi{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"Someone asked to reset the password of your {{.AppName}} account. To choose a new password, open this link:\n\n" +
+				"{{.Link}}\n\n" +
+				"The link works once and expires in {{.ExpiresInMinutes}} minutes. If you did not ask for it, you can ignore this message.\n",
+		},
+		"es": {
+			Subject: "Restablece tu contraseña de {{.AppName}}",
+			Body: "Hola{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"Alguien pidió restablecer la contraseña de tu cuenta de {{.AppName}}. Para elegir una nueva contraseña, abre este enlace:\n\n" +
+				"{{.Link}}\n\n" +
+				"El enlace funciona una vez y caduca en {{.ExpiresInMinutes}} minutos. Si no lo pediste, puedes ignorar este mensaje.\n",
+		},
+	},
+	KindEmailVerification: {
+		"en": {
+			Subject: "Verify your email for {{.AppName}}",
+			Body: "Hi{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"To confirm that {{.Email}} is your email address, open this link:\n\n" +
+				"{{.Link}}\n\n" +
+				"The link expires in {{.ExpiresInHours}} hours. If you did not ask for it, you can ignore this message.\n",
+		},
+		"es": {
+			Subject: "Verifica tu correo para {{.AppName}}",
+			Body: "Hola{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"Para confirmar que {{.Email}} es tu dirección de correo, abre este enlace:\n\n" +
+				"{{.Link}}\n\n" +
+				"El enlace caduca en {{.ExpiresInHours}} horas. Si no lo pediste, puedes ignorar este mensaje.\n",
+		},
+	},
+	KindInvitation: {
+		"en": {
+			Subject: "You are invited to {{.AppName}}",
+			Body: "Hi{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"{{if .InvitedBy}}{{.InvitedBy}} invited you{{else}}You are invited{{end}} to join {{.AppName}}. To accept, open this link:\n\n" +
+				"{{.Link}}\n\n" +
+				"The link expires in {{.ExpiresInHours}} hours.\n",
+		},
+		"es": {
+			Subject: "Te invitaron a {{.AppName}}",
+			Body: "Hola{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"{{if .InvitedBy}}{{.InvitedBy}} te invitó{{else}}Te invitaron{{end}} a unirte a {{.AppName}}. Para aceptar, abre este enlace:\n\n" +
+				"{{.Link}}\n\n" +
+				"El enlace caduca en {{.ExpiresInHours}} horas.\n",
+		},
+	},
+	KindNewDevice: {
+		"en": {
+			Subject: "New sign-in to your {{.AppName}} account",
+			Body: "Hi{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"Your {{.AppName}} account was signed in to from a new device:\n\n" +
+				"Device: {{.Device}}\nIP address: {{.IPAddress}}\nTime: {{.Time}}\n\n" +
+				"If this was not you, change your password and sign out your other sessions.\n",
+		},
+		"es": {
+			Subject: "Nuevo inicio de sesión en tu cuenta de {{.AppName}}",
+			Body: "Hola{{if .Name}} {{.Name}}{{end}},\n\n" +
+				"Se inició sesión en tu cuenta de {{.AppName}} desde un dispositivo nuevo:\n\n" +
+				"Dispositivo: {{.Device}}\nDirección IP: {{.IPAddress}}\nHora: {{.Time}}\n\n" +
+				"Si no fuiste tú, cambia tu contraseña y cierra tus otras sesiones.\n",
+		},
+	},
+}
+
+// Kinds returns every notification kind, sorted
+func Kinds() []string {
+	kinds := make([]string, 0, len(templateFields))
+	for kind := range templateFields {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// IsKind reports whether kind is a known notification kind
+func IsKind(kind string) bool {
+	_, ok := templateFields[kind]
+	return ok
+}
+
+// BuiltinTemplate returns the built-in template of a kind for exactly the given locale
+func BuiltinTemplate(kind, locale string) (Template, bool) {
+	tmpl, ok := builtinTemplates[kind][locale]
+	return tmpl, ok
+}
+
+// NormalizeLocale lowercases a language tag such as pt_BR into pt-br, returning "" when it is not one
+func NormalizeLocale(locale string) string {
+	locale = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+	if len(locale) > 20 || !localePattern.MatchString(locale) {
+		return ""
+	}
+	return locale
+}
+
+// LocaleCandidates returns the locales to look for a template in, from the most specific to DefaultLocale
+func LocaleCandidates(locale string) []string {
+	var candidates []string
+	for locale = NormalizeLocale(locale); locale != ""; {
+		candidates = append(candidates, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	if len(candidates) == 0 || candidates[len(candidates)-1] != DefaultLocale {
+		candidates = append(candidates, DefaultLocale)
+	}
+	return candidates
+}
+
+// PreferredLocale returns the locale a recipient prefers most in an Accept-Language header, or "" for none
+func PreferredLocale(acceptLanguage string) string {
+	preferred, preferredWeight := "", 0.0
+	for _, entry := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		locale := NormalizeLocale(tag)
+		if locale == "" {
+			continue
+		}
+
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if weight > preferredWeight {
+			preferred, preferredWeight = locale, weight
+		}
+	}
+	return preferred
+}
+
+// Render executes the template with the given data, failing on fields the data lacks
+func (t Template) Render(data map[string]interface{}) (string, string, error) {
+	subject, err := execute("subject", t.Subject, data)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := execute("body", t.Body, data)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(headerValue(subject)), body, nil
+}
+
+// Validate checks that the template parses and only uses the data a kind of notification is rendered with
+func (t Template) Validate(kind string) error {
+	fields, ok := templateFields[kind]
+	if !ok {
+		return fmt.Errorf("unknown notification kind %q", kind)
+	}
+	if strings.TrimSpace(t.Subject) == "" || strings.TrimSpace(t.Body) == "" {
+		return fmt.Errorf("subject and body are required")
+	}
+	if len(t.Subject) > maxTemplateSize || len(t.Body) > maxTemplateSize {
+		return fmt.Errorf("subject and body must be at most %d bytes", maxTemplateSize)
+	}
+
+	sample := map[string]interface{}{"AppName": "Example", "Name": "Ada", "Email": "ada@example.com"}
+	for _, field := range fields {
+		sample[field] = "example"
+	}
+	_, _, err := t.Render(sample)
+	return err
+}
+
+func execute(name, source string, data map[string]interface{}) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	if err := tmpl.Execute(&output, data); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
@@ -0,0 +1,82 @@
+package notifications
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuiltinTemplatesValidate(t *testing.T) {
+	for _, kind := range Kinds() {
+		if _, ok := BuiltinTemplate(kind, DefaultLocale); !ok {
+			t.Errorf("%s: no template in the default locale", kind)
+		}
+	}
+
+	for kind, locales := range builtinTemplates {
+		for locale, tmpl := range locales {
+			if err := tmpl.Validate(kind); err != nil {
+				t.Errorf("%s/%s: %v", kind, locale, err)
+			}
+		}
+	}
+}
+
+func TestTemplateValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    Template
+		wantErr bool
+	}{
+		{"fields of the kind", Template{Subject: "Reset {{.AppName}}", Body: "{{.Link}} {{.ExpiresInMinutes}}"}, false},
+		{"field of another kind", Template{Subject: "Reset", Body: "{{.Device}}"}, true},
+		{"empty body", Template{Subject: "Reset", Body: " "}, true},
+		{"unparseable", Template{Subject: "Reset {{.AppName", Body: "{{.Link}}"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tmpl.Validate(KindPasswordReset); (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocaleCandidates(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   []string
+	}{
+		{"pt-BR", []string{"pt-br", "pt", "en"}},
+		{"zh_Hant_TW", []string{"zh-hant-tw", "zh-hant", "zh", "en"}},
+		{"en-US", []string{"en-us", "en"}},
+		{"en", []string{"en"}},
+		{"", []string{"en"}},
+		{"../es", []string{"en"}},
+	}
+
+	for _, tt := range tests {
+		if got := LocaleCandidates(tt.locale); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LocaleCandidates(%q) = %v, want %v", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestPreferredLocale(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", "fr-ch"},
+		{"en;q=0.2, es_MX;q=0.9", "es-mx"},
+		{"de;q=bad, it;q=0.1", "it"},
+		{"*", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := PreferredLocale(tt.acceptLanguage); got != tt.want {
+			t.Errorf("PreferredLocale(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
@@ -484,11 +484,15 @@ func (s *AuditService) GetActionsList() []string {
 		string(models.ActionLogout),
 		string(models.ActionTokenRefresh),
 		string(models.ActionTokenValidate),
+		string(models.ActionTokenRevoke),
+		string(models.ActionTokenReuse),
+		string(models.ActionAudienceReject),
 		string(models.ActionUserCreate),
 		string(models.ActionUserUpdate),
 		string(models.ActionUserDelete),
 		string(models.ActionUserActivate),
 		string(models.ActionUserDeactivate),
+		string(models.ActionUserRegister),
 		string(models.ActionRoleAssign),
 		string(models.ActionRoleRemove),
 		string(models.ActionApplicationCreate),
@@ -498,7 +502,31 @@ func (s *AuditService) GetActionsList() []string {
 		string(models.ActionRoleUpdate),
 		string(models.ActionRoleDelete),
 		string(models.ActionPasswordChange),
+		string(models.ActionPasswordReset),
+		string(models.ActionEmailVerifySend),
+		string(models.ActionEmailVerify),
+		string(models.ActionEmailChange),
 		string(models.ActionAPIKeyRegenerate),
+		string(models.ActionSigningKeyCreate),
+		string(models.ActionSigningKeyPromote),
+		string(models.ActionSigningKeyRetire),
+		string(models.ActionServiceTokenIssue),
+		string(models.ActionTokenExchange),
+		string(models.ActionDelegatedAccess),
+		string(models.ActionDeviceDeny),
+		string(models.ActionMFAEnroll),
+		string(models.ActionMFAEnable),
+		string(models.ActionMFADisable),
+		string(models.ActionMFAChallenge),
+		string(models.ActionMFAFailed),
+		string(models.ActionWebAuthnRegister),
+		string(models.ActionWebAuthnRemove),
+		string(models.ActionMFARecoveryCodes),
+		string(models.ActionMFARecoveryUse),
+		string(models.ActionMFAReset),
+		string(models.ActionStepUpFailed),
+		string(models.ActionTemplateUpdate),
+		string(models.ActionTemplateDelete),
 	}
 }
 
@@ -512,5 +540,8 @@ func (s *AuditService) GetResourcesList() []string {
 		"token",
 		"permission",
 		"session",
+		"signing_key",
+		"service_principal_role",
+		"notification_template",
 	}
 }
\ No newline at end of file
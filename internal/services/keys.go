@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"github.com/efrenfuentes/authy/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// KeyService persists signing keys in the database and keeps the in-memory keyring in sync
+type KeyService struct {
+	db        *gorm.DB
+	logger    *logger.Logger
+	keyring   *auth.Keyring
+	encryptor *auth.Encryptor
+}
+
+// NewKeyService creates a new key service instance
+func NewKeyService(db *gorm.DB, logger *logger.Logger, keyring *auth.Keyring, encryptor *auth.Encryptor) *KeyService {
+	return &KeyService{
+		db:        db,
+		logger:    logger,
+		keyring:   keyring,
+		encryptor: encryptor,
+	}
+}
+
+// Bootstrap stores the configured key as the active key when the database has none, then loads the keyring
+func (s *KeyService) Bootstrap(configured *auth.SigningKey) error {
+	var activeCount int64
+	if err := s.db.Model(&models.SigningKey{}).Where("status = ?", models.SigningKeyActive).Count(&activeCount).Error; err != nil {
+		return err
+	}
+
+	if activeCount == 0 {
+		if _, err := s.storeKey(configured, models.SigningKeyActive); err != nil {
+			// Another instance may have seeded the key concurrently; Reload decides
+			s.logger.Warn("Failed to seed configured signing key", "error", err)
+		}
+	}
+
+	return s.Reload()
+}
+
+// Reload refreshes the keyring from the database
+func (s *KeyService) Reload() error {
+	storedKeys, err := models.GetUsableSigningKeys(s.db)
+	if err != nil {
+		return err
+	}
+
+	var active *auth.SigningKey
+	var verification []*auth.SigningKey
+	for _, storedKey := range storedKeys {
+		key, err := s.decryptKey(&storedKey)
+		if err != nil {
+			s.logger.Error("Failed to load signing key", "kid", storedKey.KID, "error", err)
+			continue
+		}
+
+		if storedKey.Status == models.SigningKeyActive {
+			active = key
+		} else {
+			verification = append(verification, key)
+		}
+	}
+
+	if active == nil {
+		return fmt.Errorf("no active signing key available")
+	}
+
+	s.keyring.Replace(active, verification)
+	return nil
+}
+
+// StartAutoReload periodically reloads the keyring so rotations made by other instances are picked up
+func (s *KeyService) StartAutoReload(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.Reload(); err != nil {
+				s.logger.Error("Failed to reload signing keys", "error", err)
+			}
+		}
+	}()
+}
+
+// ListKeys returns all stored signing keys
+func (s *KeyService) ListKeys() ([]models.SigningKey, error) {
+	return models.GetSigningKeys(s.db)
+}
+
+// CreateKey generates a new verification-only key so it is published before it starts signing
+func (s *KeyService) CreateKey(algorithm string) (*models.SigningKey, error) {
+	key, err := auth.GenerateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	storedKey, err := s.storeKey(key, models.SigningKeyVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedKey, s.Reload()
+}
+
+// PromoteKey makes the given key the active signing key
+func (s *KeyService) PromoteKey(kid string) error {
+	if err := models.PromoteSigningKey(s.db, kid); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// RetireKey removes a key from verification
+func (s *KeyService) RetireKey(kid string) error {
+	if err := models.RetireSigningKey(s.db, kid); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// storeKey encrypts and persists a signing key with the given status
+func (s *KeyService) storeKey(key *auth.SigningKey, status models.SigningKeyStatus) (*models.SigningKey, error) {
+	material, err := key.MarshalKeyMaterial()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := s.encryptor.Encrypt(material)
+	if err != nil {
+		return nil, err
+	}
+
+	storedKey := &models.SigningKey{
+		KID:          key.ID,
+		Algorithm:    key.Algorithm,
+		EncryptedKey: encryptedKey,
+		Status:       status,
+	}
+
+	if status == models.SigningKeyActive {
+		now := time.Now()
+		storedKey.ActivatedAt = &now
+	}
+
+	if err := s.db.Create(storedKey).Error; err != nil {
+		return nil, err
+	}
+
+	return storedKey, nil
+}
+
+// decryptKey restores the signing key held by a stored record
+func (s *KeyService) decryptKey(storedKey *models.SigningKey) (*auth.SigningKey, error) {
+	material, err := s.encryptor.Decrypt(storedKey.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := auth.ParseKeyMaterial(storedKey.Algorithm, material)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.ID != storedKey.KID {
+		return nil, fmt.Errorf("stored kid %s does not match key material", storedKey.KID)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/google/uuid"
+)
+
+// NotificationQuery represents parameters for querying notification deliveries
+type NotificationQuery struct {
+	UserID        *uuid.UUID `json:"user_id,omitempty"`
+	ApplicationID *uuid.UUID `json:"application_id,omitempty"`
+	Kinds         []string   `json:"kinds,omitempty"`
+	Statuses      []string   `json:"statuses,omitempty"`
+	Recipient     *string    `json:"recipient,omitempty"`
+	StartDate     *time.Time `json:"start_date,omitempty"`
+	EndDate       *time.Time `json:"end_date,omitempty"`
+	Page          int        `json:"page"`
+	PerPage       int        `json:"per_page"`
+}
+
+// NotificationResponse represents the delivery of a notification in API responses; the body is never exposed
+type NotificationResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        *uuid.UUID `json:"user_id"`
+	ApplicationID *uuid.UUID `json:"application_id"`
+	Kind          string     `json:"kind"`
+	Locale        string     `json:"locale"`
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	SentAt        *time.Time `json:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	// Related data
+	User        *UserInfo        `json:"user,omitempty"`
+	Application *ApplicationInfo `json:"application,omitempty"`
+}
+
+// QueryNotifications retrieves notification deliveries, newest first
+func (s *AuditService) QueryNotifications(query NotificationQuery) ([]NotificationResponse, int64, error) {
+	dbQuery := s.db.Model(&models.Notification{}).
+		Preload("User").
+		Preload("Application")
+
+	// Apply filters
+	if query.UserID != nil {
+		dbQuery = dbQuery.Where("user_id = ?", *query.UserID)
+	}
+
+	if query.ApplicationID != nil {
+		dbQuery = dbQuery.Where("application_id = ?", *query.ApplicationID)
+	}
+
+	if len(query.Kinds) > 0 {
+		dbQuery = dbQuery.Where("kind IN ?", query.Kinds)
+	}
+
+	if len(query.Statuses) > 0 {
+		dbQuery = dbQuery.Where("status IN ?", query.Statuses)
+	}
+
+	if query.Recipient != nil {
+		dbQuery = dbQuery.Where("recipient = ?", *query.Recipient)
+	}
+
+	if query.StartDate != nil {
+		dbQuery = dbQuery.Where("created_at >= ?", *query.StartDate)
+	}
+
+	if query.EndDate != nil {
+		dbQuery = dbQuery.Where("created_at <= ?", *query.EndDate)
+	}
+
+	// Get total count
+	var total int64
+	if err := dbQuery.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count notifications: %w", err)
+	}
+
+	// Apply pagination
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PerPage < 1 || query.PerPage > 1000 {
+		query.PerPage = 50
+	}
+
+	offset := (query.Page - 1) * query.PerPage
+
+	var notifications []models.Notification
+	if err := dbQuery.Order("created_at DESC").Limit(query.PerPage).Offset(offset).Find(&notifications).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to query notifications: %w", err)
+	}
+
+	// Convert to response format
+	responses := make([]NotificationResponse, 0, len(notifications))
+	for _, notification := range notifications {
+		response := NotificationResponse{
+			ID:            notification.ID,
+			UserID:        notification.UserID,
+			ApplicationID: notification.ApplicationID,
+			Kind:          notification.Kind,
+			Locale:        notification.Locale,
+			Recipient:     notification.Recipient,
+			Subject:       notification.Subject,
+			Status:        string(notification.Status),
+			Attempts:      notification.Attempts,
+			LastError:     notification.LastError,
+			SentAt:        notification.SentAt,
+			CreatedAt:     notification.CreatedAt,
+		}
+
+		// The next attempt only means something while delivery goes on
+		if notification.Status == models.NotificationPending {
+			nextAttemptAt := notification.NextAttemptAt
+			response.NextAttemptAt = &nextAttemptAt
+		}
+
+		if notification.User != nil {
+			response.User = &UserInfo{
+				ID:        notification.User.ID,
+				Email:     notification.User.Email,
+				FirstName: notification.User.FirstName,
+				LastName:  notification.User.LastName,
+				FullName:  notification.User.GetFullName(),
+			}
+		}
+
+		if notification.Application != nil {
+			response.Application = &ApplicationInfo{
+				ID:          notification.Application.ID,
+				Name:        notification.Application.Name,
+				Description: notification.Application.Description,
+				IsSystem:    notification.Application.IsSystem,
+			}
+		}
+
+		responses = append(responses, response)
+	}
+
+	return responses, total, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/efrenfuentes/authy/internal/cache"
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/logger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// permissionVersionCacheTTL is how long a version stays cached (seconds); it bounds how long a
+// lost cache update can hide a change
+const permissionVersionCacheTTL = 3600
+
+// PermissionVersionService tracks the permission versions of users so tokens issued before a change
+// to their roles or permissions can be detected and re-resolved
+type PermissionVersionService struct {
+	db     *gorm.DB
+	cache  *cache.Client
+	logger *logger.Logger
+}
+
+// NewPermissionVersionService creates a new permission version service instance
+func NewPermissionVersionService(db *gorm.DB, cache *cache.Client, logger *logger.Logger) *PermissionVersionService {
+	return &PermissionVersionService{
+		db:     db,
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+// getVersionKey generates cache key for the permission version of a user in an application
+func (s *PermissionVersionService) getVersionKey(userID, applicationID uuid.UUID) string {
+	return fmt.Sprintf("permission_version:%s:%s", userID.String(), applicationID.String())
+}
+
+// CurrentVersion returns the permission version of a user in an application, reading through the cache
+func (s *PermissionVersionService) CurrentVersion(ctx context.Context, userID, applicationID uuid.UUID) (int64, error) {
+	versionKey := s.getVersionKey(userID, applicationID)
+	if cached, err := s.cache.Get(ctx, versionKey); err == nil && cached != "" {
+		if version, err := strconv.ParseInt(cached, 10, 64); err == nil {
+			return version, nil
+		}
+	}
+
+	version, err := models.GetPermissionVersion(s.db, userID, applicationID)
+	if err != nil {
+		return 0, err
+	}
+
+	s.cache.Set(ctx, versionKey, strconv.FormatInt(version, 10), permissionVersionCacheTTL)
+	return version, nil
+}
+
+// ResolvePermissions returns the permissions a user currently holds in an application
+func (s *PermissionVersionService) ResolvePermissions(ctx context.Context, userID, applicationID uuid.UUID) ([]string, error) {
+	return models.GetUserPermissionStrings(s.db, userID, applicationID)
+}
+
+// BumpUser records a change to the permissions of a user in an application
+func (s *PermissionVersionService) BumpUser(ctx context.Context, userID, applicationID uuid.UUID) error {
+	version, err := models.BumpPermissionVersion(s.db, userID, applicationID)
+	if err != nil {
+		return err
+	}
+
+	versionKey := s.getVersionKey(userID, applicationID)
+	if err := s.cache.Set(ctx, versionKey, strconv.FormatInt(version, 10), permissionVersionCacheTTL); err != nil {
+		// A stale cached version would hide the change; make the next lookup read the database
+		s.cache.Delete(ctx, versionKey)
+	}
+
+	return nil
+}
+
+// BumpHolders records a change to the permissions of every given user and application pair
+func (s *PermissionVersionService) BumpHolders(ctx context.Context, holders []models.PermissionHolder) error {
+	for _, holder := range holders {
+		if err := s.BumpUser(ctx, holder.UserID, holder.ApplicationID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BumpRole records a change to the permissions of every holder of a role
+func (s *PermissionVersionService) BumpRole(ctx context.Context, roleID uuid.UUID) error {
+	holders, err := models.GetRoleHolders(s.db, roleID)
+	if err != nil {
+		return err
+	}
+	return s.BumpHolders(ctx, holders)
+}
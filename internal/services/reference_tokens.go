@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/efrenfuentes/authy/internal/models"
+	"github.com/efrenfuentes/authy/pkg/auth"
+	"gorm.io/gorm"
+)
+
+// ReferenceTokenService resolves opaque access tokens from the claims recorded with them in the
+// database, for when their cache entry is gone
+type ReferenceTokenService struct {
+	db *gorm.DB
+}
+
+// NewReferenceTokenService creates a new reference token service instance
+func NewReferenceTokenService(db *gorm.DB) *ReferenceTokenService {
+	return &ReferenceTokenService{
+		db: db,
+	}
+}
+
+// LookupReferenceToken returns the claims of a still-valid opaque access token. Revoked tokens
+// are no longer valid in the database, so they are not found.
+func (s *ReferenceTokenService) LookupReferenceToken(ctx context.Context, tokenHash string) (*auth.Claims, error) {
+	token, err := models.FindValidTokenByHash(s.db.WithContext(ctx), tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(token.Claims) == 0 {
+		return nil, auth.ErrInvalidToken
+	}
+
+	var claims auth.Claims
+	if err := json.Unmarshal(token.Claims, &claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
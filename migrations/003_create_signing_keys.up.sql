@@ -0,0 +1,13 @@
+-- Create signing_keys table (token signing keyring shared by all instances)
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kid VARCHAR(100) UNIQUE NOT NULL,
+    algorithm VARCHAR(10) NOT NULL,
+    encrypted_key TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('active', 'verification', 'retired')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    activated_at TIMESTAMP WITH TIME ZONE,
+    retired_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_status ON signing_keys(status);
@@ -0,0 +1,3 @@
+ALTER TABLE applications DROP COLUMN IF EXISTS allowed_grant_types;
+ALTER TABLE applications DROP COLUMN IF EXISTS client_type;
+ALTER TABLE applications DROP COLUMN IF EXISTS redirect_uris;
@@ -0,0 +1,5 @@
+-- Add OAuth 2.0 client settings to applications
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS redirect_uris JSONB DEFAULT '[]';
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS client_type VARCHAR(20) NOT NULL DEFAULT 'confidential'
+    CHECK (client_type IN ('confidential', 'public'));
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS allowed_grant_types JSONB DEFAULT '[]';
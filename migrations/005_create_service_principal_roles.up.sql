@@ -0,0 +1,12 @@
+-- Create service_principal_roles table (roles held by applications for the client credentials grant)
+CREATE TABLE IF NOT EXISTS service_principal_roles (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    client_application_id UUID NOT NULL REFERENCES applications(id) ON DELETE CASCADE,
+    role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    application_id UUID NOT NULL REFERENCES applications(id) ON DELETE CASCADE,
+    granted_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    granted_by UUID REFERENCES users(id),
+    UNIQUE(client_application_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_principal_roles_application ON service_principal_roles(application_id);
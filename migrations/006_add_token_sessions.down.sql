@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tokens_session;
+ALTER TABLE tokens DROP COLUMN IF EXISTS session_id;
@@ -0,0 +1,4 @@
+-- Link tokens issued within one login so a session can be revoked as a unit
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS session_id UUID;
+
+CREATE INDEX IF NOT EXISTS idx_tokens_session ON tokens(session_id);
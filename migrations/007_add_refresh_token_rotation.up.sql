@@ -0,0 +1,2 @@
+-- Track refresh token rotation so replays of rotated tokens can be detected
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,3 @@
+-- Record where each token was issued so active sessions can be listed
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS ip_address INET;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS user_agent TEXT;
@@ -0,0 +1,5 @@
+ALTER TABLE applications DROP COLUMN IF EXISTS max_concurrent_sessions;
+ALTER TABLE applications DROP COLUMN IF EXISTS idle_timeout;
+ALTER TABLE applications DROP COLUMN IF EXISTS session_lifetime;
+ALTER TABLE applications DROP COLUMN IF EXISTS refresh_token_ttl;
+ALTER TABLE applications DROP COLUMN IF EXISTS access_token_ttl;
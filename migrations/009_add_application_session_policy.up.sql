@@ -0,0 +1,6 @@
+-- Per-application token lifetimes and session limits (seconds; 0 uses the service default or no limit)
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS access_token_ttl INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS refresh_token_ttl INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS session_lifetime INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS idle_timeout INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS max_concurrent_sessions INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Create permission_versions table (bumped whenever the permissions a user holds in an application change)
+CREATE TABLE IF NOT EXISTS permission_versions (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    application_id UUID NOT NULL REFERENCES applications(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, application_id)
+);
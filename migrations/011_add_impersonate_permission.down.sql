@@ -0,0 +1 @@
+DELETE FROM permissions WHERE name = 'authy_users:impersonate';
@@ -0,0 +1,4 @@
+-- Permission to act as another user through token exchange (impersonation)
+INSERT INTO permissions (name, resource, action, description, category, is_system) VALUES
+('authy_users:impersonate', 'authy_users', 'impersonate', 'Act as another user through token exchange', 'user_management', true)
+ON CONFLICT (name) DO NOTHING;
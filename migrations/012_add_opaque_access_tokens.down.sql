@@ -0,0 +1,2 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS claims;
+ALTER TABLE applications DROP COLUMN IF EXISTS access_token_format;
@@ -0,0 +1,5 @@
+-- Per-application access token format: self-contained JWTs or opaque references
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS access_token_format VARCHAR(20) NOT NULL DEFAULT 'jwt';
+
+-- Claims of opaque access tokens, so they can be resolved when the cache loses them
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS claims JSONB;
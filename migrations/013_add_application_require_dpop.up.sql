@@ -0,0 +1,2 @@
+-- Applications can require DPoP-bound tokens (RFC 9449)
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS require_dpop BOOLEAN NOT NULL DEFAULT FALSE;
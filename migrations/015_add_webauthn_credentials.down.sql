@@ -0,0 +1,5 @@
+ALTER TABLE applications DROP COLUMN IF EXISTS webauthn_origins;
+ALTER TABLE applications DROP COLUMN IF EXISTS webauthn_rp_name;
+ALTER TABLE applications DROP COLUMN IF EXISTS webauthn_rp_id;
+
+DROP TABLE IF EXISTS webauthn_credentials;
@@ -0,0 +1,22 @@
+-- Create webauthn_credentials table (passkeys users registered, with their public key and sign count)
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rp_id VARCHAR(253) NOT NULL,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    transports JSONB DEFAULT '[]',
+    aaguid UUID,
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    name VARCHAR(100) NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+
+-- Per-application WebAuthn relying party; passkeys are disabled without an RP ID
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS webauthn_rp_id VARCHAR(253) NOT NULL DEFAULT '';
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS webauthn_rp_name VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS webauthn_origins JSONB DEFAULT '[]';
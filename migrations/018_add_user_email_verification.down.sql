@@ -0,0 +1,6 @@
+ALTER TABLE applications DROP COLUMN IF EXISTS require_verified_email;
+
+DROP INDEX IF EXISTS idx_users_pending_email;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified;
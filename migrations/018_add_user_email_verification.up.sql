@@ -0,0 +1,12 @@
+-- Email verification state of users; a new address waits in pending_email until it is confirmed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_users_pending_email ON users(pending_email);
+
+-- Accounts created before verification existed keep working
+UPDATE users SET email_verified = TRUE, email_verified_at = NOW() WHERE email_verified = FALSE;
+
+-- Per-application login policy; unverified users can log in unless the application requires verification
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS require_verified_email BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,10 @@
+ALTER TABLE users DROP COLUMN IF EXISTS pending_registration_application_id;
+
+DROP INDEX IF EXISTS idx_users_approval_pending;
+DROP INDEX IF EXISTS idx_users_email_lower;
+ALTER TABLE users DROP COLUMN IF EXISTS approval_pending;
+
+ALTER TABLE applications DROP COLUMN IF EXISTS registration_require_approval;
+ALTER TABLE applications DROP COLUMN IF EXISTS registration_role_ids;
+ALTER TABLE applications DROP COLUMN IF EXISTS registration_domains;
+ALTER TABLE applications DROP COLUMN IF EXISTS registration_enabled;
@@ -0,0 +1,16 @@
+-- Per-application self-service registration; disabled unless an admin enables it
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS registration_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS registration_domains JSONB DEFAULT '[]';
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS registration_role_ids JSONB DEFAULT '[]';
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS registration_require_approval BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Users who registered themselves and wait, inactive, for an admin to approve them
+ALTER TABLE users ADD COLUMN IF NOT EXISTS approval_pending BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Registered emails are stored lowercased and looked up regardless of case
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users(LOWER(email));
+
+CREATE INDEX IF NOT EXISTS idx_users_approval_pending ON users(approval_pending) WHERE approval_pending = TRUE;
+
+-- Users who registered with an application limiting email domains get its default roles once they verify their email
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_registration_application_id UUID REFERENCES applications(id) ON DELETE SET NULL;
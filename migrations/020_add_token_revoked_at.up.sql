@@ -0,0 +1,2 @@
+-- Record revocations, so restoring a refresh token after a failed rotation never undoes one
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
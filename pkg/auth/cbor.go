@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var errInvalidCBOR = errors.New("invalid CBOR data")
+
+// cborMaxDepth bounds the nesting of decoded CBOR items
+const cborMaxDepth = 16
+
+// decodeCBOR decodes the first CBOR item (RFC 8949) of data and returns it with the bytes that follow it.
+// It supports the definite-length subset WebAuthn authenticators produce: integers become int64, byte
+// strings []byte, text strings string, arrays []interface{} and maps map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth || len(data) == 0 {
+		return nil, nil, errInvalidCBOR
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	// Simple values and floats carry their value in the additional information
+	if major == 7 {
+		return decodeCBORSimple(info, data)
+	}
+
+	argument, data, err := decodeCBORArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0: // unsigned integer
+		if argument > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return int64(argument), data, nil
+	case 1: // negative integer
+		if argument > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return -1 - int64(argument), data, nil
+	case 2, 3: // byte and text strings
+		if argument > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		value := data[:argument]
+		if major == 3 {
+			return string(value), data[argument:], nil
+		}
+		return append([]byte{}, value...), data[argument:], nil
+	case 4: // array
+		if argument > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5: // map
+		if argument > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errInvalidCBOR
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, data, nil
+	default: // tags only annotate the item that follows
+		return decodeCBORItem(data, depth+1)
+	}
+}
+
+// decodeCBORArgument reads the argument of an item head; indefinite lengths are not supported
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, errInvalidCBOR
+	}
+}
+
+// decodeCBORSimple decodes booleans, null and undefined, and skips floats, which WebAuthn does not use
+func decodeCBORSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 25:
+		if len(data) >= 2 {
+			return nil, data[2:], nil
+		}
+	case 26:
+		if len(data) >= 4 {
+			return nil, data[4:], nil
+		}
+	case 27:
+		if len(data) >= 8 {
+			return nil, data[8:], nil
+		}
+	}
+	return nil, nil, errInvalidCBOR
+}
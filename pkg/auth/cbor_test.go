@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// cborHead encodes the head of a CBOR item with a definite argument
+func cborHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= math.MaxUint8:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	case argument <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+	}
+}
+
+// cborEncode encodes test values as CBOR, with map keys in canonical order as authenticators write them
+func cborEncode(value interface{}) []byte {
+	switch v := value.(type) {
+	case int:
+		if v >= 0 {
+			return cborHead(0, uint64(v))
+		}
+		return cborHead(1, uint64(-1-v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		encoded := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			encoded = append(encoded, cborEncode(item)...)
+		}
+		return encoded
+	case map[interface{}]interface{}:
+		keys := make([][]byte, 0, len(v))
+		entries := make(map[string][]byte, len(v))
+		for key, item := range v {
+			encodedKey := cborEncode(key)
+			keys = append(keys, encodedKey)
+			entries[string(encodedKey)] = cborEncode(item)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+		encoded := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			encoded = append(append(encoded, key...), entries[string(key)]...)
+		}
+		return encoded
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	default:
+		panic("unsupported CBOR test value")
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"small unsigned", []byte{0x17}, int64(23)},
+		{"one byte unsigned", []byte{0x18, 0x18}, int64(24)},
+		{"two byte unsigned", []byte{0x19, 0x01, 0x00}, int64(256)},
+		{"four byte unsigned", []byte{0x1a, 0x00, 0x01, 0x00, 0x00}, int64(65536)},
+		{"eight byte unsigned", cborHead(0, math.MaxInt64), int64(math.MaxInt64)},
+		{"negative", []byte{0x26}, int64(-7)},
+		{"two byte negative", []byte{0x39, 0x01, 0x00}, int64(-257)},
+		{"byte string", []byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{"text string", []byte{0x63, 'n', 'o', 'n'}, "non"},
+		{"array", []byte{0x82, 0x01, 0x20}, []interface{}{int64(1), int64(-1)}},
+		{"map", cborEncode(map[interface{}]interface{}{1: 2, "fmt": "none"}), map[interface{}]interface{}{int64(1): int64(2), "fmt": "none"}},
+		{"nested", cborEncode(map[interface{}]interface{}{"attStmt": map[interface{}]interface{}{}}), map[interface{}]interface{}{"attStmt": map[interface{}]interface{}{}}},
+		{"true", []byte{0xf5}, true},
+		{"false", []byte{0xf4}, false},
+		{"null", []byte{0xf6}, nil},
+		{"tagged", []byte{0xc2, 0x41, 0x01}, []byte{1}},
+		{"float skipped", []byte{0xf9, 0x3c, 0x00}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trailing := []byte{0xaa, 0xbb}
+			got, rest, err := decodeCBOR(append(append([]byte{}, tt.data...), trailing...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+			if !bytes.Equal(rest, trailing) {
+				t.Fatalf("rest %x, want %x", rest, trailing)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejects(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, cborMaxDepth+2)
+	deep = append(deep, 0x01)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated one byte argument", []byte{0x18}},
+		{"truncated two byte argument", []byte{0x19, 0x01}},
+		{"truncated four byte argument", []byte{0x1a, 0x00, 0x01}},
+		{"truncated eight byte argument", []byte{0x1b, 0x00, 0x00, 0x00, 0x00}},
+		{"truncated byte string", []byte{0x45, 1, 2}},
+		{"truncated text string", []byte{0x65, 'n', 'o'}},
+		{"truncated array", []byte{0x83, 0x01, 0x02}},
+		{"truncated map", []byte{0xa2, 0x01, 0x02, 0x03}},
+		{"map key without value", []byte{0xa1, 0x01}},
+		{"oversized length", []byte{0x5a, 0xff, 0xff, 0xff, 0xff}},
+		{"oversized array", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"indefinite-length byte string", []byte{0x5f, 0x41, 0x01, 0xff}},
+		{"indefinite-length text string", []byte{0x7f, 0x61, 'a', 0xff}},
+		{"indefinite-length array", []byte{0x9f, 0x01, 0xff}},
+		{"indefinite-length map", []byte{0xbf, 0x01, 0x02, 0xff}},
+		{"break outside an item", []byte{0xff}},
+		{"reserved additional information", []byte{0x1c}},
+		{"unsigned beyond int64", cborHead(0, math.MaxInt64+1)},
+		{"negative beyond int64", cborHead(1, math.MaxInt64+1)},
+		{"byte string map key", []byte{0xa1, 0x41, 0x01, 0x01}},
+		{"truncated float", []byte{0xfa, 0x00}},
+		{"nested too deep", deep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, err := decodeCBOR(tt.data); err == nil {
+				t.Fatalf("decoded %#v, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too frequently")
+	ErrAccessDenied         = errors.New("authorization denied")
+	ErrInvalidDeviceCode    = errors.New("invalid or expired device code")
+	ErrInvalidUserCode      = errors.New("invalid or expired user code")
+)
+
+// DeviceCodeTTL bounds how long a device authorization can be approved and polled
+const DeviceCodeTTL = 10 * time.Minute
+
+// DevicePollInterval is the minimum time between token requests of a device (RFC 8628 section 3.2)
+const DevicePollInterval = 5 * time.Second
+
+// userCodeAlphabet avoids vowels and look-alike characters (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// DeviceAuthorizationStatus represents where a device authorization stands
+type DeviceAuthorizationStatus string
+
+const (
+	DeviceAuthorizationPending  DeviceAuthorizationStatus = "pending"
+	DeviceAuthorizationApproved DeviceAuthorizationStatus = "approved"
+	DeviceAuthorizationDenied   DeviceAuthorizationStatus = "denied"
+)
+
+// DeviceAuthorization holds a pending device authorization request (RFC 8628)
+type DeviceAuthorization struct {
+	ClientID  uuid.UUID                 `json:"client_id"`
+	Scope     string                    `json:"scope,omitempty"`
+	UserCode  string                    `json:"user_code"`
+	Status    DeviceAuthorizationStatus `json:"status"`
+	UserID    uuid.UUID                 `json:"user_id,omitempty"`   // set once approved
+	AuthTime  time.Time                 `json:"auth_time,omitempty"` // when the user approved
+	AMR       []string                  `json:"amr,omitempty"`       // how the user signed in to approve
+	ClientIP  string                    `json:"client_ip,omitempty"` // where the user approved, recorded on the session
+	UserAgent string                    `json:"user_agent,omitempty"`
+	ExpiresAt int64                     `json:"expires_at"`
+}
+
+// devicePollState tracks how often a device polls. It is kept apart from the authorization
+// so that a poll can never overwrite the user's decision.
+type devicePollState struct {
+	Interval     int64 `json:"interval"` // seconds the device must wait between polls
+	LastPolledAt int64 `json:"last_polled_at"`
+}
+
+// getDeviceCodeKey generates cache key for device authorizations
+func (s *SessionService) getDeviceCodeKey(deviceCodeHash string) string {
+	return fmt.Sprintf("oauth_device_code:%s", deviceCodeHash)
+}
+
+// getDevicePollKey generates cache key for the polling state of device codes
+func (s *SessionService) getDevicePollKey(deviceCodeHash string) string {
+	return fmt.Sprintf("oauth_device_poll:%s", deviceCodeHash)
+}
+
+// getUserCodeKey generates cache key for looking up device authorizations by user code
+func (s *SessionService) getUserCodeKey(userCode string) string {
+	return fmt.Sprintf("oauth_user_code:%s", NormalizeUserCode(userCode))
+}
+
+// generateUserCode creates a random user code formatted as XXXX-XXXX
+func generateUserCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// NormalizeUserCode makes user codes typed by people comparable: case, dashes and spaces are ignored
+func NormalizeUserCode(userCode string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToUpper(userCode) {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			normalized.WriteRune(r)
+		}
+	}
+	return normalized.String()
+}
+
+// StartDeviceAuthorization issues a device code and user code for a client; the device code is returned only here
+func (s *SessionService) StartDeviceAuthorization(ctx context.Context, clientID uuid.UUID, scope string) (string, *DeviceAuthorization, error) {
+	deviceCode, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	userCode, err := generateUserCode()
+	if err != nil {
+		return "", nil, err
+	}
+
+	authorization := &DeviceAuthorization{
+		ClientID:  clientID,
+		Scope:     scope,
+		UserCode:  userCode,
+		Status:    DeviceAuthorizationPending,
+		ExpiresAt: time.Now().Add(DeviceCodeTTL).Unix(),
+	}
+
+	deviceCodeHash := s.hashToken(deviceCode)
+	if err := s.storeDeviceAuthorization(ctx, deviceCodeHash, authorization); err != nil {
+		return "", nil, err
+	}
+
+	userCodeKey := s.getUserCodeKey(userCode)
+	if err := s.cache.Set(ctx, userCodeKey, deviceCodeHash, int(DeviceCodeTTL.Seconds())); err != nil {
+		return "", nil, err
+	}
+
+	return deviceCode, authorization, nil
+}
+
+// storeDeviceAuthorization saves a device authorization until it expires
+func (s *SessionService) storeDeviceAuthorization(ctx context.Context, deviceCodeHash string, authorization *DeviceAuthorization) error {
+	ttl := int(authorization.ExpiresAt - time.Now().Unix())
+	if ttl <= 0 {
+		return ErrInvalidDeviceCode
+	}
+
+	authorizationJSON, err := json.Marshal(authorization)
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Set(ctx, s.getDeviceCodeKey(deviceCodeHash), string(authorizationJSON), ttl)
+}
+
+// loadDeviceAuthorization loads a device authorization by the hash of its device code
+func (s *SessionService) loadDeviceAuthorization(ctx context.Context, deviceCodeHash string) (*DeviceAuthorization, bool) {
+	authorizationJSON, err := s.cache.Get(ctx, s.getDeviceCodeKey(deviceCodeHash))
+	if err != nil || authorizationJSON == "" {
+		return nil, false
+	}
+
+	var authorization DeviceAuthorization
+	if err := json.Unmarshal([]byte(authorizationJSON), &authorization); err != nil {
+		return nil, false
+	}
+
+	return &authorization, true
+}
+
+// pendingDeviceAuthorization looks up the pending device authorization a user code belongs to
+func (s *SessionService) pendingDeviceAuthorization(ctx context.Context, userCode string) (string, *DeviceAuthorization, error) {
+	deviceCodeHash, err := s.cache.Get(ctx, s.getUserCodeKey(userCode))
+	if err != nil || deviceCodeHash == "" {
+		return "", nil, ErrInvalidUserCode
+	}
+
+	authorization, ok := s.loadDeviceAuthorization(ctx, deviceCodeHash)
+	if !ok || authorization.Status != DeviceAuthorizationPending {
+		return "", nil, ErrInvalidUserCode
+	}
+
+	return deviceCodeHash, authorization, nil
+}
+
+// GetDeviceAuthorization returns the pending device authorization of a user code, to show the user what they approve
+func (s *SessionService) GetDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	_, authorization, err := s.pendingDeviceAuthorization(ctx, userCode)
+	return authorization, err
+}
+
+// ApproveDeviceAuthorization lets the device of a user code obtain tokens for the user.
+// A user code can be used only once.
+func (s *SessionService) ApproveDeviceAuthorization(ctx context.Context, userCode string, userID uuid.UUID, amr []string, clientIP, userAgent string) (*DeviceAuthorization, error) {
+	return s.completeDeviceAuthorization(ctx, userCode, func(authorization *DeviceAuthorization) {
+		authorization.Status = DeviceAuthorizationApproved
+		authorization.UserID = userID
+		authorization.AuthTime = time.Now()
+		authorization.AMR = amr
+		authorization.ClientIP = clientIP
+		authorization.UserAgent = userAgent
+	})
+}
+
+// DenyDeviceAuthorization makes the device of a user code stop polling with access_denied
+func (s *SessionService) DenyDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	return s.completeDeviceAuthorization(ctx, userCode, func(authorization *DeviceAuthorization) {
+		authorization.Status = DeviceAuthorizationDenied
+	})
+}
+
+// completeDeviceAuthorization consumes a user code and records the user's decision
+func (s *SessionService) completeDeviceAuthorization(ctx context.Context, userCode string, decide func(*DeviceAuthorization)) (*DeviceAuthorization, error) {
+	// Consuming the user code first keeps two decisions on the same code from both succeeding
+	deviceCodeHash, err := s.cache.GetDel(ctx, s.getUserCodeKey(userCode))
+	if err != nil || deviceCodeHash == "" {
+		return nil, ErrInvalidUserCode
+	}
+
+	authorization, ok := s.loadDeviceAuthorization(ctx, deviceCodeHash)
+	if !ok || authorization.Status != DeviceAuthorizationPending {
+		return nil, ErrInvalidUserCode
+	}
+
+	decide(authorization)
+	if err := s.storeDeviceAuthorization(ctx, deviceCodeHash, authorization); err != nil {
+		return nil, err
+	}
+
+	return authorization, nil
+}
+
+// PollDeviceAuthorization checks a device code on behalf of the polling client. It returns the approved
+// authorization exactly once; until then it returns ErrAuthorizationPending, or ErrSlowDown when the
+// client polls faster than its interval, which then grows by five seconds.
+func (s *SessionService) PollDeviceAuthorization(ctx context.Context, deviceCode string, clientID uuid.UUID) (*DeviceAuthorization, error) {
+	deviceCodeHash := s.hashToken(deviceCode)
+	authorization, ok := s.loadDeviceAuthorization(ctx, deviceCodeHash)
+	if !ok || authorization.ClientID != clientID {
+		return nil, ErrInvalidDeviceCode
+	}
+
+	switch authorization.Status {
+	case DeviceAuthorizationApproved:
+		// Redeem once, even when polls race
+		if consumed, err := s.cache.GetDel(ctx, s.getDeviceCodeKey(deviceCodeHash)); err != nil || consumed == "" {
+			return nil, ErrInvalidDeviceCode
+		}
+		s.cache.Delete(ctx, s.getDevicePollKey(deviceCodeHash))
+		return authorization, nil
+	case DeviceAuthorizationDenied:
+		s.cache.Delete(ctx, s.getDeviceCodeKey(deviceCodeHash))
+		s.cache.Delete(ctx, s.getDevicePollKey(deviceCodeHash))
+		return nil, ErrAccessDenied
+	}
+
+	poll := devicePollState{Interval: int64(DevicePollInterval.Seconds())}
+	pollKey := s.getDevicePollKey(deviceCodeHash)
+	if pollJSON, err := s.cache.Get(ctx, pollKey); err == nil && pollJSON != "" {
+		json.Unmarshal([]byte(pollJSON), &poll)
+	}
+
+	now := time.Now().Unix()
+	tooFast := poll.LastPolledAt > 0 && now-poll.LastPolledAt < poll.Interval
+	if tooFast {
+		poll.Interval += int64(DevicePollInterval.Seconds())
+	}
+	poll.LastPolledAt = now
+
+	if ttl := int(authorization.ExpiresAt - now); ttl > 0 {
+		pollJSON, err := json.Marshal(poll)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.cache.Set(ctx, pollKey, string(pollJSON), ttl); err != nil {
+			return nil, err
+		}
+	}
+
+	if tooFast {
+		return nil, ErrSlowDown
+	}
+	return nil, ErrAuthorizationPending
+}
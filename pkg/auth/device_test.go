@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efrenfuentes/authy/internal/cache"
+	"github.com/google/uuid"
+)
+
+func newTestSessionService() *SessionService {
+	jwtService := NewJWTService("test-secret", 15*time.Minute, 24*time.Hour, "https://authy.test")
+	return NewSessionService(cache.NewMemory(), jwtService, 0, 0)
+}
+
+func startTestDeviceAuthorization(t *testing.T, s *SessionService, clientID uuid.UUID) (string, *DeviceAuthorization) {
+	t.Helper()
+
+	deviceCode, authorization, err := s.StartDeviceAuthorization(context.Background(), clientID, "openid")
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization: %v", err)
+	}
+	return deviceCode, authorization
+}
+
+// setLastPoll moves the device's last poll back in time, as if the device had waited
+func setLastPoll(t *testing.T, s *SessionService, deviceCode string, ago time.Duration) devicePollState {
+	t.Helper()
+
+	ctx := context.Background()
+	pollKey := s.getDevicePollKey(s.hashToken(deviceCode))
+	pollJSON, err := s.cache.Get(ctx, pollKey)
+	if err != nil {
+		t.Fatalf("poll state missing: %v", err)
+	}
+
+	var poll devicePollState
+	if err := json.Unmarshal([]byte(pollJSON), &poll); err != nil {
+		t.Fatalf("poll state: %v", err)
+	}
+	poll.LastPolledAt = time.Now().Add(-ago).Unix()
+
+	updated, _ := json.Marshal(poll)
+	if err := s.cache.Set(ctx, pollKey, string(updated), 60); err != nil {
+		t.Fatalf("store poll state: %v", err)
+	}
+	return poll
+}
+
+func TestPollDeviceAuthorizationPending(t *testing.T) {
+	s := newTestSessionService()
+	clientID := uuid.New()
+	deviceCode, _ := startTestDeviceAuthorization(t, s, clientID)
+
+	if _, err := s.PollDeviceAuthorization(context.Background(), deviceCode, clientID); !errors.Is(err, ErrAuthorizationPending) {
+		t.Fatalf("first poll: got %v, want ErrAuthorizationPending", err)
+	}
+
+	// Polling again after the interval is still just pending
+	setLastPoll(t, s, deviceCode, DevicePollInterval)
+	if _, err := s.PollDeviceAuthorization(context.Background(), deviceCode, clientID); !errors.Is(err, ErrAuthorizationPending) {
+		t.Fatalf("poll after interval: got %v, want ErrAuthorizationPending", err)
+	}
+}
+
+func TestPollDeviceAuthorizationSlowDown(t *testing.T) {
+	s := newTestSessionService()
+	clientID := uuid.New()
+	deviceCode, _ := startTestDeviceAuthorization(t, s, clientID)
+	ctx := context.Background()
+
+	if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrAuthorizationPending) {
+		t.Fatalf("first poll: got %v, want ErrAuthorizationPending", err)
+	}
+
+	interval := int64(DevicePollInterval.Seconds())
+	for i := 1; i <= 3; i++ {
+		if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrSlowDown) {
+			t.Fatalf("fast poll %d: got %v, want ErrSlowDown", i, err)
+		}
+
+		poll := setLastPoll(t, s, deviceCode, 0)
+		if want := interval * int64(i+1); poll.Interval != want {
+			t.Fatalf("fast poll %d: interval %d, want %d", i, poll.Interval, want)
+		}
+	}
+
+	// Waiting the original interval is no longer enough once it has grown
+	setLastPoll(t, s, deviceCode, DevicePollInterval)
+	if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrSlowDown) {
+		t.Fatalf("poll after original interval: got %v, want ErrSlowDown", err)
+	}
+
+	poll := setLastPoll(t, s, deviceCode, time.Duration(interval*5)*time.Second)
+	if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrAuthorizationPending) {
+		t.Fatalf("poll after grown interval of %ds: got %v, want ErrAuthorizationPending", poll.Interval, err)
+	}
+}
+
+func TestPollDeviceAuthorizationExpired(t *testing.T) {
+	s := newTestSessionService()
+	clientID := uuid.New()
+	ctx := context.Background()
+
+	t.Run("unknown device code", func(t *testing.T) {
+		if _, err := s.PollDeviceAuthorization(ctx, "unknown", clientID); !errors.Is(err, ErrInvalidDeviceCode) {
+			t.Fatalf("got %v, want ErrInvalidDeviceCode", err)
+		}
+	})
+
+	t.Run("dropped from the cache on expiry", func(t *testing.T) {
+		deviceCode, _ := startTestDeviceAuthorization(t, s, clientID)
+		s.cache.Delete(ctx, s.getDeviceCodeKey(s.hashToken(deviceCode)))
+
+		if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrInvalidDeviceCode) {
+			t.Fatalf("got %v, want ErrInvalidDeviceCode", err)
+		}
+	})
+
+	t.Run("approved after expiry", func(t *testing.T) {
+		deviceCode, authorization := startTestDeviceAuthorization(t, s, clientID)
+		authorization.ExpiresAt = time.Now().Add(-time.Second).Unix()
+		authorizationJSON, _ := json.Marshal(authorization)
+		s.cache.Set(ctx, s.getDeviceCodeKey(s.hashToken(deviceCode)), string(authorizationJSON), 60)
+
+		if _, err := s.ApproveDeviceAuthorization(ctx, authorization.UserCode, uuid.New(), []string{"pwd"}, "", ""); !errors.Is(err, ErrInvalidDeviceCode) {
+			t.Fatalf("approve: got %v, want ErrInvalidDeviceCode", err)
+		}
+	})
+
+	t.Run("another client", func(t *testing.T) {
+		deviceCode, _ := startTestDeviceAuthorization(t, s, clientID)
+
+		if _, err := s.PollDeviceAuthorization(ctx, deviceCode, uuid.New()); !errors.Is(err, ErrInvalidDeviceCode) {
+			t.Fatalf("got %v, want ErrInvalidDeviceCode", err)
+		}
+	})
+}
+
+func TestPollDeviceAuthorizationSingleUse(t *testing.T) {
+	s := newTestSessionService()
+	clientID := uuid.New()
+	userID := uuid.New()
+	deviceCode, authorization := startTestDeviceAuthorization(t, s, clientID)
+	ctx := context.Background()
+
+	// Typed by a person, the user code is matched regardless of case and dashes
+	userCode := " " + strings.ToLower(authorization.UserCode[:4]+authorization.UserCode[5:]) + " "
+	if _, err := s.ApproveDeviceAuthorization(ctx, userCode, userID, []string{"pwd"}, "127.0.0.1", "test"); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if _, err := s.ApproveDeviceAuthorization(ctx, authorization.UserCode, uuid.New(), []string{"pwd"}, "", ""); !errors.Is(err, ErrInvalidUserCode) {
+		t.Fatalf("second approve: got %v, want ErrInvalidUserCode", err)
+	}
+	if _, err := s.DenyDeviceAuthorization(ctx, authorization.UserCode); !errors.Is(err, ErrInvalidUserCode) {
+		t.Fatalf("deny after approve: got %v, want ErrInvalidUserCode", err)
+	}
+
+	approved, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID)
+	if err != nil {
+		t.Fatalf("poll after approve: %v", err)
+	}
+	if approved.UserID != userID || approved.Status != DeviceAuthorizationApproved {
+		t.Fatalf("poll after approve: got user %s status %s", approved.UserID, approved.Status)
+	}
+
+	if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrInvalidDeviceCode) {
+		t.Fatalf("second exchange: got %v, want ErrInvalidDeviceCode", err)
+	}
+}
+
+func TestPollDeviceAuthorizationDenied(t *testing.T) {
+	s := newTestSessionService()
+	clientID := uuid.New()
+	deviceCode, authorization := startTestDeviceAuthorization(t, s, clientID)
+	ctx := context.Background()
+
+	if _, err := s.DenyDeviceAuthorization(ctx, authorization.UserCode); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("poll after deny: got %v, want ErrAccessDenied", err)
+	}
+	if _, err := s.PollDeviceAuthorization(ctx, deviceCode, clientID); !errors.Is(err, ErrInvalidDeviceCode) {
+		t.Fatalf("poll after denial was reported: got %v, want ErrInvalidDeviceCode", err)
+	}
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+	ErrDPoPRequired     = errors.New("DPoP proof required")
+)
+
+// DPoPHeader is the request header carrying a DPoP proof (RFC 9449)
+const DPoPHeader = "DPoP"
+
+// DPoPProofLifetime is how long after it was issued a proof is accepted
+const DPoPProofLifetime = 5 * time.Minute
+
+// dpopClockSkew tolerates proofs issued slightly in the future by clients with fast clocks
+const dpopClockSkew = 30 * time.Second
+
+// DPoPSigningAlgorithms lists the algorithms accepted for DPoP proofs
+var DPoPSigningAlgorithms = []string{AlgorithmES256, AlgorithmRS256, AlgorithmEdDSA}
+
+// Confirmation binds a token to a key (RFC 7800); JKT is the RFC 7638 thumbprint of a DPoP key
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// DPoPRequest describes the HTTP request a DPoP proof must have been created for
+type DPoPRequest struct {
+	Method      string
+	URL         string
+	AccessToken string // set when the proof accompanies an access token
+}
+
+// dpopClaims represents the claims of a DPoP proof
+type dpopClaims struct {
+	HTTPMethod      string `json:"htm"`
+	HTTPURI         string `json:"htu"`
+	AccessTokenHash string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// getDPoPProofKey generates cache key for DPoP proofs already used with a key
+func (s *SessionService) getDPoPProofKey(thumbprint, jti string) string {
+	return fmt.Sprintf("dpop_jti:%s:%s", thumbprint, s.hashToken(jti))
+}
+
+// confirmation returns the key confirmation to put in tokens issued under the policy, nil for bearer tokens
+func (p *SessionPolicy) confirmation() *Confirmation {
+	if p == nil || p.DPoPKeyThumbprint == "" {
+		return nil
+	}
+	return &Confirmation{JKT: p.DPoPKeyThumbprint}
+}
+
+// AuthorizationScheme returns the scheme the token must be presented with, and its token_type (RFC 9449 section 5)
+func (c *Claims) AuthorizationScheme() string {
+	if c.Cnf != nil {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// VerifyDPoPProof checks a DPoP proof against the request it was sent with and returns the thumbprint
+// of its key. Each proof is accepted only once.
+func (s *SessionService) VerifyDPoPProof(ctx context.Context, proof string, req *DPoPRequest) (string, error) {
+	var thumbprint string
+	parser := jwt.NewParser(jwt.WithValidMethods(DPoPSigningAlgorithms))
+	token, err := parser.ParseWithClaims(proof, &dpopClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, ErrInvalidDPoPProof
+		}
+
+		// The proof carries the public key it was signed with
+		header, ok := token.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, ErrInvalidDPoPProof
+		}
+		if _, private := header["d"]; private {
+			return nil, ErrInvalidDPoPProof
+		}
+
+		headerJSON, err := json.Marshal(header)
+		if err != nil {
+			return nil, ErrInvalidDPoPProof
+		}
+		var jwk JWK
+		if err := json.Unmarshal(headerJSON, &jwk); err != nil {
+			return nil, ErrInvalidDPoPProof
+		}
+
+		publicKey, err := jwkToPublicKey(jwk)
+		if err != nil {
+			return nil, ErrInvalidDPoPProof
+		}
+		if thumbprint, err = JWKThumbprint(jwk); err != nil {
+			return nil, ErrInvalidDPoPProof
+		}
+		return publicKey, nil
+	})
+	if err != nil || !token.Valid {
+		return "", ErrInvalidDPoPProof
+	}
+
+	claims, ok := token.Claims.(*dpopClaims)
+	if !ok || claims.ID == "" || claims.IssuedAt == nil {
+		return "", ErrInvalidDPoPProof
+	}
+
+	now := time.Now()
+	issuedAt := claims.IssuedAt.Time
+	if issuedAt.After(now.Add(dpopClockSkew)) || now.Sub(issuedAt) > DPoPProofLifetime {
+		return "", ErrInvalidDPoPProof
+	}
+
+	if !strings.EqualFold(claims.HTTPMethod, req.Method) || !sameHTTPURI(claims.HTTPURI, req.URL) {
+		return "", ErrInvalidDPoPProof
+	}
+
+	// Proofs sent with an access token must be made for that token
+	if req.AccessToken != "" {
+		hash := sha256.Sum256([]byte(req.AccessToken))
+		expected := base64.RawURLEncoding.EncodeToString(hash[:])
+		if subtle.ConstantTimeCompare([]byte(claims.AccessTokenHash), []byte(expected)) != 1 {
+			return "", ErrInvalidDPoPProof
+		}
+	}
+
+	// Remember the proof for as long as it could be accepted
+	ttl := int((DPoPProofLifetime + dpopClockSkew).Seconds())
+	fresh, err := s.cache.SetNX(ctx, s.getDPoPProofKey(thumbprint, claims.ID), "1", ttl)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", ErrInvalidDPoPProof
+	}
+
+	return thumbprint, nil
+}
+
+// VerifyTokenBinding checks the DPoP proof presented with an access token bound to a key
+func (s *SessionService) VerifyTokenBinding(ctx context.Context, claims *Claims, proof string, req *DPoPRequest) error {
+	if claims.Cnf == nil {
+		return nil
+	}
+	if proof == "" {
+		return ErrDPoPRequired
+	}
+
+	thumbprint, err := s.VerifyDPoPProof(ctx, proof, req)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(claims.Cnf.JKT)) != 1 {
+		return ErrInvalidDPoPProof
+	}
+
+	return nil
+}
+
+// sameHTTPURI compares the htu claim of a proof with the request URL, ignoring query and fragment
+func sameHTTPURI(htu, requestURL string) bool {
+	normalize := func(raw string) (string, bool) {
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return "", false
+		}
+		path := parsed.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		return strings.ToLower(parsed.Scheme) + "://" + strings.ToLower(parsed.Host) + path, true
+	}
+
+	proofURI, ok := normalize(htu)
+	if !ok {
+		return false
+	}
+	actualURI, ok := normalize(requestURL)
+	return ok && proofURI == actualURI
+}
+
+// ExtractTokenAndScheme extracts the token of a Bearer or DPoP Authorization header along with its scheme
+func ExtractTokenAndScheme(authHeader string) (string, string) {
+	if token := ExtractTokenFromHeader(authHeader); token != "" {
+		return token, "Bearer"
+	}
+
+	const dpopPrefix = "DPoP "
+	if len(authHeader) > len(dpopPrefix) && authHeader[:len(dpopPrefix)] == dpopPrefix {
+		return authHeader[len(dpopPrefix):], "DPoP"
+	}
+	return "", ""
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testDPoPURL = "https://authy.test/oauth/token"
+
+// dpopProof describes a proof to sign; zero values get valid defaults
+type dpopProof struct {
+	typ         string
+	method      string
+	url         string
+	issuedAt    time.Time
+	noIssuedAt  bool
+	jti         string
+	noJTI       bool
+	accessToken string
+	privateJWK  bool
+}
+
+func newDPoPKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+// ecThumbprint computes the RFC 7638 thumbprint of a P-256 key independently of JWKThumbprint
+func ecThumbprint(key *ecdsa.PrivateKey) string {
+	x := base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32)))
+	y := base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32)))
+	hash := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + x + `","y":"` + y + `"}`))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func signDPoPProof(t *testing.T, key *ecdsa.PrivateKey, proof dpopProof) string {
+	t.Helper()
+
+	claims := &dpopClaims{HTTPMethod: proof.method, HTTPURI: proof.url}
+	if claims.HTTPMethod == "" {
+		claims.HTTPMethod = "POST"
+	}
+	if claims.HTTPURI == "" {
+		claims.HTTPURI = testDPoPURL
+	}
+	if !proof.noJTI {
+		claims.ID = proof.jti
+		if claims.ID == "" {
+			claims.ID = uuid.NewString()
+		}
+	}
+	if !proof.noIssuedAt {
+		issuedAt := proof.issuedAt
+		if issuedAt.IsZero() {
+			issuedAt = time.Now()
+		}
+		claims.IssuedAt = jwt.NewNumericDate(issuedAt)
+	}
+	if proof.accessToken != "" {
+		hash := sha256.Sum256([]byte(proof.accessToken))
+		claims.AccessTokenHash = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+
+	jwk, err := publicKeyToJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("public JWK: %v", err)
+	}
+	jwkJSON, _ := json.Marshal(jwk)
+	var header map[string]interface{}
+	json.Unmarshal(jwkJSON, &header)
+	if proof.privateJWK {
+		header["d"] = base64.RawURLEncoding.EncodeToString(key.D.FillBytes(make([]byte, 32)))
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = proof.typ
+	if proof.typ == "" {
+		token.Header["typ"] = "dpop+jwt"
+	}
+	token.Header["jwk"] = header
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign proof: %v", err)
+	}
+	return signed
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	tests := []struct {
+		name    string
+		proof   dpopProof
+		request DPoPRequest
+		wantErr bool
+	}{
+		{name: "valid", proof: dpopProof{}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}},
+		{name: "method case ignored", proof: dpopProof{method: "post"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}},
+		{name: "other method", proof: dpopProof{method: "GET"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "query and fragment ignored", proof: dpopProof{url: testDPoPURL + "?a=1#top"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL + "?b=2"}},
+		{name: "scheme and host case ignored", proof: dpopProof{url: "HTTPS://Authy.Test/oauth/token"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}},
+		{name: "other path", proof: dpopProof{url: "https://authy.test/oauth/revoke"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "other host", proof: dpopProof{url: "https://evil.test/oauth/token"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "other scheme", proof: dpopProof{url: "http://authy.test/oauth/token"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "relative htu", proof: dpopProof{url: "/oauth/token"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "issued within lifetime", proof: dpopProof{issuedAt: time.Now().Add(-DPoPProofLifetime + time.Minute)}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}},
+		{name: "issued before lifetime", proof: dpopProof{issuedAt: time.Now().Add(-DPoPProofLifetime - time.Minute)}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "issued within clock skew", proof: dpopProof{issuedAt: time.Now().Add(dpopClockSkew / 2)}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}},
+		{name: "issued in the future", proof: dpopProof{issuedAt: time.Now().Add(2 * dpopClockSkew)}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "no iat", proof: dpopProof{noIssuedAt: true}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "no jti", proof: dpopProof{noJTI: true}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "wrong typ", proof: dpopProof{typ: "JWT"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "private key in header", proof: dpopProof{privateJWK: true}, request: DPoPRequest{Method: "POST", URL: testDPoPURL}, wantErr: true},
+		{name: "ath matches access token", proof: dpopProof{accessToken: "access-token"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL, AccessToken: "access-token"}},
+		{name: "ath of another access token", proof: dpopProof{accessToken: "other-token"}, request: DPoPRequest{Method: "POST", URL: testDPoPURL, AccessToken: "access-token"}, wantErr: true},
+		{name: "no ath with access token", proof: dpopProof{}, request: DPoPRequest{Method: "POST", URL: testDPoPURL, AccessToken: "access-token"}, wantErr: true},
+	}
+
+	s := newTestSessionService()
+	key := newDPoPKey(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof := signDPoPProof(t, key, tt.proof)
+			thumbprint, err := s.VerifyDPoPProof(context.Background(), proof, &tt.request)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidDPoPProof) {
+					t.Fatalf("got %v, want ErrInvalidDPoPProof", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := ecThumbprint(key); thumbprint != want {
+				t.Fatalf("thumbprint %s, want %s", thumbprint, want)
+			}
+		})
+	}
+}
+
+func TestVerifyDPoPProofReplay(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	request := &DPoPRequest{Method: "POST", URL: testDPoPURL}
+	key := newDPoPKey(t)
+
+	proof := signDPoPProof(t, key, dpopProof{jti: "proof-1"})
+	if _, err := s.VerifyDPoPProof(ctx, proof, request); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := s.VerifyDPoPProof(ctx, proof, request); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("replay: got %v, want ErrInvalidDPoPProof", err)
+	}
+
+	// A new proof reusing the jti is a replay too
+	if _, err := s.VerifyDPoPProof(ctx, signDPoPProof(t, key, dpopProof{jti: "proof-1"}), request); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("reused jti: got %v, want ErrInvalidDPoPProof", err)
+	}
+
+	// jti values are tracked per key
+	if _, err := s.VerifyDPoPProof(ctx, signDPoPProof(t, newDPoPKey(t), dpopProof{jti: "proof-1"}), request); err != nil {
+		t.Fatalf("same jti with another key: %v", err)
+	}
+}
+
+func TestVerifyTokenBinding(t *testing.T) {
+	key := newDPoPKey(t)
+	bound := &Claims{Cnf: &Confirmation{JKT: ecThumbprint(key)}}
+
+	tests := []struct {
+		name    string
+		claims  *Claims
+		proof   func(t *testing.T) string
+		wantErr error
+	}{
+		{name: "bearer token", claims: &Claims{}, proof: func(t *testing.T) string { return "" }},
+		{name: "proof of the bound key", claims: bound, proof: func(t *testing.T) string {
+			return signDPoPProof(t, key, dpopProof{method: "GET", accessToken: "access-token"})
+		}},
+		{name: "no proof", claims: bound, proof: func(t *testing.T) string { return "" }, wantErr: ErrDPoPRequired},
+		{name: "proof of another key", claims: bound, proof: func(t *testing.T) string {
+			return signDPoPProof(t, newDPoPKey(t), dpopProof{method: "GET", accessToken: "access-token"})
+		}, wantErr: ErrInvalidDPoPProof},
+		{name: "proof for another token", claims: bound, proof: func(t *testing.T) string {
+			return signDPoPProof(t, key, dpopProof{method: "GET", accessToken: "other-token"})
+		}, wantErr: ErrInvalidDPoPProof},
+	}
+
+	s := newTestSessionService()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &DPoPRequest{Method: "GET", URL: testDPoPURL, AccessToken: "access-token"}
+			err := s.VerifyTokenBinding(context.Background(), tt.claims, tt.proof(t), request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	rsaKey := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+	thumbprint, err := JWKThumbprint(rsaKey)
+	if err != nil {
+		t.Fatalf("RSA thumbprint: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; thumbprint != want {
+		t.Fatalf("RSA thumbprint %s, want %s", thumbprint, want)
+	}
+
+	key := newDPoPKey(t)
+	jwk, err := publicKeyToJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("public JWK: %v", err)
+	}
+	thumbprint, err = JWKThumbprint(jwk)
+	if err != nil {
+		t.Fatalf("EC thumbprint: %v", err)
+	}
+	if want := ecThumbprint(key); thumbprint != want {
+		t.Fatalf("EC thumbprint %s, want %s", thumbprint, want)
+	}
+
+	if _, err := JWKThumbprint(JWK{Kty: "oct"}); err == nil {
+		t.Fatal("symmetric key: got a thumbprint, want an error")
+	}
+}
+
+func TestVerifyDPoPProofRSAKeySize(t *testing.T) {
+	s := newTestSessionService()
+	request := &DPoPRequest{Method: "POST", URL: testDPoPURL}
+
+	for _, tt := range []struct {
+		bits    int
+		wantErr bool
+	}{
+		{bits: 1024, wantErr: true},
+		{bits: 2048},
+	} {
+		key, err := rsa.GenerateKey(rand.Reader, tt.bits)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		jwk, err := publicKeyToJWK(&key.PublicKey)
+		if err != nil {
+			t.Fatalf("public JWK: %v", err)
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, &dpopClaims{
+			HTTPMethod: "POST",
+			HTTPURI:    testDPoPURL,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:       uuid.NewString(),
+				IssuedAt: jwt.NewNumericDate(time.Now()),
+			},
+		})
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = jwk
+		proof, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("sign proof: %v", err)
+		}
+
+		_, err = s.VerifyDPoPProof(context.Background(), proof, request)
+		if tt.wantErr && !errors.Is(err, ErrInvalidDPoPProof) {
+			t.Errorf("%d-bit RSA key: got %v, want ErrInvalidDPoPProof", tt.bits, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("%d-bit RSA key: unexpected error %v", tt.bits, err)
+		}
+	}
+}
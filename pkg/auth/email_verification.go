@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidEmailVerificationToken reports an unknown, expired, used or superseded email verification token
+var ErrInvalidEmailVerificationToken = errors.New("invalid or expired email verification token")
+
+// EmailVerificationTTL bounds how long an email verification link can be used
+const EmailVerificationTTL = 24 * time.Hour
+
+// emailVerification is what an email verification token stands for
+type emailVerification struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
+
+// getEmailVerificationKey generates cache key for email verification tokens
+func (s *SessionService) getEmailVerificationKey(tokenHash string) string {
+	return fmt.Sprintf("email_verification:%s", tokenHash)
+}
+
+// getUserEmailVerificationKey generates cache key for the latest email verification token of a user
+func (s *SessionService) getUserEmailVerificationKey(userID uuid.UUID) string {
+	return fmt.Sprintf("email_verification_user:%s", userID.String())
+}
+
+// IssueEmailVerificationToken creates a single-use token confirming that a user receives mail at an address.
+// Only its hash is stored, and only the latest token of a user is valid.
+func (s *SessionService) IssueEmailVerificationToken(ctx context.Context, userID uuid.UUID, email string) (string, error) {
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(emailVerification{UserID: userID, Email: email})
+	if err != nil {
+		return "", err
+	}
+
+	tokenHash := s.hashToken(token)
+	ttl := int(EmailVerificationTTL.Seconds())
+	if err := s.cache.Set(ctx, s.getEmailVerificationKey(tokenHash), string(data), ttl); err != nil {
+		return "", err
+	}
+	if err := s.cache.Set(ctx, s.getUserEmailVerificationKey(userID), tokenHash, ttl); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeEmailVerificationToken returns the user and address an email verification token was issued for;
+// it succeeds only once per token
+func (s *SessionService) ConsumeEmailVerificationToken(ctx context.Context, token string) (uuid.UUID, string, error) {
+	tokenHash := s.hashToken(token)
+
+	data, err := s.cache.GetDel(ctx, s.getEmailVerificationKey(tokenHash))
+	if err != nil || data == "" {
+		return uuid.Nil, "", ErrInvalidEmailVerificationToken
+	}
+
+	var verification emailVerification
+	if err := json.Unmarshal([]byte(data), &verification); err != nil {
+		return uuid.Nil, "", ErrInvalidEmailVerificationToken
+	}
+
+	// A newer link, such as one for another address, replaces the links sent before it; a superseded link
+	// leaves the newer one valid
+	latest, err := s.cache.DeleteIfEqual(ctx, s.getUserEmailVerificationKey(verification.UserID), tokenHash)
+	if err != nil || !latest {
+		return uuid.Nil, "", ErrInvalidEmailVerificationToken
+	}
+
+	return verification.UserID, verification.Email, nil
+}
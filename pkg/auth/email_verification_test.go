@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestConsumeEmailVerificationToken(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	older, err := s.IssueEmailVerificationToken(ctx, userID, "ada@example.com")
+	if err != nil {
+		t.Fatalf("IssueEmailVerificationToken: %v", err)
+	}
+	latest, err := s.IssueEmailVerificationToken(ctx, userID, "ada@example.org")
+	if err != nil {
+		t.Fatalf("IssueEmailVerificationToken: %v", err)
+	}
+
+	// The link for the earlier address is superseded, and using it leaves the latest one valid
+	if _, _, err := s.ConsumeEmailVerificationToken(ctx, older); !errors.Is(err, ErrInvalidEmailVerificationToken) {
+		t.Fatalf("superseded token: got %v, want ErrInvalidEmailVerificationToken", err)
+	}
+	gotUserID, gotEmail, err := s.ConsumeEmailVerificationToken(ctx, latest)
+	if err != nil || gotUserID != userID || gotEmail != "ada@example.org" {
+		t.Fatalf("latest token: got %s, %q, %v", gotUserID, gotEmail, err)
+	}
+
+	if _, _, err := s.ConsumeEmailVerificationToken(ctx, latest); !errors.Is(err, ErrInvalidEmailVerificationToken) {
+		t.Fatalf("token used twice: %v", err)
+	}
+}
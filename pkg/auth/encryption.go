@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrDecryptionFailed = errors.New("failed to decrypt data")
+
+// Encryptor encrypts secrets at rest using AES-256-GCM, and hashes with HMAC-SHA256 the secrets that are
+// only ever compared
+type Encryptor struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewEncryptor creates an encryptor whose keys are derived from the given secret
+func NewEncryptor(secret string) (*Encryptor, error) {
+	key := sha256.Sum256([]byte(secret))
+
+	// A separate key, so hashes reveal nothing about the encryption key
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("authy mac key"))
+	macKey := mac.Sum(nil)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encryptor{aead: aead, macKey: macKey}, nil
+}
+
+// Encrypt seals the plaintext and returns it base64 encoded with the nonce prepended
+func (e *Encryptor) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := e.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt
+func (e *Encryptor) Decrypt(ciphertext string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	nonceSize := e.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrDecryptionFailed
+	}
+
+	plaintext, err := e.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return plaintext, nil
+}
+
+// MAC returns the HMAC-SHA256 of the data under the encryptor's hashing key. Without the secret it
+// can't be computed, so guessing the data from a stored MAC requires the secret too.
+func (e *Encryptor) MAC(data []byte) []byte {
+	mac := hmac.New(sha256.New, e.macKey)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -12,8 +12,24 @@ var (
 	ErrInvalidToken     = errors.New("invalid token")
 	ErrExpiredToken     = errors.New("token has expired")
 	ErrInvalidTokenType = errors.New("invalid token type")
+	ErrInvalidAudience  = errors.New("token was not issued for this audience")
 )
 
+// AudienceError reports a valid token presented to an application it was not issued for
+type AudienceError struct {
+	Expected uuid.UUID // application the token was presented to
+	Claims   *Claims
+}
+
+func (e *AudienceError) Error() string {
+	return ErrInvalidAudience.Error()
+}
+
+// Is makes errors.Is(err, ErrInvalidAudience) match
+func (e *AudienceError) Is(target error) bool {
+	return target == ErrInvalidAudience
+}
+
 // TokenType represents the type of JWT token
 type TokenType string
 
@@ -24,41 +40,130 @@ const (
 
 // Claims represents the JWT claims structure
 type Claims struct {
-	UserID        uuid.UUID `json:"user_id"`
-	ApplicationID uuid.UUID `json:"application_id"`
-	TokenType     TokenType `json:"token_type"`
-	Permissions   []string  `json:"permissions,omitempty"`
+	UserID            uuid.UUID        `json:"user_id"`
+	ApplicationID     uuid.UUID        `json:"application_id"`
+	ClientID          uuid.UUID        `json:"client_id"`           // application the token was issued to
+	SessionID         uuid.UUID        `json:"sid"`                 // refresh token family shared by all tokens of one login
+	AuthTime          *jwt.NumericDate `json:"auth_time,omitempty"` // when the session started
+	TokenType         TokenType        `json:"token_type"`
+	Permissions       []string         `json:"permissions,omitempty"`
+	PermissionVersion int64            `json:"pv,omitempty"`  // user's permission version when Permissions were resolved
+	Act               *Actor           `json:"act,omitempty"` // party acting on behalf of the subject (token exchange)
+	Cnf               *Confirmation    `json:"cnf,omitempty"` // DPoP key the token is bound to
+	AMR               []string         `json:"amr,omitempty"` // how the user authenticated (RFC 8176)
 	jwt.RegisteredClaims
 }
 
+// IssuedTo returns the client application the token was issued to
+func (c *Claims) IssuedTo() uuid.UUID {
+	// Tokens issued before client_id was introduced were always issued to their audience
+	if c.ClientID == uuid.Nil {
+		return c.ApplicationID
+	}
+	return c.ClientID
+}
+
+// IsIntendedFor reports whether the token was issued for the given audience
+func (c *Claims) IsIntendedFor(audience uuid.UUID) bool {
+	// The audience is always the application the token grants access to
+	return c.ApplicationID == audience
+}
+
+// IsServicePrincipal reports whether the token was issued to an application acting on its own behalf
+func (c *Claims) IsServicePrincipal() bool {
+	return c.UserID == uuid.Nil
+}
+
 // JWTService handles JWT token operations
 type JWTService struct {
-	secretKey           []byte
+	keyring             *Keyring
 	accessTokenExpiry   time.Duration
 	refreshTokenExpiry  time.Duration
 	issuer             string
 }
 
-// NewJWTService creates a new JWT service instance
+// NewJWTService creates a new JWT service instance signing with a shared HMAC secret
 func NewJWTService(secretKey string, accessExpiry, refreshExpiry time.Duration, issuer string) *JWTService {
+	return NewJWTServiceWithKey(NewHMACSigningKey(secretKey), accessExpiry, refreshExpiry, issuer)
+}
+
+// NewJWTServiceWithKey creates a new JWT service instance signing with the given key
+func NewJWTServiceWithKey(signingKey *SigningKey, accessExpiry, refreshExpiry time.Duration, issuer string) *JWTService {
+	return NewJWTServiceWithKeyring(NewKeyring(signingKey), accessExpiry, refreshExpiry, issuer)
+}
+
+// NewJWTServiceWithKeyring creates a new JWT service instance backed by a rotating keyring
+func NewJWTServiceWithKeyring(keyring *Keyring, accessExpiry, refreshExpiry time.Duration, issuer string) *JWTService {
 	return &JWTService{
-		secretKey:          []byte(secretKey),
+		keyring:            keyring,
 		accessTokenExpiry:  accessExpiry,
 		refreshTokenExpiry: refreshExpiry,
 		issuer:            issuer,
 	}
 }
 
-// GenerateAccessToken creates a new access token
-func (j *JWTService) GenerateAccessToken(userID, applicationID uuid.UUID, permissions []string) (string, *Claims, error) {
+// signClaims signs the claims with the active key, identifying it in the kid header
+func (j *JWTService) signClaims(claims jwt.Claims) (string, error) {
+	signingKey := j.keyring.Active()
+
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.ID
+	return token.SignedString(signingKey.privateKey)
+}
+
+// verificationKeyFor selects the key that must have signed the token based on its kid header
+func (j *JWTService) verificationKeyFor(token *jwt.Token) (interface{}, error) {
+	signingKey := j.keyring.Active()
+
+	// Tokens issued before key rotation carry no kid and were signed by the configured key
+	if kid, ok := token.Header["kid"].(string); ok && kid != "" {
+		key, found := j.keyring.Lookup(kid)
+		if !found {
+			return nil, ErrInvalidToken
+		}
+		signingKey = key
+	}
+
+	// Validate the signing method
+	if token.Method.Alg() != signingKey.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	return signingKey.verificationKey(), nil
+}
+
+// GenerateAccessToken creates a new access token within a session
+func (j *JWTService) GenerateAccessToken(userID, applicationID, sessionID uuid.UUID, permissions []string) (string, *Claims, error) {
 	now := time.Now()
-	expiresAt := now.Add(j.accessTokenExpiry)
+	return j.generateAccessToken(userID, applicationID, sessionID, permissions, 0, now, now.Add(j.accessTokenExpiry), nil)
+}
 
-	claims := &Claims{
-		UserID:        userID,
-		ApplicationID: applicationID,
-		TokenType:     AccessTokenType,
-		Permissions:   permissions,
+// generateAccessToken creates an access token within a session started at authTime, stamped by the policy when set
+func (j *JWTService) generateAccessToken(userID, applicationID, sessionID uuid.UUID, permissions []string, permissionVersion int64, authTime, expiresAt time.Time, policy *SessionPolicy) (string, *Claims, error) {
+	claims := j.accessClaims(userID, applicationID, sessionID, permissions, permissionVersion, authTime, expiresAt)
+	policy.stamp(claims)
+
+	tokenString, err := j.signClaims(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tokenString, claims, nil
+}
+
+// accessClaims builds the claims of an access token within a session started at authTime
+func (j *JWTService) accessClaims(userID, applicationID, sessionID uuid.UUID, permissions []string, permissionVersion int64, authTime, expiresAt time.Time) *Claims {
+	now := time.Now()
+
+	return &Claims{
+		UserID:            userID,
+		ApplicationID:     applicationID,
+		ClientID:          applicationID,
+		SessionID:         sessionID,
+		AuthTime:          jwt.NewNumericDate(authTime),
+		TokenType:         AccessTokenType,
+		Permissions:       permissions,
+		PermissionVersion: permissionVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        uuid.New().String(),
 			Subject:   userID.String(),
@@ -69,24 +174,24 @@ func (j *JWTService) GenerateAccessToken(userID, applicationID uuid.UUID, permis
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(j.secretKey)
-	if err != nil {
-		return "", nil, err
-	}
-
-	return tokenString, claims, nil
+// GenerateRefreshToken creates a new refresh token within a session
+func (j *JWTService) GenerateRefreshToken(userID, applicationID, sessionID uuid.UUID) (string, *Claims, error) {
+	now := time.Now()
+	return j.generateRefreshToken(userID, applicationID, sessionID, now, now.Add(j.refreshTokenExpiry), nil)
 }
 
-// GenerateRefreshToken creates a new refresh token
-func (j *JWTService) GenerateRefreshToken(userID, applicationID uuid.UUID) (string, *Claims, error) {
+// generateRefreshToken creates a refresh token within a session started at authTime, stamped by the policy when set
+func (j *JWTService) generateRefreshToken(userID, applicationID, sessionID uuid.UUID, authTime, expiresAt time.Time, policy *SessionPolicy) (string, *Claims, error) {
 	now := time.Now()
-	expiresAt := now.Add(j.refreshTokenExpiry)
 
 	claims := &Claims{
 		UserID:        userID,
 		ApplicationID: applicationID,
+		ClientID:      applicationID,
+		SessionID:     sessionID,
+		AuthTime:      jwt.NewNumericDate(authTime),
 		TokenType:     RefreshTokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        uuid.New().String(),
@@ -98,9 +203,9 @@ func (j *JWTService) GenerateRefreshToken(userID, applicationID uuid.UUID) (stri
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
+	policy.stamp(claims)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(j.secretKey)
+	tokenString, err := j.signClaims(claims)
 	if err != nil {
 		return "", nil, err
 	}
@@ -110,13 +215,7 @@ func (j *JWTService) GenerateRefreshToken(userID, applicationID uuid.UUID) (stri
 
 // ValidateToken validates and parses a JWT token
 func (j *JWTService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrInvalidToken
-		}
-		return j.secretKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, j.verificationKeyFor)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -161,6 +260,27 @@ func (j *JWTService) ValidateRefreshToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// JWKS returns the public verification keys as a JSON Web Key Set
+func (j *JWTService) JWKS() *JWKSet {
+	keySet := &JWKSet{Keys: []JWK{}}
+	for _, key := range j.keyring.Keys() {
+		if jwk, ok := key.PublicJWK(); ok {
+			keySet.Keys = append(keySet.Keys, jwk)
+		}
+	}
+	return keySet
+}
+
+// Issuer returns the issuer identifier placed in every token
+func (j *JWTService) Issuer() string {
+	return j.issuer
+}
+
+// SigningAlgorithm returns the algorithm used to sign tokens
+func (j *JWTService) SigningAlgorithm() string {
+	return j.keyring.Active().Algorithm
+}
+
 // ExtractTokenFromHeader extracts JWT token from Authorization header
 func ExtractTokenFromHeader(authHeader string) string {
 	const bearerPrefix = "Bearer "
@@ -185,21 +305,34 @@ func (j *JWTService) GetTokenExpiry(tokenType TokenType) time.Duration {
 // TokenPair represents a pair of access and refresh tokens
 type TokenPair struct {
 	AccessToken  string    `json:"access_token"`
-	RefreshToken string    `json:"refresh_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"` // not issued for client credentials
 	TokenType    string    `json:"token_type"`
 	ExpiresIn    int64     `json:"expires_in"` // seconds until access token expires
+	IDToken      string    `json:"id_token,omitempty"` // only when the openid scope was requested
+}
+
+// GenerateTokenPair creates both access and refresh tokens for a new session, applying the
+// issuing application's policy (nil for the service defaults)
+func (j *JWTService) GenerateTokenPair(userID, applicationID, sessionID uuid.UUID, permissions []string, policy *SessionPolicy) (*TokenPair, *Claims, *Claims, error) {
+	return j.generateTokenPair(userID, applicationID, sessionID, permissions, 0, time.Now(), policy)
 }
 
-// GenerateTokenPair creates both access and refresh tokens
-func (j *JWTService) GenerateTokenPair(userID, applicationID uuid.UUID, permissions []string) (*TokenPair, *Claims, *Claims, error) {
+// generateTokenPair creates both access and refresh tokens within a session started at authTime,
+// stamping the access token with the permission version its permissions were resolved at
+func (j *JWTService) generateTokenPair(userID, applicationID, sessionID uuid.UUID, permissions []string, permissionVersion int64, authTime time.Time, policy *SessionPolicy) (*TokenPair, *Claims, *Claims, error) {
+	accessExpiresAt, refreshExpiresAt, err := policy.tokenExpiries(time.Now(), authTime, j.accessTokenExpiry, j.refreshTokenExpiry)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	// Generate access token
-	accessToken, accessClaims, err := j.GenerateAccessToken(userID, applicationID, permissions)
+	accessToken, accessClaims, err := j.generateAccessToken(userID, applicationID, sessionID, permissions, permissionVersion, authTime, accessExpiresAt, policy)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
 	// Generate refresh token
-	refreshToken, refreshClaims, err := j.GenerateRefreshToken(userID, applicationID)
+	refreshToken, refreshClaims, err := j.generateRefreshToken(userID, applicationID, sessionID, authTime, refreshExpiresAt, policy)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -207,9 +340,9 @@ func (j *JWTService) GenerateTokenPair(userID, applicationID uuid.UUID, permissi
 	tokenPair := &TokenPair{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		TokenType:    "Bearer",
-		ExpiresIn:    int64(j.accessTokenExpiry.Seconds()),
+		TokenType:    accessClaims.AuthorizationScheme(),
+		ExpiresIn:    int64(time.Until(accessExpiresAt).Round(time.Second).Seconds()),
 	}
 
 	return tokenPair, accessClaims, refreshClaims, nil
-}
\ No newline at end of file
+}
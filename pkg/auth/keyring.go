@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+)
+
+// Keyring holds the active signing key and the keys still accepted for verification
+type Keyring struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeyring creates a keyring with a single active key
+func NewKeyring(active *SigningKey) *Keyring {
+	k := &Keyring{}
+	k.Replace(active, nil)
+	return k
+}
+
+// Active returns the key used to sign new tokens
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Lookup finds a key by its kid, including the active key
+func (k *Keyring) Lookup(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// Keys returns every key accepted for verification, active key first
+func (k *Keyring) Keys() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(k.keys))
+	keys = append(keys, k.active)
+	for kid, key := range k.keys {
+		if kid != k.active.ID {
+			keys = append(keys, key)
+		}
+	}
+
+	// Keep verification-only keys in a stable order
+	sort.Slice(keys[1:], func(a, b int) bool {
+		return keys[1+a].ID < keys[1+b].ID
+	})
+
+	return keys
+}
+
+// Replace atomically swaps the active key and the set of verification-only keys
+func (k *Keyring) Replace(active *SigningKey, verification []*SigningKey) {
+	keys := make(map[string]*SigningKey, len(verification)+1)
+	for _, key := range verification {
+		keys[key.ID] = key
+	}
+	keys[active.ID] = active
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = active
+	k.keys = keys
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidKey           = errors.New("invalid signing key")
+)
+
+// Supported signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for signing or verifying
+const minRSAKeyBits = 2048
+
+// SigningKey holds the key material used to sign and verify tokens
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  crypto.PublicKey
+}
+
+// NewHMACSigningKey creates a symmetric signing key from a shared secret
+func NewHMACSigningKey(secret string) *SigningKey {
+	// Derive a kid that identifies the secret without revealing it
+	hash := sha256.Sum256([]byte("authy-kid:" + secret))
+
+	return &SigningKey{
+		ID:         "hs-" + base64.RawURLEncoding.EncodeToString(hash[:12]),
+		Algorithm:  AlgorithmHS256,
+		method:     jwt.SigningMethodHS256,
+		privateKey: []byte(secret),
+	}
+}
+
+// NewSigningKey wraps a private key for the given algorithm, validating that they match
+func NewSigningKey(algorithm string, privateKey crypto.PrivateKey) (*SigningKey, error) {
+	key := &SigningKey{Algorithm: algorithm, privateKey: privateKey}
+
+	switch algorithm {
+	case AlgorithmRS256:
+		rsaKey, ok := privateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: RS256 requires an RSA private key", ErrInvalidKey)
+		}
+		if rsaKey.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%w: RSA keys must be at least 2048 bits", ErrInvalidKey)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.publicKey = &rsaKey.PublicKey
+	case AlgorithmES256:
+		ecKey, ok := privateKey.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: ES256 requires a P-256 ECDSA private key", ErrInvalidKey)
+		}
+		key.method = jwt.SigningMethodES256
+		key.publicKey = &ecKey.PublicKey
+	case AlgorithmEdDSA:
+		edKey, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: EdDSA requires an Ed25519 private key", ErrInvalidKey)
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.publicKey = edKey.Public()
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	kid, err := key.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	key.ID = kid
+
+	return key, nil
+}
+
+// ParseSigningKey parses a PEM encoded private key (PKCS#8, PKCS#1 or SEC 1) for the given algorithm
+func ParseSigningKey(algorithm string, pemData []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidKey)
+	}
+
+	var privateKey crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return NewSigningKey(algorithm, privateKey)
+}
+
+// GenerateSigningKey creates a new random key pair for the given algorithm
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var privateKey crypto.PrivateKey
+	var err error
+
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return NewHMACSigningKey(string(secret)), nil
+	case AlgorithmRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	case AlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey(algorithm, privateKey)
+}
+
+// IsSymmetric reports whether the key is a shared HMAC secret
+func (k *SigningKey) IsSymmetric() bool {
+	return k.publicKey == nil
+}
+
+// MarshalPrivateKeyPEM encodes the private key as a PKCS#8 PEM block
+func (k *SigningKey) MarshalPrivateKeyPEM() ([]byte, error) {
+	if k.IsSymmetric() {
+		return nil, fmt.Errorf("%w: symmetric keys cannot be PEM encoded", ErrInvalidKey)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalKeyMaterial encodes the key for storage: the raw secret for HMAC keys, PKCS#8 PEM otherwise
+func (k *SigningKey) MarshalKeyMaterial() ([]byte, error) {
+	if k.IsSymmetric() {
+		return k.privateKey.([]byte), nil
+	}
+	return k.MarshalPrivateKeyPEM()
+}
+
+// ParseKeyMaterial restores a key produced by MarshalKeyMaterial
+func ParseKeyMaterial(algorithm string, material []byte) (*SigningKey, error) {
+	if algorithm == AlgorithmHS256 {
+		return NewHMACSigningKey(string(material)), nil
+	}
+	return ParseSigningKey(algorithm, material)
+}
+
+// verificationKey returns the key used to verify signatures
+func (k *SigningKey) verificationKey() interface{} {
+	if k.IsSymmetric() {
+		return k.privateKey
+	}
+	return k.publicKey
+}
+
+// JWK represents a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet represents a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK returns the public part of the key as a JWK, or false for symmetric keys
+func (k *SigningKey) PublicJWK() (JWK, bool) {
+	jwk, err := publicKeyToJWK(k.publicKey)
+	if err != nil {
+		return JWK{}, false
+	}
+
+	jwk.Use = "sig"
+	jwk.Kid = k.ID
+	jwk.Alg = k.Algorithm
+	return jwk, true
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of the public key
+func (k *SigningKey) Thumbprint() (string, error) {
+	jwk, err := publicKeyToJWK(k.publicKey)
+	if err != nil {
+		return "", err
+	}
+	return JWKThumbprint(jwk)
+}
+
+// JWKThumbprint computes the RFC 7638 thumbprint of a public JWK
+func JWKThumbprint(jwk JWK) (string, error) {
+	// Required members only, in lexicographic order
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Crv, jwk.X)
+	default:
+		return "", ErrUnsupportedAlgorithm
+	}
+
+	hash := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// publicKeyToJWK converts a public key into its JWK representation
+func publicKeyToJWK(publicKey crypto.PublicKey) (JWK, error) {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		ecdhKey, err := pub.ECDH()
+		if err != nil {
+			return JWK{}, err
+		}
+		// Uncompressed point: 0x04 || X || Y
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+		return JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+			Y:   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	default:
+		return JWK{}, ErrInvalidKey
+	}
+}
+
+// jwkToPublicKey converts a public JWK into a key usable for verifying signatures
+func jwkToPublicKey(jwk JWK) (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		e, err := decode(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidKey
+		}
+		publicKey := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if publicKey.N.BitLen() < minRSAKeyBits {
+			return nil, ErrInvalidKey
+		}
+		return publicKey, nil
+	case "EC":
+		if jwk.Crv != elliptic.P256().Params().Name {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, ErrInvalidKey
+		}
+		// Parsing the uncompressed point rejects points off the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{0x04}, x...), y...)); err != nil {
+			return nil, ErrInvalidKey
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		x, err := decode(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidKey
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
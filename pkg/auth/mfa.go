@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidTOTPCode     = errors.New("invalid authentication code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+	ErrMFALocked           = errors.New("too many invalid authentication codes")
+)
+
+// Authentication method references recorded in the amr claim (RFC 8176)
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+)
+
+// TOTP parameters (RFC 6238); the defaults every authenticator app understands
+const (
+	TOTPPeriod = 30 * time.Second
+	TOTPDigits = 6
+	totpSkew   = 1 // periods accepted before and after the current one, for clock drift
+)
+
+// MFAChallengeTTL bounds how long a user has to complete the second factor of a login
+const MFAChallengeTTL = 5 * time.Minute
+
+// MaxMFAAttempts is the number of wrong codes after which an MFA challenge is discarded
+const MaxMFAAttempts = 5
+
+// MaxUserMFAFailures is the number of wrong second factors after which a user's second factor is refused
+// for MFAFailureWindow, however many challenges or sign-in pages the attempts were spread over
+const MaxUserMFAFailures = 10
+
+// MFAFailureWindow is how long the wrong second factors of a user are counted
+const MFAFailureWindow = 15 * time.Minute
+
+// RecoveryCodeCount is the number of recovery codes a user gets; each stands in for a second factor once
+const RecoveryCodeCount = 10
+
+// recoveryCodeAlphabet is Crockford's base32, which avoids letters easily mistaken for digits
+const recoveryCodeAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// MFAChallenge holds a login whose password was verified but whose second factor is pending
+type MFAChallenge struct {
+	UserID        uuid.UUID `json:"user_id"`
+	ApplicationID uuid.UUID `json:"application_id"`
+	Scope         string    `json:"scope,omitempty"`
+	Nonce         string    `json:"nonce,omitempty"`
+	Methods       []string  `json:"methods"` // authentication methods already completed
+	Attempts      int       `json:"-"`       // attempts made so far, counted in their own key
+	ExpiresAt     int64     `json:"expires_at"`
+}
+
+// AuthenticationMethods returns the amr claim for the given methods, adding mfa when more than one was used
+func AuthenticationMethods(methods ...string) []string {
+	if len(methods) > 1 {
+		return append(append([]string{}, methods...), AMRMFA)
+	}
+	return methods
+}
+
+// GenerateTOTPSecret creates a random TOTP secret, base32 encoded as authenticator apps expect
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI returns the otpauth URI authenticator apps enroll a secret from, usually shown as a QR code
+func TOTPProvisioningURI(issuer, accountName, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + accountName)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// GenerateRecoveryCodes creates a set of random recovery codes, formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		random := make([]byte, 10)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+
+		code := make([]byte, 0, 11)
+		for j, b := range random {
+			if j == 5 {
+				code = append(code, '-')
+			}
+			code = append(code, recoveryCodeAlphabet[b&0x1f])
+		}
+		codes = append(codes, string(code))
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage and lookup, ignoring case, separators and
+// the letters Crockford's base32 reads as digits. The hash is keyed, so stolen hashes can't be
+// brute forced without the key encryption key.
+func HashRecoveryCode(encryptor *Encryptor, code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "", "i", "1", "l", "1", "o", "0").Replace(strings.ToLower(code))
+	return hex.EncodeToString(encryptor.MAC([]byte(normalized)))
+}
+
+// totpCode computes the code of a secret for a time step (RFC 4226 section 5.3)
+func totpCode(secret []byte, step uint64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo)
+}
+
+// matchTOTP returns the time step a code is valid for around now, accepting the configured clock skew
+func matchTOTP(secret, code string, now time.Time) (uint64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := uint64(now.Unix()) / uint64(TOTPPeriod.Seconds())
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		step := current + uint64(offset)
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// getTOTPStepKey generates cache key for the TOTP time steps a user already authenticated with
+func (s *SessionService) getTOTPStepKey(userID uuid.UUID, step uint64) string {
+	return fmt.Sprintf("totp_used:%s:%d", userID.String(), step)
+}
+
+// getMFAChallengeKey generates cache key for MFA challenges
+func (s *SessionService) getMFAChallengeKey(challengeHash string) string {
+	return fmt.Sprintf("mfa_challenge:%s", challengeHash)
+}
+
+// getUserMFAFailuresKey generates cache key for the number of wrong second factors of a user
+func (s *SessionService) getUserMFAFailuresKey(userID uuid.UUID) string {
+	return fmt.Sprintf("mfa_failures:%s", userID.String())
+}
+
+// getMFAAttemptsKey generates cache key for the number of attempts at an MFA challenge
+func (s *SessionService) getMFAAttemptsKey(challengeHash string) string {
+	return fmt.Sprintf("mfa_attempts:%s", challengeHash)
+}
+
+// VerifyTOTP checks a user's TOTP code against their secret. Each code is accepted only once, so a code
+// seen by someone else can't be replayed within its validity window.
+func (s *SessionService) VerifyTOTP(ctx context.Context, userID uuid.UUID, secret, code string) error {
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	window := int((2*totpSkew + 1) * TOTPPeriod.Seconds())
+	fresh, err := s.cache.SetNX(ctx, s.getTOTPStepKey(userID, step), "1", window)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidTOTPCode
+	}
+
+	return nil
+}
+
+// StartMFAChallenge stores a login awaiting its second factor and returns the challenge token, which is returned only here
+func (s *SessionService) StartMFAChallenge(ctx context.Context, challenge *MFAChallenge) (string, error) {
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	challenge.ExpiresAt = time.Now().Add(MFAChallengeTTL).Unix()
+	if err := s.storeMFAChallenge(ctx, s.hashToken(token), challenge); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// storeMFAChallenge saves an MFA challenge until it expires
+func (s *SessionService) storeMFAChallenge(ctx context.Context, challengeHash string, challenge *MFAChallenge) error {
+	ttl := int(challenge.ExpiresAt - time.Now().Unix())
+	if ttl <= 0 {
+		return ErrInvalidMFAChallenge
+	}
+
+	challengeJSON, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Set(ctx, s.getMFAChallengeKey(challengeHash), string(challengeJSON), ttl)
+}
+
+// GetMFAChallenge looks up a pending MFA challenge by its token
+func (s *SessionService) GetMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	challengeJSON, err := s.cache.Get(ctx, s.getMFAChallengeKey(s.hashToken(token)))
+	if err != nil || challengeJSON == "" {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	var challenge MFAChallenge
+	if err := json.Unmarshal([]byte(challengeJSON), &challenge); err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	return &challenge, nil
+}
+
+// AttemptMFAChallenge counts an attempt at a challenge before its second factor is checked, refusing it once
+// MaxMFAAttempts were made. Counting first keeps parallel attempts within the limit too.
+func (s *SessionService) AttemptMFAChallenge(ctx context.Context, token string, challenge *MFAChallenge) error {
+	challengeHash := s.hashToken(token)
+
+	ttl := int(challenge.ExpiresAt - time.Now().Unix())
+	if ttl <= 0 {
+		return ErrInvalidMFAChallenge
+	}
+
+	attempts, err := s.cache.Incr(ctx, s.getMFAAttemptsKey(challengeHash), ttl)
+	if err != nil {
+		return err
+	}
+	challenge.Attempts = int(attempts)
+
+	if attempts > MaxMFAAttempts {
+		s.cache.Delete(ctx, s.getMFAChallengeKey(challengeHash))
+		return ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+// AttemptUserMFA counts an attempt at a user's second factor before it is checked, refusing it with
+// ErrMFALocked once MaxUserMFAFailures attempts failed within MFAFailureWindow
+func (s *SessionService) AttemptUserMFA(ctx context.Context, userID uuid.UUID) error {
+	attempts, err := s.cache.Incr(ctx, s.getUserMFAFailuresKey(userID), int(MFAFailureWindow.Seconds()))
+	if err != nil {
+		return err
+	}
+	if attempts > MaxUserMFAFailures {
+		return ErrMFALocked
+	}
+	return nil
+}
+
+// ResetUserMFA clears the attempts counted by AttemptUserMFA once the user's second factor was verified
+func (s *SessionService) ResetUserMFA(ctx context.Context, userID uuid.UUID) error {
+	return s.cache.Delete(ctx, s.getUserMFAFailuresKey(userID))
+}
+
+// FailMFAChallenge records a wrong second factor counted by AttemptMFAChallenge, discarding the challenge
+// after MaxMFAAttempts
+func (s *SessionService) FailMFAChallenge(ctx context.Context, token string, challenge *MFAChallenge) error {
+	if challenge.Attempts < MaxMFAAttempts {
+		return nil
+	}
+
+	// The count is kept until the challenge would have expired, so attempts in flight stay refused
+	return s.cache.Delete(ctx, s.getMFAChallengeKey(s.hashToken(token)))
+}
+
+// CompleteMFAChallenge consumes a challenge once its second factor was verified; it succeeds only once per challenge
+func (s *SessionService) CompleteMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	challengeHash := s.hashToken(token)
+	challengeJSON, err := s.cache.GetDel(ctx, s.getMFAChallengeKey(challengeHash))
+	if err != nil || challengeJSON == "" {
+		return nil, ErrInvalidMFAChallenge
+	}
+	s.cache.Delete(ctx, s.getMFAAttemptsKey(challengeHash))
+
+	var challenge MFAChallenge
+	if err := json.Unmarshal([]byte(challengeJSON), &challenge); err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	return &challenge, nil
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors, "12345678901234567890", base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// RFC 6238 Appendix B (SHA-1); six digit codes are the last six digits of the eight digit ones
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		step := uint64(tt.unix) / uint64(TOTPPeriod.Seconds())
+		if code := totpCode([]byte("12345678901234567890"), step); code != tt.code {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, code, tt.code)
+		}
+
+		if _, ok := matchTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0)); !ok {
+			t.Errorf("T=%d: code %s not accepted", tt.unix, tt.code)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	issued := time.Unix(1111111109, 0)
+	const code = "081804"
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"same period", issued, true},
+		{"one period late", issued.Add(TOTPPeriod), true},
+		{"one period early", issued.Add(-TOTPPeriod), true},
+		{"two periods late", issued.Add(2 * TOTPPeriod), false},
+		{"two periods early", issued.Add(-2 * TOTPPeriod), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(rfc6238Secret, code, tt.at)
+			if ok != tt.want {
+				t.Fatalf("got %v, want %v", ok, tt.want)
+			}
+			if ok && step != uint64(issued.Unix())/uint64(TOTPPeriod.Seconds()) {
+				t.Fatalf("matched step %d, want the step the code was issued for", step)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPInput(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		want   bool
+	}{
+		{"spaces ignored", rfc6238Secret, "081 804", true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "081804", true},
+		{"wrong code", rfc6238Secret, "081805", false},
+		{"too short", rfc6238Secret, "81804", false},
+		{"eight digits", rfc6238Secret, "07081804", false},
+		{"invalid secret", "not base32!", "081804", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := matchTOTP(tt.secret, tt.code, at); ok != tt.want {
+				t.Fatalf("got %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTOTPRejectsReuse(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	// Stay clear of a period boundary, past which the previous period's code would fall out of the window
+	period := int64(TOTPPeriod.Seconds())
+	if remaining := period - time.Now().Unix()%period; remaining < 2 {
+		time.Sleep(time.Duration(remaining) * time.Second)
+	}
+	current := uint64(time.Now().Unix()) / uint64(period)
+	code := totpCode(key, current)
+
+	if err := s.VerifyTOTP(ctx, userID, secret, code); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := s.VerifyTOTP(ctx, userID, secret, code); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("reuse: got %v, want ErrInvalidTOTPCode", err)
+	}
+
+	// The code of the previous period is still within the skew window, once
+	previous := totpCode(key, current-1)
+	if err := s.VerifyTOTP(ctx, userID, secret, previous); err != nil {
+		t.Fatalf("previous period: %v", err)
+	}
+	if err := s.VerifyTOTP(ctx, userID, secret, previous); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("previous period reuse: got %v, want ErrInvalidTOTPCode", err)
+	}
+
+	// Codes are tracked per user
+	if err := s.VerifyTOTP(ctx, uuid.New(), secret, code); err != nil {
+		t.Fatalf("another user: %v", err)
+	}
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+
+	token, err := s.StartMFAChallenge(ctx, &MFAChallenge{UserID: uuid.New(), ApplicationID: uuid.New(), Methods: []string{AMRPassword}})
+	if err != nil {
+		t.Fatalf("StartMFAChallenge: %v", err)
+	}
+
+	// Parallel attempts all read the challenge before any of them fails
+	var challenges []*MFAChallenge
+	for i := 0; i < 3*MaxMFAAttempts; i++ {
+		challenge, err := s.GetMFAChallenge(ctx, token)
+		if err != nil {
+			t.Fatalf("GetMFAChallenge: %v", err)
+		}
+		challenges = append(challenges, challenge)
+	}
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for _, challenge := range challenges {
+		wg.Add(1)
+		go func(challenge *MFAChallenge) {
+			defer wg.Done()
+			if err := s.AttemptMFAChallenge(ctx, token, challenge); err != nil {
+				return
+			}
+			mu.Lock()
+			allowed++
+			mu.Unlock()
+			if err := s.FailMFAChallenge(ctx, token, challenge); err != nil {
+				t.Errorf("FailMFAChallenge: %v", err)
+			}
+		}(challenge)
+	}
+	wg.Wait()
+
+	if allowed != MaxMFAAttempts {
+		t.Fatalf("%d attempts checked, want %d", allowed, MaxMFAAttempts)
+	}
+	if _, err := s.GetMFAChallenge(ctx, token); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("challenge kept after %d wrong codes: %v", MaxMFAAttempts, err)
+	}
+}
+
+func TestMFAChallengeCompletesOnce(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+
+	token, err := s.StartMFAChallenge(ctx, &MFAChallenge{UserID: uuid.New(), ApplicationID: uuid.New(), Methods: []string{AMRPassword}})
+	if err != nil {
+		t.Fatalf("StartMFAChallenge: %v", err)
+	}
+	challenge, _ := s.GetMFAChallenge(ctx, token)
+
+	// A wrong code below the limit keeps the challenge
+	if err := s.AttemptMFAChallenge(ctx, token, challenge); err != nil {
+		t.Fatalf("AttemptMFAChallenge: %v", err)
+	}
+	if err := s.FailMFAChallenge(ctx, token, challenge); err != nil {
+		t.Fatalf("FailMFAChallenge: %v", err)
+	}
+	if err := s.AttemptMFAChallenge(ctx, token, challenge); err != nil || challenge.Attempts != 2 {
+		t.Fatalf("second attempt: %v after %d attempts", err, challenge.Attempts)
+	}
+
+	if _, err := s.CompleteMFAChallenge(ctx, token); err != nil {
+		t.Fatalf("CompleteMFAChallenge: %v", err)
+	}
+	if _, err := s.CompleteMFAChallenge(ctx, token); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("challenge completed twice: %v", err)
+	}
+}
+
+func TestUserMFALimit(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	for i := 0; i < MaxUserMFAFailures; i++ {
+		if err := s.AttemptUserMFA(ctx, userID); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	if err := s.AttemptUserMFA(ctx, userID); !errors.Is(err, ErrMFALocked) {
+		t.Fatalf("got %v after %d failures, want ErrMFALocked", err, MaxUserMFAFailures)
+	}
+
+	// Other users are not affected, and a verified second factor starts the count again
+	if err := s.AttemptUserMFA(ctx, uuid.New()); err != nil {
+		t.Fatalf("another user: %v", err)
+	}
+	if err := s.ResetUserMFA(ctx, userID); err != nil {
+		t.Fatalf("ResetUserMFA: %v", err)
+	}
+	if err := s.AttemptUserMFA(ctx, userID); err != nil {
+		t.Fatalf("after reset: %v", err)
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	encryptor, err := NewEncryptor("test-key")
+	if err != nil {
+		t.Fatalf("NewEncryptor: %v", err)
+	}
+
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	hash := HashRecoveryCode(encryptor, "abcde-1o2l3")
+
+	// Typed differently, the same code has the same hash
+	for _, typed := range []string{"ABCDE-1O2L3", "abcde1o2l3", " abcde 10213 ", "abcde-i0213"} {
+		if got := HashRecoveryCode(encryptor, typed); got != hash {
+			t.Errorf("hash of %q differs from the hash of abcde-1o2l3", typed)
+		}
+	}
+
+	for _, code := range codes {
+		if HashRecoveryCode(encryptor, code) == hash {
+			t.Errorf("generated code %q hashes like abcde-1o2l3", code)
+		}
+	}
+
+	// The hash depends on the key, and is not a plain digest of the code
+	other, _ := NewEncryptor("other-key")
+	if HashRecoveryCode(other, "abcde-1o2l3") == hash {
+		t.Error("hash doesn't depend on the key")
+	}
+	digest := sha256.Sum256([]byte("abcde10213"))
+	if hash == hex.EncodeToString(digest[:]) {
+		t.Error("hash is an unkeyed SHA-256 digest")
+	}
+}
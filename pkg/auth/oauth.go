@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidAuthorizationCode = errors.New("invalid or expired authorization code")
+	ErrInvalidCodeVerifier      = errors.New("invalid code verifier")
+)
+
+// PKCEMethodS256 is the only supported PKCE code challenge method
+const PKCEMethodS256 = "S256"
+
+// AuthorizationCodeTTL bounds how long an authorization code can be redeemed
+const AuthorizationCodeTTL = 60 * time.Second
+
+// AuthorizationCode holds the context an authorization code was issued in
+type AuthorizationCode struct {
+	ClientID            uuid.UUID `json:"client_id"`
+	UserID              uuid.UUID `json:"user_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scope               string    `json:"scope,omitempty"`
+	Nonce               string    `json:"nonce,omitempty"`
+	CodeChallenge       string    `json:"code_challenge"`
+	CodeChallengeMethod string    `json:"code_challenge_method"`
+	AuthTime            time.Time `json:"auth_time"`
+	AMR                 []string  `json:"amr,omitempty"`       // how the user signed in
+	ClientIP            string    `json:"client_ip,omitempty"` // where the user signed in, recorded on the session
+	UserAgent           string    `json:"user_agent,omitempty"`
+}
+
+// GenerateClientToken creates an access token for an application acting as a service principal.
+// The token has no user; its subject is the client application and its audience the target application,
+// whose policy sets the token lifetime.
+func (j *JWTService) GenerateClientToken(clientID, audienceID uuid.UUID, permissions []string, policy *SessionPolicy) (string, *Claims, error) {
+	now := time.Now()
+	accessTTL := j.accessTokenExpiry
+	if policy != nil && policy.AccessTokenTTL > 0 {
+		accessTTL = policy.AccessTokenTTL
+	}
+
+	claims := &Claims{
+		ApplicationID: audienceID,
+		ClientID:      clientID,
+		TokenType:     AccessTokenType,
+		Permissions:   permissions,
+		Cnf:           policy.confirmation(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   clientID.String(),
+			Issuer:    j.issuer,
+			Audience:  []string{audienceID.String()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	tokenString, err := j.signClaims(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tokenString, claims, nil
+}
+
+// GenerateOpaqueToken creates a random URL-safe token
+func GenerateOpaqueToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// VerifyPKCE checks a code verifier against the challenge sent in the authorization request (RFC 7636)
+func VerifyPKCE(verifier, challenge, method string) error {
+	if method != PKCEMethodS256 || !isValidCodeVerifier(verifier) {
+		return ErrInvalidCodeVerifier
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) != 1 {
+		return ErrInvalidCodeVerifier
+	}
+
+	return nil
+}
+
+// isValidCodeVerifier checks the verifier length and character set from RFC 7636 section 4.1
+func isValidCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, r := range verifier {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '-' || r == '.' || r == '_' || r == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// getAuthorizationCodeKey generates cache key for authorization codes
+func (s *SessionService) getAuthorizationCodeKey(codeHash string) string {
+	return fmt.Sprintf("oauth_code:%s", codeHash)
+}
+
+// StoreAuthorizationCode issues a new authorization code for the given context
+func (s *SessionService) StoreAuthorizationCode(ctx context.Context, authCode *AuthorizationCode) (string, error) {
+	code, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	codeJSON, err := json.Marshal(authCode)
+	if err != nil {
+		return "", err
+	}
+
+	codeKey := s.getAuthorizationCodeKey(s.hashToken(code))
+	if err := s.cache.Set(ctx, codeKey, string(codeJSON), int(AuthorizationCodeTTL.Seconds())); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ConsumeAuthorizationCode redeems an authorization code; each code can be redeemed only once
+func (s *SessionService) ConsumeAuthorizationCode(ctx context.Context, code string) (*AuthorizationCode, error) {
+	codeKey := s.getAuthorizationCodeKey(s.hashToken(code))
+	codeJSON, err := s.cache.GetDel(ctx, codeKey)
+	if err != nil || codeJSON == "" {
+		return nil, ErrInvalidAuthorizationCode
+	}
+
+	var authCode AuthorizationCode
+	if err := json.Unmarshal([]byte(codeJSON), &authCode); err != nil {
+		return nil, ErrInvalidAuthorizationCode
+	}
+
+	return &authCode, nil
+}
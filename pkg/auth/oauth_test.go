@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	sum := sha256.Sum256([]byte(strings.Repeat("a", 43)))
+	shortestChallenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		method    string
+		wantErr   bool
+	}{
+		{"matching verifier", verifier, challenge, PKCEMethodS256, false},
+		{"shortest verifier", strings.Repeat("a", 43), shortestChallenge, PKCEMethodS256, false},
+		{"verifier mismatch", strings.Replace(verifier, "d", "e", 1), challenge, PKCEMethodS256, true},
+		{"challenge as verifier", challenge, challenge, PKCEMethodS256, true},
+		{"missing verifier", "", challenge, PKCEMethodS256, true},
+		{"plain method", verifier, verifier, "plain", true},
+		{"missing method", verifier, challenge, "", true},
+		{"verifier too short", strings.Repeat("a", 42), challenge, PKCEMethodS256, true},
+		{"verifier too long", strings.Repeat("a", 129), challenge, PKCEMethodS256, true},
+		{"verifier with invalid characters", verifier[:42] + "+", challenge, PKCEMethodS256, true},
+	}
+
+	for _, tt := range tests {
+		err := VerifyPKCE(tt.verifier, tt.challenge, tt.method)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: VerifyPKCE() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && err != ErrInvalidCodeVerifier {
+			t.Errorf("%s: VerifyPKCE() error = %v, want ErrInvalidCodeVerifier", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidPasswordResetToken reports an unknown, expired, used or superseded password reset token
+var ErrInvalidPasswordResetToken = errors.New("invalid or expired password reset token")
+
+// PasswordResetTTL bounds how long a password reset link can be used
+const PasswordResetTTL = 30 * time.Minute
+
+// getPasswordResetKey generates cache key for password reset tokens
+func (s *SessionService) getPasswordResetKey(tokenHash string) string {
+	return fmt.Sprintf("password_reset:%s", tokenHash)
+}
+
+// getUserPasswordResetKey generates cache key for the latest password reset token of a user
+func (s *SessionService) getUserPasswordResetKey(userID uuid.UUID) string {
+	return fmt.Sprintf("password_reset_user:%s", userID.String())
+}
+
+// IssuePasswordResetToken creates a single-use password reset token for a user. Only its hash is stored,
+// and only the latest token of a user is valid.
+func (s *SessionService) IssuePasswordResetToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	tokenHash := s.hashToken(token)
+	ttl := int(PasswordResetTTL.Seconds())
+	if err := s.cache.Set(ctx, s.getPasswordResetKey(tokenHash), userID.String(), ttl); err != nil {
+		return "", err
+	}
+	if err := s.cache.Set(ctx, s.getUserPasswordResetKey(userID), tokenHash, ttl); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumePasswordResetToken returns the user a password reset token was issued for; it succeeds only once per token
+func (s *SessionService) ConsumePasswordResetToken(ctx context.Context, token string) (uuid.UUID, error) {
+	tokenHash := s.hashToken(token)
+
+	storedUserID, err := s.cache.GetDel(ctx, s.getPasswordResetKey(tokenHash))
+	if err != nil || storedUserID == "" {
+		return uuid.Nil, ErrInvalidPasswordResetToken
+	}
+
+	userID, err := uuid.Parse(storedUserID)
+	if err != nil {
+		return uuid.Nil, ErrInvalidPasswordResetToken
+	}
+
+	// A newer request replaces the links sent before it; a superseded link leaves the newer one valid
+	latest, err := s.cache.DeleteIfEqual(ctx, s.getUserPasswordResetKey(userID), tokenHash)
+	if err != nil || !latest {
+		return uuid.Nil, ErrInvalidPasswordResetToken
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestConsumePasswordResetToken(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	older, err := s.IssuePasswordResetToken(ctx, userID)
+	if err != nil {
+		t.Fatalf("IssuePasswordResetToken: %v", err)
+	}
+	latest, err := s.IssuePasswordResetToken(ctx, userID)
+	if err != nil {
+		t.Fatalf("IssuePasswordResetToken: %v", err)
+	}
+
+	// The older link is superseded, and using it leaves the latest one valid
+	if _, err := s.ConsumePasswordResetToken(ctx, older); !errors.Is(err, ErrInvalidPasswordResetToken) {
+		t.Fatalf("superseded token: got %v, want ErrInvalidPasswordResetToken", err)
+	}
+	got, err := s.ConsumePasswordResetToken(ctx, latest)
+	if err != nil || got != userID {
+		t.Fatalf("latest token: got %s, %v, want %s", got, err, userID)
+	}
+
+	if _, err := s.ConsumePasswordResetToken(ctx, latest); !errors.Is(err, ErrInvalidPasswordResetToken) {
+		t.Fatalf("token used twice: %v", err)
+	}
+	if _, err := s.ConsumePasswordResetToken(ctx, "unknown"); !errors.Is(err, ErrInvalidPasswordResetToken) {
+		t.Fatalf("unknown token: %v", err)
+	}
+}
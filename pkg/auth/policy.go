@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrSessionExpired = errors.New("session has expired")
+
+// SessionPolicy holds the token lifetimes and session limits of an application, and the DPoP key and
+// authentication methods of the request the tokens are issued for. Zero values fall back to the service
+// defaults (or no limit).
+type SessionPolicy struct {
+	AccessTokenTTL        time.Duration
+	RefreshTokenTTL       time.Duration
+	AbsoluteLifetime      time.Duration // maximum session age, regardless of refreshes
+	IdleTimeout           time.Duration // maximum time between uses of a session
+	MaxConcurrentSessions int           // per user in the application
+	OpaqueAccessTokens    bool          // issue opaque reference access tokens instead of JWTs
+	DPoPKeyThumbprint     string        // bind issued tokens to this DPoP key (RFC 9449); empty issues bearer tokens
+	AuthenticationMethods []string      // amr of the issued tokens; refreshed tokens keep those of their refresh token
+}
+
+// stamp records the DPoP binding and authentication methods of the policy in the claims of a token
+func (p *SessionPolicy) stamp(claims *Claims) {
+	if p == nil {
+		return
+	}
+	claims.Cnf = p.confirmation()
+	claims.AMR = p.AuthenticationMethods
+}
+
+// tokenExpiries computes when the access and refresh tokens issued now within a session started at authTime expire
+func (p *SessionPolicy) tokenExpiries(now, authTime time.Time, defaultAccessTTL, defaultRefreshTTL time.Duration) (time.Time, time.Time, error) {
+	accessTTL, refreshTTL := defaultAccessTTL, defaultRefreshTTL
+	var deadline time.Time
+	if p != nil {
+		if p.AccessTokenTTL > 0 {
+			accessTTL = p.AccessTokenTTL
+		}
+		if p.RefreshTokenTTL > 0 {
+			refreshTTL = p.RefreshTokenTTL
+		}
+		if p.AbsoluteLifetime > 0 {
+			deadline = authTime.Add(p.AbsoluteLifetime)
+		}
+	}
+
+	accessExpiresAt := now.Add(accessTTL)
+	refreshExpiresAt := now.Add(refreshTTL)
+
+	// No token may outlive the session
+	if !deadline.IsZero() {
+		if !deadline.After(now) {
+			return time.Time{}, time.Time{}, ErrSessionExpired
+		}
+		if accessExpiresAt.After(deadline) {
+			accessExpiresAt = deadline
+		}
+		if refreshExpiresAt.After(deadline) {
+			refreshExpiresAt = deadline
+		}
+	}
+
+	return accessExpiresAt, refreshExpiresAt, nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ReferenceTokenStore looks up the claims of opaque access tokens persisted outside the cache,
+// so they stay valid when their cache entry is lost
+type ReferenceTokenStore interface {
+	LookupReferenceToken(ctx context.Context, tokenHash string) (*Claims, error)
+}
+
+// SetReferenceTokenStore enables resolving opaque access tokens missing from the cache
+func (s *SessionService) SetReferenceTokenStore(store ReferenceTokenStore) {
+	s.referenceTokens = store
+}
+
+// IsReferenceToken reports whether a token is an opaque reference rather than a signed JWT
+func IsReferenceToken(token string) bool {
+	return token != "" && !strings.Contains(token, ".")
+}
+
+// referenceAccessToken returns an opaque reference to issue instead of a signed access token when
+// the policy asks for one. The claims then exist only in the cache and the database.
+func referenceAccessToken(policy *SessionPolicy, signedToken string) (string, error) {
+	if policy == nil || !policy.OpaqueAccessTokens {
+		return signedToken, nil
+	}
+	return GenerateOpaqueToken()
+}
+
+// lookupReferenceToken resolves an opaque access token from the cache, falling back to the store
+func (s *SessionService) lookupReferenceToken(ctx context.Context, tokenHash string) (*Claims, error) {
+	if sessionData, ok := s.getSessionData(ctx, tokenHash); ok {
+		if !time.Now().Before(sessionData.ExpiresAt) {
+			return nil, ErrExpiredToken
+		}
+		return sessionData.claims(s.jwtService.issuer), nil
+	}
+
+	if s.referenceTokens == nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims, err := s.referenceTokens.LookupReferenceToken(ctx, tokenHash)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt == nil || !time.Now().Before(claims.ExpiresAt.Time) {
+		return nil, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+// parseToken verifies a signed token, or resolves an opaque access token from its stored claims
+func (s *SessionService) parseToken(ctx context.Context, token string) (*Claims, error) {
+	if IsReferenceToken(token) {
+		return s.lookupReferenceToken(ctx, s.hashToken(token))
+	}
+	return s.jwtService.ValidateToken(token)
+}
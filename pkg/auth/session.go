@@ -9,31 +9,95 @@ import (
 	"time"
 
 	"github.com/efrenfuentes/authy/internal/cache"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 // SessionService manages user sessions with JWT and cache
 type SessionService struct {
-	cache      *cache.Client
-	jwtService *JWTService
+	cache           cache.Store
+	jwtService      *JWTService
+	idleTimeout     time.Duration // default for applications without their own; 0 disables
+	maxSessionAge   time.Duration // default for applications without their own; 0 disables
+	permissions     PermissionResolver
+	referenceTokens ReferenceTokenStore
+}
+
+// PermissionResolver looks up the current permission version and permissions of a user in an application
+type PermissionResolver interface {
+	CurrentVersion(ctx context.Context, userID, applicationID uuid.UUID) (int64, error)
+	ResolvePermissions(ctx context.Context, userID, applicationID uuid.UUID) ([]string, error)
 }
 
 // NewSessionService creates a new session service
-func NewSessionService(cache *cache.Client, jwtService *JWTService) *SessionService {
+func NewSessionService(cache cache.Store, jwtService *JWTService, idleTimeout, maxSessionAge time.Duration) *SessionService {
 	return &SessionService{
-		cache:      cache,
-		jwtService: jwtService,
+		cache:         cache,
+		jwtService:    jwtService,
+		idleTimeout:   idleTimeout,
+		maxSessionAge: maxSessionAge,
 	}
 }
 
+// SetPermissionResolver enables permission versioning: access tokens are stamped with the user's
+// permission version and re-resolved on validation once it changes
+func (s *SessionService) SetPermissionResolver(resolver PermissionResolver) {
+	s.permissions = resolver
+}
+
+// sessionActivity represents the cached activity of a session (refresh token family)
+type sessionActivity struct {
+	LastUsedAt  int64 `json:"last_used_at"`
+	IdleTimeout int64 `json:"idle_timeout,omitempty"` // seconds; 0 disables the idle check
+	ExpiresAt   int64 `json:"expires_at"`             // when the session's current refresh token expires
+}
+
 // SessionData represents cached session information
 type SessionData struct {
-	UserID        uuid.UUID `json:"user_id"`
-	ApplicationID uuid.UUID `json:"application_id"`
-	TokenType     TokenType `json:"token_type"`
-	Permissions   []string  `json:"permissions,omitempty"`
-	IssuedAt      time.Time `json:"issued_at"`
-	ExpiresAt     time.Time `json:"expires_at"`
+	UserID            uuid.UUID     `json:"user_id"`
+	ApplicationID     uuid.UUID     `json:"application_id"`
+	ClientID          uuid.UUID     `json:"client_id"`
+	SessionID         uuid.UUID     `json:"session_id"`
+	TokenType         TokenType     `json:"token_type"`
+	Permissions       []string      `json:"permissions,omitempty"`
+	PermissionVersion int64         `json:"permission_version,omitempty"`
+	Act               *Actor        `json:"act,omitempty"`
+	Cnf               *Confirmation `json:"cnf,omitempty"`
+	AMR               []string      `json:"amr,omitempty"`
+	TokenID           string        `json:"jti,omitempty"`
+	Subject           string        `json:"sub,omitempty"`
+	AuthTime          *time.Time    `json:"auth_time,omitempty"`
+	IssuedAt          time.Time     `json:"issued_at"`
+	ExpiresAt         time.Time     `json:"expires_at"`
+}
+
+// claims reconstructs the claims of the token the session data was cached for
+func (d *SessionData) claims(issuer string) *Claims {
+	claims := &Claims{
+		UserID:            d.UserID,
+		ApplicationID:     d.ApplicationID,
+		ClientID:          d.ClientID,
+		SessionID:         d.SessionID,
+		TokenType:         d.TokenType,
+		Permissions:       d.Permissions,
+		PermissionVersion: d.PermissionVersion,
+		Act:               d.Act,
+		Cnf:               d.Cnf,
+		AMR:               d.AMR,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        d.TokenID,
+			Subject:   d.Subject,
+			Issuer:    issuer,
+			Audience:  []string{d.ApplicationID.String()},
+			IssuedAt:  jwt.NewNumericDate(d.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(d.ExpiresAt),
+			NotBefore: jwt.NewNumericDate(d.IssuedAt),
+		},
+	}
+	if d.AuthTime != nil {
+		claims.AuthTime = jwt.NewNumericDate(*d.AuthTime)
+	}
+	return claims
 }
 
 // hashToken creates a SHA-256 hash of the token for cache keys
@@ -52,22 +116,49 @@ func (s *SessionService) getBlacklistKey(tokenHash string) string {
 	return fmt.Sprintf("blacklist:%s", tokenHash)
 }
 
-// getUserSessionsKey generates cache key for user's active sessions
+// getUserSessionsKey generates cache key for the sorted set of a user's active sessions in an application
 func (s *SessionService) getUserSessionsKey(userID, applicationID uuid.UUID) string {
+	return fmt.Sprintf("user_sessions_idx:%s:%s", userID.String(), applicationID.String())
+}
+
+// getLegacyUserSessionsKey generates cache key for the JSON list of a user's sessions in an application written by
+// earlier releases; it is read until the sessions in it expire
+func (s *SessionService) getLegacyUserSessionsKey(userID, applicationID uuid.UUID) string {
 	return fmt.Sprintf("user_sessions:%s:%s", userID.String(), applicationID.String())
 }
 
+// getUserTokensKey generates cache key for the sorted set of a user's tokens across all applications
+func (s *SessionService) getUserTokensKey(userID uuid.UUID) string {
+	return fmt.Sprintf("user_tokens_idx:%s", userID.String())
+}
+
+// getSessionActivityKey generates cache key for the last use of a session
+func (s *SessionService) getSessionActivityKey(sessionID uuid.UUID) string {
+	return fmt.Sprintf("session_activity:%s", sessionID.String())
+}
+
 // StoreToken stores a token in cache
 func (s *SessionService) StoreToken(ctx context.Context, token string, claims *Claims) error {
 	tokenHash := s.hashToken(token)
 	
 	sessionData := &SessionData{
-		UserID:        claims.UserID,
-		ApplicationID: claims.ApplicationID,
-		TokenType:     claims.TokenType,
-		Permissions:   claims.Permissions,
-		IssuedAt:      claims.IssuedAt.Time,
-		ExpiresAt:     claims.ExpiresAt.Time,
+		UserID:            claims.UserID,
+		ApplicationID:     claims.ApplicationID,
+		ClientID:          claims.ClientID,
+		SessionID:         claims.SessionID,
+		TokenType:         claims.TokenType,
+		Permissions:       claims.Permissions,
+		PermissionVersion: claims.PermissionVersion,
+		Act:               claims.Act,
+		Cnf:               claims.Cnf,
+		AMR:               claims.AMR,
+		TokenID:           claims.ID,
+		Subject:           claims.Subject,
+		IssuedAt:          claims.IssuedAt.Time,
+		ExpiresAt:         claims.ExpiresAt.Time,
+	}
+	if claims.AuthTime != nil {
+		sessionData.AuthTime = &claims.AuthTime.Time
 	}
 	
 	sessionJSON, err := json.Marshal(sessionData)
@@ -87,27 +178,47 @@ func (s *SessionService) StoreToken(ctx context.Context, token string, claims *C
 		return err
 	}
 	
-	// Add to user's active sessions list
-	userSessionsKey := s.getUserSessionsKey(claims.UserID, claims.ApplicationID)
-	activeSessionsJSON, _ := s.cache.Get(ctx, userSessionsKey)
-	
-	var activeSessions []string
-	if activeSessionsJSON != "" {
-		json.Unmarshal([]byte(activeSessionsJSON), &activeSessions)
+	// Service principal tokens belong to no user session
+	if claims.IsServicePrincipal() {
+		return nil
 	}
 	
-	// Add new token hash to active sessions
-	activeSessions = append(activeSessions, tokenHash)
-	activeSessionsData, _ := json.Marshal(activeSessions)
+	// Add to the user's active sessions in the application and to their index across all applications
+	if err := s.cache.IndexAdd(ctx, s.getUserSessionsKey(claims.UserID, claims.ApplicationID), tokenHash, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+	return s.cache.IndexAdd(ctx, s.getUserTokensKey(claims.UserID), tokenHash, claims.ExpiresAt.Time)
+}
+
+// getSessionData loads the cached session data of a token
+func (s *SessionService) getSessionData(ctx context.Context, tokenHash string) (*SessionData, bool) {
+	sessionJSON, err := s.cache.Get(ctx, s.getTokenKey(tokenHash))
+	if err != nil || sessionJSON == "" {
+		return nil, false
+	}
 	
-	// Store with the same TTL as the longest token
-	s.cache.Set(ctx, userSessionsKey, string(activeSessionsData), ttl)
+	var sessionData SessionData
+	if err := json.Unmarshal([]byte(sessionJSON), &sessionData); err != nil {
+		return nil, false
+	}
 	
-	return nil
+	return &sessionData, true
 }
 
 // ValidateToken validates a token using cache and JWT
 func (s *SessionService) ValidateToken(ctx context.Context, token string) (*Claims, error) {
+	return s.validateToken(ctx, token, uuid.Nil)
+}
+
+// ValidateTokenForAudience validates a token presented to the given application. A valid token
+// issued for another application fails with an *AudienceError.
+func (s *SessionService) ValidateTokenForAudience(ctx context.Context, token string, audience uuid.UUID) (*Claims, error) {
+	return s.validateToken(ctx, token, audience)
+}
+
+// validateToken validates a token using cache and JWT, checking its audience unless it is uuid.Nil.
+// Presenting a token to the wrong application doesn't count as session activity.
+func (s *SessionService) validateToken(ctx context.Context, token string, audience uuid.UUID) (*Claims, error) {
 	tokenHash := s.hashToken(token)
 	
 	// Check if token is blacklisted
@@ -116,32 +227,53 @@ func (s *SessionService) ValidateToken(ctx context.Context, token string) (*Clai
 		return nil, ErrInvalidToken
 	}
 	
-	// Check cache first for performance
+	// Check cache first for performance; opaque access tokens normally live only there
 	tokenKey := s.getTokenKey(tokenHash)
-	sessionJSON, err := s.cache.Get(ctx, tokenKey)
-	if err == nil && sessionJSON != "" {
-		var sessionData SessionData
-		if err := json.Unmarshal([]byte(sessionJSON), &sessionData); err == nil {
-			// Verify token hasn't expired
-			if time.Now().Before(sessionData.ExpiresAt) {
-				// Reconstruct claims from cached data
-				claims := &Claims{
-					UserID:        sessionData.UserID,
-					ApplicationID: sessionData.ApplicationID,
-					TokenType:     sessionData.TokenType,
-					Permissions:   sessionData.Permissions,
+	if sessionData, ok := s.getSessionData(ctx, tokenHash); ok && time.Now().Before(sessionData.ExpiresAt) {
+		// Reconstruct claims from cached data
+		claims := sessionData.claims(s.jwtService.issuer)
+		if audience != uuid.Nil && !claims.IsIntendedFor(audience) {
+			return nil, &AudienceError{Expected: audience, Claims: claims}
+		}
+		if err := s.TouchSession(ctx, claims.SessionID); err != nil {
+			return nil, err
+		}
+		
+		refreshed, err := s.refreshPermissions(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+		if refreshed {
+			// Keep the re-resolved permissions for the token's remaining lifetime
+			sessionData.Permissions = claims.Permissions
+			sessionData.PermissionVersion = claims.PermissionVersion
+			if updatedJSON, err := json.Marshal(sessionData); err == nil {
+				if ttl := int(time.Until(sessionData.ExpiresAt).Seconds()); ttl > 0 {
+					s.cache.Set(ctx, tokenKey, string(updatedJSON), ttl)
 				}
-				return claims, nil
 			}
 		}
+		return claims, nil
 	}
 	
-	// Fallback to JWT validation if not in cache or cache miss
-	claims, err := s.jwtService.ValidateToken(token)
+	// Fallback to JWT validation (or the reference token store) if not in cache or cache miss
+	claims, err := s.parseToken(ctx, token)
 	if err != nil {
 		return nil, err
 	}
 	
+	if audience != uuid.Nil && !claims.IsIntendedFor(audience) {
+		return nil, &AudienceError{Expected: audience, Claims: claims}
+	}
+	
+	if err := s.TouchSession(ctx, claims.SessionID); err != nil {
+		return nil, err
+	}
+	
+	if _, err := s.refreshPermissions(ctx, claims); err != nil {
+		return nil, err
+	}
+	
 	// Store valid token in cache for future requests
 	if err := s.StoreToken(ctx, token, claims); err != nil {
 		// Log error but don't fail validation
@@ -153,12 +285,30 @@ func (s *SessionService) ValidateToken(ctx context.Context, token string) (*Clai
 
 // InvalidateToken adds a token to the blacklist
 func (s *SessionService) InvalidateToken(ctx context.Context, token string) error {
-	tokenHash := s.hashToken(token)
+	// Blacklist until the token expires; lifetimes vary per application
+	if claims, err := s.parseToken(ctx, token); err == nil {
+		return s.InvalidateTokenHash(ctx, s.hashToken(token), claims.ExpiresAt.Time)
+	}
+	
+	// Unparseable tokens: blacklist for as long as any token could still be valid (tokens can't be un-blacklisted)
+	blacklistUntil := time.Now().Add(24 * time.Hour)
+	if refreshUntil := time.Now().Add(s.jwtService.GetTokenExpiry(RefreshTokenType)); refreshUntil.After(blacklistUntil) {
+		blacklistUntil = refreshUntil
+	}
+	
+	return s.InvalidateTokenHash(ctx, s.hashToken(token), blacklistUntil)
+}
+
+// InvalidateTokenHash blacklists a token by its SHA-256 hash until the token would have expired
+func (s *SessionService) InvalidateTokenHash(ctx context.Context, tokenHash string, expiresAt time.Time) error {
+	ttl := int(time.Until(expiresAt).Seconds())
+	if ttl <= 0 {
+		ttl = 1 // already expired; nothing left to protect
+	}
 	
 	// Add to blacklist
 	blacklistKey := s.getBlacklistKey(tokenHash)
-	// Set blacklist entry with long TTL (tokens can't be un-blacklisted)
-	if err := s.cache.Set(ctx, blacklistKey, "true", int(24*time.Hour.Seconds())); err != nil {
+	if err := s.cache.Set(ctx, blacklistKey, "true", ttl); err != nil {
 		return err
 	}
 	
@@ -172,18 +322,13 @@ func (s *SessionService) InvalidateToken(ctx context.Context, token string) erro
 // InvalidateUserTokensInApplication invalidates all tokens for a user in a specific application
 func (s *SessionService) InvalidateUserTokensInApplication(ctx context.Context, userID, applicationID uuid.UUID, tokenType TokenType) error {
 	userSessionsKey := s.getUserSessionsKey(userID, applicationID)
-	activeSessionsJSON, err := s.cache.Get(ctx, userSessionsKey)
+	activeSessions, err := s.userSessions(ctx, userID, applicationID)
 	if err != nil {
-		return nil // No active sessions
-	}
-	
-	var activeSessions []string
-	if err := json.Unmarshal([]byte(activeSessionsJSON), &activeSessions); err != nil {
 		return err
 	}
 	
 	// Invalidate each active session of the specified type
-	for _, tokenHash := range activeSessions {
+	for tokenHash := range activeSessions {
 		tokenKey := s.getTokenKey(tokenHash)
 		sessionJSON, err := s.cache.Get(ctx, tokenKey)
 		if err != nil {
@@ -205,26 +350,46 @@ func (s *SessionService) InvalidateUserTokensInApplication(ctx context.Context,
 	
 	// Clear the user sessions list
 	s.cache.Delete(ctx, userSessionsKey)
+	s.cache.Delete(ctx, s.getLegacyUserSessionsKey(userID, applicationID))
 	
 	return nil
 }
 
-// GetActiveSessionsCount returns the number of active sessions for a user in an application
-func (s *SessionService) GetActiveSessionsCount(ctx context.Context, userID, applicationID uuid.UUID) (int, error) {
-	userSessionsKey := s.getUserSessionsKey(userID, applicationID)
-	activeSessionsJSON, err := s.cache.Get(ctx, userSessionsKey)
+// userSessions returns the token hashes of a user's sessions in an application, including those still listed
+// under the legacy key, with their expiry when known
+func (s *SessionService) userSessions(ctx context.Context, userID, applicationID uuid.UUID) (map[string]time.Time, error) {
+	sessions, err := s.cache.IndexMembers(ctx, s.getUserSessionsKey(userID, applicationID))
 	if err != nil {
-		return 0, nil
+		return nil, err
 	}
 	
-	var activeSessions []string
-	if err := json.Unmarshal([]byte(activeSessionsJSON), &activeSessions); err != nil {
+	legacyJSON, err := s.cache.Get(ctx, s.getLegacyUserSessionsKey(userID, applicationID))
+	if err != nil || legacyJSON == "" {
+		return sessions, nil
+	}
+	var legacySessions []string
+	if err := json.Unmarshal([]byte(legacyJSON), &legacySessions); err != nil {
+		return sessions, nil
+	}
+	for _, tokenHash := range legacySessions {
+		if _, ok := sessions[tokenHash]; !ok {
+			sessions[tokenHash] = time.Time{}
+		}
+	}
+	
+	return sessions, nil
+}
+
+// GetActiveSessionsCount returns the number of active sessions for a user in an application
+func (s *SessionService) GetActiveSessionsCount(ctx context.Context, userID, applicationID uuid.UUID) (int, error) {
+	activeSessions, err := s.userSessions(ctx, userID, applicationID)
+	if err != nil {
 		return 0, err
 	}
 	
 	// Count only valid (non-expired, non-blacklisted) sessions
 	validCount := 0
-	for _, tokenHash := range activeSessions {
+	for tokenHash := range activeSessions {
 		tokenKey := s.getTokenKey(tokenHash)
 		sessionJSON, err := s.cache.Get(ctx, tokenKey)
 		if err == nil && sessionJSON != "" {
@@ -238,6 +403,138 @@ func (s *SessionService) GetActiveSessionsCount(ctx context.Context, userID, app
 	return validCount, nil
 }
 
+// effectivePolicy fills in the service-wide idle timeout and maximum session age an application doesn't set
+func (s *SessionService) effectivePolicy(policy *SessionPolicy) *SessionPolicy {
+	effective := SessionPolicy{}
+	if policy != nil {
+		effective = *policy
+	}
+	if effective.IdleTimeout <= 0 {
+		effective.IdleTimeout = s.idleTimeout
+	}
+	if effective.AbsoluteLifetime <= 0 {
+		effective.AbsoluteLifetime = s.maxSessionAge
+	}
+	return &effective
+}
+
+// getSessionActivity loads the activity record of a session
+func (s *SessionService) getSessionActivity(ctx context.Context, sessionID uuid.UUID) (*sessionActivity, bool) {
+	activityKey := s.getSessionActivityKey(sessionID)
+	activityJSON, err := s.cache.Get(ctx, activityKey)
+	if err != nil || activityJSON == "" {
+		return nil, false
+	}
+	
+	var activity sessionActivity
+	if err := json.Unmarshal([]byte(activityJSON), &activity); err != nil {
+		return nil, false
+	}
+	
+	return &activity, true
+}
+
+// storeSessionActivity saves the activity record of a session until its refresh token expires
+func (s *SessionService) storeSessionActivity(ctx context.Context, sessionID uuid.UUID, activity *sessionActivity) error {
+	ttl := int(activity.ExpiresAt - time.Now().Unix())
+	if ttl <= 0 {
+		return nil
+	}
+	
+	activityJSON, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	
+	activityKey := s.getSessionActivityKey(sessionID)
+	return s.cache.Set(ctx, activityKey, string(activityJSON), ttl)
+}
+
+// startSessionActivity records a session that was just issued or refreshed under the given policy
+func (s *SessionService) startSessionActivity(ctx context.Context, sessionID uuid.UUID, policy *SessionPolicy, expiresAt time.Time) error {
+	return s.storeSessionActivity(ctx, sessionID, &sessionActivity{
+		LastUsedAt:  time.Now().Unix(),
+		IdleTimeout: int64(policy.IdleTimeout.Seconds()),
+		ExpiresAt:   expiresAt.Unix(),
+	})
+}
+
+// checkSessionIdle returns ErrSessionExpired once a session has been idle for longer than its idle timeout
+func (s *SessionService) checkSessionIdle(ctx context.Context, sessionID uuid.UUID) (*sessionActivity, error) {
+	if sessionID == uuid.Nil {
+		return nil, nil // service principal and pre-session tokens
+	}
+	
+	activity, ok := s.getSessionActivity(ctx, sessionID)
+	if !ok {
+		return nil, nil // issued before activity tracking
+	}
+	
+	if activity.IdleTimeout > 0 && time.Now().Unix()-activity.LastUsedAt > activity.IdleTimeout {
+		return nil, ErrSessionExpired
+	}
+	
+	return activity, nil
+}
+
+// TouchSession checks a session against its idle timeout and records that it was just used
+func (s *SessionService) TouchSession(ctx context.Context, sessionID uuid.UUID) error {
+	activity, err := s.checkSessionIdle(ctx, sessionID)
+	if err != nil || activity == nil {
+		return err
+	}
+	
+	activity.LastUsedAt = time.Now().Unix()
+	return s.storeSessionActivity(ctx, sessionID, activity)
+}
+
+// GetSessionLastUsed returns when a token of the session was last used, if known
+func (s *SessionService) GetSessionLastUsed(ctx context.Context, sessionID uuid.UUID) (time.Time, bool) {
+	activity, ok := s.getSessionActivity(ctx, sessionID)
+	if !ok {
+		return time.Time{}, false
+	}
+	
+	return time.Unix(activity.LastUsedAt, 0), true
+}
+
+// currentPermissionVersion returns the permission version to stamp on a user's new access token, 0 when versioning is disabled
+func (s *SessionService) currentPermissionVersion(ctx context.Context, userID, applicationID uuid.UUID) (int64, error) {
+	if s.permissions == nil {
+		return 0, nil
+	}
+	return s.permissions.CurrentVersion(ctx, userID, applicationID)
+}
+
+// refreshPermissions replaces the permissions of a user access token issued before the user's permissions
+// last changed, reporting whether it did. Failing to look up the version fails validation rather than
+// trusting possibly revoked permissions. Exchanged tokens were down-scoped, so they are rejected instead.
+func (s *SessionService) refreshPermissions(ctx context.Context, claims *Claims) (bool, error) {
+	if s.permissions == nil || claims.TokenType != AccessTokenType || claims.IsServicePrincipal() {
+		return false, nil
+	}
+	
+	version, err := s.permissions.CurrentVersion(ctx, claims.UserID, claims.ApplicationID)
+	if err != nil {
+		return false, err
+	}
+	if version == claims.PermissionVersion {
+		return false, nil
+	}
+	if claims.Act != nil {
+		return false, ErrInvalidToken
+	}
+	
+	permissions, err := s.permissions.ResolvePermissions(ctx, claims.UserID, claims.ApplicationID)
+	if err != nil {
+		return false, err
+	}
+	
+	claims.Permissions = permissions
+	claims.PermissionVersion = version
+	return true, nil
+}
+
 // CleanupExpiredTokens removes expired tokens from cache
 func (s *SessionService) CleanupExpiredTokens(ctx context.Context) error {
 	// This would typically be run as a background job
@@ -246,51 +543,171 @@ func (s *SessionService) CleanupExpiredTokens(ctx context.Context) error {
 	return nil
 }
 
-// RefreshTokenPair creates new tokens and invalidates the old refresh token
-func (s *SessionService) RefreshTokenPair(ctx context.Context, refreshToken string, permissions []string) (*TokenPair, error) {
+// RefreshTokenPair creates new tokens and invalidates the old refresh token, applying the application's policy
+func (s *SessionService) RefreshTokenPair(ctx context.Context, refreshToken string, permissions []string, policy *SessionPolicy) (*TokenPair, *Claims, *Claims, error) {
 	// Validate the refresh token
 	claims, err := s.jwtService.ValidateRefreshToken(refreshToken)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	
 	// Check if refresh token is blacklisted
 	tokenHash := s.hashToken(refreshToken)
 	blacklistKey := s.getBlacklistKey(tokenHash)
 	if blacklisted, _ := s.cache.Get(ctx, blacklistKey); blacklisted != "" {
-		return nil, ErrInvalidToken
+		return nil, nil, nil, ErrInvalidToken
 	}
 	
-	// Invalidate the old refresh token
-	if err := s.InvalidateToken(ctx, refreshToken); err != nil {
-		return nil, err
+	// An idle session can't be revived by refreshing it
+	if _, err := s.checkSessionIdle(ctx, claims.SessionID); err != nil {
+		return nil, nil, nil, err
+	}
+	
+	// A bound refresh token can only be used with a proof of the same key
+	if claims.Cnf != nil && (policy == nil || policy.DPoPKeyThumbprint != claims.Cnf.JKT) {
+		return nil, nil, nil, ErrInvalidDPoPProof
 	}
 	
-	// Generate new token pair
-	tokenPair, accessClaims, refreshClaims, err := s.jwtService.GenerateTokenPair(
+	// Generate new token pair in the same refresh token family
+	sessionID := claims.SessionID
+	if sessionID == uuid.Nil {
+		sessionID = uuid.New() // issued before families were tracked
+	}
+	
+	// The session keeps its original start so the absolute lifetime can't be extended by refreshing
+	authTime := claims.IssuedAt.Time
+	if claims.AuthTime != nil {
+		authTime = claims.AuthTime.Time
+	}
+	
+	permissionVersion, err := s.currentPermissionVersion(ctx, claims.UserID, claims.ApplicationID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	
+	policy = s.effectivePolicy(policy)
+	policy.AuthenticationMethods = claims.AMR
+	tokenPair, accessClaims, refreshClaims, err := s.jwtService.generateTokenPair(
 		claims.UserID, 
 		claims.ApplicationID, 
+		sessionID,
 		permissions,
+		permissionVersion,
+		authTime,
+		policy,
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+	
+	if tokenPair.AccessToken, err = referenceAccessToken(policy, tokenPair.AccessToken); err != nil {
+		return nil, nil, nil, err
+	}
+	
+	if err := s.startSessionActivity(ctx, sessionID, policy, refreshClaims.ExpiresAt.Time); err != nil {
+		return nil, nil, nil, err
 	}
 	
 	// Store new tokens in cache
 	if err := s.StoreToken(ctx, tokenPair.AccessToken, accessClaims); err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	
 	if err := s.StoreToken(ctx, tokenPair.RefreshToken, refreshClaims); err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+	
+	// Invalidate the old refresh token only once the new pair is stored, so a failed refresh can be retried
+	if err := s.InvalidateTokenHash(ctx, tokenHash, claims.ExpiresAt.Time); err != nil {
+		return nil, nil, nil, err
+	}
+	
+	return tokenPair, accessClaims, refreshClaims, nil
+}
+
+// GenerateTokenPair creates access and refresh tokens for a new session and starts tracking its activity
+func (s *SessionService) GenerateTokenPair(ctx context.Context, userID, applicationID, sessionID uuid.UUID, permissions []string, policy *SessionPolicy) (*TokenPair, *Claims, *Claims, error) {
+	permissionVersion, err := s.currentPermissionVersion(ctx, userID, applicationID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	
+	policy = s.effectivePolicy(policy)
+	tokenPair, accessClaims, refreshClaims, err := s.jwtService.generateTokenPair(userID, applicationID, sessionID, permissions, permissionVersion, time.Now(), policy)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	
+	if tokenPair.AccessToken, err = referenceAccessToken(policy, tokenPair.AccessToken); err != nil {
+		return nil, nil, nil, err
+	}
+	
+	if err := s.startSessionActivity(ctx, sessionID, policy, refreshClaims.ExpiresAt.Time); err != nil {
+		return nil, nil, nil, err
 	}
 	
-	return tokenPair, nil
+	return tokenPair, accessClaims, refreshClaims, nil
+}
+
+// GenerateClientToken creates a service principal access token, opaque when the policy asks for it
+func (s *SessionService) GenerateClientToken(clientID, audienceID uuid.UUID, permissions []string, policy *SessionPolicy) (string, *Claims, error) {
+	token, claims, err := s.jwtService.GenerateClientToken(clientID, audienceID, permissions, policy)
+	if err != nil {
+		return "", nil, err
+	}
+	
+	if token, err = referenceAccessToken(policy, token); err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// GenerateIDToken creates an OpenID Connect id_token (wrapper for JWT service)
+func (s *SessionService) GenerateIDToken(identity *UserIdentity, applicationID uuid.UUID, nonce string, authTime time.Time, accessToken string) (string, error) {
+	return s.jwtService.GenerateIDToken(identity, applicationID, nonce, authTime, accessToken)
+}
+
+// Issuer returns the issuer identifier (wrapper for JWT service)
+func (s *SessionService) Issuer() string {
+	return s.jwtService.Issuer()
 }
 
-// GenerateTokenPair creates new access and refresh tokens (wrapper for JWT service)
-func (s *SessionService) GenerateTokenPair(userID, applicationID uuid.UUID, permissions []string) (*TokenPair, *Claims, *Claims, error) {
-	return s.jwtService.GenerateTokenPair(userID, applicationID, permissions)
+// InspectToken validates a token of any type and returns its complete claims, signed or stored,
+// without counting as session activity
+func (s *SessionService) InspectToken(ctx context.Context, token string) (*Claims, error) {
+	// Signed tokens are always parsed, so introspection reports exactly what was signed
+	tokenHash := s.hashToken(token)
+	blacklistKey := s.getBlacklistKey(tokenHash)
+	if blacklisted, _ := s.cache.Get(ctx, blacklistKey); blacklisted != "" {
+		return nil, ErrInvalidToken
+	}
+
+	return s.parseToken(ctx, token)
+}
+
+// IntrospectToken validates a token of any type presented by the given application and returns its
+// complete claims with up to date permissions. A valid token issued for another application fails with
+// an *AudienceError before the session is touched.
+func (s *SessionService) IntrospectToken(ctx context.Context, token string, audience uuid.UUID) (*Claims, error) {
+	claims, err := s.InspectToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	
+	if !claims.IsIntendedFor(audience) {
+		return nil, &AudienceError{Expected: audience, Claims: claims}
+	}
+	
+	// A resource server accepting the token counts as session activity
+	if err := s.TouchSession(ctx, claims.SessionID); err != nil {
+		return nil, err
+	}
+	
+	if _, err := s.refreshPermissions(ctx, claims); err != nil {
+		return nil, err
+	}
+	
+	return claims, nil
 }
 
 // ValidateRefreshToken validates a refresh token (wrapper for JWT service)
@@ -302,25 +719,35 @@ func (s *SessionService) ValidateRefreshToken(ctx context.Context, refreshToken
 		return nil, ErrInvalidToken
 	}
 	
-	return s.jwtService.ValidateRefreshToken(refreshToken)
+	claims, err := s.jwtService.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	
+	if _, err := s.checkSessionIdle(ctx, claims.SessionID); err != nil {
+		return nil, err
+	}
+	
+	return claims, nil
 }
 
-// InvalidateAllUserTokens invalidates all tokens for a user across all applications
+// InvalidateAllUserTokens blacklists every token in the user's global index, across all applications.
+// The index only knows tokens that passed through the cache; callers should also revoke the tokens
+// recorded in the database.
 func (s *SessionService) InvalidateAllUserTokens(ctx context.Context, userID uuid.UUID) error {
-	// This is a simplified implementation that iterates through known applications
-	// In a production system, you might want to maintain a global user sessions index
-	
-	// Get all applications to invalidate tokens across all of them
-	// For now, we'll use a pattern-based search or rely on the application
-	// calling InvalidateUserTokensInApplication for each application they know about
+	userTokensKey := s.getUserTokensKey(userID)
+	userTokens, err := s.cache.IndexMembers(ctx, userTokensKey)
+	if err != nil {
+		return err
+	}
 	
-	// We'll implement a basic version that tries to invalidate tokens for common token types
-	// This would need to be enhanced based on your specific cache key patterns
+	for tokenHash, expiresAt := range userTokens {
+		if err := s.InvalidateTokenHash(ctx, tokenHash, expiresAt); err != nil {
+			return err
+		}
+	}
 	
-	// Note: Since we don't have access to the database here, we'll rely on
-	// the calling code to handle application-specific invalidation
-	// This is mainly for the user deletion case where we want to ensure
-	// no tokens remain active
+	s.cache.Delete(ctx, userTokensKey)
 	
-	return nil // Placeholder - caller should handle app-specific invalidation
-}
\ No newline at end of file
+	return nil
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// issueTestTokenPair issues and stores a token pair for a new session of a user in an application
+func issueTestTokenPair(t *testing.T, s *SessionService, userID, applicationID uuid.UUID) (*TokenPair, *Claims, *Claims) {
+	t.Helper()
+
+	pair, accessClaims, refreshClaims, err := s.GenerateTokenPair(context.Background(), userID, applicationID, uuid.New(), []string{"documents:read"}, nil)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+	if err := s.StoreToken(context.Background(), pair.AccessToken, accessClaims); err != nil {
+		t.Fatalf("StoreToken: %v", err)
+	}
+	if err := s.StoreToken(context.Background(), pair.RefreshToken, refreshClaims); err != nil {
+		t.Fatalf("StoreToken: %v", err)
+	}
+	return pair, accessClaims, refreshClaims
+}
+
+func TestStoreTokenAlongsideLegacySessionList(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	userID, applicationID := uuid.New(), uuid.New()
+
+	// A session stored by an earlier release, listed in the JSON string the index used to be
+	legacy, _, _ := issueTestTokenPair(t, s, userID, applicationID)
+	s.cache.Delete(ctx, s.getUserSessionsKey(userID, applicationID))
+	legacyList, _ := json.Marshal([]string{s.hashToken(legacy.AccessToken)})
+	if err := s.cache.Set(ctx, s.getLegacyUserSessionsKey(userID, applicationID), string(legacyList), 3600); err != nil {
+		t.Fatalf("store legacy list: %v", err)
+	}
+
+	current, _, _ := issueTestTokenPair(t, s, userID, applicationID)
+
+	if count, err := s.GetActiveSessionsCount(ctx, userID, applicationID); err != nil || count != 3 {
+		t.Fatalf("got %d active sessions (%v), want the legacy access token and the new pair", count, err)
+	}
+
+	if err := s.InvalidateUserTokensInApplication(ctx, userID, applicationID, AccessTokenType); err != nil {
+		t.Fatalf("InvalidateUserTokensInApplication: %v", err)
+	}
+	for _, token := range []string{legacy.AccessToken, current.AccessToken} {
+		if _, err := s.ValidateToken(ctx, token); err == nil {
+			t.Fatal("access token still valid after its sessions were invalidated")
+		}
+	}
+	if _, err := s.cache.Get(ctx, s.getLegacyUserSessionsKey(userID, applicationID)); err == nil {
+		t.Fatal("legacy list kept after invalidation")
+	}
+}
+
+// testPermissionResolver reports a fixed permission version and permissions for every user
+type testPermissionResolver struct {
+	version     int64
+	permissions []string
+	err         error
+}
+
+func (r *testPermissionResolver) CurrentVersion(ctx context.Context, userID, applicationID uuid.UUID) (int64, error) {
+	return r.version, r.err
+}
+
+func (r *testPermissionResolver) ResolvePermissions(ctx context.Context, userID, applicationID uuid.UUID) ([]string, error) {
+	return r.permissions, r.err
+}
+
+func TestValidateTokenRefreshesStalePermissions(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	resolver := &testPermissionResolver{version: 1, permissions: []string{"documents:read"}}
+	s.SetPermissionResolver(resolver)
+
+	pair, accessClaims, _ := issueTestTokenPair(t, s, uuid.New(), uuid.New())
+	if accessClaims.PermissionVersion != 1 {
+		t.Fatalf("issued permission version %d, want 1", accessClaims.PermissionVersion)
+	}
+
+	// The user's roles change after the token was issued
+	resolver.version = 2
+	resolver.permissions = []string{"documents:read", "documents:write"}
+
+	// Once from the cached session data, once from the signed token
+	for _, fromCache := range []bool{true, false} {
+		if !fromCache {
+			s.cache.Delete(ctx, s.getTokenKey(s.hashToken(pair.AccessToken)))
+		}
+		claims, err := s.ValidateToken(ctx, pair.AccessToken)
+		if err != nil {
+			t.Fatalf("ValidateToken (cached %v): %v", fromCache, err)
+		}
+		if claims.PermissionVersion != 2 || len(claims.Permissions) != 2 || claims.Permissions[1] != "documents:write" {
+			t.Fatalf("cached %v: got permissions %v at version %d, want the current ones", fromCache, claims.Permissions, claims.PermissionVersion)
+		}
+	}
+
+	// The refreshed permissions are kept for the token's remaining lifetime
+	resolver.permissions = nil
+	claims, err := s.ValidateToken(ctx, pair.AccessToken)
+	if err != nil || len(claims.Permissions) != 2 {
+		t.Fatalf("got permissions %v (%v), want those refreshed at version 2", claims.Permissions, err)
+	}
+
+	// Without a current version the permissions can't be trusted
+	resolver.err = errors.New("database unavailable")
+	if _, err := s.ValidateToken(ctx, pair.AccessToken); err == nil {
+		t.Fatal("ValidateToken succeeded without the current permission version")
+	}
+}
+
+func TestValidateTokenRejectsStaleExchangedToken(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	resolver := &testPermissionResolver{version: 1, permissions: []string{"documents:read", "documents:write"}}
+	s.SetPermissionResolver(resolver)
+
+	token, _, err := s.ExchangeToken(ctx, &TokenExchange{
+		UserID:      uuid.New(),
+		AudienceID:  uuid.New(),
+		ClientID:    uuid.New(),
+		SessionID:   uuid.New(),
+		Permissions: []string{"documents:read"},
+		Actor:       &Actor{Subject: uuid.New().String()},
+		AuthTime:    time.Now(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+
+	if _, err := s.ValidateToken(ctx, token); err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+
+	// A down-scoped token can't be re-resolved to the user's full permissions
+	resolver.version = 2
+	if _, err := s.ValidateToken(ctx, token); err != ErrInvalidToken {
+		t.Fatalf("ValidateToken after permissions changed: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestValidateTokenRejectsOtherAudience(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	applicationID, otherApplicationID := uuid.New(), uuid.New()
+
+	pair, accessClaims, refreshClaims := issueTestTokenPair(t, s, uuid.New(), applicationID)
+
+	// The session was last used a while ago and must not look used by the rejected requests
+	lastUsed := time.Now().Add(-10 * time.Minute).Unix()
+	if err := s.storeSessionActivity(ctx, accessClaims.SessionID, &sessionActivity{
+		LastUsedAt:  lastUsed,
+		IdleTimeout: 3600,
+		ExpiresAt:   refreshClaims.ExpiresAt.Unix(),
+	}); err != nil {
+		t.Fatalf("storeSessionActivity: %v", err)
+	}
+
+	checkAudienceError := func(name string, err error) {
+		t.Helper()
+		var audienceErr *AudienceError
+		if !errors.As(err, &audienceErr) || !errors.Is(err, ErrInvalidAudience) {
+			t.Fatalf("%s: got %v, want an *AudienceError", name, err)
+		}
+		if audienceErr.Expected != otherApplicationID || audienceErr.Claims.ApplicationID != applicationID {
+			t.Fatalf("%s: audience error for %s with claims of %s", name, audienceErr.Expected, audienceErr.Claims.ApplicationID)
+		}
+	}
+
+	_, err := s.ValidateTokenForAudience(ctx, pair.AccessToken, otherApplicationID)
+	checkAudienceError("ValidateTokenForAudience (cached)", err)
+
+	s.cache.Delete(ctx, s.getTokenKey(s.hashToken(pair.AccessToken)))
+	_, err = s.ValidateTokenForAudience(ctx, pair.AccessToken, otherApplicationID)
+	checkAudienceError("ValidateTokenForAudience (signed)", err)
+
+	_, err = s.IntrospectToken(ctx, pair.RefreshToken, otherApplicationID)
+	checkAudienceError("IntrospectToken", err)
+
+	if used, ok := s.GetSessionLastUsed(ctx, accessClaims.SessionID); !ok || used.Unix() != lastUsed {
+		t.Fatalf("session last used at %v after rejected requests, want %v", used, time.Unix(lastUsed, 0))
+	}
+
+	// Presented to its own application the token is accepted and the session used
+	if _, err := s.ValidateTokenForAudience(ctx, pair.AccessToken, applicationID); err != nil {
+		t.Fatalf("ValidateTokenForAudience for its own application: %v", err)
+	}
+	if used, _ := s.GetSessionLastUsed(ctx, accessClaims.SessionID); used.Unix() == lastUsed {
+		t.Fatal("accepted request did not count as session activity")
+	}
+}
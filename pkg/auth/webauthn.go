@@ -0,0 +1,578 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidWebAuthnResponse = errors.New("invalid WebAuthn response")
+	ErrWebAuthnSignCount       = errors.New("WebAuthn sign count did not increase; the authenticator may be cloned")
+)
+
+// WebAuthnCeremonyTTL bounds how long a registration or login ceremony can be completed
+const WebAuthnCeremonyTTL = 5 * time.Minute
+
+// AMRHardwareKey is the amr of a login with a WebAuthn credential (RFC 8176)
+const AMRHardwareKey = "hwk"
+
+// COSE algorithms accepted for WebAuthn credential keys, in order of preference
+const (
+	coseAlgorithmES256 = -7
+	coseAlgorithmEdDSA = -8
+	coseAlgorithmRS256 = -257
+)
+
+// WebAuthn user verification requirements
+const (
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+// Flags of WebAuthn authenticator data
+const (
+	authenticatorUserPresent      = 0x01
+	authenticatorUserVerified     = 0x04
+	authenticatorBackupEligible   = 0x08
+	authenticatorAttestedCredData = 0x40
+)
+
+// RelyingParty identifies the site passkeys are registered for; a credential only works for its RP ID
+type RelyingParty struct {
+	ID      string   // registrable domain, e.g. example.com
+	Name    string   // shown by the authenticator
+	Origins []string // origins the ceremonies may run on, e.g. https://app.example.com
+}
+
+// WebAuthnUser identifies the user a credential is registered for
+type WebAuthnUser struct {
+	ID          uuid.UUID
+	Name        string
+	DisplayName string
+}
+
+// WebAuthnCredential holds what is kept of a registered credential
+type WebAuthnCredential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key
+	SignCount      uint32
+	Transports     []string
+	AAGUID         uuid.UUID // authenticator model, zero without attestation
+	BackupEligible bool      // synced passkey
+}
+
+// WebAuthnAssertion describes a verified login with a credential
+type WebAuthnAssertion struct {
+	CredentialID []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Base64URL is binary data encoded as unpadded base64url in JSON, as WebAuthn's JSON serialization uses
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// PublicKeyCredentialEntity names the relying party or user of a ceremony
+type PublicKeyCredentialEntity struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// PublicKeyCredentialUser names the user of a registration; its ID is the user handle
+type PublicKeyCredentialUser struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+// PublicKeyCredentialParameters names an accepted credential algorithm
+type PublicKeyCredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// PublicKeyCredentialDescriptor identifies a registered credential
+type PublicKeyCredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection states what authenticators a registration asks for
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// PublicKeyCredentialCreationOptions are passed to navigator.credentials.create, in WebAuthn's JSON form
+type PublicKeyCredentialCreationOptions struct {
+	RP                     PublicKeyCredentialEntity       `json:"rp"`
+	User                   PublicKeyCredentialUser         `json:"user"`
+	Challenge              string                          `json:"challenge"`
+	PubKeyCredParams       []PublicKeyCredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                           `json:"timeout"`
+	ExcludeCredentials     []PublicKeyCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection          `json:"authenticatorSelection"`
+	Attestation            string                          `json:"attestation"`
+}
+
+// PublicKeyCredentialRequestOptions are passed to navigator.credentials.get, in WebAuthn's JSON form
+type PublicKeyCredentialRequestOptions struct {
+	Challenge        string                          `json:"challenge"`
+	Timeout          int64                           `json:"timeout"`
+	RPID             string                          `json:"rpId"`
+	AllowCredentials []PublicKeyCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                          `json:"userVerification"`
+}
+
+// WebAuthnRegistrationResponse is the credential returned by navigator.credentials.create, in WebAuthn's JSON form
+type WebAuthnRegistrationResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		Transports        []string  `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// WebAuthnAssertionResponse is the credential returned by navigator.credentials.get, in WebAuthn's JSON form
+type WebAuthnAssertionResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// webAuthnCeremony holds a started registration or login until the browser answers it
+type webAuthnCeremony struct {
+	Type             string    `json:"type"` // clientData type the answer must have
+	RPID             string    `json:"rp_id"`
+	UserID           uuid.UUID `json:"user_id,omitempty"` // nil for logins with discoverable credentials
+	UserVerification string    `json:"user_verification"`
+	Binding          string    `json:"binding"` // hash of what the ceremony was started for
+}
+
+// collectedClientData represents the client data signed by the authenticator
+type collectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData represents the parsed authenticator data of a ceremony
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       uuid.UUID
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key, only in registrations
+}
+
+// getWebAuthnCeremonyKey generates cache key for WebAuthn ceremonies
+func (s *SessionService) getWebAuthnCeremonyKey(challenge string) string {
+	return fmt.Sprintf("webauthn_ceremony:%s", s.hashToken(challenge))
+}
+
+// BeginWebAuthnRegistration starts registering a credential for a user. Binding names what the
+// registration is for; finishing it requires the same binding.
+func (s *SessionService) BeginWebAuthnRegistration(ctx context.Context, rp *RelyingParty, user *WebAuthnUser, existing []WebAuthnCredential, binding string) (*PublicKeyCredentialCreationOptions, error) {
+	challenge, err := s.startWebAuthnCeremony(ctx, &webAuthnCeremony{
+		Type:             "webauthn.create",
+		RPID:             rp.ID,
+		UserID:           user.ID,
+		UserVerification: UserVerificationPreferred,
+		Binding:          s.hashToken(binding),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicKeyCredentialCreationOptions{
+		RP:        PublicKeyCredentialEntity{ID: rp.ID, Name: rp.Name},
+		User:      PublicKeyCredentialUser{ID: user.ID[:], Name: user.Name, DisplayName: user.DisplayName},
+		Challenge: challenge,
+		PubKeyCredParams: []PublicKeyCredentialParameters{
+			{Type: "public-key", Alg: coseAlgorithmES256},
+			{Type: "public-key", Alg: coseAlgorithmEdDSA},
+			{Type: "public-key", Alg: coseAlgorithmRS256},
+		},
+		Timeout:            WebAuthnCeremonyTTL.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred", // discoverable credentials allow passwordless login
+			UserVerification: UserVerificationPreferred,
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishWebAuthnRegistration verifies the browser's answer to a registration and returns the new credential.
+// Attestation is not requested, so attestation statements are not evaluated.
+func (s *SessionService) FinishWebAuthnRegistration(ctx context.Context, rp *RelyingParty, response *WebAuthnRegistrationResponse, binding string) (*WebAuthnCredential, uuid.UUID, error) {
+	if response.Type != "public-key" {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	ceremony, err := s.consumeWebAuthnCeremony(ctx, rp, response.Response.ClientDataJSON, binding)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if ceremony.Type != "webauthn.create" {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	attestation, _, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+	attestationMap, ok := attestation.(map[interface{}]interface{})
+	if !ok {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+	rawAuthData, ok := attestationMap["authData"].([]byte)
+	if !ok {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil || authData.PublicKey == nil {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+	if err := authData.verify(rp, ceremony.UserVerification); err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	// The credential ID reported by the browser must be the one the authenticator attested
+	if !bytes.Equal(authData.CredentialID, response.RawID) {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+	if _, _, err := parseCOSEKey(authData.PublicKey); err != nil {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	return &WebAuthnCredential{
+		ID:             authData.CredentialID,
+		PublicKey:      authData.PublicKey,
+		SignCount:      authData.SignCount,
+		Transports:     response.Response.Transports,
+		AAGUID:         authData.AAGUID,
+		BackupEligible: authData.Flags&authenticatorBackupEligible != 0,
+	}, ceremony.UserID, nil
+}
+
+// BeginWebAuthnLogin starts a login with one of the given credentials of a user, or with any discoverable
+// credential when there are none, which then requires user verification. Finishing it requires the same binding.
+func (s *SessionService) BeginWebAuthnLogin(ctx context.Context, rp *RelyingParty, userID uuid.UUID, allowed []WebAuthnCredential, binding string) (*PublicKeyCredentialRequestOptions, error) {
+	userVerification := UserVerificationPreferred
+	if len(allowed) == 0 {
+		userVerification = UserVerificationRequired
+	}
+
+	challenge, err := s.startWebAuthnCeremony(ctx, &webAuthnCeremony{
+		Type:             "webauthn.get",
+		RPID:             rp.ID,
+		UserID:           userID,
+		UserVerification: userVerification,
+		Binding:          s.hashToken(binding),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicKeyCredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          WebAuthnCeremonyTTL.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: credentialDescriptors(allowed),
+		UserVerification: userVerification,
+	}, nil
+}
+
+// FinishWebAuthnLogin verifies the browser's answer to a login. The lookup returns the stored credential
+// and its user; logins begun for a user only accept that user's credentials.
+func (s *SessionService) FinishWebAuthnLogin(ctx context.Context, rp *RelyingParty, response *WebAuthnAssertionResponse, binding string, lookup func(credentialID []byte) (*WebAuthnCredential, uuid.UUID, error)) (*WebAuthnAssertion, uuid.UUID, error) {
+	if response.Type != "public-key" {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	ceremony, err := s.consumeWebAuthnCeremony(ctx, rp, response.Response.ClientDataJSON, binding)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if ceremony.Type != "webauthn.get" {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	credential, userID, err := lookup(response.RawID)
+	if err != nil || credential == nil {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+	if ceremony.UserID != uuid.Nil && ceremony.UserID != userID {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+	if handle := response.Response.UserHandle; len(handle) > 0 && !bytes.Equal(handle, userID[:]) {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	authData, err := parseAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+	if err := authData.verify(rp, ceremony.UserVerification); err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	// The signature covers the authenticator data and the hash of the client data
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signed := append(append([]byte{}, response.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(credential.PublicKey, signed, response.Response.Signature); err != nil {
+		return nil, uuid.Nil, ErrInvalidWebAuthnResponse
+	}
+
+	// Authenticators that count signatures must count up; a lower count means a copy of the key is in use
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return nil, uuid.Nil, ErrWebAuthnSignCount
+	}
+
+	return &WebAuthnAssertion{
+		CredentialID: credential.ID,
+		SignCount:    authData.SignCount,
+		UserVerified: authData.Flags&authenticatorUserVerified != 0,
+	}, userID, nil
+}
+
+// startWebAuthnCeremony stores a ceremony under a new challenge and returns the challenge
+func (s *SessionService) startWebAuthnCeremony(ctx context.Context, ceremony *webAuthnCeremony) (string, error) {
+	challenge, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	ceremonyJSON, err := json.Marshal(ceremony)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.cache.Set(ctx, s.getWebAuthnCeremonyKey(challenge), string(ceremonyJSON), int(WebAuthnCeremonyTTL.Seconds())); err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// consumeWebAuthnCeremony checks the client data of an answer and consumes the ceremony its challenge belongs to
+func (s *SessionService) consumeWebAuthnCeremony(ctx context.Context, rp *RelyingParty, rawClientData []byte, binding string) (*webAuthnCeremony, error) {
+	var clientData collectedClientData
+	if err := json.Unmarshal(rawClientData, &clientData); err != nil || clientData.Challenge == "" {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	// Each challenge is answered once
+	ceremonyJSON, err := s.cache.GetDel(ctx, s.getWebAuthnCeremonyKey(clientData.Challenge))
+	if err != nil || ceremonyJSON == "" {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	var ceremony webAuthnCeremony
+	if err := json.Unmarshal([]byte(ceremonyJSON), &ceremony); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	if clientData.Type != ceremony.Type || ceremony.RPID != rp.ID ||
+		subtle.ConstantTimeCompare([]byte(ceremony.Binding), []byte(s.hashToken(binding))) != 1 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	if !rp.allowsOrigin(clientData.Origin) {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	return &ceremony, nil
+}
+
+// allowsOrigin reports whether a ceremony may run on the given origin
+func (rp *RelyingParty) allowsOrigin(origin string) bool {
+	for _, allowed := range rp.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks authenticator data against the relying party and the user verification requirement
+func (d *authenticatorData) verify(rp *RelyingParty, userVerification string) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(d.RPIDHash, rpIDHash[:]) != 1 {
+		return ErrInvalidWebAuthnResponse
+	}
+	if d.Flags&authenticatorUserPresent == 0 {
+		return ErrInvalidWebAuthnResponse
+	}
+	if userVerification == UserVerificationRequired && d.Flags&authenticatorUserVerified == 0 {
+		return ErrInvalidWebAuthnResponse
+	}
+	return nil
+}
+
+// parseAuthenticatorData parses authenticator data (WebAuthn section 6.1)
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	authData := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.Flags&authenticatorAttestedCredData == 0 {
+		return authData, nil
+	}
+
+	// Attested credential data: AAGUID, credential ID and the COSE public key
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	copy(authData.AAGUID[:], rest[:16])
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	authData.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	authData.PublicKey = rest[:len(rest)-len(extensions)]
+
+	return authData, nil
+}
+
+// parseCOSEKey converts a COSE_Key (RFC 9053) into a public key and its COSE algorithm
+func parseCOSEKey(coseKey []byte) (crypto.PublicKey, int64, error) {
+	decoded, rest, err := decodeCBOR(coseKey)
+	if err != nil || len(rest) != 0 {
+		return nil, 0, ErrInvalidKey
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrInvalidKey
+	}
+
+	keyType, _ := key[int64(1)].(int64)
+	algorithm, _ := key[int64(3)].(int64)
+	encode := func(label int64) string {
+		value, _ := key[label].([]byte)
+		return base64.RawURLEncoding.EncodeToString(value)
+	}
+
+	// The key is checked the same way as the JWKs of DPoP proofs
+	var jwk JWK
+	switch {
+	case keyType == 2 && algorithm == coseAlgorithmES256:
+		if curve, _ := key[int64(-1)].(int64); curve != 1 {
+			return nil, 0, ErrUnsupportedAlgorithm
+		}
+		jwk = JWK{Kty: "EC", Crv: "P-256", X: encode(-2), Y: encode(-3)}
+	case keyType == 1 && algorithm == coseAlgorithmEdDSA:
+		if curve, _ := key[int64(-1)].(int64); curve != 6 {
+			return nil, 0, ErrUnsupportedAlgorithm
+		}
+		jwk = JWK{Kty: "OKP", Crv: "Ed25519", X: encode(-2)}
+	case keyType == 3 && algorithm == coseAlgorithmRS256:
+		jwk = JWK{Kty: "RSA", N: encode(-1), E: encode(-2)}
+	default:
+		return nil, 0, ErrUnsupportedAlgorithm
+	}
+
+	publicKey, err := jwkToPublicKey(jwk)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return publicKey, algorithm, nil
+}
+
+// verifyCOSESignature checks a WebAuthn signature made with the key of a credential
+func verifyCOSESignature(coseKey, signed, signature []byte) error {
+	publicKey, algorithm, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(signed)
+	switch algorithm {
+	case coseAlgorithmES256:
+		if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), digest[:], signature) {
+			return ErrInvalidWebAuthnResponse
+		}
+	case coseAlgorithmEdDSA:
+		if !ed25519.Verify(publicKey.(ed25519.PublicKey), signed, signature) {
+			return ErrInvalidWebAuthnResponse
+		}
+	case coseAlgorithmRS256:
+		if err := rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidWebAuthnResponse
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+// credentialDescriptors lists credentials for the allow or exclude list of a ceremony
+func credentialDescriptors(credentials []WebAuthnCredential) []PublicKeyCredentialDescriptor {
+	descriptors := make([]PublicKeyCredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptors = append(descriptors, PublicKeyCredentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.ID,
+			Transports: credential.Transports,
+		})
+	}
+	return descriptors
+}
@@ -0,0 +1,441 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const (
+	testRPID     = "example.com"
+	testRPOrigin = "https://app.example.com"
+)
+
+var testRP = &RelyingParty{ID: testRPID, Name: "Example", Origins: []string{testRPOrigin}}
+
+// softAuthenticator is a software authenticator holding one credential
+type softAuthenticator struct {
+	credentialID []byte
+	coseKey      []byte
+	sign         func(message []byte) []byte
+}
+
+// rsaTestKey is generated once, as 2048-bit RSA keys are slow to generate
+var rsaTestKey *rsa.PrivateKey
+
+func newSoftAuthenticator(t *testing.T, algorithm int) *softAuthenticator {
+	t.Helper()
+
+	authenticator := &softAuthenticator{credentialID: []byte(uuid.NewString())}
+	switch algorithm {
+	case coseAlgorithmES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		authenticator.coseKey = cborEncode(map[interface{}]interface{}{
+			1: 2, 3: coseAlgorithmES256, -1: 1,
+			-2: key.X.FillBytes(make([]byte, 32)),
+			-3: key.Y.FillBytes(make([]byte, 32)),
+		})
+		authenticator.sign = func(message []byte) []byte {
+			digest := sha256.Sum256(message)
+			signature, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			return signature
+		}
+	case coseAlgorithmEdDSA:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		authenticator.coseKey = cborEncode(map[interface{}]interface{}{
+			1: 1, 3: coseAlgorithmEdDSA, -1: 6, -2: []byte(publicKey),
+		})
+		authenticator.sign = func(message []byte) []byte {
+			return ed25519.Sign(privateKey, message)
+		}
+	case coseAlgorithmRS256:
+		if rsaTestKey == nil {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatalf("generate key: %v", err)
+			}
+			rsaTestKey = key
+		}
+		key := rsaTestKey
+		authenticator.coseKey = cborEncode(map[interface{}]interface{}{
+			1: 3, 3: coseAlgorithmRS256, -1: key.N.Bytes(), -2: big.NewInt(int64(key.E)).Bytes(),
+		})
+		authenticator.sign = func(message []byte) []byte {
+			digest := sha256.Sum256(message)
+			signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			return signature
+		}
+	default:
+		t.Fatalf("unsupported algorithm %d", algorithm)
+	}
+	return authenticator
+}
+
+// ceremony describes how the authenticator answers; zero values give a valid answer
+type ceremony struct {
+	origin     string
+	rpID       string
+	flags      byte
+	noFlags    bool
+	signCount  uint32
+	clientType string
+}
+
+func (c ceremony) clientData(t *testing.T, challenge, defaultType string) []byte {
+	t.Helper()
+
+	data := collectedClientData{Type: c.clientType, Challenge: challenge, Origin: c.origin}
+	if data.Type == "" {
+		data.Type = defaultType
+	}
+	if data.Origin == "" {
+		data.Origin = testRPOrigin
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("client data: %v", err)
+	}
+	return encoded
+}
+
+// authenticatorData encodes authenticator data, with attested credential data when attested is set
+func (c ceremony) authenticatorData(a *softAuthenticator, attested bool) []byte {
+	rpID := c.rpID
+	if rpID == "" {
+		rpID = testRPID
+	}
+	flags := c.flags
+	if flags == 0 && !c.noFlags {
+		flags = authenticatorUserPresent | authenticatorUserVerified
+	}
+	if attested {
+		flags |= authenticatorAttestedCredData
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(append([]byte{}, rpIDHash[:]...), flags)
+	data = binary.BigEndian.AppendUint32(data, c.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey...)
+	}
+	return data
+}
+
+// register answers a registration as navigator.credentials.create would
+func (a *softAuthenticator) register(t *testing.T, challenge string, c ceremony) *WebAuthnRegistrationResponse {
+	t.Helper()
+
+	response := &WebAuthnRegistrationResponse{ID: "credential", RawID: a.credentialID, Type: "public-key"}
+	response.Response.ClientDataJSON = c.clientData(t, challenge, "webauthn.create")
+	response.Response.AttestationObject = cborEncode(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": c.authenticatorData(a, true),
+	})
+	return response
+}
+
+// assert answers a login as navigator.credentials.get would
+func (a *softAuthenticator) assert(t *testing.T, challenge string, userID uuid.UUID, c ceremony) *WebAuthnAssertionResponse {
+	t.Helper()
+
+	response := &WebAuthnAssertionResponse{ID: "credential", RawID: a.credentialID, Type: "public-key"}
+	response.Response.ClientDataJSON = c.clientData(t, challenge, "webauthn.get")
+	response.Response.AuthenticatorData = c.authenticatorData(a, false)
+	response.Response.UserHandle = userID[:]
+
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	response.Response.Signature = a.sign(append(append([]byte{}, response.Response.AuthenticatorData...), clientDataHash[:]...))
+	return response
+}
+
+func registerTestCredential(t *testing.T, s *SessionService, a *softAuthenticator, userID uuid.UUID) *WebAuthnCredential {
+	t.Helper()
+
+	ctx := context.Background()
+	user := &WebAuthnUser{ID: userID, Name: "user@example.com", DisplayName: "User"}
+	options, err := s.BeginWebAuthnRegistration(ctx, testRP, user, nil, "binding")
+	if err != nil {
+		t.Fatalf("BeginWebAuthnRegistration: %v", err)
+	}
+
+	credential, registeredUserID, err := s.FinishWebAuthnRegistration(ctx, testRP, a.register(t, options.Challenge, ceremony{}), "binding")
+	if err != nil {
+		t.Fatalf("FinishWebAuthnRegistration: %v", err)
+	}
+	if registeredUserID != userID {
+		t.Fatalf("registered for user %s, want %s", registeredUserID, userID)
+	}
+	return credential
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	algorithms := map[string]int{
+		"ES256": coseAlgorithmES256,
+		"EdDSA": coseAlgorithmEdDSA,
+		"RS256": coseAlgorithmRS256,
+	}
+
+	for name, algorithm := range algorithms {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSessionService()
+			ctx := context.Background()
+			userID := uuid.New()
+			authenticator := newSoftAuthenticator(t, algorithm)
+
+			credential := registerTestCredential(t, s, authenticator, userID)
+			if string(credential.ID) != string(authenticator.credentialID) || string(credential.PublicKey) != string(authenticator.coseKey) {
+				t.Fatal("registered credential differs from the authenticator's")
+			}
+
+			lookup := func(credentialID []byte) (*WebAuthnCredential, uuid.UUID, error) {
+				if string(credentialID) != string(credential.ID) {
+					return nil, uuid.Nil, errors.New("unknown credential")
+				}
+				return credential, userID, nil
+			}
+
+			for count := uint32(1); count <= 2; count++ {
+				options, err := s.BeginWebAuthnLogin(ctx, testRP, userID, []WebAuthnCredential{*credential}, "binding")
+				if err != nil {
+					t.Fatalf("BeginWebAuthnLogin: %v", err)
+				}
+
+				response := authenticator.assert(t, options.Challenge, userID, ceremony{signCount: count})
+				assertion, assertedUserID, err := s.FinishWebAuthnLogin(ctx, testRP, response, "binding", lookup)
+				if err != nil {
+					t.Fatalf("FinishWebAuthnLogin %d: %v", count, err)
+				}
+				if assertedUserID != userID || assertion.SignCount != count || !assertion.UserVerified {
+					t.Fatalf("login %d: got user %s sign count %d verified %v", count, assertedUserID, assertion.SignCount, assertion.UserVerified)
+				}
+				credential.SignCount = assertion.SignCount
+			}
+		})
+	}
+}
+
+func TestFinishWebAuthnRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		ceremony ceremony
+		binding  string
+		response func(response *WebAuthnRegistrationResponse)
+	}{
+		{name: "wrong origin", ceremony: ceremony{origin: "https://evil.example"}},
+		{name: "origin of another scheme", ceremony: ceremony{origin: "http://app.example.com"}},
+		{name: "wrong rpIdHash", ceremony: ceremony{rpID: "evil.example"}},
+		{name: "user not present", ceremony: ceremony{flags: authenticatorUserVerified}},
+		{name: "no flags", ceremony: ceremony{noFlags: true}},
+		{name: "login answer", ceremony: ceremony{clientType: "webauthn.get"}},
+		{name: "other binding", binding: "other"},
+		{name: "other credential ID", response: func(response *WebAuthnRegistrationResponse) {
+			response.RawID = []byte("other")
+		}},
+		{name: "not a public key credential", response: func(response *WebAuthnRegistrationResponse) {
+			response.Type = "password"
+		}},
+		{name: "truncated attestation object", response: func(response *WebAuthnRegistrationResponse) {
+			attestation := response.Response.AttestationObject
+			response.Response.AttestationObject = attestation[:len(attestation)-10]
+		}},
+		{name: "indefinite-length attestation object", response: func(response *WebAuthnRegistrationResponse) {
+			attestation := append([]byte{0xbf}, response.Response.AttestationObject[1:]...)
+			response.Response.AttestationObject = append(attestation, 0xff)
+		}},
+		{name: "attestation object without authData", response: func(response *WebAuthnRegistrationResponse) {
+			response.Response.AttestationObject = cborEncode(map[interface{}]interface{}{"fmt": "none"})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSessionService()
+			ctx := context.Background()
+			authenticator := newSoftAuthenticator(t, coseAlgorithmES256)
+
+			user := &WebAuthnUser{ID: uuid.New(), Name: "user@example.com"}
+			options, err := s.BeginWebAuthnRegistration(ctx, testRP, user, nil, "binding")
+			if err != nil {
+				t.Fatalf("BeginWebAuthnRegistration: %v", err)
+			}
+
+			response := authenticator.register(t, options.Challenge, tt.ceremony)
+			if tt.response != nil {
+				tt.response(response)
+			}
+			binding := tt.binding
+			if binding == "" {
+				binding = "binding"
+			}
+
+			if _, _, err := s.FinishWebAuthnRegistration(ctx, testRP, response, binding); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+				t.Fatalf("got %v, want ErrInvalidWebAuthnResponse", err)
+			}
+		})
+	}
+}
+
+func TestFinishWebAuthnRegistrationSingleUse(t *testing.T) {
+	s := newTestSessionService()
+	ctx := context.Background()
+	authenticator := newSoftAuthenticator(t, coseAlgorithmEdDSA)
+
+	options, err := s.BeginWebAuthnRegistration(ctx, testRP, &WebAuthnUser{ID: uuid.New()}, nil, "binding")
+	if err != nil {
+		t.Fatalf("BeginWebAuthnRegistration: %v", err)
+	}
+
+	response := authenticator.register(t, options.Challenge, ceremony{})
+	if _, _, err := s.FinishWebAuthnRegistration(ctx, testRP, response, "binding"); err != nil {
+		t.Fatalf("first answer: %v", err)
+	}
+	if _, _, err := s.FinishWebAuthnRegistration(ctx, testRP, response, "binding"); !errors.Is(err, ErrInvalidWebAuthnResponse) {
+		t.Fatalf("replayed answer: got %v, want ErrInvalidWebAuthnResponse", err)
+	}
+}
+
+func TestFinishWebAuthnLoginRejects(t *testing.T) {
+	tests := []struct {
+		name          string
+		ceremony      ceremony
+		storedCount   uint32
+		discoverable  bool // login without a user, which requires user verification
+		otherUser     bool // the credential belongs to another user than the login was begun for
+		response      func(response *WebAuthnAssertionResponse)
+		wantSignCount bool
+	}{
+		{name: "wrong origin", ceremony: ceremony{origin: "https://evil.example", signCount: 1}},
+		{name: "wrong rpIdHash", ceremony: ceremony{rpID: "evil.example", signCount: 1}},
+		{name: "user not present", ceremony: ceremony{flags: authenticatorUserVerified, signCount: 1}},
+		{name: "user not verified on a discoverable login", ceremony: ceremony{flags: authenticatorUserPresent, signCount: 1}, discoverable: true},
+		{name: "registration answer", ceremony: ceremony{clientType: "webauthn.create", signCount: 1}},
+		{name: "sign count goes backwards", ceremony: ceremony{signCount: 4}, storedCount: 5, wantSignCount: true},
+		{name: "sign count repeats", ceremony: ceremony{signCount: 5}, storedCount: 5, wantSignCount: true},
+		{name: "sign count stops", ceremony: ceremony{signCount: 0}, storedCount: 5, wantSignCount: true},
+		{name: "credential of another user", ceremony: ceremony{signCount: 1}, otherUser: true},
+		{name: "tampered authenticator data", ceremony: ceremony{signCount: 1}, response: func(response *WebAuthnAssertionResponse) {
+			response.Response.AuthenticatorData[36]++
+		}},
+		{name: "tampered client data", ceremony: ceremony{signCount: 1}, response: func(response *WebAuthnAssertionResponse) {
+			response.Response.ClientDataJSON = append(response.Response.ClientDataJSON[:len(response.Response.ClientDataJSON)-1], ' ', '}')
+		}},
+		{name: "truncated authenticator data", ceremony: ceremony{signCount: 1}, response: func(response *WebAuthnAssertionResponse) {
+			response.Response.AuthenticatorData = response.Response.AuthenticatorData[:36]
+		}},
+		{name: "other user handle", ceremony: ceremony{signCount: 1}, response: func(response *WebAuthnAssertionResponse) {
+			other := uuid.New()
+			response.Response.UserHandle = other[:]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSessionService()
+			ctx := context.Background()
+			userID := uuid.New()
+			authenticator := newSoftAuthenticator(t, coseAlgorithmES256)
+			credential := registerTestCredential(t, s, authenticator, userID)
+			credential.SignCount = tt.storedCount
+
+			credentialUserID := userID
+			if tt.otherUser {
+				credentialUserID = uuid.New()
+			}
+			lookup := func(credentialID []byte) (*WebAuthnCredential, uuid.UUID, error) {
+				return credential, credentialUserID, nil
+			}
+
+			loginUserID, allowed := userID, []WebAuthnCredential{*credential}
+			if tt.discoverable {
+				loginUserID, allowed = uuid.Nil, nil
+			}
+			options, err := s.BeginWebAuthnLogin(ctx, testRP, loginUserID, allowed, "binding")
+			if err != nil {
+				t.Fatalf("BeginWebAuthnLogin: %v", err)
+			}
+
+			response := authenticator.assert(t, options.Challenge, credentialUserID, tt.ceremony)
+			if tt.response != nil {
+				tt.response(response)
+			}
+
+			want := ErrInvalidWebAuthnResponse
+			if tt.wantSignCount {
+				want = ErrWebAuthnSignCount
+			}
+			if _, _, err := s.FinishWebAuthnLogin(ctx, testRP, response, "binding", lookup); !errors.Is(err, want) {
+				t.Fatalf("got %v, want %v", err, want)
+			}
+		})
+	}
+}
+
+func TestFinishWebAuthnLoginWithoutSignCount(t *testing.T) {
+	// Authenticators that don't count signatures always report zero
+	s := newTestSessionService()
+	ctx := context.Background()
+	userID := uuid.New()
+	authenticator := newSoftAuthenticator(t, coseAlgorithmES256)
+	credential := registerTestCredential(t, s, authenticator, userID)
+	lookup := func(credentialID []byte) (*WebAuthnCredential, uuid.UUID, error) {
+		return credential, userID, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		options, err := s.BeginWebAuthnLogin(ctx, testRP, userID, []WebAuthnCredential{*credential}, "binding")
+		if err != nil {
+			t.Fatalf("BeginWebAuthnLogin: %v", err)
+		}
+		response := authenticator.assert(t, options.Challenge, userID, ceremony{})
+		if _, _, err := s.FinishWebAuthnLogin(ctx, testRP, response, "binding", lookup); err != nil {
+			t.Fatalf("login %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestParseCOSEKeyRejects(t *testing.T) {
+	ecKey := newSoftAuthenticator(t, coseAlgorithmES256).coseKey
+
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{"trailing data", append(append([]byte{}, ecKey...), 0x00)},
+		{"truncated", ecKey[:len(ecKey)-1]},
+		{"not a map", cborEncode([]interface{}{1, 2})},
+		{"unsupported algorithm", cborEncode(map[interface{}]interface{}{1: 2, 3: -35, -1: 2})},
+		{"wrong curve", cborEncode(map[interface{}]interface{}{1: 2, 3: coseAlgorithmES256, -1: 2, -2: make([]byte, 48), -3: make([]byte, 48)})},
+		{"point not on the curve", cborEncode(map[interface{}]interface{}{1: 2, 3: coseAlgorithmES256, -1: 1, -2: make([]byte, 32), -3: make([]byte, 32)})},
+		{"short RSA key", cborEncode(map[interface{}]interface{}{1: 3, 3: coseAlgorithmRS256, -1: make([]byte, 128), -2: []byte{1, 0, 1}})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseCOSEKey(tt.key); err == nil {
+				t.Fatal("got a key, want an error")
+			}
+		})
+	}
+}
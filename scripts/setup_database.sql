@@ -259,6 +259,7 @@ INSERT INTO permissions (name, resource, action, description, category, is_syste
 ('authy_users:update', 'authy_users', 'update', 'Update user information in Authy', 'user_management', true),
 ('authy_users:delete', 'authy_users', 'delete', 'Delete/deactivate users in Authy', 'user_management', true),
 ('authy_users:list', 'authy_users', 'list', 'List all users in Authy', 'user_management', true),
+('authy_users:impersonate', 'authy_users', 'impersonate', 'Act as another user through token exchange', 'user_management', true),
 
 -- Role Management (authy_ prefixed)
 ('authy_roles:create', 'authy_roles', 'create', 'Create new roles in Authy', 'role_management', true),